@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/runner"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+)
+
+var outputFormat = "text" // "text", "ndjson", or "json" for the single result of -once
+
+// How the speed tests of a connection run: parallel, series, or hybrid, where one test runs on its
+// own before the parallel ones to measure single-stream as well as multi-stream throughput
+var speedTestModes = []string{"parallel", "series", "hybrid"}
+
+func main() {
+	if !stdoutIsTerminal() {
+		enablePlainOutput()
+	}
+
+	// Without a subcommand the arguments are those of run, as before subcommands existed
+	subcommand, args := "run", os.Args[1:]
+	if len(args) > 0 && slices.Contains(subcommands, args[0]) {
+		subcommand, args = args[0], args[1:]
+	}
+
+	switch subcommand {
+	case "regions":
+		runRegions(args)
+	case "report":
+		runReport(args)
+	case "compare":
+		runCompare(args)
+	case "serve-collector":
+		runServeCollector(args)
+	case "serve-control":
+		runServeControl(args)
+	case "install-service":
+		runInstallService(args)
+	case "uninstall-service":
+		runUninstallService(args)
+	case "service-run":
+		runServiceRun(args)
+	case "version":
+		runVersion()
+	case "help":
+		displayHelp()
+	default:
+		runBenchmark(args)
+	}
+}
+
+// Runs the run subcommand: benchmarks the locations of the input file
+func runBenchmark(args []string) {
+	opts := runner.DefaultOptions()
+	helpFlag := flag.Bool("h", false, "Display help menu")
+	versionFlag := flag.Bool("version", false, "Print the version, commit, build date and Go version and exit")
+	singleThreadedFlag := flag.Bool("s", false, "Run speed tests in series, one after another, in case of 1Gbps network")
+	repeatSpeedTestFlag := flag.Int("r", 5, "Number of parallel speed tests per VPN connection")
+	flag.StringVar(&opts.Mode, "mode", opts.Mode, "Speed test mode: parallel, series (same as -s) or hybrid (one test on its own, then -r in parallel)")
+	flag.BoolVar(&opts.AutoTune, "auto-tune", false, "Test a location whose parallel tests saturated the link again with half as many at a time, down to series")
+	flag.IntVar(&opts.Namespaces, "namespaces", 0, "On Linux, test N WireGuard regions at once, each through its own tunnel in a network namespace")
+	var outputFlag outputList
+	flag.Var(&outputFlag, "output", "Console format (text or ndjson) and extra result outputs (json:FILE, csv:FILE, webhook:URL); repeatable or comma-separated")
+	quietFlag := flag.Bool("q", false, "Only log warnings and errors")
+	verboseFlag := flag.Bool("v", false, "Log debug messages, including every command executed")
+	veryVerboseFlag := flag.Bool("vv", false, "Log trace messages, including raw command output")
+	flag.StringVar(&opts.ProgressFile, "progress", "", "Continuously write run progress to this JSON file")
+	flag.StringVar(&opts.PublicReportFile, "public-report", "", "Also write a copy of the results with rounded numbers to this file for publishing")
+	flag.Float64Var(&opts.PublicRoundMbps, "public-round", opts.PublicRoundMbps, "Round speeds in the public report to the nearest multiple of this many Mbps")
+	flag.StringVar(&opts.ProbeName, "probe-name", "", "Name to record instead of the hostname, for containers and multi-probe setups")
+	flag.BoolVar(&opts.VerifyExitIP, "verify-ip", false, "After connecting, check the public exit IP and whether its country matches the requested region")
+	flag.BoolVar(&opts.VerifyRoute, "verify-route", false, "After the tests, check that the route to the speed test server goes through the VPN interface")
+	flag.BoolVar(&opts.MTUSweep, "mtu-sweep", false, "After each location's tests, probe the path MTU through the tunnel and flag fragmentation and blackholes")
+	flag.BoolVar(&opts.CPU, "cpu", false, "Record CPU and memory use, of the system and the VPN daemon, during each location's tests")
+	flag.BoolVar(&opts.IfaceCounters, "iface-counters", false, "Record the bytes the tunnel and physical interfaces carried during each location's tests, exposing the VPN protocol's overhead")
+	flag.StringVar(&opts.IPCheckURL, "ip-check-url", opts.IPCheckURL, "IP echo service used by -verify-ip")
+	flag.StringVar(&opts.Engine, "engine", opts.Engine, "Speed test engine: ookla (speedtest CLI), native (built in, no external binary), iperf3 or http")
+	flag.StringVar(&opts.SpeedTest.HTTPDownloadURL, "http-download-url", opts.SpeedTest.HTTPDownloadURL, "URL the http engine downloads from; {bytes} is replaced with -http-size")
+	flag.StringVar(&opts.SpeedTest.HTTPUploadURL, "http-upload-url", opts.SpeedTest.HTTPUploadURL, "URL the http engine uploads to")
+	flag.Int64Var(&opts.SpeedTest.HTTPPayloadSize, "http-size", opts.SpeedTest.HTTPPayloadSize, "Bytes the http engine transfers in each direction")
+	flag.IntVar(&opts.SpeedTest.IPVersion, "ip-version", 0, "Force the speed tests over IPv4 or IPv6: 4 or 6 (default: the engine picks)")
+	flag.BoolVar(&opts.IPv6Check, "ipv6-check", false, "After connecting, record whether the location reaches IPv6 hosts")
+	flag.StringVar(&opts.SpeedTest.IperfServer, "iperf-server", "", "iperf3 server as host[:port] (default port 5201) for -engine iperf3")
+	dnsFlag := flag.String("dns", "", "Comma-separated domains to resolve through each VPN region to benchmark DNS")
+	webFlag := flag.String("web", "", "Comma-separated URLs to fetch through each VPN region, timing DNS, connect, TLS and time to first byte")
+	flag.BoolVar(&opts.Router, "router", false, "Measure through a VPN router such as Aircove, prompting to switch its region before each location")
+	flag.StringVar(&opts.RouterConnect, "router-connect", "", "With -router, shell command switching the router to {region}, {country} and {city} instead of prompting")
+	flag.StringVar(&opts.RouterDisconnect, "router-disconnect", "", "With -router, shell command turning the router's VPN off for the baseline")
+	flag.StringVar(&opts.RouterStatus, "router-status", "", "With -router, shell command exiting with 0 once the router is connected, polled after -router-connect")
+	flag.IntVar(&opts.TopMovers, "top-movers", opts.TopMovers, "Show the N regions that improved and degraded most since the previous run (0 disables)")
+	flag.StringVar(&opts.Resume, "resume", "", "Resume an interrupted run from its checkpoint file, skipping completed locations")
+	flag.IntVar(&opts.Warmup, "warmup", 0, "Run N throwaway speed tests after each VPN connect before recording samples")
+	flag.DurationVar(&opts.PauseBetweenTests, "pause-between-tests", opts.PauseBetweenTests, "Pause between consecutive speed tests, varied by up to 25%")
+	flag.DurationVar(&opts.PauseBetweenLocations, "pause-between-locations", opts.PauseBetweenLocations, "Pause before moving on to the next location, varied by up to 25%")
+	flag.BoolVar(&opts.IgnoreConflicts, "ignore-conflicts", false, "Run even when other VPN software is active, recording the conflicts in the results")
+	flag.StringVar(&opts.HTMLReportFile, "html-report", "", "Write a self-contained HTML report of the run to this file")
+	flag.StringVar(&opts.BundleFile, "bundle", "", "Zip the HTML report, raw results and log of the run into this archive")
+	flag.Var(tagMap(opts.Tags), "tag", "Tag the run as key=value, e.g. office=nyc, to group results from many machines; repeatable or comma-separated")
+	flag.StringVar(&opts.PushURL, "push-url", "", "POST the results of the run as JSON to this collector when it completes")
+	flag.BoolVar(&opts.PushSamples, "push-samples", false, "Also POST every speed test to -push-url as it completes")
+	flag.Var((*headerList)(&opts.PushHeaders), "push-header", "Header sent with every push, as \"Name: value\", e.g. for authentication; repeatable")
+	flag.StringVar(&opts.NotifyURL, "notify-url", "", "Post a run summary to this webhook (Slack, Discord, Teams or generic JSON) when the run completes or aborts")
+	flag.BoolVar(&opts.FailFast, "fail-fast", false, "Stop at the first failed location, or right away if the baseline fails")
+	flag.StringVar(&opts.CrossCheck, "cross-check", "", "Also run one test with this second engine per location and report where the engines disagree")
+	flag.Float64Var(&opts.CrossCheckTolerance, "cross-check-tolerance", opts.CrossCheckTolerance, "Percent difference above which the -cross-check engine disagrees")
+	flag.DurationVar(&opts.Soak, "soak", 0, "Stay connected to each region for this long after its tests, sampling speed and recording disconnects")
+	flag.DurationVar(&opts.SoakInterval, "soak-interval", opts.SoakInterval, "Time between speed tests during -soak")
+	flag.IntVar(&opts.ConnectCycles, "connect-cycles", opts.ConnectCycles, "Connect and disconnect N times per region before testing and record min/avg/max connect times")
+	flag.BoolVar(&opts.ConnectPhases, "connect-phases", false, "Break each connect down into the connect command, the client's states and the first connection through the tunnel")
+	flag.DurationVar(&opts.ConnectTimeout, "connect-timeout", opts.ConnectTimeout, "Give up on a region that hasn't connected within this time")
+	flag.Var((*probeList)(&opts.Probes), "probe", "Run a custom probe as NAME=COMMAND through each location's VPN and store the JSON it prints under CustomProbes; repeatable")
+	flag.StringVar(&opts.PreHook, "pre-hook", "", "Run this shell command before connecting to each location, with the location in SPEEDTEST_ variables")
+	flag.StringVar(&opts.PostHook, "post-hook", "", "Run this shell command after each location's tests and disconnect, with the location and its result in SPEEDTEST_ variables")
+	flag.DurationVar(&opts.LocationTimeout, "location-timeout", 0, "Give up on a location whose connect and tests take longer than this, and move on to the next")
+	flag.StringVar(&opts.PingAnchor, "ping-monitor", "", "Ping this host throughout the run, or connect to host:port over TCP, and write the latency and loss timeline")
+	flag.DurationVar(&opts.PingInterval, "ping-interval", opts.PingInterval, "Time between -ping-monitor pings")
+	flag.StringVar(&opts.PingTimelineFile, "ping-timeline", "", "Write the -ping-monitor timeline to this file, as CSV when it ends in .csv (default: RESULTS-timeline.json)")
+	flag.StringVar(&opts.NetworkLock, "network-lock", "", "Turn ExpressVPN's network lock (kill switch) on or off for the run, restoring it afterwards")
+	flag.StringVar(&opts.Unit, "units", opts.Unit, "Unit for speeds on the console and in reports: Mbps, MB/s or Gbps")
+	flag.BoolVar(&opts.LocalTime, "local-time", false, "Show times as local times in the HTML report and -output csv: files, instead of as stored in UTC")
+	flag.BoolVar(&opts.Geo, "geo", false, "Record the traceroute hop count and the distance to the VPN exit for each region")
+	flag.StringVar(&opts.TracerouteTarget, "traceroute-target", opts.TracerouteTarget, "Host the -geo hop count is measured to")
+	planOutFlag := flag.String("plan-out", "", "Write the resolved run plan (regions, tests, estimated duration and data) to this file and exit")
+	onceFlag := flag.String("once", "", "Test only this region (\"Country, City\"), without a baseline, print its result as one JSON object on stdout and exit")
+	flag.BoolVar(&opts.TUI, "tui", false, "Full-screen interactive mode with a live table of locations; press s to skip a location, q to abort")
+	flag.StringVar(&opts.ResultsFile, "results", "", "Write results to this file instead of results-<timestamp>.json")
+	flag.StringVar(&opts.ResultsDir, "results-dir", "", "Write the results-<timestamp>.json file to this directory instead of the working directory")
+	flag.IntVar(&opts.KeepLast, "keep-last", 0, "After the run, remove all but the newest N results-<timestamp>.json files next to the results file")
+	flag.IntVar(&opts.KeepDays, "keep-days", 0, "After the run, remove results-<timestamp>.json files next to the results file older than D days")
+	flag.BoolVar(&opts.Append, "append", false, "Add this run to the -results file when it already exists, as a new section")
+	flag.StringVar(&opts.NetworkProfile, "network-profile", "", "Name of the network the baseline is measured on, e.g. wired or wifi, to keep baselines of several networks in one -append results file")
+	flag.StringVar(&opts.BaselineProfile, "baseline-profile", "", "Compare the locations with the latest baseline of this -network-profile in the results file instead of this run's")
+	flag.StringVar(&opts.HistoryDir, "history", "", "Directory of earlier results files to flag regions that fell below their historical norm")
+	flag.IntVar(&opts.HistoryWindow, "history-window", opts.HistoryWindow, "Number of earlier runs of a region its -history norm averages")
+	flag.Float64Var(&opts.RegressionThreshold, "regression-threshold", opts.RegressionThreshold, "Percent below its -history norm a region's download speed must fall to be flagged")
+	flag.BoolVar(&opts.SpeedTest.AcceptLicense, "accept-license", true, "Accept the Ookla speedtest CLI's license and GDPR terms, which a fresh install otherwise stops to ask for")
+	flag.StringVar(&speedtestBin, "speedtest-bin", "", "Path of the speedtest binary, for when it isn't in PATH")
+	flag.StringVar(&speedtestArgs, "speedtest-args", "", "Extra space-separated arguments for every speedtest invocation, e.g. --server-id=12345")
+	flag.StringVar(&vpnctlBin, "vpnctl-bin", "", "Path of the expressvpnctl binary, for when it isn't in PATH")
+	flag.StringVar(&vpnctlArgs, "vpnctl-args", "", "Extra space-separated arguments for every expressvpnctl invocation")
+	shuffleFlag := flag.Bool("shuffle", false, "Test the locations in random order, to avoid later regions always running during busier hours")
+	seedFlag := flag.Uint64("seed", 0, "Seed for -shuffle, to reproduce the order of an earlier run (default 0: random, recorded in the results)")
+	var timesOfDay timeList
+	flag.Var(&timesOfDay, "times-of-day", "Stay up and run the location list at each of these times, e.g. 09:00,14:00,21:00, to compare regions across the day")
+	daysFlag := flag.Int("days", 1, "Days to repeat the -times-of-day passes for")
+	flag.StringVar(&opts.TimeWindow, "time-window", "", "Label recorded with every location of the run; set for each -times-of-day pass")
+	flag.Float64Var(&opts.MaxDataGB, "max-data", 0, "Stop before the speed tests transfer more than this many GB, for metered connections (0 is unlimited)")
+	flag.DurationVar(&opts.MaxDuration, "max-duration", 0, "Stop before the run takes longer than this, e.g. 2h (0 is unlimited)")
+	flag.BoolVar(&opts.LatencyOnly, "latency-only", false, "Skip the throughput tests and only measure latency, jitter and connect time per region")
+	flag.StringVar(&opts.SpeedTest.LatencyTarget, "latency-target", opts.SpeedTest.LatencyTarget, "host:port -latency-only times TCP connections to")
+	testsFlag := flag.String("tests", "download,upload,latency", "Comma-separated tests to run: download, upload and latency; skipping needs -engine native, http or iperf3")
+	flag.BoolVar(&opts.Redact, "redact", false, "Hash the hostname and leave public IPs and speed test server hostnames out of the results, for sharing them publicly")
+	encryptFlag := flag.String("encrypt", "", "Encrypt the results file with the passphrase in this file, or in the environment variable NAME for env:NAME")
+	flag.BoolVar(&asciiOutput, "ascii", false, "Replace the ▼/▲ arrows and other non-ASCII output with plain text, on the console and in the results")
+	flag.BoolVar(&plainOutput, "plain", plainOutput, "Print plain timestamped lines without spinners or colors, the default when stdout isn't a terminal")
+	planInFlag := flag.String("plan-in", "", "Execute exactly the run plan in this file instead of an input file")
+	configFlag := flag.String("config", "", "Path to a YAML config file (default: ~/.config/expressvpnspeedtest/config.yaml)")
+	for _, name := range parseFlags(flag.CommandLine, args) {
+		logger.Warn("Environment variable matches no flag", "name", name)
+	}
+
+	if *helpFlag {
+		displayHelp()
+		return
+	}
+	if *versionFlag {
+		runVersion()
+		return
+	}
+
+	configPath := *configFlag
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	config, err := loadConfig(configPath, *configFlag != "")
+	if err != nil {
+		fatal("Failed to load config file", "path", configPath, "err", err)
+	}
+	if err := applyConfig(config, flag.CommandLine); err != nil {
+		fatal("Invalid config file", "path", configPath, "err", err)
+	}
+	config.apply()
+	applyBinaryFlags()
+	opts.Commands = commands
+	opts.Flags = flag.CommandLine
+	build := currentBuild()
+	opts.ToolVersion, opts.ToolCommit, opts.ToolBuildDate = build.Version, build.Commit, build.Date
+	if config := defaultConfigPath(); config != "" {
+		opts.RedactKeyFile = filepath.Join(filepath.Dir(config), "redact.key")
+	}
+
+	if opts.BundleFile != "" {
+		opts.BundleLog = new(bytes.Buffer)
+		captureLogs(opts.BundleLog)
+	}
+
+	var plan runner.Plan
+	if *planInFlag != "" {
+		plan, err = runner.LoadPlan(*planInFlag)
+		if err != nil {
+			fatal("Failed to load plan", "path", *planInFlag, "err", err)
+		}
+		opts.Engine = plan.Engine
+		opts.CrossCheck = plan.CrossCheck
+		opts.ConnectCycles = max(plan.ConnectCycles, 1)
+		if plan.Soak != "" {
+			if opts.Soak, err = time.ParseDuration(plan.Soak); err != nil {
+				fatal("Invalid soak in plan", "path", *planInFlag, "soak", plan.Soak, "err", err)
+			}
+		}
+		*repeatSpeedTestFlag = plan.Baseline.Samples
+		*singleThreadedFlag = !plan.Baseline.Parallel
+		if plan.Hybrid {
+			opts.Mode = "hybrid"
+		}
+		opts.Warmup = plan.Warmup
+	}
+
+	if !slices.Contains(runner.SpeedUnits, opts.Unit) {
+		fatal("Unknown speed unit", "unit", opts.Unit, "valid", strings.Join(runner.SpeedUnits, ", "))
+	}
+	switch opts.Mode {
+	case "series":
+		*singleThreadedFlag = true
+	case "hybrid":
+		if *singleThreadedFlag {
+			fatal("-s can't be combined with -mode hybrid")
+		}
+	case "parallel":
+	default:
+		fatal("Unknown speed test mode", "mode", opts.Mode, "valid", strings.Join(speedTestModes, ", "))
+	}
+	if opts.ConnectCycles < 1 {
+		fatal("Number of connect cycles must be at least 1")
+	}
+	if opts.PingInterval <= 0 {
+		fatal("-ping-interval must be positive")
+	}
+	if opts.NetworkLock != "" && opts.NetworkLock != "on" && opts.NetworkLock != "off" {
+		fatal("Invalid -network-lock, expected on or off", "value", opts.NetworkLock)
+	}
+	if _, ok := speedtest.Engines[opts.Engine]; !ok {
+		fatal("Unknown speed test engine", "engine", opts.Engine, "valid", strings.Join(speedtest.EngineNames(), ", "))
+	}
+	if _, ok := speedtest.Engines[opts.CrossCheck]; opts.CrossCheck != "" && (!ok || opts.CrossCheck == opts.Engine) {
+		fatal("The cross-check engine must be another speed test engine", "engine", opts.Engine, "crossCheckEngine", opts.CrossCheck)
+	}
+	if (opts.Engine == "iperf3" || opts.CrossCheck == "iperf3") && opts.SpeedTest.IperfServer == "" {
+		fatal("The iperf3 engine needs a server, set it with -iperf-server host:port")
+	}
+	if version := opts.SpeedTest.IPVersion; version != 0 && version != 4 && version != 6 {
+		fatal("Invalid -ip-version, expected 4 or 6", "value", opts.SpeedTest.IPVersion)
+	}
+	for _, engine := range []string{opts.Engine, opts.CrossCheck} {
+		if opts.SpeedTest.IPVersion != 0 && engine != "" && !slices.Contains(speedtest.IPVersionEngines, engine) {
+			fatal("The engine can't be forced to an IP version", "engine", engine, "valid", strings.Join(speedtest.IPVersionEngines, ", "))
+		}
+	}
+
+	if err := runner.ApplyTestSelection(&opts, *testsFlag); err != nil {
+		fatal("Invalid -tests", "err", err)
+	}
+	if opts.SpeedTest.SkipDownload {
+		// Earlier runs are compared by their download speeds
+		opts.TopMovers, opts.HistoryDir = 0, ""
+	}
+
+	logLevel.Set(verbosityLevel(*quietFlag, *verboseFlag, *veryVerboseFlag))
+	if *quietFlag {
+		pterm.DisableOutput()
+	}
+	opts.Quiet = logLevel.Level() > slog.LevelInfo
+
+	applyEncryption(&opts.Files, *encryptFlag)
+	if asciiOutput {
+		enableASCII()
+	}
+	if plainOutput {
+		enablePlainOutput()
+		if opts.TUI {
+			fatal("-tui needs a terminal, stdout isn't one or -plain is set")
+		}
+	}
+	opts.ASCII, opts.Plain = asciiOutput, plainOutput
+	consoleLog := logOutput
+	opts.RedirectLogs = func(w io.Writer) {
+		if w == nil {
+			w = consoleLog
+		}
+		redirectLogs(w)
+	}
+	if opts.TUI && (opts.Router || slices.Contains(outputFlag, "ndjson")) {
+		fatal("-tui can't be combined with -router or ndjson output")
+	}
+	if (opts.RouterConnect != "" || opts.RouterDisconnect != "" || opts.RouterStatus != "") && !opts.Router {
+		fatal("-router-connect, -router-disconnect and -router-status need -router")
+	}
+	if opts.Router && (opts.RouterConnect == "") != (opts.RouterDisconnect == "") {
+		fatal("-router-connect and -router-disconnect go together, the baseline needs the router's VPN off")
+	}
+	if opts.RouterStatus != "" && opts.RouterConnect == "" {
+		fatal("-router-status needs -router-connect")
+	}
+	if opts.VerifyRoute && opts.Router {
+		fatal("-verify-route can't be combined with -router, the VPN runs on the router")
+	}
+	opts.Once = *onceFlag != ""
+	if opts.Once && (opts.TUI || opts.Router || opts.Resume != "" || *planInFlag != "" || *planOutFlag != "" || len(timesOfDay) > 0) {
+		fatal("-once can't be combined with -tui, -router, -resume, -plan-in, -plan-out or -times-of-day")
+	}
+
+	for _, output := range outputFlag {
+		switch output {
+		case "text":
+		case "ndjson":
+			// Spinners and human-readable text would corrupt the JSON stream
+			pterm.DisableOutput()
+			outputFormat = output
+		default:
+			sink, err := runner.ParseSink(output, opts.Files, opts.Display)
+			if err != nil {
+				fatal("Invalid output", "err", err)
+			}
+			opts.Sinks = append(opts.Sinks, sink)
+		}
+	}
+	if opts.Once {
+		// Only the result may reach stdout
+		pterm.DisableOutput()
+		outputFormat = "json"
+	}
+	opts.Output = outputFormat
+
+	if opts.LatencyOnly {
+		if opts.CrossCheck != "" || opts.Soak > 0 {
+			fatal("-latency-only can't be combined with -cross-check or -soak")
+		}
+		// No throughput to warm up or to compare with earlier runs
+		opts.Warmup, opts.TopMovers, opts.HistoryDir = 0, 0, ""
+	}
+
+	if len(timesOfDay) > 0 && opts.TimeWindow == "" {
+		fileName := cmp.Or(opts.ResultsFile, "results-"+time.Now().Format("20060102150405")+".json")
+		os.Exit(runTimeOfDayMatrix(args, fileName, timesOfDay, *daysFlag, opts.Append))
+	}
+
+	opts.Samples = 5 // Number of parallel speed tests per VPN connection
+	if *singleThreadedFlag {
+		opts.Samples = 1
+	}
+	if *repeatSpeedTestFlag != 0 {
+		opts.Samples = *repeatSpeedTestFlag
+	}
+	opts.Parallel = !*singleThreadedFlag
+
+	if opts.LatencyOnly {
+		printText("Measuring only latency, to", opts.SpeedTest.LatencyTarget)
+	} else if opts.Samples == 1 {
+		printText("Running a single speed test per VPN connection")
+	} else if opts.Samples > 1 {
+		if *singleThreadedFlag {
+			printText("Running", opts.Samples, "speed tests in series")
+		} else if opts.Mode == "hybrid" {
+			printText("Running one speed test on its own, then", opts.Samples, "parallel tests")
+		} else {
+			printText("Running speed tests with", opts.Samples, "parallel tests")
+		}
+	} else {
+		fatal("Number of speed tests must be at least 1")
+	}
+
+	if *dnsFlag != "" {
+		opts.DNSDomains = strings.Split(*dnsFlag, ",")
+	}
+	if *webFlag != "" {
+		opts.WebURLs = strings.Split(*webFlag, ",")
+	}
+
+	var input results.InputData
+	inputFile := flag.Arg(0)
+	if opts.Once {
+		input.Locations = []results.Location{onceLocation(*onceFlag)}
+		input.Aliases = config.Aliases
+	} else if *planInFlag != "" {
+		input = plan.Input()
+	} else if inputFile == "" && len(config.Locations) > 0 {
+		input.Locations = config.Locations
+		input.Aliases = config.Aliases
+	} else {
+		input, err = loadInput(inputFile)
+		if err != nil {
+			fatal("Failed to load input file", "path", inputFile, "err", err)
+		}
+	}
+	benchmark := runner.NewRunner(opts)
+	locations, err := benchmark.AddInput(input)
+	if err != nil {
+		fatal("Failed to load input file", "path", inputFile, "err", err)
+	}
+
+	// A plan is executed in the order it was written
+	if *shuffleFlag && *planInFlag == "" {
+		if *seedFlag == 0 {
+			// Set as a flag so the seed is recorded with the run's flags
+			flag.CommandLine.Set("seed", strconv.FormatUint(rand.Uint64N(math.MaxUint64)+1, 10))
+		}
+		shuffleLocations(locations, *seedFlag)
+		printText("Testing the locations in random order, seed", *seedFlag)
+	}
+
+	if *planOutFlag != "" {
+		plan := benchmark.BuildPlan(locations)
+		if err := runner.SavePlan(*planOutFlag, plan); err != nil {
+			fatal("Failed to write plan", "path", *planOutFlag, "err", err)
+		}
+		printText("Plan written to", *planOutFlag, "- estimated", plan.EstimatedDuration, "and", fmt.Sprintf("%.0fMB", plan.EstimatedDataMB))
+		return
+	}
+
+	handleInterrupts(benchmark)
+	if err := benchmark.Run(context.Background(), locations); err != nil {
+		exitWith(runner.ExitCodeOf(err), "Run failed", "err", err)
+	}
+	if code := benchmark.ExitCode(); code != runner.ExitOK {
+		os.Exit(code)
+	}
+}
+
+// Aborts the run after the current step on the first interrupt, so the VPN is disconnected and the run
+// can be resumed; a second one stops right away
+func handleInterrupts(benchmark *runner.Runner) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		logger.Warn("Aborting after the current step, interrupt again to stop now")
+		benchmark.RequestAbort("by an interrupt")
+		<-interrupts
+		benchmark.Abort(errors.New("interrupted"))
+		exitWith(runner.ExitError, "Interrupted")
+	}()
+}
+
+// Reads the locations and aliases of an input file, after checking it against the input schema
+func loadInput(fileName string) (results.InputData, error) {
+	var input results.InputData
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return input, err
+	}
+	if err := results.ValidateInput(data); err != nil {
+		return input, err
+	}
+	err = json.Unmarshal(data, &input)
+	return input, err
+}
+
+// Returns the location -once names: "Country, City", a country, smart or a region name as the provider lists it
+func onceLocation(region string) results.Location {
+	country, city, _ := strings.Cut(region, ",")
+	return results.Location{Country: strings.TrimSpace(country), City: strings.TrimSpace(city)}
+}
+
+func displayHelp() {
+	fmt.Println("Usage: expressvpnspeedtest [command] [options]")
+	fmt.Println("Commands:")
+	fmt.Println("  run [options] <input_file.json>  Benchmark the locations in the input file (the default command)")
+	fmt.Println("  regions [-input FILE] [-json] [search]  List the available regions, marking those the input file resolves to")
+	fmt.Println("  report [-units U] [-html FILE] [-local-time] [-baseline-profile P] <results.json>  Show a results file as a table or render it as HTML")
+	fmt.Println("  compare [-alpha 0.05] [-units Mbps] <before.json> <after.json>  Compare two results files")
+	fmt.Println("  compare [-alpha 0.05] -since TIME [-until TIME] <results.json|DIR>...  Compare a time window with the one before it")
+	fmt.Println("  serve-collector [-listen :8080] [-dir DIR] [-token TOKEN]  Accept results pushed with -push-url and serve a list and summary")
+	fmt.Println("  serve-control [-listen :8090] [-dir DIR] [-token TOKEN]  Start, watch, stream and abort runs over a REST API")
+	fmt.Println("  install-service [-name N] [-schedule daily] [-user] [-print] [--] [run options] <input.json>  Run on a schedule with systemd or a Windows service")
+	fmt.Println("  uninstall-service [-name N] [-user]  Remove what install-service registered")
+	fmt.Println("  version                          Print the version, commit, build date and Go version")
+	fmt.Println("Run options:")
+	fmt.Println("  -h     Show this help message and exit")
+	fmt.Println("  -version  Print the version, commit, build date and Go version and exit")
+	fmt.Println("  -s     Run speed tests in series, one after another, in case of 1Gbps network")
+	fmt.Println("  -r N   Set the number of parallel speed tests (default: 5)")
+	fmt.Println("  -mode MODE  parallel (default), series (same as -s) or hybrid: one test on its own, then -r in parallel")
+	fmt.Println("  -auto-tune  Test a location whose parallel tests saturated the link again with half as many at a time, down to series")
+	fmt.Println("  -output FORMAT  Output format: text (default) or ndjson, one JSON object per sample on stdout")
+	fmt.Println("                  Also json:FILE, csv:FILE or webhook:URL to send results to more outputs; repeatable")
+	fmt.Println("  -progress FILE  Continuously write run progress (location, index/total, ETA, last result) to FILE")
+	fmt.Println("  -q     Quiet: only log warnings and errors")
+	fmt.Println("  -v     Verbose: log debug messages, including every command executed")
+	fmt.Println("  -vv    Very verbose: also log the raw output of every command")
+	fmt.Println("  -public-report FILE  Also write a copy of the results with rounded numbers for publishing")
+	fmt.Println("  -public-round N      Round public report speeds to the nearest N Mbps (default: 10)")
+	fmt.Println("  -dns DOMAINS  Comma-separated domains to resolve through each VPN region to benchmark DNS")
+	fmt.Println("  -web URLS     Comma-separated URLs to fetch through each VPN region, timing DNS, connect, TLS and first byte")
+	fmt.Println("  -probe-name NAME  Record NAME instead of the hostname in results")
+	fmt.Println("  -verify-ip  Check the public exit IP after connecting and flag country mismatches")
+	fmt.Println("  -verify-route  Check that the route to the speed test server goes through the VPN, not around it")
+	fmt.Println("  -mtu-sweep  Probe the path MTU through the tunnel to -traceroute-target and flag fragmentation and blackholes")
+	fmt.Println("  -cpu  Record CPU and memory use, of the system and the VPN daemon, during each location's tests")
+	fmt.Println("  -iface-counters  Record the bytes the tunnel and physical interfaces carried during each location's tests")
+	fmt.Println("  -ip-check-url URL  IP echo service used by -verify-ip (default: https://ipapi.co/json/)")
+	fmt.Println("  -engine NAME  Speed test engine: ookla (speedtest CLI, default), native (built in, no external binary), iperf3 or http")
+	fmt.Println("  -http-download-url URL  URL the http engine downloads from; {bytes} is replaced with -http-size (default: Cloudflare)")
+	fmt.Println("  -http-upload-url URL    URL the http engine uploads to (default: Cloudflare)")
+	fmt.Println("  -http-size BYTES        Bytes the http engine transfers in each direction (default: 25000000)")
+	fmt.Println("  -ip-version 4|6  Force the speed tests over IPv4 or IPv6, with the native, http or iperf3 engine")
+	fmt.Println("  -ipv6-check  Record whether each location reaches IPv6 hosts")
+	fmt.Println("  -iperf-server HOST:PORT  Your own iperf3 server for -engine iperf3 (default port: 5201)")
+	fmt.Println("  -resume FILE  Resume an interrupted run from its checkpoint file (results-*.json.checkpoint)")
+	fmt.Println("  -router       Measure through a VPN router such as Aircove, prompting to switch its region before each location")
+	fmt.Println("  -router-connect CMD  With -router, switch the router to {region}, {country} and {city} with a shell command instead of prompting")
+	fmt.Println("  -router-disconnect CMD  With -router, turn the router's VPN off for the baseline with a shell command")
+	fmt.Println("  -router-status CMD  With -router, poll a shell command that exits with 0 once the router is connected")
+	fmt.Println("  -top-movers N  Show the N regions that improved and degraded most since the previous run (default: 3, 0 disables)")
+	fmt.Println("  -warmup N  Run N throwaway speed tests after each VPN connect before recording samples")
+	fmt.Println("  -pause-between-tests D      Pause between consecutive speed tests, varied by up to 25% (default: 2s)")
+	fmt.Println("  -pause-between-locations D  Pause before the next location, varied by up to 25% (default: 5s)")
+	fmt.Println("  -ignore-conflicts  Run even when other VPN tunnels or a Tailscale exit node are active, recording them in the results")
+	fmt.Println("  -html-report FILE  Write a self-contained HTML report (inlined styles and chart, no CDN) to FILE")
+	fmt.Println("  -bundle FILE       Zip the HTML report, raw results and run log into FILE for sharing")
+	fmt.Println("  -notify-url URL  Post a run summary to a Slack, Discord, Teams or generic webhook when the run completes or aborts")
+	fmt.Println("  -fail-fast  Stop at the first failed location, or right away if the baseline fails")
+	fmt.Println("  -cross-check ENGINE  Also run one test with the other engine per location and flag where they disagree")
+	fmt.Println("  -cross-check-tolerance PCT  Percent difference above which the engines disagree (default: 20)")
+	fmt.Println("  -soak D           Stay connected to each region for D after its tests, sampling speed and recording disconnects")
+	fmt.Println("  -soak-interval D  Time between speed tests during -soak (default: 1m)")
+	fmt.Println("  -connect-cycles N  Connect and disconnect N times per region before testing, recording min/avg/max connect times")
+	fmt.Println("  -connect-phases    Break each connect down into the connect command, the client's states and the first probe")
+	fmt.Println("  -connect-timeout D  Give up on a region that hasn't connected within D (default: 1m)")
+	fmt.Println("  -namespaces N  Test N regions at once, each in a network namespace of its own; Linux only, as root, with every")
+	fmt.Println("                 location on a wireguard provider and -engine ookla or iperf3")
+	fmt.Println("  -probe NAME=CMD  Run CMD through each location's VPN and store the JSON it prints as CustomProbes.NAME; repeatable")
+	fmt.Println("  -pre-hook CMD   Run CMD before connecting to each location, with the location in SPEEDTEST_ variables")
+	fmt.Println("  -post-hook CMD  Run CMD after each location's tests and disconnect, with its result in SPEEDTEST_ variables")
+	fmt.Println("  -location-timeout D  Give up on a location whose connect and tests take longer than D, and move on")
+	fmt.Println("  -ping-monitor HOST  Ping HOST (or connect to HOST:PORT over TCP) throughout the run and write a latency/loss timeline")
+	fmt.Println("  -ping-interval D    Time between -ping-monitor pings (default: 1s)")
+	fmt.Println("  -ping-timeline FILE Write the timeline to FILE, as CSV when it ends in .csv (default: RESULTS-timeline.json)")
+	fmt.Println("  -network-lock on|off  Turn ExpressVPN's network lock (kill switch) on or off for the run, restoring it afterwards")
+	fmt.Println("  -units UNIT  Show speeds on the console and in reports in Mbps (default), MB/s or Gbps")
+	fmt.Println("  -local-time  Show times as local times in the HTML report and -output csv: files instead of in UTC")
+	fmt.Println("  -geo  Record the traceroute hop count and the great-circle distance to the VPN exit for each region")
+	fmt.Println("  -traceroute-target HOST  Host the -geo hop count is measured to (default: 1.1.1.1)")
+	fmt.Println("  -once REGION  Test only REGION (\"Country, City\"), without a baseline, and print its result as one JSON object on stdout")
+	fmt.Println("  -tui  Full-screen interactive mode with a live table; press s to skip a location, q to abort")
+	fmt.Println("  -results FILE  Write results to FILE instead of results-TIMESTAMP.json")
+	fmt.Println("  -results-dir DIR  Write results-TIMESTAMP.json to DIR instead of the working directory")
+	fmt.Println("  -keep-last N      After the run, remove all but the newest N results-TIMESTAMP.json files")
+	fmt.Println("  -keep-days D      After the run, remove results-TIMESTAMP.json files older than D days")
+	fmt.Println("  -append        Add the run to an existing -results file as a new section")
+	fmt.Println("  -network-profile NAME  Tag the baseline with the network it was measured on, e.g. wired or wifi")
+	fmt.Println("  -baseline-profile NAME  Compare the locations with the latest baseline of this network profile in the results file")
+	fmt.Println("  -history DIR  Flag regions whose download speed fell below their average over the earlier results files in DIR")
+	fmt.Println("  -history-window N         Number of earlier runs of a region the norm averages (default: 10)")
+	fmt.Println("  -regression-threshold PCT  Percent below the norm that counts as a regression (default: 20)")
+	fmt.Println("  -plan-out FILE  Write the resolved run plan with estimated duration and data to FILE and exit")
+	fmt.Println("  -push-url URL   POST the results of the run as JSON to a collector; -push-samples also posts every speed test")
+	fmt.Println("  -push-header H  Header for every push, e.g. \"Authorization: Bearer TOKEN\"; repeatable")
+	fmt.Println("  -accept-license=false  Don't accept the Ookla CLI's license and GDPR terms on its behalf")
+	fmt.Println("  -speedtest-bin PATH  Path of the speedtest binary; -vpnctl-bin PATH for expressvpnctl")
+	fmt.Println("  -speedtest-args ARGS Extra arguments for every speedtest run, e.g. \"--server-id=12345\"; -vpnctl-args for expressvpnctl")
+	fmt.Println("  -shuffle        Test the locations in random order; -seed N reproduces an earlier order")
+	fmt.Println("  -times-of-day T  Run the location list at each time, e.g. 09:00,14:00,21:00, for -days N days")
+	fmt.Println("  -max-data GB    Stop before the speed tests transfer more than GB; -max-duration D before the run takes longer than D")
+	fmt.Println("  -latency-only   Only measure latency, jitter and connect time, to -latency-target HOST:PORT (default: 1.1.1.1:443)")
+	fmt.Println("  -tests LIST     Tests to run, e.g. download,latency to skip the upload (native, http and iperf3 engines)")
+	fmt.Println("  -redact         Hash the hostname and leave public IPs and speed test servers out of the results")
+	fmt.Println("  -encrypt KEYFILE  Encrypt the results with the passphrase in KEYFILE, or in variable NAME for env:NAME")
+	fmt.Println("  -ascii          Plain text instead of the arrows and other non-ASCII output, on the console and in the results")
+	fmt.Println("  -plain          Timestamped lines without spinners or colors, automatic when stdout isn't a terminal")
+	fmt.Println("  -plan-in FILE   Execute exactly the run plan in FILE instead of an input file")
+	fmt.Println("  -config FILE  Load defaults from a YAML config file (default: ~/.config/expressvpnspeedtest/config.yaml)")
+	fmt.Println("Example:")
+	fmt.Println("  expressvpnspeedtest [--repeatSpeedTest 10] locations.json")
+	fmt.Println("Input file format example:")
+	fmt.Println(`  {
+    "locations": [
+	  {
+		"country": "Netherlands",
+		"city": "Amsterdam"
+	  },
+	  {
+		"country": "Romania",
+		"city": "Bucharest"
+	  },
+	  {
+		"country": "Canada",
+	  	"city": "Toronto"
+	  }
+    ]
+}`)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+
+	"github.com/pterm/pterm"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+var asciiOutput bool // Plain text instead of the ▼/▲ arrows and other non-ASCII output, on the console and in the results
+
+// Switches the console and the log to plain ASCII; the runner's ASCII option does the same for
+// streamed samples and saved results
+func enableASCII() {
+	asciiOutput = true
+	pterm.SetDefaultOutput(results.ASCIIWriter{W: os.Stdout})
+	redirectLogs(results.ASCIIWriter{W: logOutput})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+var commit = ""    // Set at build time with -ldflags "-X main.commit=$(git rev-parse HEAD)"
+var buildDate = "" // Set at build time with -ldflags "-X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+
+// toolBuild identifies the build that produced a results file
+type toolBuild struct {
+	Version   string
+	Commit    string // Ends in -dirty when built from a checkout with uncommitted changes
+	Date      string
+	GoVersion string
+}
+
+// Returns the version, commit and build date set with -ldflags, filling in what wasn't set from the build
+// info Go embeds: the module version of go install builds and the commit of builds from a git checkout
+func currentBuild() toolBuild {
+	build := toolBuild{Version: version, Commit: commit, Date: buildDate, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+	if build.Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		build.Version = strings.TrimPrefix(info.Main.Version, "v")
+	}
+	if build.Commit != "" {
+		return build
+	}
+	settings := map[string]string{}
+	for _, setting := range info.Settings {
+		settings[setting.Key] = setting.Value
+	}
+	build.Commit = settings["vcs.revision"]
+	if build.Commit != "" && settings["vcs.modified"] == "true" {
+		build.Commit += "-dirty"
+	}
+	if build.Date == "" {
+		build.Date = settings["vcs.time"] // When the commit was made, the closest Go records to a build date
+	}
+	return build
+}
+
+// Formats the build for -version: the version, then the commit, build date and Go version when known
+func (b toolBuild) String() string {
+	lines := []string{"expressvpnspeedtest " + b.Version}
+	if b.Commit != "" {
+		lines = append(lines, "commit: "+b.Commit)
+	}
+	if b.Date != "" {
+		lines = append(lines, "built:  "+b.Date)
+	}
+	lines = append(lines, fmt.Sprintf("go:     %s %s/%s", b.GoVersion, runtime.GOOS, runtime.GOARCH))
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/runner"
+	"flavius.xyz/vpn_speed_test_cli/pkg/vpn"
+)
+
+const maxPushSize = 32 << 20 // Bytes; a results file with thousands of locations stays well below
+
+var errMissingMachineName = errors.New("the payload has no MachineName")
+
+// Collector stores the results machines push with -push-url, one directory per machine and one
+// results file per run, and serves a list and summary of them
+type Collector struct {
+	Dir   string
+	Token string // Required as "Authorization: Bearer TOKEN" when set
+
+	mutex sync.Mutex // Serializes writes to the stored files
+}
+
+// CollectedRun is one stored run as listed by /api/runs
+type CollectedRun struct {
+	MachineName string            `json:"MachineName"`
+	RunID       string            `json:"RunID"`
+	UUID        string            `json:"UUID,omitempty"`
+	Tags        map[string]string `json:"Tags,omitempty"`
+	Started     string            `json:"Started,omitempty"`
+	WithoutVPN  string            `json:"WithoutVPN"`
+	Locations   int               `json:"Locations"`
+	URL         string            `json:"URL"` // Where the full results of the run are served
+}
+
+// LocationSummary aggregates one location over every stored run of every machine
+type LocationSummary struct {
+	LocationName string  `json:"LocationName"`
+	Machines     int     `json:"Machines"`
+	Runs         int     `json:"Runs"`
+	DownloadMbps float64 `json:"DownloadMbps"` // Mean of the runs
+	UploadMbps   float64 `json:"UploadMbps"`
+	Latest       string  `json:"Latest"` // Date/Time of the latest run
+}
+
+// Runs the serve-collector subcommand: expressvpnspeedtest serve-collector [-listen :8080] [-dir DIR] [-token TOKEN]
+func runServeCollector(args []string) {
+	flags := flag.NewFlagSet("serve-collector", flag.ExitOnError)
+	listen := flags.String("listen", ":8080", "Address to accept pushes and API requests on")
+	dir := flags.String("dir", "collected", "Directory the pushed results are stored in, one subdirectory per machine")
+	token := flags.String("token", "", "Require \"Authorization: Bearer TOKEN\" on every request")
+	parseFlags(flags, args)
+
+	if err := checkListenToken(*listen, *token); err != nil {
+		fatal("Refusing to accept pushes from the network without a token", "err", err)
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		fatal("Failed to create collector directory", "path", *dir, "err", err)
+	}
+	collector := &Collector{Dir: *dir, Token: *token}
+	logger.Info("Collector listening", "addr", *listen, "dir", *dir)
+	if err := http.ListenAndServe(*listen, collector.Handler()); err != nil {
+		fatal("Collector stopped", "err", err)
+	}
+}
+
+// Requires a token when the address accepts connections from other hosts, since anyone on the network
+// could push results, or start runs through serve-control
+func checkListenToken(listen, token string) error {
+	if token != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is reachable from other hosts; pass -token, or listen on 127.0.0.1 only", listen)
+}
+
+// Returns the collector's routes: pushes are POSTed to any path, the API lives under /api and the Grafana
+// datasource under /grafana
+func (c *Collector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /", c.handlePush)
+	mux.HandleFunc("GET /api/runs", c.handleRuns)
+	mux.HandleFunc("GET /api/runs/{machine}/{id}", c.handleRun)
+	mux.HandleFunc("GET /api/summary", c.handleSummary)
+	c.addGrafanaRoutes(mux)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+c.Token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Stores a pushed results file or sample
+func (c *Collector) handlePush(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushSize))
+
+	var err error
+	switch r.Header.Get("X-Payload-Type") {
+	case runner.PayloadSample:
+		var sample runner.PushedSample
+		if err := decoder.Decode(&sample); err != nil {
+			http.Error(w, "invalid sample: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = c.storeSample(sample)
+	case runner.PayloadResults, "":
+		var data results.Results
+		if err := decoder.Decode(&data); err != nil {
+			http.Error(w, "invalid results: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Machines running older versions push older files
+		if err := results.Migrate(&data); err != nil {
+			http.Error(w, "invalid results: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = c.storeResults(data)
+	default:
+		http.Error(w, "unknown X-Payload-Type", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		logger.Error("Error storing pushed payload", "remote", r.RemoteAddr, "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Saves each run of a results file on its own, so pushing an appended file again doesn't duplicate runs
+func (c *Collector) storeResults(data results.Results) error {
+	machine := vpn.Slugify(data.MachineName)
+	if machine == "" {
+		return errMissingMachineName
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(c.Dir, machine), 0755); err != nil {
+		return err
+	}
+	for id, run := range splitRuns(data) {
+		if err := results.Save(run, filepath.Join(c.Dir, machine, "results-"+id+".json")); err != nil {
+			return err
+		}
+	}
+	logger.Info("Results received", "machine", data.MachineName, "locations", len(data.VPNStats))
+	return nil
+}
+
+// Appends a sample to the samples file of its run
+func (c *Collector) storeSample(sample runner.PushedSample) error {
+	machine, id := vpn.Slugify(sample.MachineName), vpn.Slugify(sample.RunID)
+	if machine == "" {
+		return errMissingMachineName
+	}
+	if id == "" {
+		id = "unknown"
+	}
+	line, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(c.Dir, machine), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(c.Dir, machine, "samples-"+id+".ndjson"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Splits a results file into one results file per run, keyed by the run's slugified ID; a file without
+// run sections, which once migrated means without locations, is kept whole under the time it arrived
+func splitRuns(data results.Results) map[string]results.Results {
+	if len(data.Runs) == 0 {
+		return map[string]results.Results{time.Now().Format("20060102150405"): data}
+	}
+
+	split := map[string]results.Results{}
+	for _, run := range data.Runs {
+		id := vpn.Slugify(run.ID)
+		if id == "" {
+			continue
+		}
+		info := run.RunInfo
+		part := data
+		part.WithoutVPN = run.WithoutVPN
+		part.Network = run.Network
+		part.Conflicts = run.Conflicts
+		part.RunInfo = &info
+		part.Runs = []results.Run{run}
+		part.VPNStats = nil
+		for _, stat := range data.VPNStats {
+			if stat.RunID == run.ID {
+				part.VPNStats = append(part.VPNStats, stat)
+			}
+		}
+		split[id] = part
+	}
+	return split
+}
+
+// runKey identifies a stored run by its machine directory and run ID
+type runKey struct {
+	Machine string
+	ID      string
+}
+
+// Loads every stored results file, keyed by machine directory and run ID, keeping only the runs
+// carrying every tag of the request's tag=key=value query parameters
+func (c *Collector) load(r *http.Request) (map[runKey]results.Results, error) {
+	wanted := tagMap{}
+	for _, tag := range r.URL.Query()["tag"] {
+		if err := wanted.Set(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(c.Dir, "*", "results-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	stored := map[runKey]results.Results{}
+	for _, match := range matches {
+		data, err := results.Load(match)
+		if err != nil {
+			logger.Warn("Skipping unreadable results file", "path", match, "err", err)
+			continue
+		}
+		if !hasTags(data, wanted) {
+			continue
+		}
+		machine := filepath.Base(filepath.Dir(match))
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), "results-"), ".json")
+		stored[runKey{Machine: machine, ID: id}] = data
+	}
+	return stored, nil
+}
+
+// Reports whether a run carries all the tags
+func hasTags(data results.Results, tags map[string]string) bool {
+	for key, value := range tags {
+		if data.RunInfo == nil || data.RunInfo.Tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Lists the stored runs, newest first
+func (c *Collector) handleRuns(w http.ResponseWriter, r *http.Request) {
+	stored, err := c.load(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	runs := []CollectedRun{}
+	for key, data := range stored {
+		run := CollectedRun{
+			MachineName: data.MachineName,
+			RunID:       key.ID,
+			WithoutVPN:  data.WithoutVPN,
+			Locations:   len(data.VPNStats),
+			URL:         "/api/runs/" + key.Machine + "/" + key.ID,
+		}
+		if data.RunInfo != nil {
+			run.UUID = data.RunInfo.UUID
+			run.Tags = data.RunInfo.Tags
+			run.Started = data.RunInfo.Started
+		}
+		runs = append(runs, run)
+	}
+	slices.SortFunc(runs, func(a, b CollectedRun) int {
+		return strings.Compare(b.RunID+b.MachineName, a.RunID+a.MachineName)
+	})
+	writeJSON(w, runs)
+}
+
+// Serves the full results of one stored run
+func (c *Collector) handleRun(w http.ResponseWriter, r *http.Request) {
+	machine, id := vpn.Slugify(r.PathValue("machine")), vpn.Slugify(r.PathValue("id"))
+	if machine == "" || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	fileName := filepath.Join(c.Dir, machine, "results-"+id+".json")
+	if _, err := os.Stat(fileName); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := results.Load(fileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, data)
+}
+
+// Aggregates every location over all stored runs
+func (c *Collector) handleSummary(w http.ResponseWriter, r *http.Request) {
+	stored, err := c.load(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, summarizeLocations(stored))
+}
+
+// Averages each location's speeds over the stored runs, sorted by location name
+func summarizeLocations(stored map[runKey]results.Results) []LocationSummary {
+	type aggregate struct {
+		machines         map[string]bool
+		download, upload results.RunningStats
+		latest           results.VPNStat
+	}
+	aggregates := map[string]*aggregate{}
+	for key, data := range stored {
+		for _, stat := range data.VPNStats {
+			a, ok := aggregates[stat.LocationName]
+			if !ok {
+				a = &aggregate{machines: map[string]bool{}}
+				aggregates[stat.LocationName] = a
+			}
+			a.machines[key.Machine] = true
+			a.download.Add(results.ParseMbps(stat.VPNDownloadSpeed))
+			a.upload.Add(results.ParseMbps(stat.VPNUploadSpeed))
+			if stat.Tested().After(a.latest.Tested()) {
+				a.latest = stat
+			}
+		}
+	}
+
+	summaries := []LocationSummary{}
+	for name, a := range aggregates {
+		summaries = append(summaries, LocationSummary{
+			LocationName: name,
+			Machines:     len(a.machines),
+			Runs:         a.download.Count,
+			DownloadMbps: math.Round(a.download.Mean*100) / 100,
+			UploadMbps:   math.Round(a.upload.Mean*100) / 100,
+			Latest:       a.latest.Timestamp,
+		})
+	}
+	slices.SortFunc(summaries, func(a, b LocationSummary) int {
+		return strings.Compare(a.LocationName, b.LocationName)
+	})
+	return summaries
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/runner"
+)
+
+// MetricComparison is the before/after comparison of one metric for one location
+type MetricComparison struct {
+	Before       float64
+	After        float64
+	DeltaPercent float64
+	PValue       float64
+	Verdict      string // "improved", "regressed", "noise" or "insufficient data"
+}
+
+// Comparison holds the per-location result of comparing two runs
+type Comparison struct {
+	LocationName string
+	Download     MetricComparison
+	Upload       MetricComparison
+}
+
+// Runs the compare subcommand: expressvpnspeedtest compare [-alpha 0.05] [-units Mbps] [-encrypt KEYFILE] <before.json> <after.json>,
+// or with -since, the window from -since to -until against the equally long window before it over files and directories of results
+func runCompare(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	alpha := flags.Float64("alpha", 0.05, "Significance level below which a difference is reported as real")
+	since := flags.String("since", "", "Compare the locations tested from this time (RFC 3339 or YYYY-MM-DD) with those of the equally long window before it")
+	until := flags.String("until", "", "End of the window compared with -since (default: now)")
+	var display runner.Display
+	flags.StringVar(&display.Unit, "units", "Mbps", "Unit for speeds: Mbps, MB/s or Gbps")
+	encryptFlag := flags.String("encrypt", "", "Decrypt the results files with the passphrase in this file, or in the environment variable NAME for env:NAME")
+	parseFlags(flags, args)
+
+	usage := "Usage: expressvpnspeedtest compare [-alpha 0.05] [-units Mbps] [-encrypt KEYFILE] <before.json> <after.json>\n" +
+		"       expressvpnspeedtest compare [-alpha 0.05] -since TIME [-until TIME] <results.json|DIR>..."
+	if *since == "" && (*until != "" || flags.NArg() != 2) || *since != "" && flags.NArg() == 0 {
+		fatal(usage)
+	}
+	var files results.FileOptions
+	applyEncryption(&files, *encryptFlag)
+	if !slices.Contains(runner.SpeedUnits, display.Unit) {
+		fatal("Unknown speed unit", "unit", display.Unit, "valid", strings.Join(runner.SpeedUnits, ", "))
+	}
+
+	var before, after results.Results
+	if *since != "" {
+		start, err := parseWindowTime(*since)
+		if err != nil {
+			fatal("Invalid -since", "value", *since, "err", err)
+		}
+		end := time.Now()
+		if *until != "" {
+			if end, err = parseWindowTime(*until); err != nil {
+				fatal("Invalid -until", "value", *until, "err", err)
+			}
+		}
+		if !start.Before(end) {
+			fatal("-since must be before -until", "since", *since, "until", *until)
+		}
+		history, err := loadResultsPaths(files, flags.Args())
+		if err != nil {
+			fatal("Failed to load results", "err", err)
+		}
+		before, after = splitWindows(history, start, end)
+		fmt.Printf("Comparing %s to %s with %s to %s\n", results.FormatTime(start), results.FormatTime(end),
+			results.FormatTime(start.Add(-end.Sub(start))), results.FormatTime(start))
+	} else {
+		var err error
+		if before, err = files.Load(flags.Arg(0)); err != nil {
+			fatal("Failed to load results file", "path", flags.Arg(0), "err", err)
+		}
+		if after, err = files.Load(flags.Arg(1)); err != nil {
+			fatal("Failed to load results file", "path", flags.Arg(1), "err", err)
+		}
+	}
+
+	comparisons := compareResults(before, after, *alpha)
+	if len(comparisons) == 0 {
+		if *since != "" {
+			fmt.Println("No locations tested in both time windows")
+		} else {
+			fmt.Println("No locations in common between the two results files")
+		}
+		os.Exit(0)
+	}
+
+	tableData := pterm.TableData{{"Location", "Metric", "Before", "After", "Change", "p-value", "Verdict"}}
+	for _, c := range comparisons {
+		tableData = append(tableData, comparisonRow(display, c.LocationName, "Download", c.Download))
+		tableData = append(tableData, comparisonRow(display, "", "Upload", c.Upload))
+	}
+	pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+}
+
+// Parses a -since or -until time, either RFC 3339 or a date taken as local midnight
+func parseWindowTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, s, time.Local)
+}
+
+// Pools the locations of results files and of every results file in directories, such as a -history
+// directory or a collector's machine directory; files in a directory that aren't results files are skipped
+func loadResultsPaths(files results.FileOptions, paths []string) (results.Results, error) {
+	var pooled results.Results
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return pooled, err
+		}
+		names := []string{path}
+		if info.IsDir() {
+			if names, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+				return pooled, err
+			}
+		}
+		for _, file := range names {
+			data, err := files.Load(file)
+			if err != nil {
+				if info.IsDir() {
+					logger.Debug("Skipping file that isn't a results file", "path", file, "err", err)
+					continue
+				}
+				return pooled, fmt.Errorf("%s: %w", file, err)
+			}
+			pooled.VPNStats = append(pooled.VPNStats, data.VPNStats...)
+		}
+	}
+	return pooled, nil
+}
+
+// Splits the pooled locations into those tested in the window from start to end, and in the equally
+// long window right before it; locations outside both, or without a time, are left out
+func splitWindows(data results.Results, start, end time.Time) (before, after results.Results) {
+	beforeStart := start.Add(-end.Sub(start))
+	for _, stat := range data.VPNStats {
+		tested := stat.Tested()
+		switch {
+		case tested.IsZero() || tested.Before(beforeStart) || !tested.Before(end):
+		case tested.Before(start):
+			before.VPNStats = append(before.VPNStats, stat)
+		default:
+			after.VPNStats = append(after.VPNStats, stat)
+		}
+	}
+	return before, after
+}
+
+func comparisonRow(d runner.Display, location string, metric string, m MetricComparison) []string {
+	pValue := "-"
+	if !math.IsNaN(m.PValue) {
+		pValue = fmt.Sprintf("%.3f", m.PValue)
+	}
+	return []string{
+		location,
+		metric,
+		d.FormatSpeed(m.Before),
+		d.FormatSpeed(m.After),
+		fmt.Sprintf("%+.1f%%", m.DeltaPercent),
+		pValue,
+		m.Verdict,
+	}
+}
+
+// Compares every location present in both runs, in the order of the first run
+func compareResults(before, after results.Results, alpha float64) []Comparison {
+	beforeDownload, beforeUpload, order := before.GroupSamples()
+	afterDownload, afterUpload, _ := after.GroupSamples()
+
+	var comparisons []Comparison
+	for _, location := range order {
+		if _, ok := afterDownload[location]; !ok {
+			continue
+		}
+		comparisons = append(comparisons, Comparison{
+			LocationName: location,
+			Download:     compareMetric(*beforeDownload[location], *afterDownload[location], alpha),
+			Upload:       compareMetric(*beforeUpload[location], *afterUpload[location], alpha),
+		})
+	}
+	return comparisons
+}
+
+// Compares two sets of samples of the same metric with Welch's t-test
+func compareMetric(before, after results.RunningStats, alpha float64) MetricComparison {
+	m := MetricComparison{
+		Before: before.Mean,
+		After:  after.Mean,
+		PValue: math.NaN(),
+	}
+	if m.Before != 0 {
+		m.DeltaPercent = (m.After - m.Before) / m.Before * 100
+	}
+
+	_, p, ok := welchTTest(before, after)
+	switch {
+	case !ok:
+		m.Verdict = "insufficient data"
+	case p >= alpha:
+		m.PValue = p
+		m.Verdict = "noise"
+	case m.After > m.Before:
+		m.PValue = p
+		m.Verdict = "improved"
+	default:
+		m.PValue = p
+		m.Verdict = "regressed"
+	}
+	return m
+}
+
+// Returns the t statistic and two-sided p-value of Welch's unequal-variance t-test;
+// ok is false when either side has fewer than two samples
+func welchTTest(a, b results.RunningStats) (t float64, p float64, ok bool) {
+	if a.Count < 2 || b.Count < 2 {
+		return 0, 0, false
+	}
+
+	va := a.Variance() / float64(a.Count)
+	vb := b.Variance() / float64(b.Count)
+	if va+vb == 0 {
+		// Identical constant samples can't tell us anything beyond their means
+		if a.Mean == b.Mean {
+			return 0, 1, true
+		}
+		return math.Inf(1), 0, true
+	}
+
+	t = (a.Mean - b.Mean) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/float64(a.Count-1) + vb*vb/float64(b.Count-1))
+	p = regularizedIncompleteBeta(df/2, 0.5, df/(df+t*t))
+	return t, p, true
+}
+
+// Computes I_x(a, b) using the continued fraction from Numerical Recipes
+func regularizedIncompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+
+	// The continued fraction converges quickly only for x < (a+1)/(a+b+2)
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaContinuedFraction(b, a, 1-x)/b
+	}
+	return front * betaContinuedFraction(a, b, x) / a
+}
+
+func betaContinuedFraction(a, b, x float64) float64 {
+	const maxIterations = 200
+	const epsilon = 1e-14
+	const tiny = 1e-300
+
+	c := 1.0
+	d := 1 - (a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+
+		// Even step
+		num := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+
+		// Odd step
+		num = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
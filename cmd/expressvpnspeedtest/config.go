@@ -0,0 +1,481 @@
+package main
+
+import (
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Config holds defaults loaded from the YAML config file; command-line flags override them
+type Config struct {
+	Samples               int                       `yaml:"samples"`
+	Series                bool                      `yaml:"series"`
+	Mode                  string                    `yaml:"mode"`
+	AutoTune              bool                      `yaml:"auto_tune"`
+	Namespaces            int                       `yaml:"namespaces"`
+	Output                string                    `yaml:"output"`
+	Progress              string                    `yaml:"progress"`
+	PublicReport          string                    `yaml:"public_report"`
+	PublicRound           float64                   `yaml:"public_round"`
+	Quiet                 bool                      `yaml:"quiet"`
+	Verbose               bool                      `yaml:"verbose"`
+	DNSDomains            []string                  `yaml:"dns_domains"`
+	ProbeName             string                    `yaml:"probe_name"`
+	VerifyIP              bool                      `yaml:"verify_ip"`
+	IPCheckURL            string                    `yaml:"ip_check_url"`
+	Engine                string                    `yaml:"engine"`
+	TopMovers             int                       `yaml:"top_movers"`
+	Path                  string                    `yaml:"path"`
+	Commands              map[string]command.Config `yaml:"commands"`
+	Aliases               map[string]string         `yaml:"aliases"`
+	Warmup                int                       `yaml:"warmup"`
+	PauseBetweenTests     string                    `yaml:"pause_between_tests"`
+	PauseBetweenLocations string                    `yaml:"pause_between_locations"`
+	IgnoreConflicts       bool                      `yaml:"ignore_conflicts"`
+	HTMLReport            string                    `yaml:"html_report"`
+	Bundle                string                    `yaml:"bundle"`
+	NotifyURL             string                    `yaml:"notify_url"`
+	FailFast              bool                      `yaml:"fail_fast"`
+	CrossCheck            string                    `yaml:"cross_check"`
+	CrossCheckTolerance   float64                   `yaml:"cross_check_tolerance"`
+	Soak                  string                    `yaml:"soak"`
+	SoakInterval          string                    `yaml:"soak_interval"`
+	ConnectCycles         int                       `yaml:"connect_cycles"`
+	ConnectPhases         bool                      `yaml:"connect_phases"`
+	ConnectTimeout        string                    `yaml:"connect_timeout"`
+	LocationTimeout       string                    `yaml:"location_timeout"`
+	Probes                map[string]string         `yaml:"probes"`
+	PreHook               string                    `yaml:"pre_hook"`
+	PostHook              string                    `yaml:"post_hook"`
+	RouterConnect         string                    `yaml:"router_connect"`
+	RouterDisconnect      string                    `yaml:"router_disconnect"`
+	RouterStatus          string                    `yaml:"router_status"`
+	Units                 string                    `yaml:"units"`
+	LocalTime             bool                      `yaml:"local_time"`
+	IperfServer           string                    `yaml:"iperf_server"`
+	HTTPDownloadURL       string                    `yaml:"http_download_url"`
+	HTTPUploadURL         string                    `yaml:"http_upload_url"`
+	HTTPSize              int64                     `yaml:"http_size"`
+	IPVersion             int                       `yaml:"ip_version"`
+	IPv6Check             bool                      `yaml:"ipv6_check"`
+	Geo                   bool                      `yaml:"geo"`
+	TracerouteTarget      string                    `yaml:"traceroute_target"`
+	TUI                   bool                      `yaml:"tui"`
+	Results               string                    `yaml:"results"`
+	Append                bool                      `yaml:"append"`
+	NetworkProfile        string                    `yaml:"network_profile"`
+	BaselineProfile       string                    `yaml:"baseline_profile"`
+	ResultsDir            string                    `yaml:"results_dir"`
+	KeepLast              int                       `yaml:"keep_last"`
+	KeepDays              int                       `yaml:"keep_days"`
+	History               string                    `yaml:"history"`
+	HistoryWindow         int                       `yaml:"history_window"`
+	RegressionThreshold   float64                   `yaml:"regression_threshold"`
+	VerifyRoute           bool                      `yaml:"verify_route"`
+	IfaceCounters         bool                      `yaml:"iface_counters"`
+	CPU                   bool                      `yaml:"cpu"`
+	MTUSweep              bool                      `yaml:"mtu_sweep"`
+	Tags                  map[string]string         `yaml:"tags"`
+	PushURL               string                    `yaml:"push_url"`
+	PushSamples           bool                      `yaml:"push_samples"`
+	PushHeaders           []string                  `yaml:"push_headers"`
+	AcceptLicense         *bool                     `yaml:"accept_license"`
+	SpeedtestBin          string                    `yaml:"speedtest_bin"`
+	SpeedtestArgs         string                    `yaml:"speedtest_args"`
+	VpnctlBin             string                    `yaml:"vpnctl_bin"`
+	VpnctlArgs            string                    `yaml:"vpnctl_args"`
+	Shuffle               bool                      `yaml:"shuffle"`
+	Seed                  uint64                    `yaml:"seed"`
+	TimesOfDay            []string                  `yaml:"times_of_day"`
+	Days                  int                       `yaml:"days"`
+	MaxData               float64                   `yaml:"max_data"`
+	MaxDuration           string                    `yaml:"max_duration"`
+	LatencyOnly           bool                      `yaml:"latency_only"`
+	LatencyTarget         string                    `yaml:"latency_target"`
+	Tests                 []string                  `yaml:"tests"`
+	WebURLs               []string                  `yaml:"web_urls"`
+	PingMonitor           string                    `yaml:"ping_monitor"`
+	PingInterval          string                    `yaml:"ping_interval"`
+	PingTimeline          string                    `yaml:"ping_timeline"`
+	NetworkLock           string                    `yaml:"network_lock"`
+	Redact                bool                      `yaml:"redact"`
+	Encrypt               string                    `yaml:"encrypt"`
+	ASCII                 bool                      `yaml:"ascii"`
+	Plain                 bool                      `yaml:"plain"`
+	Locations             []results.Location        `yaml:"locations"`
+}
+
+// Returns ~/.config/expressvpnspeedtest/config.yaml, or an empty string if there is no home directory
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "expressvpnspeedtest", "config.yaml")
+}
+
+// Loads the config file; a missing file is only an error when it was requested explicitly
+func loadConfig(fileName string, explicit bool) (Config, error) {
+	var config Config
+	if fileName == "" {
+		return config, nil
+	}
+
+	file, err := os.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return config, nil
+		}
+		return config, err
+	}
+
+	err = yaml.Unmarshal(file, &config)
+	return config, err
+}
+
+var commands = command.Overrides{} // How the binaries are run, from the commands section and -speedtest-bin/-vpnctl-bin
+
+// Applies the settings that have no command-line flag
+func (c Config) apply() {
+	if c.Path != "" {
+		// Both the lookup of binaries and the commands themselves see the override
+		os.Setenv("PATH", c.Path)
+	}
+	for name, override := range c.Commands {
+		commands[name] = override
+	}
+}
+
+var speedtestBin, vpnctlBin string   // Paths of the speedtest and expressvpnctl binaries, for when they aren't in PATH
+var speedtestArgs, vpnctlArgs string // Extra arguments for every speedtest and expressvpnctl invocation
+
+// Applies -speedtest-bin, -vpnctl-bin and their extra arguments over the commands section of the config file
+func applyBinaryFlags() {
+	overrideCommand("speedtest", speedtestBin, speedtestArgs)
+	overrideCommand("expressvpnctl", vpnctlBin, vpnctlArgs)
+}
+
+// Sets the path and space-separated extra arguments of a command, keeping its other overrides
+func overrideCommand(name string, path string, args string) {
+	if path == "" && args == "" {
+		return
+	}
+	override := commands[name]
+	if path != "" {
+		override.Path = path
+	}
+	if args != "" {
+		override.Args = strings.Fields(args)
+	}
+	commands[name] = override
+}
+
+// Maps the config values that are set to the flags they provide defaults for
+func (c Config) flagValues() map[string]string {
+	values := map[string]string{}
+	if c.Samples != 0 {
+		values["r"] = strconv.Itoa(c.Samples)
+	}
+	if c.Series {
+		values["s"] = "true"
+	}
+	if c.Mode != "" {
+		values["mode"] = c.Mode
+	}
+	if c.AutoTune {
+		values["auto-tune"] = "true"
+	}
+	if c.Namespaces != 0 {
+		values["namespaces"] = strconv.Itoa(c.Namespaces)
+	}
+	if c.Output != "" {
+		values["output"] = c.Output
+	}
+	if c.Progress != "" {
+		values["progress"] = c.Progress
+	}
+	if c.PublicReport != "" {
+		values["public-report"] = c.PublicReport
+	}
+	if c.PublicRound != 0 {
+		values["public-round"] = strconv.FormatFloat(c.PublicRound, 'f', -1, 64)
+	}
+	if len(c.DNSDomains) > 0 {
+		values["dns"] = strings.Join(c.DNSDomains, ",")
+	}
+	if c.ProbeName != "" {
+		values["probe-name"] = c.ProbeName
+	}
+	if c.VerifyIP {
+		values["verify-ip"] = "true"
+	}
+	if c.IPCheckURL != "" {
+		values["ip-check-url"] = c.IPCheckURL
+	}
+	if c.Engine != "" {
+		values["engine"] = c.Engine
+	}
+	if c.TopMovers != 0 {
+		values["top-movers"] = strconv.Itoa(c.TopMovers)
+	}
+	if c.Warmup != 0 {
+		values["warmup"] = strconv.Itoa(c.Warmup)
+	}
+	if c.PauseBetweenTests != "" {
+		values["pause-between-tests"] = c.PauseBetweenTests
+	}
+	if c.PauseBetweenLocations != "" {
+		values["pause-between-locations"] = c.PauseBetweenLocations
+	}
+	if c.IgnoreConflicts {
+		values["ignore-conflicts"] = "true"
+	}
+	if c.HTMLReport != "" {
+		values["html-report"] = c.HTMLReport
+	}
+	if c.Bundle != "" {
+		values["bundle"] = c.Bundle
+	}
+	if c.NotifyURL != "" {
+		values["notify-url"] = c.NotifyURL
+	}
+	if c.FailFast {
+		values["fail-fast"] = "true"
+	}
+	if c.CrossCheck != "" {
+		values["cross-check"] = c.CrossCheck
+	}
+	if c.CrossCheckTolerance != 0 {
+		values["cross-check-tolerance"] = strconv.FormatFloat(c.CrossCheckTolerance, 'f', -1, 64)
+	}
+	if c.Soak != "" {
+		values["soak"] = c.Soak
+	}
+	if c.SoakInterval != "" {
+		values["soak-interval"] = c.SoakInterval
+	}
+	if c.ConnectCycles != 0 {
+		values["connect-cycles"] = strconv.Itoa(c.ConnectCycles)
+	}
+	if c.ConnectPhases {
+		values["connect-phases"] = "true"
+	}
+	if c.ConnectTimeout != "" {
+		values["connect-timeout"] = c.ConnectTimeout
+	}
+	if c.LocationTimeout != "" {
+		values["location-timeout"] = c.LocationTimeout
+	}
+	if len(c.Probes) > 0 {
+		var lines []string
+		for _, name := range slices.Sorted(maps.Keys(c.Probes)) {
+			lines = append(lines, name+"="+c.Probes[name])
+		}
+		values["probe"] = strings.Join(lines, "\n")
+	}
+	if c.PreHook != "" {
+		values["pre-hook"] = c.PreHook
+	}
+	if c.PostHook != "" {
+		values["post-hook"] = c.PostHook
+	}
+	if c.RouterConnect != "" {
+		values["router-connect"] = c.RouterConnect
+	}
+	if c.RouterDisconnect != "" {
+		values["router-disconnect"] = c.RouterDisconnect
+	}
+	if c.RouterStatus != "" {
+		values["router-status"] = c.RouterStatus
+	}
+	if c.Units != "" {
+		values["units"] = c.Units
+	}
+	if c.LocalTime {
+		values["local-time"] = "true"
+	}
+	if c.IperfServer != "" {
+		values["iperf-server"] = c.IperfServer
+	}
+	if c.HTTPDownloadURL != "" {
+		values["http-download-url"] = c.HTTPDownloadURL
+	}
+	if c.HTTPUploadURL != "" {
+		values["http-upload-url"] = c.HTTPUploadURL
+	}
+	if c.IPVersion != 0 {
+		values["ip-version"] = strconv.Itoa(c.IPVersion)
+	}
+	if c.IPv6Check {
+		values["ipv6-check"] = "true"
+	}
+	if c.HTTPSize != 0 {
+		values["http-size"] = strconv.FormatInt(c.HTTPSize, 10)
+	}
+	if c.Geo {
+		values["geo"] = "true"
+	}
+	if c.TracerouteTarget != "" {
+		values["traceroute-target"] = c.TracerouteTarget
+	}
+	if c.TUI {
+		values["tui"] = "true"
+	}
+	if c.Results != "" {
+		values["results"] = c.Results
+	}
+	if c.Append {
+		values["append"] = "true"
+	}
+	if c.NetworkProfile != "" {
+		values["network-profile"] = c.NetworkProfile
+	}
+	if c.BaselineProfile != "" {
+		values["baseline-profile"] = c.BaselineProfile
+	}
+	if c.ResultsDir != "" {
+		values["results-dir"] = c.ResultsDir
+	}
+	if c.KeepLast != 0 {
+		values["keep-last"] = strconv.Itoa(c.KeepLast)
+	}
+	if c.KeepDays != 0 {
+		values["keep-days"] = strconv.Itoa(c.KeepDays)
+	}
+	if c.History != "" {
+		values["history"] = c.History
+	}
+	if c.HistoryWindow != 0 {
+		values["history-window"] = strconv.Itoa(c.HistoryWindow)
+	}
+	if c.RegressionThreshold != 0 {
+		values["regression-threshold"] = strconv.FormatFloat(c.RegressionThreshold, 'f', -1, 64)
+	}
+	if c.VerifyRoute {
+		values["verify-route"] = "true"
+	}
+	if c.IfaceCounters {
+		values["iface-counters"] = "true"
+	}
+	if c.CPU {
+		values["cpu"] = "true"
+	}
+	if c.MTUSweep {
+		values["mtu-sweep"] = "true"
+	}
+	if len(c.Tags) > 0 {
+		values["tag"] = tagMap(c.Tags).String()
+	}
+	if c.PushURL != "" {
+		values["push-url"] = c.PushURL
+	}
+	if c.PushSamples {
+		values["push-samples"] = "true"
+	}
+	if len(c.PushHeaders) > 0 {
+		values["push-header"] = strings.Join(c.PushHeaders, "\n")
+	}
+	if c.AcceptLicense != nil {
+		values["accept-license"] = strconv.FormatBool(*c.AcceptLicense)
+	}
+	if c.SpeedtestBin != "" {
+		values["speedtest-bin"] = c.SpeedtestBin
+	}
+	if c.SpeedtestArgs != "" {
+		values["speedtest-args"] = c.SpeedtestArgs
+	}
+	if c.VpnctlBin != "" {
+		values["vpnctl-bin"] = c.VpnctlBin
+	}
+	if c.VpnctlArgs != "" {
+		values["vpnctl-args"] = c.VpnctlArgs
+	}
+	if c.Shuffle {
+		values["shuffle"] = "true"
+	}
+	if c.Seed != 0 {
+		values["seed"] = strconv.FormatUint(c.Seed, 10)
+	}
+	if len(c.TimesOfDay) > 0 {
+		values["times-of-day"] = strings.Join(c.TimesOfDay, ",")
+	}
+	if c.Days != 0 {
+		values["days"] = strconv.Itoa(c.Days)
+	}
+	if c.MaxData != 0 {
+		values["max-data"] = strconv.FormatFloat(c.MaxData, 'f', -1, 64)
+	}
+	if c.MaxDuration != "" {
+		values["max-duration"] = c.MaxDuration
+	}
+	if c.LatencyOnly {
+		values["latency-only"] = "true"
+	}
+	if c.LatencyTarget != "" {
+		values["latency-target"] = c.LatencyTarget
+	}
+	if len(c.Tests) > 0 {
+		values["tests"] = strings.Join(c.Tests, ",")
+	}
+	if len(c.WebURLs) > 0 {
+		values["web"] = strings.Join(c.WebURLs, ",")
+	}
+	if c.PingMonitor != "" {
+		values["ping-monitor"] = c.PingMonitor
+	}
+	if c.PingInterval != "" {
+		values["ping-interval"] = c.PingInterval
+	}
+	if c.PingTimeline != "" {
+		values["ping-timeline"] = c.PingTimeline
+	}
+	if c.NetworkLock != "" {
+		values["network-lock"] = c.NetworkLock
+	}
+	if c.Redact {
+		values["redact"] = "true"
+	}
+	if c.Encrypt != "" {
+		values["encrypt"] = c.Encrypt
+	}
+	if c.ASCII {
+		values["ascii"] = "true"
+	}
+	if c.Plain {
+		values["plain"] = "true"
+	}
+	if c.Quiet {
+		values["q"] = "true"
+	}
+	if c.Verbose {
+		values["v"] = "true"
+	}
+	return values
+}
+
+// Applies config values to every flag not given explicitly on the command line
+func applyConfig(c Config, flags *flag.FlagSet) error {
+	setFlags := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	for name, value := range c.flagValues() {
+		if setFlags[name] {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
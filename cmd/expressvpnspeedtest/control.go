@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/runner"
+)
+
+// Run flags a controller may pass: those tuning the tests and what they record. Flags naming commands,
+// files or URLs are left out, since whoever holds the token would otherwise run commands, read and write
+// files or send results anywhere as the user serve-control runs as; -results, -progress and -output are
+// set by the controller itself
+var controlFlags = []string{
+	"s", "r", "mode", "auto-tune", "q", "v", "vv", "engine", "http-size", "ip-version", "iperf-server",
+	"tests", "latency-only", "latency-target", "accept-license", "warmup", "pause-between-tests",
+	"pause-between-locations", "connect-timeout", "connect-cycles", "connect-phases", "location-timeout",
+	"fail-fast", "once", "shuffle", "seed", "max-data", "max-duration", "soak", "soak-interval",
+	"cross-check", "cross-check-tolerance", "verify-ip", "verify-route", "ipv6-check", "mtu-sweep", "cpu",
+	"iface-counters", "geo", "dns", "ignore-conflicts", "network-lock", "probe-name", "tag", "time-window",
+	"network-profile", "baseline-profile", "redact", "units", "local-time", "ascii", "plain", "top-movers",
+}
+
+// The controlFlags that take no value, so the argument after them is not theirs
+var controlBoolFlags = []string{
+	"s", "auto-tune", "q", "v", "vv", "latency-only", "accept-license", "connect-phases", "fail-fast", "shuffle",
+	"verify-ip", "verify-route", "ipv6-check", "mtu-sweep", "cpu", "iface-counters", "geo", "ignore-conflicts",
+	"redact", "local-time", "ascii", "plain",
+}
+
+// Controller lets an external orchestrator drive benchmarks on this machine over HTTP: start a run,
+// poll its status, stream its samples and abort it. Runs are child processes of this binary, one at a
+// time since they share the VPN connection, each with its files in a directory of its own
+type Controller struct {
+	Dir        string
+	Token      string // Required as "Authorization: Bearer TOKEN" when set
+	Executable string // Binary started for each run; empty for this one
+
+	mutex sync.Mutex
+	runs  map[string]*controlledRun
+}
+
+// controlledRun is one run started through the controller
+type controlledRun struct {
+	id      string
+	args    []string
+	dir     string
+	started time.Time
+	cmd     *exec.Cmd
+
+	mutex    sync.Mutex // Guards the fields below
+	output   []byte     // What the run printed to stdout: its samples as JSON lines
+	changed  chan struct{}
+	finished time.Time
+	exitCode int
+	aborted  bool
+}
+
+// ControlStartRequest starts a run with the run command's flags; the input file, only accepted inline,
+// is written next to the run's results and passed as its last argument
+type ControlStartRequest struct {
+	Args  []string        `json:"args"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// ControlRunStatus is the state of a run started through the controller
+type ControlRunStatus struct {
+	ID          string           `json:"id"`
+	Args        []string         `json:"args"`
+	State       string           `json:"state"` // running, completed, failed or aborted
+	Started     string           `json:"started"`
+	Finished    string           `json:"finished,omitempty"`
+	ExitCode    *int             `json:"exitCode,omitempty"`
+	ResultsFile string           `json:"resultsFile"`
+	Progress    *runner.Progress `json:"progress,omitempty"` // The run's -progress file, once it has written one
+}
+
+// Runs the serve-control subcommand: expressvpnspeedtest serve-control [-listen :8090] [-dir DIR] [-token TOKEN]
+func runServeControl(args []string) {
+	flags := flag.NewFlagSet("serve-control", flag.ExitOnError)
+	listen := flags.String("listen", ":8090", "Address to accept control requests on")
+	dir := flags.String("dir", "control", "Directory the runs' results, progress and logs are kept in, one subdirectory per run")
+	token := flags.String("token", "", "Require \"Authorization: Bearer TOKEN\" on every request")
+	parseFlags(flags, args)
+
+	if err := checkListenToken(*listen, *token); err != nil {
+		fatal("Refusing to accept runs from the network without a token", "err", err)
+	}
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		fatal("Failed to create control directory", "path", *dir, "err", err)
+	}
+	controller := &Controller{Dir: *dir, Token: *token}
+	logger.Info("Control API listening", "addr", *listen, "dir", *dir)
+	if err := http.ListenAndServe(*listen, controller.Handler()); err != nil {
+		fatal("Control API stopped", "err", err)
+	}
+}
+
+// Returns the control API's routes, all under /api/runs
+func (c *Controller) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/runs", c.handleStart)
+	mux.HandleFunc("GET /api/runs", c.handleList)
+	mux.HandleFunc("GET /api/runs/{id}", c.handleStatus)
+	mux.HandleFunc("GET /api/runs/{id}/samples", c.handleSamples)
+	mux.HandleFunc("POST /api/runs/{id}/abort", c.handleAbort)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+c.Token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Starts a run unless one is still running
+func (c *Controller) handleStart(w http.ResponseWriter, r *http.Request) {
+	var request ControlStartRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushSize)).Decode(&request); err != nil {
+		http.Error(w, "invalid start request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkControlArgs(request.Args); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.Input) > 0 {
+		input, err := sanitizeControlInput(request.Input)
+		if err != nil {
+			http.Error(w, "invalid input: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		request.Input = input
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, run := range c.runs {
+		if run.running() {
+			http.Error(w, "run "+run.id+" is still running", http.StatusConflict)
+			return
+		}
+	}
+	run, err := c.start(request)
+	if err != nil {
+		logger.Error("Failed to start run", "err", err)
+		http.Error(w, "failed to start run: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("Run started", "id", run.id, "args", strings.Join(run.args, " "), "remote", r.RemoteAddr)
+	w.Header().Set("Location", "/api/runs/"+run.id)
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, run.status())
+}
+
+// Rejects the flags a controller may not pass, and positional arguments: the input file would be read
+// from anywhere on the machine, so it only comes as the request's input. Every argument starting with a
+// dash is taken for a flag, including the values of flags, which may refuse a harmless value but never
+// lets a flag through
+func checkControlArgs(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("%q: the input file can't be passed in args, send its content as input", arg)
+		}
+		name, _, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if !slices.Contains(controlFlags, name) {
+			return fmt.Errorf("-%s can't be passed through the control API", name)
+		}
+		if !hasValue && !slices.Contains(controlBoolFlags, name) && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			i++ // Its value
+		}
+	}
+	return nil
+}
+
+// Drops the per-location hooks of an inline input file and refuses command providers, which would run
+// the shell commands of whoever holds the token
+func sanitizeControlInput(data []byte) ([]byte, error) {
+	var input results.InputData
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, err
+	}
+	stripHooks := func(locations []results.Location) {
+		for i := range locations {
+			locations[i].PreHook, locations[i].PostHook = "", ""
+		}
+	}
+	stripHooks(input.Locations)
+	for i, provider := range input.Providers {
+		if provider.Type == "command" {
+			return nil, fmt.Errorf("provider %q: command providers can't be started through the control API", provider.Name)
+		}
+		stripHooks(input.Providers[i].Locations)
+	}
+	return json.Marshal(input)
+}
+
+// Starts the run's process, its stdout kept for streaming and its stderr logged to run.log; the caller
+// holds the controller's mutex
+func (c *Controller) start(request ControlStartRequest) (*controlledRun, error) {
+	run := &controlledRun{id: runner.NewUUID(), started: time.Now(), changed: make(chan struct{})}
+	run.dir = filepath.Join(c.Dir, run.id)
+	if err := os.MkdirAll(run.dir, 0700); err != nil {
+		return nil, err
+	}
+	run.args = request.Args
+	if len(request.Input) > 0 {
+		inputFile := filepath.Join(run.dir, "input.json")
+		if err := os.WriteFile(inputFile, request.Input, 0600); err != nil {
+			return nil, err
+		}
+		run.args = append(slices.Clone(run.args), inputFile)
+	}
+
+	executable := c.Executable
+	if executable == "" {
+		var err error
+		if executable, err = os.Executable(); err != nil {
+			return nil, err
+		}
+	}
+	log, err := os.OpenFile(filepath.Join(run.dir, "run.log"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// Flags after the input file wouldn't be parsed, so they go first
+	args := append([]string{"run", "-results", filepath.Join(run.dir, "results.json"),
+		"-progress", filepath.Join(run.dir, "progress.json"), "-output", "ndjson"}, run.args...)
+	run.cmd = exec.Command(executable, args...)
+	run.cmd.Stdout, run.cmd.Stderr = run, log
+	if err := run.cmd.Start(); err != nil {
+		log.Close()
+		return nil, err
+	}
+
+	if c.runs == nil {
+		c.runs = map[string]*controlledRun{}
+	}
+	c.runs[run.id] = run
+	go func() {
+		err := run.cmd.Wait()
+		log.Close()
+		run.mutex.Lock()
+		run.finished = time.Now()
+		run.exitCode = run.cmd.ProcessState.ExitCode()
+		run.notify()
+		run.mutex.Unlock()
+		logger.Info("Run finished", "id", run.id, "exitCode", run.exitCode, "err", err)
+	}()
+	return run, nil
+}
+
+// Keeps what the run prints and wakes the clients streaming it
+func (run *controlledRun) Write(p []byte) (int, error) {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	run.output = append(run.output, p...)
+	run.notify()
+	return len(p), nil
+}
+
+// Wakes the clients waiting for output or the end of the run; the caller holds the run's mutex
+func (run *controlledRun) notify() {
+	close(run.changed)
+	run.changed = make(chan struct{})
+}
+
+func (run *controlledRun) running() bool {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	return run.finished.IsZero()
+}
+
+func (run *controlledRun) status() ControlRunStatus {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+
+	status := ControlRunStatus{
+		ID:          run.id,
+		Args:        run.args,
+		State:       "running",
+		Started:     results.FormatTime(run.started),
+		ResultsFile: filepath.Join(run.dir, "results.json"),
+	}
+	if !run.finished.IsZero() {
+		status.Finished = results.FormatTime(run.finished)
+		status.ExitCode = &run.exitCode
+		switch {
+		case run.aborted:
+			status.State = "aborted"
+		case run.exitCode == runner.ExitOK:
+			status.State = "completed"
+		default:
+			status.State = "failed"
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(run.dir, "progress.json")); err == nil {
+		var progress runner.Progress
+		if json.Unmarshal(data, &progress) == nil {
+			status.Progress = &progress
+		}
+	}
+	return status
+}
+
+// Returns a run by the ID in the path, or answers 404
+func (c *Controller) lookup(w http.ResponseWriter, r *http.Request) *controlledRun {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	run, ok := c.runs[r.PathValue("id")]
+	if !ok {
+		http.NotFound(w, r)
+	}
+	return run
+}
+
+// Lists the runs started since the controller started, oldest first
+func (c *Controller) handleList(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	runs := slices.Collect(maps.Values(c.runs))
+	c.mutex.Unlock()
+	slices.SortFunc(runs, func(a, b *controlledRun) int { return a.started.Compare(b.started) })
+	statuses := []ControlRunStatus{}
+	for _, run := range runs {
+		statuses = append(statuses, run.status())
+	}
+	writeJSON(w, statuses)
+}
+
+func (c *Controller) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if run := c.lookup(w, r); run != nil {
+		writeJSON(w, run.status())
+	}
+}
+
+// Streams the run's samples as JSON lines from its start, following them until the run ends or the
+// client goes away
+func (c *Controller) handleSamples(w http.ResponseWriter, r *http.Request) {
+	run := c.lookup(w, r)
+	if run == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	sent := 0
+	for {
+		run.mutex.Lock()
+		pending := run.output[sent:]
+		done := !run.finished.IsZero()
+		changed := run.changed
+		run.mutex.Unlock()
+
+		if len(pending) > 0 {
+			if _, err := w.Write(pending); err != nil {
+				return
+			}
+			sent += len(pending)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Aborts the run after its current step, like Ctrl+C; with ?now=true, or where interrupts can't be
+// sent (Windows), it is killed right away and may leave the VPN connected
+func (c *Controller) handleAbort(w http.ResponseWriter, r *http.Request) {
+	run := c.lookup(w, r)
+	if run == nil {
+		return
+	}
+	if !run.running() {
+		http.Error(w, "run "+run.id+" already finished", http.StatusConflict)
+		return
+	}
+
+	run.mutex.Lock()
+	run.aborted = true
+	run.mutex.Unlock()
+	var err error
+	if r.URL.Query().Get("now") == "true" || runtime.GOOS == "windows" {
+		err = run.cmd.Process.Kill()
+	} else {
+		err = run.cmd.Process.Signal(os.Interrupt)
+	}
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		http.Error(w, "failed to abort run: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("Run aborted", "id", run.id, "remote", r.RemoteAddr)
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, run.status())
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Reads the passphrase from a key file, or from the environment variable NAME for env:NAME, so it
+// never shows up in the process list or the run's recorded flags
+func loadPassphrase(source string) ([]byte, error) {
+	var passphrase string
+	if name, ok := strings.CutPrefix(source, "env:"); ok {
+		passphrase = os.Getenv(name)
+	} else {
+		file, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		passphrase = string(file)
+	}
+	passphrase = strings.TrimSpace(passphrase)
+	if passphrase == "" {
+		return nil, errors.New("the passphrase is empty")
+	}
+	return []byte(passphrase), nil
+}
+
+// Makes results files be saved encrypted and loaded decrypted with the -encrypt passphrase, if one is set
+func applyEncryption(files *results.FileOptions, source string) {
+	if source == "" {
+		return
+	}
+	passphrase, err := loadPassphrase(source)
+	if err != nil {
+		fatal("Failed to read the encryption passphrase", "source", source, "err", err)
+	}
+	files.Passphrase = passphrase
+}
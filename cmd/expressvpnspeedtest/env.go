@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Prefix of the environment variables that set flags, for containers and CI
+const envPrefix = "EVST_"
+
+// Returns the environment variable that sets a flag, e.g. EVST_CONNECT_TIMEOUT for -connect-timeout
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Sets every flag not given on the command line from its environment variable, so the command line
+// overrides the environment, which overrides the config file. Returns the EVST_ variables that match no flag
+func applyEnv(flags *flag.FlagSet, environ []string) ([]string, error) {
+	setFlags := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+	names := map[string]string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		names[envName(f.Name)] = f.Name
+	})
+
+	var unknown []string
+	for _, entry := range environ {
+		key, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(key, envPrefix) {
+			continue
+		}
+		name, ok := names[key]
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		if setFlags[name] {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return unknown, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return unknown, nil
+}
+
+// Parses the arguments, then sets the flags not given from the environment; returns the EVST_
+// variables that match no flag
+func parseFlags(flags *flag.FlagSet, args []string) []string {
+	flags.Parse(args)
+	unknown, err := applyEnv(flags, os.Environ())
+	if err != nil {
+		fatal("Invalid environment variable", "err", err)
+	}
+	return unknown
+}
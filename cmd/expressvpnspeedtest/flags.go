@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/runner"
+)
+
+// tagMap collects repeated or comma-separated -tag key=value values
+type tagMap map[string]string
+
+func (t tagMap) String() string {
+	var pairs []string
+	for _, key := range slices.Sorted(maps.Keys(t)) {
+		pairs = append(pairs, key+"="+t[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (t tagMap) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, tagValue, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		t[strings.TrimSpace(key)] = strings.TrimSpace(tagValue)
+	}
+	return nil
+}
+
+// headerList collects repeated "Name: value" -push-header values; newlines separate several in one value,
+// as the config file and environment pass them
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, "\n")
+}
+
+func (h *headerList) Set(value string) error {
+	for _, header := range strings.Split(value, "\n") {
+		if header = strings.TrimSpace(header); header == "" {
+			continue
+		}
+		if name, _, ok := strings.Cut(header, ":"); !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid header %q, expected \"Name: value\"", header)
+		}
+		*h = append(*h, header)
+	}
+	return nil
+}
+
+// probeList is the -probe flag, NAME=COMMAND per line since commands may contain commas
+type probeList []runner.Probe
+
+func (p *probeList) String() string {
+	var lines []string
+	for _, pr := range *p {
+		lines = append(lines, pr.Name+"="+pr.Command)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *probeList) Set(value string) error {
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		name, cmd, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(cmd) == "" {
+			return fmt.Errorf("invalid probe %q, expected NAME=COMMAND", line)
+		}
+		*p = append(*p, runner.Probe{Name: strings.TrimSpace(name), Command: strings.TrimSpace(cmd)})
+	}
+	return nil
+}
+
+// outputList collects repeated or comma-separated -output values
+type outputList []string
+
+func (o *outputList) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *outputList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*o = append(*o, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// grafanaMetric is a number of every stored location result that Grafana can plot
+type grafanaMetric struct {
+	name  string
+	value func(stat results.VPNStat) float64
+}
+
+var grafanaMetrics = []grafanaMetric{
+	{"download_mbps", func(stat results.VPNStat) float64 { return results.ParseMbps(stat.VPNDownloadSpeed) }},
+	{"upload_mbps", func(stat results.VPNStat) float64 { return results.ParseMbps(stat.VPNUploadSpeed) }},
+	{"latency_ms", func(stat results.VPNStat) float64 { return results.ParseUnit(stat.VPNLatency, "ms") }},
+	{"jitter_ms", func(stat results.VPNStat) float64 { return results.ParseUnit(stat.VPNJitter, "ms") }},
+	{"packet_loss_percent", func(stat results.VPNStat) float64 { return results.ParseUnit(stat.VPNPacketLoss, "%") }},
+	{"connect_seconds", func(stat results.VPNStat) float64 {
+		duration, _ := time.ParseDuration(stat.TimeToConnect)
+		return duration.Seconds()
+	}},
+}
+
+// grafanaRange is the dashboard's time range, sent with queries and annotation requests
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Reports whether t falls in the range; an unset bound doesn't limit it
+func (r grafanaRange) contains(t time.Time) bool {
+	return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || !t.After(r.To))
+}
+
+// grafanaSeries is a time series in the JSON datasource's format: [value, Unix milliseconds] pairs
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// grafanaAnnotation marks a stored run on the dashboard
+type grafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation,omitempty"` // The request's annotation, echoed for older versions of the datasource
+	Time       int64           `json:"time"`
+	TimeEnd    int64           `json:"timeEnd,omitempty"`
+	Title      string          `json:"title"`
+	Text       string          `json:"text,omitempty"`
+	Tags       []string        `json:"tags,omitempty"`
+}
+
+// Adds the routes of Grafana's JSON datasource under /grafana, so dashboards can plot the stored history
+// without a database in between
+func (c *Collector) addGrafanaRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /grafana/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK) // The datasource's connection test
+	})
+	mux.HandleFunc("POST /grafana/search", c.handleGrafanaSearch)
+	mux.HandleFunc("POST /grafana/metrics", c.handleGrafanaSearch)
+	mux.HandleFunc("POST /grafana/query", c.handleGrafanaQuery)
+	mux.HandleFunc("POST /grafana/annotations", c.handleGrafanaAnnotations)
+}
+
+// Lists the targets a panel can query: every metric over all locations, and every metric of one location as
+// METRIC:LOCATION; /metrics, which newer versions of the datasource call, gets them as label/value pairs
+func (c *Collector) handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Target string `json:"target"`
+		Metric string `json:"metric"`
+	}
+	json.NewDecoder(r.Body).Decode(&request) // The filter is optional
+	stored, err := c.load(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var locations []string
+	for _, data := range stored {
+		for _, stat := range data.VPNStats {
+			locations = append(locations, stat.LocationName)
+		}
+	}
+	slices.Sort(locations)
+	locations = slices.Compact(locations)
+
+	filter := strings.ToLower(request.Target + request.Metric)
+	targets := []string{}
+	for _, metric := range grafanaMetrics {
+		for _, target := range append([]string{metric.name}, prefixAll(metric.name+":", locations)...) {
+			if strings.Contains(strings.ToLower(target), filter) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	if strings.HasSuffix(r.URL.Path, "/metrics") {
+		options := []map[string]string{}
+		for _, target := range targets {
+			options = append(options, map[string]string{"label": target, "value": target})
+		}
+		writeJSON(w, options)
+		return
+	}
+	writeJSON(w, targets)
+}
+
+func prefixAll(prefix string, values []string) []string {
+	prefixed := make([]string, len(values))
+	for i, value := range values {
+		prefixed[i] = prefix + value
+	}
+	return prefixed
+}
+
+// Returns a series per location and machine for each target, over the dashboard's time range
+func (c *Collector) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Range   grafanaRange `json:"range"`
+		Targets []struct {
+			Target string `json:"target"`
+			Hide   bool   `json:"hide"`
+		} `json:"targets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := c.load(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series := []grafanaSeries{}
+	for _, target := range request.Targets {
+		if target.Hide || target.Target == "" {
+			continue
+		}
+		name, location, _ := strings.Cut(target.Target, ":")
+		i := slices.IndexFunc(grafanaMetrics, func(m grafanaMetric) bool { return m.name == name })
+		if i < 0 {
+			http.Error(w, fmt.Sprintf("unknown metric %q", name), http.StatusBadRequest)
+			return
+		}
+		series = append(series, querySeries(stored, grafanaMetrics[i], location, request.Range)...)
+	}
+	writeJSON(w, series)
+}
+
+// Collects the metric of every stored result of the location, or of all locations, in the range, one
+// series per location and machine sorted by name, each sorted by time
+func querySeries(stored map[runKey]results.Results, metric grafanaMetric, location string, timeRange grafanaRange) []grafanaSeries {
+	points := map[string][][2]float64{}
+	for _, data := range stored {
+		for _, stat := range data.VPNStats {
+			if location != "" && stat.LocationName != location {
+				continue
+			}
+			tested := stat.Tested()
+			if tested.IsZero() || !timeRange.contains(tested) {
+				continue
+			}
+			name := fmt.Sprintf("%s %s (%s)", metric.name, stat.LocationName, data.MachineName)
+			points[name] = append(points[name], [2]float64{metric.value(stat), float64(tested.UnixMilli())})
+		}
+	}
+
+	series := []grafanaSeries{}
+	for name, datapoints := range points {
+		slices.SortFunc(datapoints, func(a, b [2]float64) int { return cmp.Compare(a[1], b[1]) })
+		series = append(series, grafanaSeries{Target: name, Datapoints: datapoints})
+	}
+	slices.SortFunc(series, func(a, b grafanaSeries) int { return strings.Compare(a.Target, b.Target) })
+	return series
+}
+
+// Marks every stored run that started in the range, tagged with its machine and -tag pairs
+func (c *Collector) handleGrafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Range      grafanaRange    `json:"range"`
+		Annotation json.RawMessage `json:"annotation"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid annotation request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	stored, err := c.load(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	annotations := []grafanaAnnotation{}
+	for key, data := range stored {
+		if data.RunInfo == nil {
+			continue
+		}
+		started, err := results.ParseTime(data.RunInfo.Started)
+		if err != nil || !request.Range.contains(started) {
+			continue
+		}
+		annotation := grafanaAnnotation{
+			Annotation: request.Annotation,
+			Time:       started.UnixMilli(),
+			Title:      fmt.Sprintf("Run %s on %s", key.ID, data.MachineName),
+			Text:       fmt.Sprintf("%d locations, %s without VPN", len(data.VPNStats), data.WithoutVPN),
+			Tags:       append([]string{data.MachineName}, strings.Split(tagMap(data.RunInfo.Tags).String(), ",")...),
+		}
+		if finished, err := results.ParseTime(data.RunInfo.Finished); err == nil {
+			annotation.TimeEnd = finished.UnixMilli()
+		}
+		if data.RunInfo.StoppedEarly != "" {
+			annotation.Text += "; stopped early: " + data.RunInfo.StoppedEarly
+		}
+		annotation.Tags = slices.DeleteFunc(annotation.Tags, func(tag string) bool { return tag == "" })
+		annotations = append(annotations, annotation)
+	}
+	slices.SortFunc(annotations, func(a, b grafanaAnnotation) int { return cmp.Compare(a.Time, b.Time) })
+	writeJSON(w, annotations)
+}
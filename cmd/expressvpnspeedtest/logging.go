@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/runner"
+)
+
+// LevelTrace sits below debug and additionally logs raw command output
+const LevelTrace = command.LevelTrace
+
+var logLevel = new(slog.LevelVar)
+var logOptions = &slog.HandlerOptions{Level: logLevel, ReplaceAttr: replaceLevelName}
+var logger *slog.Logger
+var logOutput io.Writer = os.Stderr
+var logCaptures []io.Writer
+
+func init() {
+	resetLogger()
+}
+
+// Additionally writes every log record to w, e.g. to include the log in a bundle
+func captureLogs(w io.Writer) {
+	logCaptures = append(logCaptures, w)
+	resetLogger()
+}
+
+// Sends log records to w instead of stderr; captured copies are unaffected
+func redirectLogs(w io.Writer) {
+	logOutput = w
+	resetLogger()
+}
+
+// Rebuilds the logger; the pkg packages log through the default logger, so it is replaced as well
+func resetLogger() {
+	writers := append([]io.Writer{logOutput}, logCaptures...)
+	logger = slog.New(slog.NewTextHandler(io.MultiWriter(writers...), logOptions))
+	slog.SetDefault(logger)
+}
+
+// Maps the -q, -v and -vv flags to a log level; the most verbose flag wins
+func verbosityLevel(quiet, verbose, veryVerbose bool) slog.Level {
+	switch {
+	case veryVerbose:
+		return LevelTrace
+	case verbose:
+		return slog.LevelDebug
+	case quiet:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Names the custom trace level in log output instead of "DEBUG-4"
+func replaceLevelName(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && a.Value.Any() == LevelTrace {
+		a.Value = slog.StringValue("TRACE")
+	}
+	return a
+}
+
+// Logs an error and exits
+func fatal(msg string, args ...any) {
+	exitWith(runner.ExitError, msg, args...)
+}
+
+// Logs an error and exits with the given code
+func exitWith(code int, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(code)
+}
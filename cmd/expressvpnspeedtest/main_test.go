@@ -0,0 +1,808 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/runner"
+)
+
+func TestInputFileValidation(t *testing.T) {
+	// Test valid input file
+	validInput := results.InputData{
+		Locations: []results.Location{
+			{Country: "Netherlands", City: "Amsterdam"},
+		},
+	}
+	validFile := filepath.Join(t.TempDir(), "valid.json")
+	jsonData, _ := json.MarshalIndent(validInput, "", "  ")
+	err := os.WriteFile(validFile, jsonData, 0644)
+	assert.NoError(t, err)
+
+	// Test invalid input file (malformed JSON)
+	invalidFile := filepath.Join(t.TempDir(), "invalid.json")
+	err = os.WriteFile(invalidFile, []byte("{invalid json}"), 0644)
+	assert.NoError(t, err)
+
+	// Test reading valid file
+	data, err := os.ReadFile(validFile)
+	assert.NoError(t, err)
+
+	var input results.InputData
+	err = json.Unmarshal(data, &input)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(input.Locations))
+
+	// Test reading invalid file
+	data, err = os.ReadFile(invalidFile)
+	assert.NoError(t, err)
+
+	err = json.Unmarshal(data, &input)
+	assert.Error(t, err) // Should fail with JSON parsing error
+}
+
+func TestVerbosityLevel(t *testing.T) {
+	assert.Equal(t, slog.LevelInfo, verbosityLevel(false, false, false))
+	assert.Equal(t, slog.LevelWarn, verbosityLevel(true, false, false))
+	assert.Equal(t, slog.LevelDebug, verbosityLevel(false, true, false))
+	assert.Equal(t, LevelTrace, verbosityLevel(false, true, true))
+	assert.Equal(t, slog.LevelDebug, verbosityLevel(true, true, false))
+}
+
+func TestConfigFile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFile, []byte(`samples: 3
+series: true
+output: ndjson
+locations:
+  - country: Netherlands
+    city: Amsterdam
+`), 0644)
+	assert.NoError(t, err)
+
+	config, err := loadConfig(configFile, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, config.Samples)
+	assert.Equal(t, []results.Location{{Country: "Netherlands", City: "Amsterdam"}}, config.Locations)
+
+	// Flags given on the command line win over the config file
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	series := flags.Bool("s", false, "")
+	repeat := flags.Int("r", 5, "")
+	output := flags.String("output", "text", "")
+	flags.String("progress", "", "")
+	flags.Bool("q", false, "")
+	flags.Bool("v", false, "")
+	assert.NoError(t, flags.Parse([]string{"-r", "10"}))
+	assert.NoError(t, applyConfig(config, flags))
+	assert.True(t, *series)
+	assert.Equal(t, 10, *repeat)
+	assert.Equal(t, "ndjson", *output)
+
+	// A missing default config is fine, a missing explicit one is not
+	_, err = loadConfig(filepath.Join(t.TempDir(), "missing.yaml"), false)
+	assert.NoError(t, err)
+	_, err = loadConfig(filepath.Join(t.TempDir(), "missing.yaml"), true)
+	assert.Error(t, err)
+}
+
+func TestApplyEnv(t *testing.T) {
+	assert.Equal(t, "EVST_CONNECT_TIMEOUT", envName("connect-timeout"))
+	assert.Equal(t, "EVST_R", envName("r"))
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	repeat := flags.Int("r", 5, "")
+	resultsFile := flags.String("results", "", "")
+	timeout := flags.Duration("connect-timeout", time.Minute, "")
+	verify := flags.Bool("verify-ip", false, "")
+	assert.NoError(t, flags.Parse([]string{"-r", "10"}))
+
+	// The command line wins over the environment, which wins over the config file
+	unknown, err := applyEnv(flags, []string{"EVST_R=3", "EVST_RESULTS=/data/results.json", "EVST_CONNECT_TIMEOUT=90s", "EVST_VERIFY_IP=1", "EVST_RESLUTS=x", "HOME=/root"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"EVST_RESLUTS"}, unknown)
+	assert.Equal(t, 10, *repeat)
+	assert.Equal(t, "/data/results.json", *resultsFile)
+	assert.Equal(t, 90*time.Second, *timeout)
+	assert.True(t, *verify)
+
+	assert.NoError(t, applyConfig(Config{Results: "config.json", Samples: 7}, flags))
+	assert.Equal(t, "/data/results.json", *resultsFile)
+	assert.Equal(t, 10, *repeat)
+
+	_, err = applyEnv(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	assert.NoError(t, err)
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("r", 5, "")
+	_, err = applyEnv(flags, []string{"EVST_R=many"})
+	assert.ErrorContains(t, err, "EVST_R")
+}
+
+func TestBinaryFlags(t *testing.T) {
+	origCommands := commands
+	defer func() { commands = origCommands }()
+	defer func() { speedtestBin, speedtestArgs, vpnctlBin, vpnctlArgs = "", "", "", "" }()
+
+	// The flags replace the path of the config file's commands section but keep its environment
+	commands = command.Overrides{"speedtest": {Path: "/usr/bin/speedtest", Env: map[string]string{"HOME": "/tmp"}}}
+	speedtestBin, speedtestArgs = "/opt/ookla/speedtest", "--accept-license  --accept-gdpr"
+	vpnctlArgs = "--verbose"
+	applyBinaryFlags()
+
+	assert.Equal(t, command.Config{Path: "/opt/ookla/speedtest", Env: map[string]string{"HOME": "/tmp"}, Args: []string{"--accept-license", "--accept-gdpr"}}, commands["speedtest"])
+	assert.Equal(t, command.Config{Args: []string{"--verbose"}}, commands["expressvpnctl"])
+	cmd := commands.New("speedtest", "-f", "json-pretty")
+	assert.Equal(t, []string{"/opt/ookla/speedtest", "--accept-license", "--accept-gdpr", "-f", "json-pretty"}, cmd.Args)
+	cmd = commands.New("expressvpnctl", "get", "regions")
+	assert.Equal(t, []string{"expressvpnctl", "--verbose", "get", "regions"}, cmd.Args)
+}
+
+func TestWelchTTest(t *testing.T) {
+	a := []float64{27.5, 21.0, 19.0, 23.6, 17.0, 17.9, 16.9, 20.1, 21.9, 22.6, 23.1, 19.6, 19.0, 21.7, 21.4}
+	b := []float64{27.1, 22.0, 20.8, 23.4, 23.4, 23.5, 25.8, 22.0, 24.8, 20.2, 21.9, 22.1, 22.9, 20.5, 24.4}
+
+	tStat, p, ok := welchTTest(results.NewRunningStats(a...), results.NewRunningStats(b...))
+	assert.True(t, ok)
+	assert.InDelta(t, -2.46, tStat, 0.01)
+	assert.InDelta(t, 0.021, p, 0.001)
+
+	_, _, ok = welchTTest(results.NewRunningStats(100), results.NewRunningStats(b...))
+	assert.False(t, ok)
+}
+
+func TestCompareResults(t *testing.T) {
+	before := results.Results{VPNStats: []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", DownloadSamples: []float64{400, 410, 395, 405, 402}, UploadSamples: []float64{250, 255, 245, 252, 248}},
+		{LocationName: "Romania, Bucharest", VPNDownloadSpeed: "380.00Mbps", VPNUploadSpeed: "340.00Mbps"},
+	}}
+	after := results.Results{VPNStats: []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", DownloadSamples: []float64{300, 310, 295, 305, 298}, UploadSamples: []float64{251, 249, 254, 246, 253}},
+		{LocationName: "Romania, Bucharest", VPNDownloadSpeed: "390.00Mbps", VPNUploadSpeed: "345.00Mbps"},
+	}}
+
+	comparisons := compareResults(before, after, 0.05)
+	assert.Equal(t, 2, len(comparisons))
+	assert.Equal(t, "regressed", comparisons[0].Download.Verdict)
+	assert.Equal(t, "noise", comparisons[0].Upload.Verdict)
+	assert.Equal(t, "insufficient data", comparisons[1].Download.Verdict)
+	assert.InDelta(t, -25.0, comparisons[0].Download.DeltaPercent, 0.5)
+}
+
+func TestCompareWindows(t *testing.T) {
+	dir := t.TempDir()
+	older := results.Results{VPNStats: []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", Timestamp: "2026-09-20T10:00:00Z", DownloadSamples: []float64{400, 410, 395}, UploadSamples: []float64{250, 255, 245}},
+		{LocationName: "Netherlands, Amsterdam", Timestamp: "2026-08-01T10:00:00Z", DownloadSamples: []float64{900}, UploadSamples: []float64{900}},
+	}}
+	newer := results.Results{VPNStats: []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", Timestamp: "2026-10-05T10:00:00Z", DownloadSamples: []float64{300, 310, 295}, UploadSamples: []float64{251, 249, 254}},
+		{LocationName: "Netherlands, Amsterdam", Timestamp: "2026-10-15T00:00:00Z", DownloadSamples: []float64{900}, UploadSamples: []float64{900}},
+		{LocationName: "Japan, Tokyo", DownloadSamples: []float64{100}, UploadSamples: []float64{50}},
+	}}
+	assert.NoError(t, results.Save(older, filepath.Join(dir, "older.json")))
+	assert.NoError(t, results.Save(newer, filepath.Join(dir, "newer.json")))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.json"), []byte("[]"), 0644))
+
+	pooled, err := loadResultsPaths(results.FileOptions{}, []string{dir})
+	assert.NoError(t, err, "Files in a directory that aren't results files are skipped")
+	assert.Len(t, pooled.VPNStats, 5)
+	_, err = loadResultsPaths(results.FileOptions{}, []string{filepath.Join(dir, "notes.json")})
+	assert.Error(t, err, "A results file named on its own must load")
+
+	since, err := parseWindowTime("2026-10-01T00:00:00Z")
+	assert.NoError(t, err)
+	until, err := parseWindowTime("2026-10-15T00:00:00Z")
+	assert.NoError(t, err)
+	before, after := splitWindows(pooled, since, until)
+	assert.Len(t, before.VPNStats, 1, "Only the equally long window before -since is compared with")
+	assert.Equal(t, "2026-09-20T10:00:00Z", before.VPNStats[0].Timestamp)
+	assert.Len(t, after.VPNStats, 1, "-until is exclusive and locations without a time are left out")
+	assert.Equal(t, "2026-10-05T10:00:00Z", after.VPNStats[0].Timestamp)
+
+	comparisons := compareResults(before, after, 0.05)
+	assert.Len(t, comparisons, 1)
+	assert.Equal(t, "regressed", comparisons[0].Download.Verdict)
+
+	day, err := parseWindowTime("2026-10-01")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local), day, "Dates are local midnight")
+	_, err = parseWindowTime("yesterday")
+	assert.Error(t, err)
+}
+
+func TestSubcommands(t *testing.T) {
+	regions := []string{"usa-new-york", "usa-new-jersey-1", "uk-london", ""}
+	assert.Equal(t, []string{"usa-new-york"}, filterRegions(regions, "New York"))
+	assert.Equal(t, []string{"usa-new-york", "usa-new-jersey-1"}, filterRegions(regions, "usa"))
+	assert.Equal(t, []string{"usa-new-york", "usa-new-jersey-1", "uk-london"}, filterRegions(regions, ""))
+
+	input := results.InputData{
+		Aliases: map[string]string{"Docklands": "uk-london"},
+		Locations: []results.Location{
+			{Country: "United States", City: "New York"},
+			{Country: "UK", City: "Docklands"},
+			{Country: "UK", City: "London"},
+			{Country: "France", City: "Paris"},
+		},
+	}
+	list := listRegions(filterRegions(regions, "uk"), regions, input)
+	assert.Equal(t, []RegionInfo{{Region: "uk-london", Locations: []string{"UK, Docklands", "UK, London"}}}, list.Regions)
+	assert.Equal(t, 1, len(list.Unmatched))
+	assert.Equal(t, "France, Paris", list.Unmatched[0].Location)
+	assert.NotEmpty(t, list.Unmatched[0].Suggestions)
+
+	// Without an input file every region is listed unmarked
+	assert.Equal(t, []RegionInfo{{Region: "usa-new-york"}}, listRegions([]string{"usa-new-york"}, regions, results.InputData{}).Regions)
+
+	// Smart resolves to no region and isn't reported as unmatched
+	list = listRegions([]string{"usa-new-york"}, []string{"usa-new-york"}, results.InputData{Locations: []results.Location{{Country: "smart"}}})
+	assert.Empty(t, list.Unmatched)
+	assert.Empty(t, list.Regions[0].Locations)
+
+	data := results.Results{VPNStats: []results.VPNStat{{
+		LocationName:     "Germany, Berlin",
+		TimeToConnect:    "2.1s",
+		VPNDownloadSpeed: "400.00Mbps",
+		VPNUploadSpeed:   "80.00Mbps",
+		VPNLatency:       "21.00ms",
+		VPNJitter:        "1.50ms",
+		VPNPacketLoss:    "0.00%",
+	}}}
+	table := runner.Display{}.ReportTable(data)
+	assert.Equal(t, 2, len(table))
+	assert.Equal(t, []string{"Germany, Berlin", "2.1s", "400.00Mbps", "80.00Mbps", "-", "21.00ms", "1.50ms", "0.00%"}, table[1])
+}
+
+func TestVersion(t *testing.T) {
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+	version, commit, buildDate = "1.2.3", "4f1c2a9", "2025-02-28T09:12:44Z"
+	build := currentBuild()
+	assert.Equal(t, toolBuild{Version: "1.2.3", Commit: "4f1c2a9", Date: "2025-02-28T09:12:44Z", GoVersion: runtime.Version()}, build)
+	assert.Equal(t, "expressvpnspeedtest 1.2.3\ncommit: 4f1c2a9\nbuilt:  2025-02-28T09:12:44Z\ngo:     "+runtime.Version()+" "+runtime.GOOS+"/"+runtime.GOARCH, build.String())
+
+	// Unknown commits and build dates are left out
+	assert.Equal(t, "expressvpnspeedtest dev\ngo:     go1.24.0 "+runtime.GOOS+"/"+runtime.GOARCH, toolBuild{Version: "dev", GoVersion: "go1.24.0"}.String())
+}
+
+func TestCheckListenToken(t *testing.T) {
+	assert.Error(t, checkListenToken(":8080", ""))
+	assert.Error(t, checkListenToken("0.0.0.0:8080", ""))
+	assert.Error(t, checkListenToken("192.168.1.2:8080", ""))
+	assert.Error(t, checkListenToken("8080", ""))
+	assert.NoError(t, checkListenToken(":8080", "secret"))
+	assert.NoError(t, checkListenToken("127.0.0.1:8080", ""))
+	assert.NoError(t, checkListenToken("[::1]:8080", ""))
+	assert.NoError(t, checkListenToken("localhost:8080", ""))
+}
+
+func TestCollector(t *testing.T) {
+	collector := &Collector{Dir: t.TempDir(), Token: "secret"}
+	server := httptest.NewServer(collector.Handler())
+	defer server.Close()
+
+	// Posts a payload as -push-url does
+	push := func(payloadType string, payload any, token string) int {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/results", bytes.NewReader(body))
+		req.Header.Set("X-Payload-Type", payloadType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A file holding two runs, as written with -append
+	data := results.Results{
+		MachineName: "Probe 1",
+		WithoutVPN:  "900Mbps ▼  800Mbps ▲",
+		Runs: []results.Run{
+			{ID: "20250301080000", RunInfo: results.RunInfo{Started: "2025-03-01T08:00:00Z"}, WithoutVPN: "850Mbps ▼  800Mbps ▲"},
+			{ID: "20250302080000", RunInfo: results.RunInfo{Started: "2025-03-02T08:00:00Z", Tags: map[string]string{"office": "nyc"}}, WithoutVPN: "900Mbps ▼  800Mbps ▲"},
+		},
+		VPNStats: []results.VPNStat{
+			{RunID: "20250301080000", LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "100.00Mbps", VPNUploadSpeed: "20.00Mbps", Timestamp: "2025-03-01T08:05:00Z"},
+			{RunID: "20250302080000", LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "200.00Mbps", VPNUploadSpeed: "40.00Mbps", Timestamp: "2025-03-02T08:05:00Z"},
+			{RunID: "20250302080000", LocationName: "Romania, Bucharest", VPNDownloadSpeed: "50.00Mbps", VPNUploadSpeed: "10.00Mbps", Timestamp: "2025-03-02T08:10:00Z"},
+		},
+	}
+	assert.Equal(t, http.StatusUnauthorized, push(runner.PayloadResults, data, ""))
+	assert.Equal(t, http.StatusNoContent, push(runner.PayloadResults, data, "secret"))
+	assert.Equal(t, http.StatusNoContent, push(runner.PayloadResults, data, "secret")) // Pushed again after another -append run
+	assert.Equal(t, http.StatusNoContent, push(runner.PayloadSample, runner.PushedSample{MachineName: "Probe 1", RunID: "20250302080000"}, "secret"))
+	assert.Equal(t, http.StatusBadRequest, push(runner.PayloadResults, results.Results{}, "secret"))
+
+	get := func(path string, v any) int {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		if v != nil {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	var runs []CollectedRun
+	assert.Equal(t, http.StatusOK, get("/api/runs", &runs))
+	assert.Equal(t, []CollectedRun{
+		{MachineName: "Probe 1", RunID: "20250302080000", Tags: map[string]string{"office": "nyc"}, Started: "2025-03-02T08:00:00Z", WithoutVPN: "900Mbps ▼  800Mbps ▲", Locations: 2, URL: "/api/runs/probe-1/20250302080000"},
+		{MachineName: "Probe 1", RunID: "20250301080000", Started: "2025-03-01T08:00:00Z", WithoutVPN: "850Mbps ▼  800Mbps ▲", Locations: 1, URL: "/api/runs/probe-1/20250301080000"},
+	}, runs)
+
+	var run results.Results
+	assert.Equal(t, http.StatusOK, get(runs[1].URL, &run))
+	assert.Len(t, run.VPNStats, 1)
+	assert.Equal(t, http.StatusNotFound, get("/api/runs/probe-1/20990101000000", nil))
+
+	// Filtered by tag
+	assert.Equal(t, http.StatusOK, get("/api/runs?tag=office=nyc", &runs))
+	assert.Len(t, runs, 1)
+	assert.Equal(t, http.StatusOK, get("/api/runs?tag=office=nyc&tag=link=fiber", &runs))
+	assert.Empty(t, runs)
+
+	var summary []LocationSummary
+	assert.Equal(t, http.StatusOK, get("/api/summary", &summary))
+	assert.Equal(t, []LocationSummary{
+		{LocationName: "Netherlands, Amsterdam", Machines: 1, Runs: 2, DownloadMbps: 150, UploadMbps: 30, Latest: "2025-03-02T08:05:00Z"},
+		{LocationName: "Romania, Bucharest", Machines: 1, Runs: 1, DownloadMbps: 50, UploadMbps: 10, Latest: "2025-03-02T08:10:00Z"},
+	}, summary)
+
+	samples, err := os.ReadFile(filepath.Join(collector.Dir, "probe-1", "samples-20250302080000.ndjson"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(samples), "\n"))
+
+	// Grafana's JSON datasource
+	post := func(path string, body string, v any) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		if v != nil {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, get("/grafana/", nil))
+
+	var targets []string
+	assert.Equal(t, http.StatusOK, post("/grafana/search", `{"target": "download"}`, &targets))
+	assert.Equal(t, []string{"download_mbps", "download_mbps:Netherlands, Amsterdam", "download_mbps:Romania, Bucharest"}, targets)
+
+	march2 := time.Date(2025, 3, 2, 0, 0, 0, 0, time.Local)
+	var series []grafanaSeries
+	assert.Equal(t, http.StatusOK, post("/grafana/query", `{"targets": [{"target": "download_mbps"}, {"target": "upload_mbps:Netherlands, Amsterdam"}]}`, &series))
+	assert.Equal(t, []grafanaSeries{
+		{Target: "download_mbps Netherlands, Amsterdam (Probe 1)", Datapoints: [][2]float64{
+			{100, float64(march2.Add(-16*time.Hour + 5*time.Minute).UnixMilli())},
+			{200, float64(march2.Add(8*time.Hour + 5*time.Minute).UnixMilli())},
+		}},
+		{Target: "download_mbps Romania, Bucharest (Probe 1)", Datapoints: [][2]float64{{50, float64(march2.Add(8*time.Hour + 10*time.Minute).UnixMilli())}}},
+		{Target: "upload_mbps Netherlands, Amsterdam (Probe 1)", Datapoints: [][2]float64{
+			{20, float64(march2.Add(-16*time.Hour + 5*time.Minute).UnixMilli())},
+			{40, float64(march2.Add(8*time.Hour + 5*time.Minute).UnixMilli())},
+		}},
+	}, series)
+
+	// Limited to the dashboard's time range
+	timeRange := `"range": {"from": "` + march2.Format(time.RFC3339) + `", "to": "` + march2.Add(24*time.Hour).Format(time.RFC3339) + `"}`
+	assert.Equal(t, http.StatusOK, post("/grafana/query", `{`+timeRange+`, "targets": [{"target": "latency_ms:Romania, Bucharest"}]}`, &series))
+	assert.Len(t, series, 1)
+	assert.Equal(t, http.StatusBadRequest, post("/grafana/query", `{"targets": [{"target": "speed"}]}`, nil))
+
+	var annotations []grafanaAnnotation
+	assert.Equal(t, http.StatusOK, post("/grafana/annotations", `{`+timeRange+`, "annotation": {"name": "runs"}}`, &annotations))
+	assert.Len(t, annotations, 1)
+	assert.Equal(t, "Run 20250302080000 on Probe 1", annotations[0].Title)
+	assert.Equal(t, []string{"Probe 1", "office=nyc"}, annotations[0].Tags)
+	assert.Equal(t, march2.Add(8*time.Hour).UnixMilli(), annotations[0].Time)
+}
+
+func TestInstallService(t *testing.T) {
+	origCommands, origDir, origDataDir := commands, systemdDir, serviceDataDir
+	defer func() { commands, systemdDir, serviceDataDir = origCommands, origDir, origDataDir }()
+	dir := t.TempDir()
+	serviceDataDir = func(user bool) (string, error) {
+		return filepath.Join(dir, "data"), nil
+	}
+
+	spec := ServiceSpec{
+		Name:     "vpn-bench",
+		Binary:   "/usr/local/bin/expressvpnspeedtest",
+		Args:     []string{"-append", "-results", "my results.json", "-tag", "rate=100%", "locations.json"},
+		WorkDir:  "/srv/bench",
+		Env:      []string{"EVST_PUSH_HEADER=Authorization: Bearer \"s3cr$t\"", "EVST_SAMPLES=3"},
+		Schedule: "*-*-* 03:00:00",
+		EnvFile:  filepath.Join(dir, "data", "vpn-bench.env"),
+	}
+	service, timer := systemdUnits(spec)
+	assert.Contains(t, service, "Type=oneshot\nWorkingDirectory=/srv/bench\nEnvironmentFile="+spec.EnvFile+"\n")
+	assert.NotContains(t, service, "s3cr", "Unit files are world-readable")
+	assert.Contains(t, service, `ExecStart=/usr/local/bin/expressvpnspeedtest run -append -results "my results.json" -tag rate=100%% locations.json`)
+	assert.Contains(t, timer, "OnCalendar=*-*-* 03:00:00\nPersistent=true\n")
+	assert.Equal(t, "EVST_PUSH_HEADER=\"Authorization: Bearer \\\"s3cr\\$t\\\"\"\nEVST_SAMPLES=\"3\"\n", environmentFile(spec.Env))
+
+	spec.Schedule = "daily"
+	assert.Equal(t, []string{"service-run", "-name", "vpn-bench", "-schedule", "daily", "-dir", "/srv/bench", "--",
+		"-append", "-results", "my results.json", "-tag", "rate=100%", "locations.json"}, serviceRunArgs(spec))
+
+	// Installing writes both units and the environment file, readable by root only, and enables the timer;
+	// uninstalling reverses it
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "systemctl")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+calls+"\n"), 0755))
+	commands = command.Overrides{"systemctl": {Path: script}}
+	systemdDir = func(user bool) (string, error) {
+		return filepath.Join(dir, "units"), nil
+	}
+
+	assert.NoError(t, installSystemd(spec, true))
+	assert.FileExists(t, filepath.Join(dir, "units", "vpn-bench.service"))
+	assert.FileExists(t, filepath.Join(dir, "units", "vpn-bench.timer"))
+	info, err := os.Stat(spec.EnvFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	env, err := readEnvironmentFile(spec.EnvFile)
+	assert.NoError(t, err)
+	assert.Equal(t, spec.Env, env)
+	assert.NoError(t, uninstallSystemd("vpn-bench", true))
+	assert.NoFileExists(t, filepath.Join(dir, "units", "vpn-bench.service"))
+	assert.NoFileExists(t, filepath.Join(dir, "units", "vpn-bench.timer"))
+	assert.NoFileExists(t, spec.EnvFile)
+
+	logged, err := os.ReadFile(calls)
+	assert.NoError(t, err)
+	assert.Equal(t, "--user daemon-reload\n--user enable --now vpn-bench.timer\n--user disable --now vpn-bench.timer\n--user daemon-reload\n", string(logged))
+}
+
+func TestRunSchedule(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the benchmark")
+	}
+	origIntervals := serviceIntervals
+	defer func() { serviceIntervals = origIntervals }()
+	serviceIntervals = map[string]time.Duration{"often": 50 * time.Millisecond}
+
+	dir := t.TempDir()
+	spec := ServiceSpec{Name: "vpn-bench", Binary: filepath.Join(dir, "benchmark"), Args: []string{"locations.json"},
+		WorkDir: dir, Env: []string{"EVST_SAMPLES=3"}, Schedule: "often"}
+	assert.NoError(t, os.WriteFile(spec.Binary, []byte("#!/bin/sh\necho \"$@ $EVST_SAMPLES $(pwd)\"\n"), 0755))
+
+	// A run is due right away without a last run, then every interval
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runSchedule(spec, filepath.Join(dir, "data"), stop)
+		close(done)
+	}()
+	time.Sleep(120 * time.Millisecond)
+	close(stop)
+	<-done
+
+	log, err := os.ReadFile(filepath.Join(dir, "data", "vpn-bench.log"))
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, strings.Count(string(log), "run locations.json 3 "+dir+"\n"), 2)
+	assert.Contains(t, string(log), "Finished: exit code 0")
+	last, err := os.ReadFile(filepath.Join(dir, "data", "vpn-bench.last"))
+	assert.NoError(t, err)
+	_, err = time.Parse(time.RFC3339, strings.TrimSpace(string(last)))
+	assert.NoError(t, err)
+}
+
+func TestShuffleLocations(t *testing.T) {
+	var locations []results.Location
+	for _, city := range []string{"Amsterdam", "Bucharest", "Toronto", "Tokyo", "Sydney", "Chicago", "Paris", "Madrid"} {
+		locations = append(locations, results.Location{City: city})
+	}
+
+	first := slices.Clone(locations)
+	shuffleLocations(first, 42)
+	second := slices.Clone(locations)
+	shuffleLocations(second, 42)
+	assert.Equal(t, first, second, "The same seed gives the same order")
+	assert.ElementsMatch(t, locations, first)
+	assert.NotEqual(t, locations, first)
+
+	other := slices.Clone(locations)
+	shuffleLocations(other, 43)
+	assert.NotEqual(t, first, other)
+}
+
+func TestTimeOfDayMatrix(t *testing.T) {
+	origPass, origSleep := runMatrixPass, sleep
+	defer func() { runMatrixPass, sleep = origPass, origSleep }()
+	sleep = func(time.Duration) {}
+
+	var timesOfDay timeList
+	assert.NoError(t, timesOfDay.Set("21:00, 9:00,14:00"))
+	assert.Equal(t, timeList{"09:00", "14:00", "21:00"}, timesOfDay)
+	assert.Error(t, timesOfDay.Set("25:00"))
+
+	morning := time.Date(2025, 3, 1, 10, 30, 0, 0, time.Local)
+	assert.Equal(t, time.Date(2025, 3, 1, 14, 0, 0, 0, time.Local), nextClockTime(morning, "14:00"))
+	assert.Equal(t, time.Date(2025, 3, 2, 9, 0, 0, 0, time.Local), nextClockTime(morning, "09:00"))
+
+	// Every time of every day is a pass of its own, appended to the same file
+	var passes [][]string
+	runMatrixPass = func(args []string) error {
+		passes = append(passes, args)
+		if len(passes) == 2 {
+			return errors.New("exit status 2")
+		}
+		return nil
+	}
+	code := runTimeOfDayMatrix([]string{"-r", "3", "locations.json"}, filepath.Join(t.TempDir(), "matrix.json"), timesOfDay, 2, false)
+	assert.Equal(t, runner.ExitLocationsFailed, code)
+	assert.Len(t, passes, 6)
+	assert.Equal(t, []string{"run", "-results"}, passes[0][:2])
+	assert.Equal(t, []string{"-append", "-time-window"}, passes[0][3:5])
+	assert.Equal(t, []string{"-r", "3", "locations.json"}, passes[0][6:])
+	var windows []string
+	for _, pass := range passes {
+		windows = append(windows, pass[5])
+	}
+	for _, clock := range timesOfDay {
+		assert.Equal(t, 2, strings.Count(strings.Join(windows, " "), clock))
+	}
+
+	data := results.Results{VPNStats: []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", TimeWindow: "09:00", VPNDownloadSpeed: "100.00Mbps", VPNUploadSpeed: "20.00Mbps"},
+		{LocationName: "Netherlands, Amsterdam", TimeWindow: "09:00", VPNDownloadSpeed: "200.00Mbps", VPNUploadSpeed: "40.00Mbps"},
+		{LocationName: "Netherlands, Amsterdam", TimeWindow: "21:00", VPNDownloadSpeed: "50.00Mbps", VPNUploadSpeed: "10.00Mbps"},
+		{LocationName: "Romania, Bucharest", TimeWindow: "21:00", VPNDownloadSpeed: "80.00Mbps", VPNUploadSpeed: "30.00Mbps"},
+	}}
+	assert.Equal(t, pterm.TableData{
+		{"Location", "09:00", "21:00"},
+		{"Netherlands, Amsterdam", "150.00Mbps ▼ 30.00Mbps ▲", "50.00Mbps ▼ 10.00Mbps ▲"},
+		{"Romania, Bucharest", "-", "80.00Mbps ▼ 30.00Mbps ▲"},
+	}, runner.Display{}.TimeOfDayTable(data))
+	assert.Nil(t, runner.Display{}.TimeOfDayTable(results.Results{VPNStats: []results.VPNStat{{LocationName: "Romania, Bucharest"}}}))
+}
+
+func TestLoadPassphrase(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	assert.NoError(t, os.WriteFile(keyFile, []byte("  secret phrase\n"), 0600))
+	passphrase, err := loadPassphrase(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret phrase", string(passphrase))
+
+	t.Setenv("TEST_RESULTS_PASSPHRASE", "from the environment")
+	passphrase, err = loadPassphrase("env:TEST_RESULTS_PASSPHRASE")
+	assert.NoError(t, err)
+	assert.Equal(t, "from the environment", string(passphrase))
+
+	_, err = loadPassphrase("env:TEST_RESULTS_UNSET")
+	assert.Error(t, err, "An empty passphrase is refused")
+	_, err = loadPassphrase(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestControlAPI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts as the benchmark")
+	}
+	dir := t.TempDir()
+	controller := &Controller{Dir: filepath.Join(dir, "runs"), Token: "secret"}
+	server := httptest.NewServer(controller.Handler())
+	defer server.Close()
+
+	request := func(method, path, body string, v any) int {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		if v != nil {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+	waitFinished := func(id string) ControlRunStatus {
+		var status ControlRunStatus
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			request(http.MethodGet, "/api/runs/"+id, "", &status)
+			if status.State != "running" {
+				break
+			}
+		}
+		return status
+	}
+
+	// A benchmark printing its samples and its arguments
+	controller.Executable = filepath.Join(dir, "benchmark")
+	assert.NoError(t, os.WriteFile(controller.Executable, []byte(`#!/bin/sh
+echo "$@" >&2
+echo '{"location":"Netherlands, Amsterdam"}'
+echo '{"location":"Romania, Bucharest"}'
+`), 0755))
+
+	resp, err := http.Post(server.URL+"/api/runs", "application/json", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/runs", `{"args": ["-results=mine.json"]}`, nil))
+	// Flags running commands or naming files, however they are spelled
+	for _, args := range []string{`["-pre-hook", "id"]`, `["--post-hook=id"]`, `["-probe", "x=id"]`, `["-config", "c.yaml"]`, `["-encrypt", "key"]`, `["-r", "3", "-html-report", "/tmp/r.html"]`} {
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/runs", `{"args": `+args+`}`, nil), args)
+	}
+	// Files on the machine, as the input file or after a flag taking no value
+	for _, args := range []string{`["/etc/shadow"]`, `["-r", "3", "input.json"]`, `["-s", "input.json"]`, `["-redact", "-r", "3", "../secret.json"]`} {
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/runs", `{"args": `+args+`}`, nil), args)
+	}
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/runs", `{"input": {"providers": [{"name": "x", "type": "command", "connect": "id"}]}}`, nil))
+
+	var status ControlRunStatus
+	assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/api/runs", `{"args": ["-r", "3"], "input": {"locations": [{"country": "Germany", "preHook": "id", "postHook": "id"}]}}`, &status))
+	assert.Equal(t, "running", status.State)
+	status = waitFinished(status.ID)
+	assert.Equal(t, "completed", status.State)
+	assert.Equal(t, runner.ExitOK, *status.ExitCode)
+	inputFile := filepath.Join(controller.Dir, status.ID, "input.json")
+	assert.Equal(t, []string{"-r", "3", inputFile}, status.Args)
+	log, err := os.ReadFile(filepath.Join(controller.Dir, status.ID, "run.log"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(log), "run -results "+status.ResultsFile+" -progress ")
+	assert.Contains(t, string(log), "-output ndjson -r 3 "+inputFile)
+	input, err := os.ReadFile(inputFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(input), "Hook") // Hooks are dropped from inline input
+	assert.Contains(t, string(input), `"country":"Germany"`)
+	info, err := os.Stat(inputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	info, err = os.Stat(filepath.Dir(inputFile))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/runs/"+status.ID+"/samples", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	samples, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "{\"location\":\"Netherlands, Amsterdam\"}\n{\"location\":\"Romania, Bucharest\"}\n", string(samples))
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/api/runs/unknown", "", nil))
+
+	// A benchmark that stops after its current step when interrupted, as the real one does
+	assert.NoError(t, os.WriteFile(controller.Executable, []byte(`#!/bin/sh
+trap 'exit 2' INT
+echo '{"location":"Netherlands, Amsterdam"}'
+sleep 10 >/dev/null &
+wait $!
+`), 0755))
+	assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/api/runs", `{"args": ["-s", "-tag", "site=lab"], "input": {"locations": [{"country": "Germany"}]}}`, &status))
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/api/runs", `{"input": {"locations": [{"country": "Germany"}]}}`, nil))
+
+	// The first sample arrives while the run goes on
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/api/runs/"+status.ID+"/samples", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	line := make([]byte, len("{\"location\":\"Netherlands, Amsterdam\"}\n"))
+	_, err = io.ReadFull(resp.Body, line)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/api/runs/"+status.ID+"/abort", "", nil))
+	rest, _ := io.ReadAll(resp.Body) // Until the run ends
+	resp.Body.Close()
+	assert.Empty(t, rest)
+
+	status = waitFinished(status.ID)
+	assert.Equal(t, "aborted", status.State)
+	assert.Equal(t, 2, *status.ExitCode)
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/api/runs/"+status.ID+"/abort", "", nil))
+
+	var runs []ControlRunStatus
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/api/runs", "", &runs))
+	assert.Len(t, runs, 2)
+	assert.Equal(t, status.ID, runs[1].ID)
+}
+
+func TestCommandOverrides(t *testing.T) {
+	origCommands := commands
+	defer func() { commands = origCommands }()
+
+	var config Config
+	err := yaml.Unmarshal([]byte(`commands:
+  speedtest:
+    path: /snap/bin/speedtest
+    env:
+      SPEEDTEST_HOME: /tmp/speedtest
+      LANG: C
+`), &config)
+	assert.NoError(t, err)
+
+	commands = command.Overrides{}
+	config.apply()
+
+	cmd := commands.New("speedtest", "-f", "json-pretty")
+	assert.Equal(t, "/snap/bin/speedtest", cmd.Path)
+	assert.Equal(t, []string{"/snap/bin/speedtest", "-f", "json-pretty"}, cmd.Args)
+	assert.Contains(t, cmd.Env, "SPEEDTEST_HOME=/tmp/speedtest")
+	assert.Contains(t, cmd.Env, "LANG=C")
+
+	// Commands without overrides inherit the environment unchanged
+	cmd = commands.New("expressvpnctl", "get", "regions")
+	assert.Nil(t, cmd.Env)
+}
+
+func TestFlagValues(t *testing.T) {
+	tags := tagMap{}
+	assert.NoError(t, tags.Set("office=nyc, link=fiber"))
+	assert.NoError(t, tags.Set("office=berlin"))
+	assert.Error(t, tags.Set("fiber"))
+	assert.Equal(t, "link=fiber,office=berlin", tags.String())
+
+	var headers headerList
+	assert.NoError(t, headers.Set("Authorization: Bearer secret\nX-Probe: lab"))
+	assert.Error(t, headers.Set("no colon"))
+	assert.Equal(t, headerList{"Authorization: Bearer secret", "X-Probe: lab"}, headers)
+
+	var probes probeList
+	assert.NoError(t, probes.Set("game=echo \"{}\""))
+	assert.NoError(t, probes.Set("bad=echo not json\nfail=echo 'no route' >&2; exit 3"))
+	assert.Error(t, probes.Set("missing-command="))
+	assert.Equal(t, probeList{{Name: "game", Command: `echo "{}"`}, {Name: "bad", Command: "echo not json"}, {Name: "fail", Command: "echo 'no route' >&2; exit 3"}}, probes)
+
+	var outputs outputList
+	assert.NoError(t, outputs.Set("ndjson"))
+	assert.NoError(t, outputs.Set("csv:a.csv, webhook:https://example.com/hook?a=1"))
+	assert.Equal(t, outputList{"ndjson", "csv:a.csv", "webhook:https://example.com/hook?a=1"}, outputs)
+
+	// The run's options are filled in through the flags
+	opts := runner.DefaultOptions()
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.Var(tagMap(opts.Tags), "tag", "")
+	flags.Var((*headerList)(&opts.PushHeaders), "push-header", "")
+	flags.Var((*probeList)(&opts.Probes), "probe", "")
+	assert.NoError(t, flags.Parse([]string{"-tag", "office=nyc", "-push-header", "X-Probe: lab", "-probe", "game=true"}))
+	assert.Equal(t, map[string]string{"office": "nyc"}, opts.Tags)
+	assert.Equal(t, []string{"X-Probe: lab"}, opts.PushHeaders)
+	assert.Equal(t, []runner.Probe{{Name: "game", Command: "true"}}, opts.Probes)
+}
+
+func TestOnceLocation(t *testing.T) {
+	assert.Equal(t, results.Location{Country: "Germany", City: "Frankfurt"}, onceLocation(" Germany, Frankfurt "))
+	assert.Equal(t, results.Location{Country: "Germany - Frankfurt - 1"}, onceLocation("Germany - Frankfurt - 1"))
+	assert.True(t, onceLocation("smart").IsSmart())
+}
+
+func TestASCIIOutput(t *testing.T) {
+	asciiOutput = true
+	defer func() { asciiOutput = false }()
+
+	stdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	assert.NoError(t, err)
+	os.Stdout = writer
+	printText("Without VPN:", "900.00Mbps ▼  90.00Mbps ▲")
+	printTextf("Connected to %s\n", "Brazil, São Paulo")
+	writer.Close()
+	os.Stdout = stdout
+	output, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "Without VPN: 900.00Mbps down  90.00Mbps up\nConnected to Brazil, Sao Paulo\n", string(output))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/runner"
+)
+
+var sleep = time.Sleep // Replaced in tests
+
+// timeList collects repeated or comma-separated HH:MM times, kept sorted
+type timeList []string
+
+func (t *timeList) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *timeList) Set(value string) error {
+	for _, clock := range strings.Split(value, ",") {
+		if clock = strings.TrimSpace(clock); clock == "" {
+			continue
+		}
+		parsed, err := time.Parse("15:04", clock)
+		if err != nil {
+			return fmt.Errorf("invalid time %q, expected HH:MM", clock)
+		}
+		if clock = parsed.Format("15:04"); !slices.Contains(*t, clock) {
+			*t = append(*t, clock)
+		}
+	}
+	slices.Sort(*t)
+	return nil
+}
+
+// Runs one pass of the location list; a variable so tests can record the passes instead
+var runMatrixPass = func(args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// Returns the first time after from that is at the clock time, e.g. 09:00
+func nextClockTime(from time.Time, clock string) time.Time {
+	parsed, _ := time.Parse("15:04", clock)
+	next := time.Date(from.Year(), from.Month(), from.Day(), parsed.Hour(), parsed.Minute(), 0, 0, from.Location())
+	if !next.After(from) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Stays up for the -times-of-day passes, running the location list at each time, days days in a row.
+// Each pass is a run of its own, appended to the same results file with its time window recorded, so
+// the report can compare every region across the times of day
+func runTimeOfDayMatrix(args []string, fileName string, timesOfDay timeList, days int, appendResults bool) int {
+	if _, err := os.Stat(fileName); err == nil && !appendResults {
+		fatal("Results file already exists; pass -append to add the passes to it", "path", fileName)
+	}
+
+	// Start with whichever time comes first, then follow the day round
+	at := time.Now()
+	first := 0
+	for i, clock := range timesOfDay {
+		if nextClockTime(at, clock).Before(nextClockTime(at, timesOfDay[first])) {
+			first = i
+		}
+	}
+	order := append(slices.Clone(timesOfDay[first:]), timesOfDay[:first]...)
+
+	passes := len(order) * max(days, 1)
+	code := runner.ExitOK
+	for pass := range passes {
+		clock := order[pass%len(order)]
+		at = nextClockTime(at, clock)
+		printText("Next pass", pass+1, "of", passes, "at", at.Format(runner.RunTimeLayout))
+		sleep(time.Until(at))
+
+		// Flags after the input file wouldn't be parsed, so they go first; the pass's -time-window keeps it from scheduling again
+		passArgs := append([]string{"run", "-results", fileName, "-append", "-time-window", clock}, args...)
+		if err := runMatrixPass(passArgs); err != nil {
+			logger.Error("Pass failed", "time", clock, "err", err)
+			code = runner.ExitLocationsFailed
+		}
+	}
+	return code
+}
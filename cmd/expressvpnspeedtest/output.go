@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/runner"
+)
+
+// Prints human-readable output, suppressed in ndjson and quiet modes
+func printText(a ...any) {
+	if outputFormat == "text" && logLevel.Level() <= slog.LevelInfo {
+		writeText(fmt.Sprintln(a...))
+	}
+}
+
+// Prints formatted human-readable output, suppressed in ndjson and quiet modes
+func printTextf(format string, a ...any) {
+	if outputFormat == "text" && logLevel.Level() <= slog.LevelInfo {
+		writeText(fmt.Sprintf(format, a...))
+	}
+}
+
+// Writes human-readable output, transliterated with -ascii and as timestamped lines in plain mode
+func writeText(text string) {
+	if asciiOutput {
+		text = results.ToASCII(text)
+	}
+	if plainOutput {
+		runner.PrintPlain(text)
+		return
+	}
+	fmt.Print(text)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+
+	"github.com/pterm/pterm"
+	"golang.org/x/term"
+)
+
+var plainOutput bool // Timestamped lines instead of spinners, colors and redrawn lines
+
+// Reports whether stdout is a terminal
+func stdoutIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// Switches to plain output, for pipes, CI and the systemd journal, whose logs would otherwise fill
+// up with control sequences
+func enablePlainOutput() {
+	plainOutput = true
+	pterm.DisableStyling()
+}
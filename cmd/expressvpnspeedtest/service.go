@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// ServiceSpec is a recurring benchmark run as registered with the service manager
+type ServiceSpec struct {
+	Name     string
+	Binary   string   // Absolute path of this executable
+	Args     []string // Arguments of the run subcommand
+	WorkDir  string   // Where relative paths, such as the results file, are resolved
+	Env      []string // EVST_ variables as KEY=value, so the run sees the configuration it was installed with
+	Schedule string   // systemd OnCalendar expression, or hourly, daily or weekly
+	EnvFile  string   // Where Env is kept, readable by root or the administrators only as it may hold secrets
+}
+
+// Directory systemd units are installed to; a variable so tests can redirect it
+var systemdDir = func(user bool) (string, error) {
+	if !user {
+		return "/etc/systemd/system", nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "systemd", "user"), nil
+}
+
+// Directory the EVST_ variables of installed services are kept in, and on Windows their logs and last
+// runs; a variable so tests can redirect it
+var serviceDataDir = func(user bool) (string, error) {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(cmp.Or(os.Getenv("ProgramData"), `C:\ProgramData`), "expressvpnspeedtest"), nil
+	}
+	if !user {
+		return "/etc/expressvpnspeedtest", nil
+	}
+	config := defaultConfigPath()
+	if config == "" {
+		return "", fmt.Errorf("no home directory")
+	}
+	return filepath.Dir(config), nil
+}
+
+// Time between the runs of the Windows service for each -schedule it supports
+var serviceIntervals = map[string]time.Duration{"hourly": time.Hour, "daily": 24 * time.Hour, "weekly": 7 * 24 * time.Hour}
+
+// Runs the install-service subcommand: expressvpnspeedtest install-service [-name NAME] [-schedule daily] [-user] [-print] [--] [run options] <input_file.json>
+func runInstallService(args []string) {
+	flags := flag.NewFlagSet("install-service", flag.ExitOnError)
+	name := flags.String("name", "expressvpnspeedtest", "Name of the systemd unit or Windows service")
+	schedule := flags.String("schedule", "daily", "When to run: a systemd OnCalendar expression, or hourly, daily or weekly on Windows")
+	user := flags.Bool("user", false, "Install a systemd user unit instead of a system one")
+	printOnly := flags.Bool("print", false, "Print what would be installed instead of installing it")
+	parseFlags(flags, args)
+
+	spec, err := newServiceSpec(*name, *schedule, flags.Args(), *user)
+	if err != nil {
+		fatal("Failed to prepare service", "err", err)
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		service, timer := systemdUnits(spec)
+		if *printOnly {
+			fmt.Printf("# %s.service\n%s\n# %s.timer\n%s", spec.Name, service, spec.Name, timer)
+			if len(spec.Env) > 0 {
+				fmt.Printf("\n# %s (mode 0600)\n%s", spec.EnvFile, environmentFile(spec.Env))
+			}
+			return
+		}
+		err = installSystemd(spec, *user)
+	case "windows":
+		if _, ok := serviceIntervals[spec.Schedule]; !ok {
+			fatal("The Windows service only supports hourly, daily or weekly schedules", "schedule", spec.Schedule)
+		}
+		if *printOnly {
+			fmt.Println(strings.Join(append([]string{windowsQuote(spec.Binary)}, serviceRunArgs(spec)...), " "))
+			return
+		}
+		err = installWindowsService(spec)
+	default:
+		fatal("install-service supports systemd on Linux and Windows services", "os", runtime.GOOS)
+	}
+	if err != nil {
+		fatal("Failed to install service", "name", spec.Name, "err", err)
+	}
+	printText("Installed", spec.Name, "to run", spec.Schedule)
+}
+
+// Runs the uninstall-service subcommand: expressvpnspeedtest uninstall-service [-name NAME] [-user]
+func runUninstallService(args []string) {
+	flags := flag.NewFlagSet("uninstall-service", flag.ExitOnError)
+	name := flags.String("name", "expressvpnspeedtest", "Name of the systemd unit or Windows service")
+	user := flags.Bool("user", false, "Remove a systemd user unit instead of a system one")
+	parseFlags(flags, args)
+
+	var err error
+	switch runtime.GOOS {
+	case "linux":
+		err = uninstallSystemd(*name, *user)
+	case "windows":
+		err = uninstallWindowsService(*name)
+	default:
+		fatal("uninstall-service supports systemd on Linux and Windows services", "os", runtime.GOOS)
+	}
+	if err != nil {
+		fatal("Failed to uninstall service", "name", *name, "err", err)
+	}
+	printText("Uninstalled", *name)
+}
+
+// Captures how this invocation would run: the executable, the working directory and the EVST_ environment
+func newServiceSpec(name string, schedule string, args []string, user bool) (ServiceSpec, error) {
+	binary, err := os.Executable()
+	if err != nil {
+		return ServiceSpec{}, err
+	}
+	if resolved, err := filepath.EvalSymlinks(binary); err == nil {
+		binary = resolved
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		return ServiceSpec{}, err
+	}
+
+	var env []string
+	for _, entry := range os.Environ() {
+		if strings.HasPrefix(entry, envPrefix) {
+			env = append(env, entry)
+		}
+	}
+	slices.Sort(env)
+	dataDir, err := serviceDataDir(user)
+	if err != nil {
+		return ServiceSpec{}, err
+	}
+	return ServiceSpec{Name: name, Binary: binary, Args: args, WorkDir: workDir, Env: env, Schedule: schedule,
+		EnvFile: filepath.Join(dataDir, name+".env")}, nil
+}
+
+// Renders the oneshot service running the benchmark and the timer starting it on schedule
+func systemdUnits(spec ServiceSpec) (string, string) {
+	var service strings.Builder
+	service.WriteString("[Unit]\n")
+	fmt.Fprintf(&service, "Description=ExpressVPN speed test benchmark (%s)\n", spec.Name)
+	service.WriteString("Wants=network-online.target\nAfter=network-online.target\n\n")
+	service.WriteString("[Service]\nType=oneshot\n")
+	fmt.Fprintf(&service, "WorkingDirectory=%s\n", systemdQuote(spec.WorkDir))
+	// Unit files are world-readable, and the variables may hold secrets such as EVST_PUSH_HEADER
+	if len(spec.Env) > 0 {
+		fmt.Fprintf(&service, "EnvironmentFile=%s\n", systemdQuote(spec.EnvFile))
+	}
+	execStart := []string{systemdQuote(spec.Binary), "run"}
+	for _, arg := range spec.Args {
+		execStart = append(execStart, systemdQuote(arg))
+	}
+	fmt.Fprintf(&service, "ExecStart=%s\n", strings.Join(execStart, " "))
+
+	var timer strings.Builder
+	timer.WriteString("[Unit]\n")
+	fmt.Fprintf(&timer, "Description=Run %s on schedule\n\n", spec.Name)
+	fmt.Fprintf(&timer, "[Timer]\nOnCalendar=%s\n", spec.Schedule)
+	timer.WriteString("Persistent=true\nRandomizedDelaySec=10m\n\n")
+	timer.WriteString("[Install]\nWantedBy=timers.target\n")
+	return service.String(), timer.String()
+}
+
+// Quotes a word for a unit file: specifiers and variables are escaped, and words with spaces or
+// quotes are double-quoted
+func systemdQuote(word string) string {
+	word = strings.NewReplacer("%", "%%", "$", "$$").Replace(word)
+	if word != "" && !strings.ContainsAny(word, " \t\"'\\") {
+		return word
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(word) + `"`
+}
+
+// Writes the units and enables the timer
+func installSystemd(spec ServiceSpec, user bool) error {
+	dir, err := systemdDir(user)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := writeEnvironmentFile(spec); err != nil {
+		return err
+	}
+	service, timer := systemdUnits(spec)
+	if err := os.WriteFile(filepath.Join(dir, spec.Name+".service"), []byte(service), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, spec.Name+".timer"), []byte(timer), 0644); err != nil {
+		return err
+	}
+
+	if _, err := commands.RunCombined("systemctl", systemctlArgs(user, "daemon-reload")...); err != nil {
+		return err
+	}
+	_, err = commands.RunCombined("systemctl", systemctlArgs(user, "enable", "--now", spec.Name+".timer")...)
+	return err
+}
+
+// Disables the timer and removes the units
+func uninstallSystemd(name string, user bool) error {
+	dir, err := systemdDir(user)
+	if err != nil {
+		return err
+	}
+	if _, err := commands.RunCombined("systemctl", systemctlArgs(user, "disable", "--now", name+".timer")...); err != nil {
+		logger.Warn("Failed to disable timer", "name", name, "err", err)
+	}
+	dataDir, err := serviceDataDir(user)
+	if err != nil {
+		return err
+	}
+	for _, file := range []string{filepath.Join(dir, name+".service"), filepath.Join(dir, name+".timer"), filepath.Join(dataDir, name+".env")} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	_, err = commands.RunCombined("systemctl", systemctlArgs(user, "daemon-reload")...)
+	return err
+}
+
+func systemctlArgs(user bool, args ...string) []string {
+	if user {
+		return append([]string{"--user"}, args...)
+	}
+	return args
+}
+
+// Writes the EVST_ variables of the service to its environment file, readable by its owner only
+func writeEnvironmentFile(spec ServiceSpec) error {
+	if len(spec.Env) == 0 {
+		return nil
+	}
+	for _, entry := range spec.Env {
+		if strings.ContainsAny(entry, "\r\n") {
+			key, _, _ := strings.Cut(entry, "=")
+			return fmt.Errorf("%s: environment files can't hold values spanning lines", key)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(spec.EnvFile), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(spec.EnvFile, []byte(environmentFile(spec.Env)), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(spec.EnvFile, 0600); err != nil {
+		return err
+	}
+	return protectFile(spec.EnvFile)
+}
+
+// Renders KEY=value entries as an environment file, the values double-quoted with backslashes before
+// the characters systemd would otherwise interpret
+func environmentFile(env []string) string {
+	var file strings.Builder
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		fmt.Fprintf(&file, "%s=\"%s\"\n", key, quote.Replace(value))
+	}
+	return file.String()
+}
+
+// Reads the KEY=value entries of an environment file written by environmentFile
+func readEnvironmentFile(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, quoted, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		if len(quoted) < 2 || quoted[0] != '"' || quoted[len(quoted)-1] != '"' {
+			return nil, fmt.Errorf("%s: %s: the value isn't quoted", fileName, key)
+		}
+		quoted = quoted[1 : len(quoted)-1]
+		var value strings.Builder
+		for i := 0; i < len(quoted); i++ {
+			if quoted[i] == '\\' && i+1 < len(quoted) {
+				i++
+			}
+			value.WriteByte(quoted[i])
+		}
+		env = append(env, key+"="+value.String())
+	}
+	return env, scanner.Err()
+}
+
+// Arguments the Windows service is registered with: the service-run subcommand, which runs the benchmark
+// with the run options on schedule
+func serviceRunArgs(spec ServiceSpec) []string {
+	return append([]string{"service-run", "-name", spec.Name, "-schedule", spec.Schedule, "-dir", spec.WorkDir, "--"}, spec.Args...)
+}
+
+// Runs the service-run subcommand the Windows service starts: expressvpnspeedtest service-run -name NAME
+// -schedule daily -dir DIR -- [run options] <input_file.json>
+func runServiceRun(args []string) {
+	flags := flag.NewFlagSet("service-run", flag.ExitOnError)
+	name := flags.String("name", "expressvpnspeedtest", "Name of the Windows service")
+	schedule := flags.String("schedule", "daily", "How often to run: hourly, daily or weekly")
+	dir := flags.String("dir", "", "Directory to run the benchmark in")
+	flags.Parse(args)
+
+	if _, ok := serviceIntervals[*schedule]; !ok {
+		fatal("Unsupported schedule", "schedule", *schedule)
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		fatal("Failed to find the executable", "err", err)
+	}
+	dataDir, err := serviceDataDir(false)
+	if err != nil {
+		fatal("Failed to find the service directory", "err", err)
+	}
+	spec := ServiceSpec{Name: *name, Binary: binary, Args: flags.Args(), WorkDir: *dir, Schedule: *schedule,
+		EnvFile: filepath.Join(dataDir, *name+".env")}
+	if spec.Env, err = readEnvironmentFile(spec.EnvFile); err != nil && !os.IsNotExist(err) {
+		fatal("Failed to read the service environment", "err", err)
+	}
+	if err := runAsService(spec, dataDir); err != nil {
+		fatal("Service failed", "name", spec.Name, "err", err)
+	}
+}
+
+// Runs the schedule until interrupted
+func runScheduleInForeground(spec ServiceSpec, dataDir string) error {
+	stop := make(chan struct{})
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		close(stop)
+	}()
+	runSchedule(spec, dataDir, stop)
+	return nil
+}
+
+// Removes the environment file, log and last run of an uninstalled Windows service
+func removeServiceFiles(name string) {
+	dataDir, err := serviceDataDir(false)
+	if err != nil {
+		return
+	}
+	for _, suffix := range []string{".env", ".log", ".last"} {
+		if err := os.Remove(filepath.Join(dataDir, name+suffix)); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove service file", "err", err)
+		}
+	}
+}
+
+// Runs the benchmark every interval of the schedule until stop is closed. The last run is remembered in
+// NAME.last, so a run missed while the machine was off happens when the service starts, and the output
+// of every run is appended to NAME.log
+func runSchedule(spec ServiceSpec, dataDir string, stop <-chan struct{}) {
+	interval := serviceIntervals[spec.Schedule]
+	lastFile := filepath.Join(dataDir, spec.Name+".last")
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		logger.Error("Failed to create the service directory", "path", dataDir, "err", err)
+	}
+	for {
+		var wait time.Duration
+		if data, err := os.ReadFile(lastFile); err == nil {
+			if last, err := results.ParseTime(strings.TrimSpace(string(data))); err == nil {
+				wait = max(time.Until(last.Add(interval)), 0)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+
+		if err := os.WriteFile(lastFile, []byte(results.FormatTime(time.Now())+"\n"), 0644); err != nil {
+			logger.Error("Failed to record the run", "path", lastFile, "err", err)
+		}
+		runScheduledBenchmark(spec, filepath.Join(dataDir, spec.Name+".log"), stop)
+	}
+}
+
+// Runs the benchmark once, stopping it when stop is closed: interrupted so it disconnects where signals
+// can be sent, killed on Windows
+func runScheduledBenchmark(spec ServiceSpec, logFile string, stop <-chan struct{}) {
+	log, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		logger.Error("Failed to open the service log", "path", logFile, "err", err)
+		return
+	}
+	defer log.Close()
+
+	cmd := exec.Command(spec.Binary, append([]string{"run"}, spec.Args...)...)
+	cmd.Dir = spec.WorkDir
+	cmd.Env = append(os.Environ(), spec.Env...)
+	cmd.Stdout, cmd.Stderr = log, log
+	fmt.Fprintf(log, "%s Starting %s\n", results.FormatTime(time.Now()), strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(log, "%s Failed to start: %v\n", results.FormatTime(time.Now()), err)
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-stop:
+		if runtime.GOOS == "windows" {
+			cmd.Process.Kill()
+		} else {
+			cmd.Process.Signal(os.Interrupt)
+		}
+		err = <-done
+	}
+	fmt.Fprintf(log, "%s Finished: exit code %d\n", results.FormatTime(time.Now()), cmd.ProcessState.ExitCode())
+}
+
+// Double-quotes a word containing spaces or quotes for a Windows command line
+func windowsQuote(word string) string {
+	if word != "" && !strings.ContainsAny(word, " \t\"") {
+		return word
+	}
+	return `"` + strings.ReplaceAll(word, `"`, `\"`) + `"`
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+var errNoWindowsService = errors.New("Windows services can only be installed by Windows builds")
+
+func installWindowsService(spec ServiceSpec) error {
+	return errNoWindowsService
+}
+
+func uninstallWindowsService(name string) error {
+	return errNoWindowsService
+}
+
+// Runs the schedule in the foreground, as there is no service control manager to hand it to
+func runAsService(spec ServiceSpec, dataDir string) error {
+	return runScheduleInForeground(spec, dataDir)
+}
+
+// File modes already limit the environment file to its owner
+func protectFile(fileName string) error {
+	return nil
+}
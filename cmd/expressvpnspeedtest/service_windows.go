@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Registers the service running service-run, or updates it when it exists, and starts it. It runs as
+// LocalSystem, the account services start as by default
+func installWindowsService(spec ServiceSpec) error {
+	if err := writeEnvironmentFile(spec); err != nil {
+		return err
+	}
+	manager, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer manager.Disconnect()
+
+	config := mgr.Config{
+		DisplayName: "ExpressVPN speed test benchmark (" + spec.Name + ")",
+		Description: "Runs expressvpnspeedtest " + spec.Schedule + " in " + spec.WorkDir,
+		StartType:   mgr.StartAutomatic,
+	}
+	service, err := manager.OpenService(spec.Name)
+	if err == nil {
+		// Replace the configuration and restart, as the arguments may have changed
+		stopWindowsService(service)
+		args := []string{windows.EscapeArg(spec.Binary)}
+		for _, arg := range serviceRunArgs(spec) {
+			args = append(args, windows.EscapeArg(arg))
+		}
+		config.BinaryPathName = strings.Join(args, " ")
+		err = service.UpdateConfig(config)
+	} else {
+		service, err = manager.CreateService(spec.Name, spec.Binary, config, serviceRunArgs(spec)...)
+	}
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+	return service.Start()
+}
+
+// Stops and deletes the service and removes its environment file, log and last run
+func uninstallWindowsService(name string) error {
+	manager, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer manager.Disconnect()
+	service, err := manager.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+	stopWindowsService(service)
+	if err := service.Delete(); err != nil {
+		return err
+	}
+	removeServiceFiles(name)
+	return nil
+}
+
+// Asks the service to stop and waits up to a minute for the benchmark it runs to end
+func stopWindowsService(service *mgr.Service) {
+	status, err := service.Control(svc.Stop)
+	for deadline := time.Now().Add(time.Minute); err == nil && status.State != svc.Stopped && time.Now().Before(deadline); {
+		time.Sleep(500 * time.Millisecond)
+		status, err = service.Query()
+	}
+}
+
+// scheduledService runs the benchmark on schedule for the service control manager
+type scheduledService struct {
+	spec    ServiceSpec
+	dataDir string
+}
+
+func (s scheduledService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		runSchedule(s.spec, s.dataDir, stop)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for request := range requests {
+		switch request.Cmd {
+		case svc.Interrogate:
+			status <- request.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			close(stop)
+			<-done
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// Hands the schedule to the service control manager, or runs it in the foreground when started from a console
+func runAsService(spec ServiceSpec, dataDir string) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return runScheduleInForeground(spec, dataDir)
+	}
+	return svc.Run(spec.Name, scheduledService{spec: spec, dataDir: dataDir})
+}
+
+// Limits a file to SYSTEM and the administrators, as the environment file may hold secrets
+func protectFile(fileName string) error {
+	descriptor, err := windows.SecurityDescriptorFromString("D:P(A;;FA;;;SY)(A;;FA;;;BA)")
+	if err != nil {
+		return err
+	}
+	dacl, _, err := descriptor.DACL()
+	if err != nil {
+		return err
+	}
+	if err := windows.SetNamedSecurityInfo(fileName, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION, nil, nil, dacl, nil); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+	return nil
+}
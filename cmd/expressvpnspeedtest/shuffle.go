@@ -0,0 +1,15 @@
+package main
+
+import (
+	"math/rand/v2"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Shuffles the locations in place; the same seed always gives the same order
+func shuffleLocations(locations []results.Location, seed uint64) {
+	random := rand.New(rand.NewPCG(seed, seed))
+	random.Shuffle(len(locations), func(i, j int) {
+		locations[i], locations[j] = locations[j], locations[i]
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/pterm/pterm"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/runner"
+	"flavius.xyz/vpn_speed_test_cli/pkg/vpn"
+)
+
+var version = "dev" // Set at build time with -ldflags "-X main.version=1.2.3"
+
+var subcommands = []string{"run", "regions", "report", "compare", "serve-collector", "serve-control", "install-service", "uninstall-service", "service-run", "version", "help"}
+
+// RegionInfo is one provider region as listed by the regions subcommand
+type RegionInfo struct {
+	Region    string   `json:"region"`
+	Locations []string `json:"locations,omitempty"` // Input file locations that resolve to this region
+}
+
+// UnmatchedLocation is an input file location no region resolves to
+type UnmatchedLocation struct {
+	Location    string   `json:"location"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// RegionList is the output of the regions subcommand
+type RegionList struct {
+	Regions   []RegionInfo        `json:"regions"`
+	Unmatched []UnmatchedLocation `json:"unmatched,omitempty"`
+}
+
+// Runs the regions subcommand: expressvpnspeedtest regions [-input FILE] [-json] [search]
+func runRegions(args []string) {
+	flags := flag.NewFlagSet("regions", flag.ExitOnError)
+	inputFile := flags.String("input", "", "Mark the regions the locations of this input file resolve to")
+	jsonFlag := flags.Bool("json", false, "Print the regions as JSON")
+	flags.StringVar(&vpnctlBin, "vpnctl-bin", "", "Path of the expressvpnctl binary, for when it isn't in PATH")
+	flags.StringVar(&vpnctlArgs, "vpnctl-args", "", "Extra space-separated arguments for every expressvpnctl invocation")
+	parseFlags(flags, args)
+	applyBinaryFlags()
+
+	regions, err := vpn.ExpressVPN{Commands: commands}.Regions()
+	if err != nil {
+		exitWith(runner.ExitProviderUnavailable, "ExpressVPN client is unavailable; is expressvpnctl installed and the daemon running?", "err", err)
+	}
+
+	var input results.InputData
+	if *inputFile != "" {
+		if input, err = loadInput(*inputFile); err != nil {
+			fatal("Failed to load input file", "path", *inputFile, "err", err)
+		}
+	}
+
+	list := listRegions(filterRegions(regions, strings.Join(flags.Args(), " ")), regions, input)
+	if *jsonFlag {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(list)
+		return
+	}
+
+	for _, info := range list.Regions {
+		if len(info.Locations) > 0 {
+			fmt.Printf("%s\t* %s\n", info.Region, strings.Join(info.Locations, "; "))
+		} else {
+			fmt.Println(info.Region)
+		}
+	}
+	for _, unmatched := range list.Unmatched {
+		logger.Warn("No matching region", "location", unmatched.Location, "closest", strings.Join(unmatched.Suggestions, ", "))
+	}
+}
+
+// Resolves every input location against all regions, the way a run would, and lists the shown
+// regions with the locations that resolve to them
+func listRegions(shown []string, all []string, input results.InputData) RegionList {
+	matches := map[string][]string{}
+	var unmatched []UnmatchedLocation
+	for _, location := range input.Locations {
+		if location.IsSmart() {
+			// The client picks the region when connecting
+			continue
+		}
+		region := vpn.AliasRegion(location, input.Aliases)
+		var suggestions []string
+		if region == "" {
+			region, suggestions = vpn.MatchRegion(location, all)
+		}
+		if region == "" {
+			unmatched = append(unmatched, UnmatchedLocation{Location: location.Key(), Suggestions: suggestions})
+			continue
+		}
+		matches[region] = append(matches[region], location.Key())
+	}
+
+	list := RegionList{Regions: []RegionInfo{}, Unmatched: unmatched}
+	for _, region := range shown {
+		list.Regions = append(list.Regions, RegionInfo{Region: region, Locations: matches[region]})
+	}
+	return list
+}
+
+// Returns the regions whose slug contains the slug of the search, e.g. "New York" matches "usa-new-york"
+func filterRegions(regions []string, search string) []string {
+	slug := vpn.Slugify(search)
+	var matches []string
+	for _, region := range regions {
+		if region = strings.TrimSpace(region); region != "" && strings.Contains(region, slug) {
+			matches = append(matches, region)
+		}
+	}
+	return matches
+}
+
+// Runs the report subcommand: expressvpnspeedtest report [-units Mbps] [-html FILE] [-local-time] [-baseline-profile NAME] [-encrypt KEYFILE] <results.json>
+func runReport(args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	var display runner.Display
+	flags.StringVar(&display.Unit, "units", "Mbps", "Unit for speeds: Mbps, MB/s or Gbps")
+	htmlFile := flags.String("html", "", "Render the results as a self-contained HTML report to this file instead")
+	flags.BoolVar(&display.LocalTime, "local-time", false, "Show the times of the HTML report as local times")
+	baselineProfile := flags.String("baseline-profile", "", "Compare the locations with the latest baseline of this network profile in the file")
+	encryptFlag := flags.String("encrypt", "", "Decrypt the results file with the passphrase in this file, or in the environment variable NAME for env:NAME")
+	parseFlags(flags, args)
+
+	if flags.NArg() != 1 {
+		fatal("Usage: expressvpnspeedtest report [-units Mbps] [-html FILE] [-local-time] [-baseline-profile NAME] [-encrypt KEYFILE] <results.json>")
+	}
+	var files results.FileOptions
+	applyEncryption(&files, *encryptFlag)
+	if !slices.Contains(runner.SpeedUnits, display.Unit) {
+		fatal("Unknown speed unit", "unit", display.Unit, "valid", strings.Join(runner.SpeedUnits, ", "))
+	}
+
+	data, err := files.Load(flags.Arg(0))
+	if err != nil {
+		fatal("Failed to load results file", "path", flags.Arg(0), "err", err)
+	}
+	if *baselineProfile != "" {
+		if _, ok := data.ProfileBaseline(*baselineProfile); !ok {
+			fatal("The results file has no baseline of the network profile", "profile", *baselineProfile, "path", flags.Arg(0))
+		}
+		for i := range data.VPNStats {
+			data.VPNStats[i].PercentOfBaseline = runner.PercentOfBaseline(data, data.VPNStats[i], *baselineProfile)
+		}
+	}
+
+	if *htmlFile != "" {
+		var report bytes.Buffer
+		if err := display.RenderHTMLReport(&report, data); err != nil {
+			fatal("Failed to render HTML report", "err", err)
+		}
+		if err := results.WriteFileAtomic(*htmlFile, report.Bytes(), 0644); err != nil {
+			fatal("Failed to write HTML report", "path", *htmlFile, "err", err)
+		}
+		return
+	}
+
+	fmt.Printf("%s (%s)\n", data.MachineName, data.OS)
+	fmt.Println("Without VPN:", display.DisplaySpeeds(data.WithoutVPN))
+	pterm.DefaultTable.WithHasHeader().WithData(display.ReportTable(data)).Render()
+	if matrix := display.TimeOfDayTable(data); matrix != nil {
+		fmt.Println("By time of day:")
+		pterm.DefaultTable.WithHasHeader().WithData(matrix).Render()
+	}
+	if matrix := display.ProviderTable(data); matrix != nil {
+		fmt.Println("By provider:")
+		pterm.DefaultTable.WithHasHeader().WithData(matrix).Render()
+	}
+}
+
+// Runs the version subcommand, or -version
+func runVersion() {
+	fmt.Println(currentBuild())
+}
@@ -0,0 +1,138 @@
+// Package command runs the external binaries the benchmark depends on, such as expressvpnctl,
+// speedtest and iperf3, with per-binary path and environment overrides
+package command
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LevelTrace sits below debug and additionally logs raw command output
+const LevelTrace = slog.LevelDebug - 4
+
+// Config overrides how an external binary is invoked
+type Config struct {
+	Path string            `yaml:"path"` // Absolute path used instead of looking the name up in PATH
+	Env  map[string]string `yaml:"env"`  // Extra environment variables for the command
+	Args []string          `yaml:"args"` // Extra arguments passed before those of every invocation, e.g. --accept-license
+}
+
+// Error is a failed command together with what it printed, so callers can report why it failed
+type Error struct {
+	Command string
+	Err     error
+	Output  string // Stderr, or the combined output of RunCombined
+}
+
+func (e *Error) Error() string {
+	if e.Output == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Output
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+const maxErrorOutput = 500 // Bytes of output kept in an Error, from the end where the cause usually is
+
+// Overrides configures how external binaries are invoked, keyed by command name, e.g. "speedtest" or
+// "expressvpnctl"; a nil Overrides runs every binary from PATH as it is
+type Overrides map[string]Config
+
+// Creates a command, applying any configured binary path, extra arguments and extra environment
+func (o Overrides) New(name string, args ...string) *exec.Cmd {
+	return o.NewContext(context.Background(), name, args...)
+}
+
+// Creates a command like New that is killed when the context is done
+func (o Overrides) NewContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	override, ok := o[name]
+	if !ok {
+		return exec.CommandContext(ctx, name, args...)
+	}
+
+	binary := name
+	if override.Path != "" {
+		binary = override.Path
+	}
+	cmd := exec.CommandContext(ctx, binary, append(slices.Clone(override.Args), args...)...)
+
+	if len(override.Env) > 0 {
+		cmd.Env = append(os.Environ(), commandEnv(override.Env)...)
+	}
+	return cmd
+}
+
+// Formats extra environment variables as sorted KEY=value pairs
+func commandEnv(env map[string]string) []string {
+	var pairs []string
+	for key, value := range env {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// Runs a command and returns its stdout, logging the invocation and raw output; on failure
+// the error carries its stderr
+func (o Overrides) Run(name string, args ...string) ([]byte, error) {
+	return o.RunContext(context.Background(), name, args...)
+}
+
+// Runs a command like Run that is killed when the context is done
+func (o Overrides) RunContext(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := o.NewContext(ctx, name, args...)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	return logCommand(cmd, &out, &stderr)
+}
+
+// Runs a command and returns its combined stdout and stderr, logging the invocation and raw output
+func (o Overrides) RunCombined(name string, args ...string) ([]byte, error) {
+	return o.RunCombinedContext(context.Background(), name, args...)
+}
+
+// Runs a command like RunCombined that is killed when the context is done
+func (o Overrides) RunCombinedContext(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := o.NewContext(ctx, name, args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	return logCommand(cmd, &out, &out)
+}
+
+// Logs go to the default slog logger, which the CLI configures
+func logCommand(cmd *exec.Cmd, out *bytes.Buffer, stderr *bytes.Buffer) ([]byte, error) {
+	command := strings.Join(cmd.Args, " ")
+	slog.Debug("Executing command", "cmd", command)
+
+	start := time.Now()
+	err := cmd.Run()
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	if err != nil {
+		slog.Debug("Command failed", "cmd", command, "duration", elapsed, "err", err)
+	} else {
+		slog.Debug("Command finished", "cmd", command, "duration", elapsed)
+	}
+	slog.Log(context.Background(), LevelTrace, "Command output", "cmd", command, "output", out.String())
+
+	if err != nil {
+		output := strings.TrimSpace(stderr.String())
+		if len(output) > maxErrorOutput {
+			output = "..." + output[len(output)-maxErrorOutput:]
+		}
+		return out.Bytes(), &Error{Command: command, Err: err, Output: output}
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,57 @@
+package results
+
+import (
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Plain text equivalents of the glyphs in the output; none may contain a quote or backslash,
+// so transliterated JSON stays valid
+var glyphReplacer = strings.NewReplacer(
+	"▼", "down", "▲", "up", "→", "->", "←", "<-", "±", "+/-", "…", "...", "–", "-", "—", "-",
+	"µ", "u", "×", "x", "°", " deg", "ß", "ss", "æ", "ae", "Æ", "AE", "ø", "o", "Ø", "O",
+	"ł", "l", "Ł", "L", "đ", "d", "Đ", "D", "‘", "'", "’", "'", "“", "'", "”", "'",
+)
+
+// Returns s in plain ASCII: known glyphs spelled out, diacritics removed and anything else replaced by "?"
+func ToASCII(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+
+	var b strings.Builder
+	for _, r := range norm.NFD.String(glyphReplacer.Replace(s)) {
+		switch {
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// Accents, split off their letters by the decomposition
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// ASCIIWriter transliterates everything written through it to ASCII
+type ASCIIWriter struct {
+	W io.Writer
+}
+
+func (a ASCIIWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(a.W, ToASCII(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,83 @@
+package results
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+// Encrypted files start with the header, followed by the salt, the nonce and the AES-256-GCM ciphertext
+const (
+	encryptedHeader = "EVST-ENCRYPTED-1\n"
+	saltSize        = 16
+	kdfIterations   = 600000 // OWASP's recommendation for PBKDF2-HMAC-SHA256
+)
+
+var ErrEncrypted = errors.New("the results file is encrypted; pass -encrypt with its passphrase or key file")
+var ErrDecrypt = errors.New("wrong passphrase, or the encrypted results file is damaged")
+
+// Reports whether data is an encrypted results file
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedHeader))
+}
+
+// Encrypts data with a key derived from the passphrase and a random salt
+func Encrypt(data []byte, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append([]byte(encryptedHeader), salt...)
+	sealed = append(sealed, nonce...)
+	// The header is authenticated too, so the format version can't be swapped
+	return aead.Seal(sealed, nonce, data, []byte(encryptedHeader)), nil
+}
+
+// Decrypts data written by Encrypt
+func Decrypt(data []byte, passphrase []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, ErrDecrypt
+	}
+	data = data[len(encryptedHeader):]
+	if len(data) < saltSize {
+		return nil, ErrDecrypt
+	}
+	aead, err := newAEAD(passphrase, data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(encryptedHeader))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+func newAEAD(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, string(passphrase), salt, kdfIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
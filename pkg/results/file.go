@@ -0,0 +1,106 @@
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileOptions control how results files are written and read
+type FileOptions struct {
+	Passphrase []byte // Save encrypts results files and Load decrypts them when set
+	// Save transliterates the results to plain ASCII, for downstream systems that mangle the ▼/▲ arrows
+	// and accented location names
+	ASCII bool
+}
+
+// Loads a plain results file, see FileOptions.Load
+func Load(fileName string) (Results, error) {
+	return FileOptions{}.Load(fileName)
+}
+
+// Saves results to a plain JSON file, see FileOptions.Save
+func Save(data Results, fileName string) error {
+	return FileOptions{}.Save(data, fileName)
+}
+
+// Loads a results file, decrypting it and upgrading it from older schema versions; a missing file yields empty results
+func (o FileOptions) Load(fileName string) (Results, error) {
+	var data Results
+	file, err := os.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Results{}, nil
+		}
+		return data, err
+	}
+	if IsEncrypted(file) {
+		if o.Passphrase == nil {
+			return data, ErrEncrypted
+		}
+		if file, err = Decrypt(file, o.Passphrase); err != nil {
+			return data, err
+		}
+	}
+	if err := json.Unmarshal(file, &data); err != nil {
+		return data, err
+	}
+	if err := Migrate(&data); err != nil {
+		return data, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return data, nil
+}
+
+// Saves results to a JSON file, encrypted when a passphrase is set, replacing it atomically and keeping
+// the previous version as FILE.bak
+func (o FileOptions) Save(data Results, fileName string) error {
+	data.SchemaVersion = SchemaVersion
+	jsonData, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if o.ASCII {
+		jsonData = []byte(ToASCII(string(jsonData)))
+	}
+	if o.Passphrase != nil {
+		if jsonData, err = Encrypt(jsonData, o.Passphrase); err != nil {
+			return err
+		}
+	}
+
+	previous, err := os.ReadFile(fileName)
+	if err == nil {
+		err = WriteFileAtomic(fileName+".bak", previous, 0644)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return WriteFileAtomic(fileName, jsonData, 0644)
+}
+
+// Writes through a temp file in the same directory and renames it into place,
+// so readers never see a partial file and a crash keeps the previous version
+func WriteFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
@@ -0,0 +1,166 @@
+package results
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// IndexFileName is where a directory's history index is kept; it doesn't end in .json, so the globs over
+// the directory's results files leave it out
+const IndexFileName = ".history-index"
+
+// HistoryIndex holds the tested locations of a directory's results files by machine, region and day, so
+// a history of thousands of runs is queried without decoding every file; refreshing it reads only the
+// files that changed since it was saved
+type HistoryIndex struct {
+	Files   map[string]IndexedFile             `json:"files"` // By base name
+	regions map[string]map[string][]IndexEntry // By machine and region, in the order they were tested
+	changed bool
+}
+
+// IndexedFile is what the index keeps of one file; files that aren't results files are kept without a
+// machine, so they aren't read again either
+type IndexedFile struct {
+	ModTime time.Time    `json:"modTime"`
+	Size    int64        `json:"size"`
+	Machine string       `json:"machine,omitempty"`
+	Entries []IndexEntry `json:"entries,omitempty"`
+}
+
+// IndexEntry is one tested location of a results file
+type IndexEntry struct {
+	Region       string    `json:"region"` // The location's name
+	Day          string    `json:"day"`    // The UTC day it was tested, as 2006-01-02
+	RunID        string    `json:"runId,omitempty"`
+	Tested       time.Time `json:"tested"`
+	DownloadMbps float64   `json:"downloadMbps"`
+	UploadMbps   float64   `json:"uploadMbps"`
+}
+
+// Loads the index of a directory's results files and refreshes it from the files that were added, changed
+// or removed since; a missing or unreadable index is rebuilt from all of them
+func (o FileOptions) LoadIndex(dir string) (HistoryIndex, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return HistoryIndex{}, err
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return HistoryIndex{}, err
+	}
+
+	var index HistoryIndex
+	if file, err := os.ReadFile(filepath.Join(dir, IndexFileName)); err == nil {
+		if IsEncrypted(file) && o.Passphrase != nil {
+			file, err = Decrypt(file, o.Passphrase)
+		}
+		if err != nil || json.Unmarshal(file, &index) != nil {
+			index = HistoryIndex{}
+		}
+	}
+	if index.Files == nil {
+		index.Files, index.changed = map[string]IndexedFile{}, true
+	}
+
+	seen := map[string]bool{}
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(match)
+		seen[name] = true
+		if indexed, ok := index.Files[name]; ok && indexed.ModTime.Equal(info.ModTime()) && indexed.Size == info.Size() {
+			continue
+		}
+		indexed := IndexedFile{ModTime: info.ModTime(), Size: info.Size()}
+		if data, err := o.Load(match); err == nil {
+			indexed.Machine = data.MachineName
+			indexed.Entries = indexEntries(data.VPNStats)
+		}
+		index.Files[name], index.changed = indexed, true
+	}
+	for name := range index.Files {
+		if !seen[name] {
+			delete(index.Files, name)
+			index.changed = true
+		}
+	}
+
+	index.regions = map[string]map[string][]IndexEntry{}
+	for _, file := range index.Files {
+		if file.Machine == "" {
+			continue
+		}
+		if index.regions[file.Machine] == nil {
+			index.regions[file.Machine] = map[string][]IndexEntry{}
+		}
+		for _, entry := range file.Entries {
+			index.regions[file.Machine][entry.Region] = append(index.regions[file.Machine][entry.Region], entry)
+		}
+	}
+	for _, regions := range index.regions {
+		for _, entries := range regions {
+			slices.SortStableFunc(entries, func(a, b IndexEntry) int { return a.Tested.Compare(b.Tested) })
+		}
+	}
+	return index, nil
+}
+
+// Saves the index next to the results files when it changed, encrypted like them when a passphrase is set
+func (o FileOptions) SaveIndex(index HistoryIndex, dir string) error {
+	if !index.changed {
+		return nil
+	}
+	jsonData, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if o.Passphrase != nil {
+		if jsonData, err = Encrypt(jsonData, o.Passphrase); err != nil {
+			return err
+		}
+	}
+	return WriteFileAtomic(filepath.Join(dir, IndexFileName), jsonData, 0644)
+}
+
+// Returns the entries of a results file's locations that have a time
+func indexEntries(stats []VPNStat) []IndexEntry {
+	var entries []IndexEntry
+	for _, stat := range stats {
+		tested := stat.Tested()
+		if tested.IsZero() {
+			continue
+		}
+		entries = append(entries, IndexEntry{
+			Region:       stat.LocationName,
+			Day:          tested.UTC().Format(time.DateOnly),
+			RunID:        stat.RunID,
+			Tested:       tested.UTC(),
+			DownloadMbps: ParseMbps(stat.VPNDownloadSpeed),
+			UploadMbps:   ParseMbps(stat.VPNUploadSpeed),
+		})
+	}
+	return entries
+}
+
+// Returns the regions the machine tested, each with its entries in the order they were tested
+func (x HistoryIndex) Regions(machine string) map[string][]IndexEntry {
+	return x.regions[machine]
+}
+
+// Returns the entries of a region the machine tested on a UTC day, given as 2006-01-02
+func (x HistoryIndex) Day(machine, region, day string) []IndexEntry {
+	entries := x.regions[machine][region]
+	start, _ := slices.BinarySearchFunc(entries, day, func(entry IndexEntry, day string) int {
+		return strings.Compare(entry.Day, day)
+	})
+	end := start
+	for end < len(entries) && entries[end].Day == day {
+		end++
+	}
+	return entries[start:end]
+}
@@ -0,0 +1,105 @@
+package results
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the results file format this build writes. Bump it with every
+// change older files need upgrading for, and add the upgrade to migrations
+const SchemaVersion = 2
+
+// migrations[i] upgrades a results file from schema version i to i+1
+var migrations = []func(*Results){
+	migrateUnversioned,
+	migrateUTCTimes,
+}
+
+// Upgrades results loaded from an older file to the current schema version; files written by a
+// newer version are refused, as saving them again would drop the fields this build doesn't know
+func Migrate(data *Results) error {
+	if data.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("written with results schema version %d, this version reads up to %d", data.SchemaVersion, SchemaVersion)
+	}
+	for _, migrate := range migrations[data.SchemaVersion:] {
+		migrate(data)
+	}
+	data.SchemaVersion = SchemaVersion
+	return nil
+}
+
+// Upgrades a file from before versioning, which may predate OSInfo and the per-run sections
+func migrateUnversioned(data *Results) {
+	if data.OSInfo == (OSInfo{}) && data.OS != "" {
+		name, version, _ := strings.Cut(data.OS, ": ")
+		data.OSInfo = OSInfo{Name: name, Version: version}
+	}
+	if len(data.Runs) > 0 || len(data.VPNStats) == 0 {
+		return
+	}
+
+	// The whole file is a single run; give it a section and claim its locations for it
+	run := Run{ID: legacyRunID(*data), WithoutVPN: data.WithoutVPN, Network: data.Network, Conflicts: data.Conflicts}
+	if data.RunInfo != nil {
+		run.RunInfo = *data.RunInfo
+	}
+	data.Runs = []Run{run}
+	for i := range data.VPNStats {
+		if data.VPNStats[i].RunID == "" {
+			data.VPNStats[i].RunID = run.ID
+		}
+	}
+}
+
+// Derives the ID of the single run of an unversioned file from when it started, like the IDs of later runs
+func legacyRunID(data Results) string {
+	started := data.VPNStats[0].Timestamp
+	if data.RunInfo != nil && data.RunInfo.Started != "" {
+		started = data.RunInfo.Started
+	}
+	if t, err := time.Parse(legacyTimeLayout, started); err == nil {
+		return t.Format("20060102150405")
+	}
+	return "legacy"
+}
+
+// Upgrades a version 1 file, whose times were local times without a zone, to RFC 3339 in UTC, so runs
+// appended by newer versions don't mix both. The times are read in the local zone of the machine loading
+// the file, normally the one that wrote it
+func migrateUTCTimes(data *Results) {
+	upgrade := func(stored *string) {
+		if t, err := time.ParseInLocation(legacyTimeLayout, *stored, time.Local); err == nil {
+			*stored = FormatTime(t)
+		}
+	}
+	upgradeRunInfo := func(info *RunInfo) {
+		upgrade(&info.Started)
+		upgrade(&info.Finished)
+	}
+
+	if data.RunInfo != nil {
+		upgradeRunInfo(data.RunInfo)
+	}
+	for i := range data.Runs {
+		upgradeRunInfo(&data.Runs[i].RunInfo)
+	}
+	for i := range data.VPNStats {
+		stat := &data.VPNStats[i]
+		upgrade(&stat.Timestamp)
+		if t, err := time.Parse(time.RFC3339, stat.Timestamp); err == nil && stat.TimestampUnix == 0 {
+			stat.TimestampUnix = t.Unix()
+		}
+		for j := range stat.ConnectionDrops {
+			upgrade(&stat.ConnectionDrops[j].Time)
+		}
+		if stat.Soak != nil {
+			for j := range stat.Soak.Samples {
+				upgrade(&stat.Soak.Samples[j].Time)
+			}
+			for j := range stat.Soak.StateChanges {
+				upgrade(&stat.Soak.StateChanges[j].Time)
+			}
+		}
+	}
+}
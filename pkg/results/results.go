@@ -0,0 +1,440 @@
+// Package results defines the input and results file formats of the benchmark and reads and
+// writes them
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Location is a place to test, as listed in the input file
+type Location struct {
+	Country    string `json:"country"`
+	City       string `json:"city"`
+	Samples    int    `json:"samples,omitempty"`                  // Overrides -r for this location
+	Parallel   *bool  `json:"parallel,omitempty"`                 // Overrides -s for this location
+	PreHook    string `json:"preHook,omitempty" yaml:"preHook"`   // Shell command run after -pre-hook, before connecting
+	PostHook   string `json:"postHook,omitempty" yaml:"postHook"` // Shell command run before -post-hook, after disconnecting
+	Provider   string `json:"-" yaml:"-"`                         // Name of the provider section listing it; empty for ExpressVPN's top-level list
+	Assertions `yaml:",inline"`
+}
+
+// InputData is the input file: the locations to test, optional region aliases and other providers to compare
+type InputData struct {
+	Aliases   map[string]string `json:"aliases"`
+	Locations []Location        `json:"locations"`
+	Providers []ProviderInput   `json:"providers,omitempty"`
+}
+
+// ProviderInput is a VPN provider of the input file with the locations to test through it
+type ProviderInput struct {
+	Name       string     `json:"name"`
+	Type       string     `json:"type,omitempty"`       // expressvpn (default), command, wireguard or openvpn
+	Connect    string     `json:"connect,omitempty"`    // Command connecting to {region}, {country} and {city}, for the command type
+	Disconnect string     `json:"disconnect,omitempty"` // Command disconnecting, for the command type
+	Status     string     `json:"status,omitempty"`     // Optional command exiting with 0 while connected, for the command type
+	Dir        string     `json:"dir,omitempty"`        // Directory of .conf or .ovpn files, each a region, for the wireguard and openvpn types
+	Locations  []Location `json:"locations,omitempty"`  // Optional for the wireguard and openvpn types, which then test every file
+}
+
+// Results is a results file: the machine, the baseline without VPN and one entry per location.
+// A file written with -append holds several runs; WithoutVPN, Conflicts, Network and RunInfo are those of the latest
+type Results struct {
+	SchemaVersion int       `json:"SchemaVersion"` // Format version; older files are upgraded as they are loaded
+	MachineName   string    `json:"MachineName"`
+	OS            string    `json:"OS"`
+	OSInfo        OSInfo    `json:"OSInfo"`
+	WithoutVPN    string    `json:"WithoutVPN"`
+	Conflicts     []string  `json:"Conflicts,omitempty"`
+	Network       *Network  `json:"Network,omitempty"`
+	RunInfo       *RunInfo  `json:"RunInfo,omitempty"`
+	Runs          []Run     `json:"Runs,omitempty"`
+	VPNStats      []VPNStat `json:"VPNStats"`
+}
+
+// Run is the section of one invocation in a results file; its locations are the VPNStats with its ID
+type Run struct {
+	ID string `json:"ID"`
+	RunInfo
+	WithoutVPN string   `json:"WithoutVPN"`
+	Network    *Network `json:"Network,omitempty"`
+	Conflicts  []string `json:"Conflicts,omitempty"`
+}
+
+// Network is the connection the baseline was measured on, without VPN
+type Network struct {
+	Profile      string `json:"Profile,omitempty"` // Name given with -network-profile, e.g. wired or wifi
+	PublicIP     string `json:"PublicIP,omitempty"`
+	ISP          string `json:"ISP,omitempty"`          // As reported by the IP echo service
+	SpeedtestISP string `json:"SpeedtestISP,omitempty"` // As detected by the speed test engine
+}
+
+// RunInfo records how a run was made, so its results can be reproduced and audited
+type RunInfo struct {
+	UUID          string            `json:"UUID,omitempty"` // Unique across machines, unlike the timestamp-based run ID
+	ToolVersion   string            `json:"ToolVersion"`
+	ToolCommit    string            `json:"ToolCommit,omitempty"`    // Git commit the tool was built from, with -dirty for uncommitted changes
+	ToolBuildDate string            `json:"ToolBuildDate,omitempty"` // When the tool was built, or its commit was made when the build didn't record it
+	GoVersion     string            `json:"GoVersion,omitempty"`     // Go release the tool was built with
+	Flags         []string          `json:"Flags,omitempty"`         // Flags set on the command line or by the config file
+	Tags          map[string]string `json:"Tags,omitempty"`          // -tag key=value pairs for grouping runs downstream
+	Engine        string            `json:"Engine"`
+	EngineVersion string            `json:"EngineVersion,omitempty"`
+	IPVersion     int               `json:"IPVersion,omitempty"`     // IP version the tests were forced over; absent when the engine picked
+	ClientVersion string            `json:"ClientVersion,omitempty"` // ExpressVPN client version
+	NetworkLock   *bool             `json:"NetworkLock,omitempty"`   // Whether ExpressVPN's network lock (kill switch) was enabled; absent when unknown
+	Started       string            `json:"Started"`
+	Finished      string            `json:"Finished,omitempty"`
+	Duration      string            `json:"Duration,omitempty"`
+	DataUsedMB    float64           `json:"DataUsedMB,omitempty"`   // Transferred by the speed tests, estimated for engines that don't report it
+	StoppedEarly  string            `json:"StoppedEarly,omitempty"` // Why the run stopped before its last location, e.g. a -max-data budget
+	TimedOut      []string          `json:"TimedOut,omitempty"`     // Locations that exceeded -location-timeout and have no result
+}
+
+// Returns the latest run that measured a baseline on the network profile
+func (r Results) ProfileBaseline(profile string) (Run, bool) {
+	for i := len(r.Runs) - 1; i >= 0; i-- {
+		run := r.Runs[i]
+		if run.WithoutVPN != "" && run.Network != nil && run.Network.Profile == profile {
+			return run, true
+		}
+	}
+	return Run{}, false
+}
+
+// Reports whether the results file already has a section for the run
+func (r Results) HasRun(id string) bool {
+	for _, run := range r.Runs {
+		if run.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the results with only the locations of one run
+func (r Results) RunResults(id string) Results {
+	run := r
+	run.VPNStats = slices.DeleteFunc(slices.Clone(r.VPNStats), func(stat VPNStat) bool { return stat.RunID != id })
+	return run
+}
+
+// VPNStat is the averaged result of one location
+type VPNStat struct {
+	RunID             string                     `json:"RunID,omitempty"`
+	Provider          string                     `json:"Provider,omitempty"` // Provider section of the input file; empty for ExpressVPN's top-level list
+	LocationName      string                     `json:"LocationName"`
+	Region            string                     `json:"Region,omitempty"` // VPN region connected to; for the smart location, the one the client picked
+	TimeToConnect     string                     `json:"TimeToConnect"`
+	ConnectTimes      *ConnectTimes              `json:"ConnectTimes,omitempty"`
+	ConnectPhases     *ConnectPhases             `json:"ConnectPhases,omitempty"`
+	VPNDownloadSpeed  string                     `json:"VPNDownloadSpeed"`
+	VPNUploadSpeed    string                     `json:"VPNUploadSpeed"`
+	VPNLatency        string                     `json:"VPNLatency"`
+	VPNJitter         string                     `json:"VPNJitter"`
+	VPNPacketLoss     string                     `json:"VPNPacketLoss"`
+	DNSResolveTime    string                     `json:"DNSResolveTime,omitempty"`
+	Web               []WebTiming                `json:"Web,omitempty"`
+	ExitIP            string                     `json:"ExitIP,omitempty"`
+	ExitCountry       string                     `json:"ExitCountry,omitempty"`
+	ExitCountryMatch  *bool                      `json:"ExitCountryMatch,omitempty"`
+	IPv6              *bool                      `json:"IPv6,omitempty"` // Whether the location reached an IPv6 host; absent when not checked
+	EgressInterface   string                     `json:"EgressInterface,omitempty"`
+	RouteThroughVPN   *bool                      `json:"RouteThroughVPN,omitempty"`
+	HopCount          int                        `json:"HopCount,omitempty"`
+	ExitDistanceKm    float64                    `json:"ExitDistanceKm,omitempty"`
+	Server            string                     `json:"Server"`
+	Timestamp         string                     `json:"Date/Time"`
+	TimestampUnix     int64                      `json:"TimestampUnix,omitempty"` // Seconds since the epoch, for tools that don't parse dates
+	TimeWindow        string                     `json:"TimeWindow,omitempty"`    // Time of day of the -times-of-day pass, e.g. 09:00
+	Mode              string                     `json:"Mode"`
+	DownloadSamples   []float64                  `json:"DownloadSamples,omitempty"`
+	UploadSamples     []float64                  `json:"UploadSamples,omitempty"`
+	ResultURLs        []string                   `json:"ResultURLs,omitempty"` // Result pages of the samples on speedtest.net, with the Ookla engine
+	PercentOfBaseline *PercentOfBaseline         `json:"PercentOfBaseline,omitempty"`
+	SingleStream      *SingleStream              `json:"SingleStream,omitempty"`
+	Contention        *Contention                `json:"Contention,omitempty"`
+	InterfaceCounters *InterfaceCounters         `json:"InterfaceCounters,omitempty"`
+	CPU               *CPUUsage                  `json:"CPU,omitempty"`
+	MTU               *MTU                       `json:"MTU,omitempty"`
+	CustomProbes      map[string]json.RawMessage `json:"CustomProbes,omitempty"` // JSON each -probe plugin printed, by probe name
+	SamplesAttempted  int                        `json:"SamplesAttempted,omitempty"`
+	SamplesSucceeded  int                        `json:"SamplesSucceeded,omitempty"`
+	SampleErrors      []string                   `json:"SampleErrors,omitempty"`    // Why each failed sample failed, with the command's output
+	InvalidSamples    int                        `json:"InvalidSamples,omitempty"`  // Speed tests discarded and run again because the VPN connection dropped
+	ConnectionDrops   []StateChange              `json:"ConnectionDrops,omitempty"` // States other than Connected polled during the speed tests
+	AssertionsPassed  *bool                      `json:"AssertionsPassed,omitempty"`
+	AssertionFailures []string                   `json:"AssertionFailures,omitempty"`
+	CrossCheck        *CrossCheck                `json:"CrossCheck,omitempty"`
+	Soak              *Soak                      `json:"Soak,omitempty"`
+}
+
+// OSInfo is the structured description of the machine running the tests
+type OSInfo struct {
+	Name    string `json:"Name"`
+	Version string `json:"Version"`
+	Kernel  string `json:"Kernel"`
+	Arch    string `json:"Arch"`
+}
+
+// ConnectPhases breaks the connect the tests ran on down into where it spent its time, each measured from
+// when the connect command was issued
+type ConnectPhases struct {
+	Command    string         `json:"Command"`              // Until the connect command returned
+	States     []ConnectState `json:"States,omitempty"`     // When the client first reported each state while polled
+	Connected  string         `json:"Connected"`            // Until the client reported Connected
+	FirstProbe string         `json:"FirstProbe,omitempty"` // Until the first connection through the tunnel succeeded; absent when none did
+}
+
+// ConnectState is a connection state the client reported, e.g. Connecting, and when it was first seen
+type ConnectState struct {
+	State string `json:"State"`
+	At    string `json:"At"`
+}
+
+// ConnectTimes summarizes the connect times of several connect/disconnect cycles
+type ConnectTimes struct {
+	Cycles int    `json:"Cycles"`
+	Min    string `json:"Min"`
+	Avg    string `json:"Avg"`
+	Max    string `json:"Max"`
+}
+
+// WebTiming is one page fetch of the web probe, split into the phases that make up browsing latency
+type WebTiming struct {
+	URL     string `json:"URL"`
+	Status  int    `json:"Status,omitempty"`
+	DNS     string `json:"DNS,omitempty"` // Empty when the URL's host is an IP address
+	Connect string `json:"Connect,omitempty"`
+	TLS     string `json:"TLS,omitempty"`  // Empty for http:// URLs
+	TTFB    string `json:"TTFB,omitempty"` // From the start of the fetch to the first byte of the response
+	Total   string `json:"Total,omitempty"`
+	Error   string `json:"Error,omitempty"`
+}
+
+// CrossCheck is the result of the second engine run right after a location's samples
+type CrossCheck struct {
+	Engine             string `json:"Engine"`
+	VPNDownloadSpeed   string `json:"VPNDownloadSpeed"`
+	VPNUploadSpeed     string `json:"VPNUploadSpeed"`
+	DownloadDifference string `json:"DownloadDifference"` // Relative to the primary engine
+	UploadDifference   string `json:"UploadDifference"`
+	Disagrees          bool   `json:"Disagrees"`
+}
+
+// PercentOfBaseline is a location's speeds as a percentage of those without VPN, its overhead at a glance
+type PercentOfBaseline struct {
+	Profile  string  `json:"Profile,omitempty"` // Network profile of the baseline compared with
+	Download float64 `json:"Download,omitempty"`
+	Upload   float64 `json:"Upload,omitempty"`
+}
+
+// SingleStream is the speed test run on its own before the parallel tests in hybrid mode,
+// measuring what one connection gets rather than the link's capacity
+type SingleStream struct {
+	VPNDownloadSpeed string `json:"VPNDownloadSpeed"`
+	VPNUploadSpeed   string `json:"VPNUploadSpeed"`
+	VPNLatency       string `json:"VPNLatency"`
+}
+
+// Contention records parallel speed tests that together used up the link, so that each measured its
+// share of the link rather than what the VPN can do, and what the run did about it
+type Contention struct {
+	SumMbps      float64 `json:"SumMbps"`      // Download speeds of the tests running at once, added up
+	BaselineMbps float64 `json:"BaselineMbps"` // The link's download speed without VPN
+	Decision     string  `json:"Decision"`
+}
+
+// InterfaceCounters compares what the network interfaces carried during a location's tests with the
+// goodput the speed tests reported
+type InterfaceCounters struct {
+	TunnelInterface   string  `json:"TunnelInterface,omitempty"`
+	TunnelMB          float64 `json:"TunnelMB,omitempty"` // Received and sent through the tunnel
+	PhysicalInterface string  `json:"PhysicalInterface,omitempty"`
+	PhysicalMB        float64 `json:"PhysicalMB,omitempty"`      // Received and sent on the interface without VPN, which carries the encrypted tunnel
+	GoodputMB         float64 `json:"GoodputMB,omitempty"`       // Reported by the speed tests; absent for engines that don't report it
+	OverheadPercent   float64 `json:"OverheadPercent,omitempty"` // How much more the physical interface carried than the tunnel: the VPN protocol's overhead
+}
+
+// CPUUsage is the CPU and memory use sampled while a location's tests ran; on small devices the tunnel's
+// encryption can use up the CPU before the link
+type CPUUsage struct {
+	SystemPercent    float64 `json:"SystemPercent"` // Of all cores, on average
+	SystemMaxPercent float64 `json:"SystemMaxPercent"`
+	MemoryPercent    float64 `json:"MemoryPercent,omitempty"` // Of system memory in use, at most; absent when unknown
+	Daemon           string  `json:"Daemon,omitempty"`        // VPN daemon process sampled; absent when none was found
+	DaemonPercent    float64 `json:"DaemonPercent,omitempty"` // Of one core, like top, on average
+	DaemonMaxPercent float64 `json:"DaemonMaxPercent,omitempty"`
+	DaemonMemoryMB   float64 `json:"DaemonMemoryMB,omitempty"` // Resident, at most
+}
+
+// MTU is the path MTU found through the tunnel and what is wrong with it, if anything
+type MTU struct {
+	PathMTU      int    `json:"PathMTU"`                // Largest packet that reached the target unfragmented
+	Interface    string `json:"Interface,omitempty"`    // Interface the target was reached through
+	InterfaceMTU int    `json:"InterfaceMTU,omitempty"` // Absent when unknown
+	Blackhole    bool   `json:"Blackhole,omitempty"`    // Packets too large were dropped without a fragmentation needed error
+	Issue        string `json:"Issue,omitempty"`
+}
+
+// Soak records how a connection held up while staying connected to a region
+type Soak struct {
+	Duration     string        `json:"Duration"`
+	Samples      []SoakSample  `json:"Samples"`
+	StateChanges []StateChange `json:"StateChanges,omitempty"`
+	Disconnects  int           `json:"Disconnects"`
+}
+
+// SoakSample is one periodic speed test of a soak
+type SoakSample struct {
+	Time             string `json:"Time"`
+	VPNDownloadSpeed string `json:"VPNDownloadSpeed,omitempty"`
+	VPNUploadSpeed   string `json:"VPNUploadSpeed,omitempty"`
+	VPNLatency       string `json:"VPNLatency,omitempty"`
+	Error            string `json:"Error,omitempty"`
+}
+
+// StateChange is a change of the VPN connection state reported by expressvpnctl
+type StateChange struct {
+	Time  string `json:"Time"`
+	State string `json:"State"`
+}
+
+// Identifies a location as "Country, City", or "Provider: Country, City" when listed in a provider section
+func (l Location) Key() string {
+	key := l.Country + ", " + l.City
+	if l.IsSmart() {
+		key = "smart"
+	}
+	if l.Provider != "" {
+		// The same place may be tested through several providers
+		return l.Provider + ": " + key
+	}
+	return key
+}
+
+// Returns when the location was tested, the zero time when the file doesn't tell
+func (s VPNStat) Tested() time.Time {
+	if s.TimestampUnix != 0 {
+		return time.Unix(s.TimestampUnix, 0)
+	}
+	t, _ := ParseTime(s.Timestamp)
+	return t
+}
+
+// Reports whether this is the "smart" pseudo-location, which lets the VPN client pick the region
+func (l Location) IsSmart() bool {
+	return strings.EqualFold(strings.TrimSpace(l.Country), "smart") && l.City == ""
+}
+
+// Records a state change; leaving the connected state counts as a disconnect
+func (s *Soak) ObserveState(previous, state string, at time.Time) {
+	if state == previous {
+		return
+	}
+	s.StateChanges = append(s.StateChanges, StateChange{Time: FormatTime(at), State: state})
+	if previous == "Connected" {
+		s.Disconnects++
+	}
+}
+
+// Layout of the local times files written before timestamps carried their zone
+const legacyTimeLayout = "2006-01-02 15:04:05"
+
+// Formats a time to store in the results file: RFC 3339 in UTC, so results of machines in different
+// time zones order correctly
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Parses a stored time, reading the zoneless times of older files as local times
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(legacyTimeLayout, s, time.Local)
+}
+
+// Parses the speeds of a baseline such as "900.00Mbps ▼  90.00Mbps ▲", or "900.00Mbps down  90.00Mbps up"
+// as written with -ascii; 0 for a direction it doesn't have, e.g. for a latency baseline
+func ParseBaseline(s string) (download, upload float64) {
+	fields := strings.Fields(s)
+	for i := 0; i+1 < len(fields); i += 2 {
+		switch fields[i+1] {
+		case "▼", "down":
+			download = ParseMbps(fields[i])
+		case "▲", "up":
+			upload = ParseMbps(fields[i])
+		}
+	}
+	return download, upload
+}
+
+// Returns the location's speeds as a percentage of the baseline's, nil when neither can be compared
+func (s VPNStat) PercentOf(withoutVPN string) *PercentOfBaseline {
+	baseDownload, baseUpload := ParseBaseline(withoutVPN)
+	var percent PercentOfBaseline
+	if download := ParseMbps(s.VPNDownloadSpeed); baseDownload > 0 && download > 0 {
+		percent.Download = math.Round(download/baseDownload*1000) / 10
+	}
+	if upload := ParseMbps(s.VPNUploadSpeed); baseUpload > 0 && upload > 0 {
+		percent.Upload = math.Round(upload/baseUpload*1000) / 10
+	}
+	if percent == (PercentOfBaseline{}) {
+		return nil
+	}
+	return &percent
+}
+
+// Parses a stored speed such as "397.00Mbps"
+func ParseMbps(s string) float64 {
+	return ParseUnit(s, "Mbps")
+}
+
+// Parses a stored value such as "45.20ms" or "0.25%"
+func ParseUnit(s string, unit string) float64 {
+	value, _ := strconv.ParseFloat(strings.TrimSuffix(s, unit), 64)
+	return value
+}
+
+// Assertions are the pass/fail thresholds a location declares in the input file
+type Assertions struct {
+	MinDownloadMbps *float64 `json:"minDownloadMbps,omitempty" yaml:"minDownloadMbps"`
+	MinUploadMbps   *float64 `json:"minUploadMbps,omitempty" yaml:"minUploadMbps"`
+	MaxLatencyMs    *float64 `json:"maxLatencyMs,omitempty" yaml:"maxLatencyMs"`
+	MaxJitterMs     *float64 `json:"maxJitterMs,omitempty" yaml:"maxJitterMs"`
+	MaxPacketLoss   *float64 `json:"maxPacketLoss,omitempty" yaml:"maxPacketLoss"` // Percent
+}
+
+// Returns a description of every assertion the result violates
+func (a Assertions) Evaluate(stat VPNStat) []string {
+	var failures []string
+	check := func(name string, limit *float64, value float64, unit string, min bool) {
+		if limit == nil {
+			return
+		}
+		if min && value < *limit {
+			failures = append(failures, fmt.Sprintf("%s %.2f%s < %g%s", name, value, unit, *limit, unit))
+		}
+		if !min && value > *limit {
+			failures = append(failures, fmt.Sprintf("%s %.2f%s > %g%s", name, value, unit, *limit, unit))
+		}
+	}
+
+	check("download", a.MinDownloadMbps, ParseMbps(stat.VPNDownloadSpeed), "Mbps", true)
+	check("upload", a.MinUploadMbps, ParseMbps(stat.VPNUploadSpeed), "Mbps", true)
+	check("latency", a.MaxLatencyMs, ParseUnit(stat.VPNLatency, "ms"), "ms", false)
+	check("jitter", a.MaxJitterMs, ParseUnit(stat.VPNJitter, "ms"), "ms", false)
+	check("packet loss", a.MaxPacketLoss, ParseUnit(stat.VPNPacketLoss, "%"), "%", false)
+	return failures
+}
+
+// Reports whether the location declares any assertion
+func (a Assertions) Any() bool {
+	return a.MinDownloadMbps != nil || a.MinUploadMbps != nil || a.MaxLatencyMs != nil || a.MaxJitterMs != nil || a.MaxPacketLoss != nil
+}
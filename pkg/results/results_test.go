@@ -0,0 +1,259 @@
+package results
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunningStats(t *testing.T) {
+	var s RunningStats
+	assert.Equal(t, 0.0, s.Variance())
+
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		s.Add(v)
+	}
+	assert.Equal(t, 8, s.Count)
+	assert.InDelta(t, 5.0, s.Mean, 1e-9)
+	assert.InDelta(t, 32.0/7, s.Variance(), 1e-9)
+	assert.InDelta(t, math.Sqrt(32.0/7), s.StdDev(), 1e-9)
+
+	// Large offsets must not lose precision the way a naive sum of squares does
+	big := NewRunningStats(1e9+4, 1e9+7, 1e9+13, 1e9+16)
+	assert.InDelta(t, 30.0, big.Variance(), 1e-6)
+}
+
+func TestHistoryIndex(t *testing.T) {
+	dir := t.TempDir()
+	save := func(name, runID string, tested time.Time, mbps ...string) {
+		data := Results{MachineName: "probe-1"}
+		for i, location := range []string{"Netherlands, Amsterdam", "Romania, Bucharest"}[:len(mbps)] {
+			data.VPNStats = append(data.VPNStats, VPNStat{RunID: runID, LocationName: location, VPNDownloadSpeed: mbps[i], Timestamp: FormatTime(tested.Add(time.Duration(i) * time.Minute))})
+		}
+		assert.NoError(t, Save(data, filepath.Join(dir, name)))
+	}
+	save("a.json", "1", time.Date(2025, 3, 1, 23, 30, 0, 0, time.UTC), "100Mbps", "50Mbps")
+	save("b.json", "2", time.Date(2025, 3, 2, 8, 0, 0, 0, time.UTC), "80Mbps")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "locations.json"), []byte(`{"locations": []}`), 0644))
+
+	index, err := FileOptions{}.LoadIndex(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, FileOptions{}.SaveIndex(index, dir))
+	assert.Len(t, index.Regions("probe-1")["Netherlands, Amsterdam"], 2)
+	assert.Empty(t, index.Regions("probe-2"))
+	day := index.Day("probe-1", "Netherlands, Amsterdam", "2025-03-02")
+	assert.Len(t, day, 1)
+	assert.Equal(t, IndexEntry{Region: "Netherlands, Amsterdam", Day: "2025-03-02", RunID: "2", Tested: time.Date(2025, 3, 2, 8, 0, 0, 0, time.UTC), DownloadMbps: 80}, day[0])
+	assert.Empty(t, index.Day("probe-1", "Romania, Bucharest", "2025-03-02"))
+
+	// The changed file is read again, and a removed one leaves the index
+	assert.NoError(t, os.Remove(filepath.Join(dir, "a.json")))
+	save("b.json", "2", time.Date(2025, 3, 2, 8, 0, 0, 0, time.UTC), "80Mbps", "60Mbps")
+	index, err = FileOptions{}.LoadIndex(dir)
+	assert.NoError(t, err)
+	assert.Len(t, index.Regions("probe-1")["Netherlands, Amsterdam"], 1)
+	assert.Len(t, index.Day("probe-1", "Romania, Bucharest", "2025-03-02"), 1)
+	assert.Contains(t, index.Files, "locations.json")
+
+	_, err = FileOptions{}.LoadIndex(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestSaveToFileIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test_results.json")
+
+	assert.NoError(t, Save(Results{MachineName: "First"}, testFile))
+	assert.NoError(t, Save(Results{MachineName: "Second"}, testFile))
+
+	loadedData, err := Load(testFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "Second", loadedData.MachineName)
+
+	// The previous version is kept as a backup
+	backup, err := Load(testFile + ".bak")
+	assert.NoError(t, err)
+	assert.Equal(t, "First", backup.MachineName)
+
+	// No temp files are left behind next to the results
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+}
+
+func TestSaveASCII(t *testing.T) {
+	assert.Equal(t, "849.00Mbps down  845.00Mbps up", ToASCII("849.00Mbps ▼  845.00Mbps ▲"))
+	assert.Equal(t, "Brazil, Sao Paulo", ToASCII("Brazil, São Paulo"))
+	assert.Equal(t, "Zurich, Koln, Malmo", ToASCII("Zürich, Köln, Malmö"))
+	assert.Equal(t, "Tokyo ??", ToASCII("Tokyo 東京"))
+
+	opts := FileOptions{ASCII: true}
+	testFile := filepath.Join(t.TempDir(), "test_results.json")
+	assert.NoError(t, opts.Save(Results{MachineName: "Kraków", WithoutVPN: "100.00Mbps ▼  20.00Mbps ▲"}, testFile))
+
+	file, err := os.ReadFile(testFile)
+	assert.NoError(t, err)
+	for _, b := range file {
+		assert.Less(t, b, byte(0x80), "Only ASCII is written")
+	}
+	loadedData, err := opts.Load(testFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "Krakow", loadedData.MachineName)
+	assert.Equal(t, "100.00Mbps down  20.00Mbps up", loadedData.WithoutVPN)
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	legacyFile := filepath.Join(dir, "legacy.json")
+	assert.NoError(t, os.WriteFile(legacyFile, []byte(`{
+  "MachineName": "probe",
+  "OS": "Ubuntu: 20.04 LTS",
+  "WithoutVPN": "900.00Mbps ▼  90.00Mbps ▲",
+  "VPNStats": [{"LocationName": "Netherlands, Amsterdam", "Date/Time": "2024-06-01 09:30:00"}]
+}`), 0644))
+
+	data, err := Load(legacyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaVersion, data.SchemaVersion)
+	assert.Equal(t, OSInfo{Name: "Ubuntu", Version: "20.04 LTS"}, data.OSInfo)
+	assert.Equal(t, []Run{{ID: "20240601093000", WithoutVPN: "900.00Mbps ▼  90.00Mbps ▲"}}, data.Runs)
+	assert.Equal(t, "20240601093000", data.VPNStats[0].RunID)
+
+	assert.NoError(t, Save(Results{MachineName: "probe"}, legacyFile))
+	file, err := os.ReadFile(legacyFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(file), `"SchemaVersion": 2`)
+
+	// Version 1 files stored local times without a zone
+	origLocal := time.Local
+	defer func() { time.Local = origLocal }()
+	time.Local = time.FixedZone("CET", 3600)
+	v1File := filepath.Join(dir, "v1.json")
+	assert.NoError(t, os.WriteFile(v1File, []byte(`{
+  "SchemaVersion": 1,
+  "RunInfo": {"Started": "2025-03-01 09:00:00", "Finished": "2025-03-01 10:00:00"},
+  "Runs": [{"ID": "20250301090000", "Started": "2025-03-01 09:00:00"}],
+  "VPNStats": [{
+    "LocationName": "Netherlands, Amsterdam",
+    "Date/Time": "2025-03-01 09:30:00",
+    "ConnectionDrops": [{"Time": "2025-03-01 09:31:00", "State": "Reconnecting"}],
+    "Soak": {"Samples": [{"Time": "2025-03-01 09:40:00"}], "StateChanges": [{"Time": "2025-03-01 09:45:00", "State": "Connected"}]}
+  }]
+}`), 0644))
+	data, err = Load(v1File)
+	assert.NoError(t, err)
+	assert.Equal(t, "2025-03-01T08:00:00Z", data.RunInfo.Started)
+	assert.Equal(t, "2025-03-01T09:00:00Z", data.RunInfo.Finished)
+	assert.Equal(t, "2025-03-01T08:00:00Z", data.Runs[0].RunInfo.Started)
+	stat := data.VPNStats[0]
+	assert.Equal(t, "2025-03-01T08:30:00Z", stat.Timestamp)
+	assert.Equal(t, int64(1740817800), stat.TimestampUnix)
+	assert.Equal(t, "2025-03-01T08:31:00Z", stat.ConnectionDrops[0].Time)
+	assert.Equal(t, "2025-03-01T08:40:00Z", stat.Soak.Samples[0].Time)
+	assert.Equal(t, "2025-03-01T08:45:00Z", stat.Soak.StateChanges[0].Time)
+
+	newerFile := filepath.Join(dir, "newer.json")
+	assert.NoError(t, os.WriteFile(newerFile, []byte(`{"SchemaVersion": 99, "VPNStats": []}`), 0644))
+	_, err = Load(newerFile)
+	assert.ErrorContains(t, err, "schema version 99")
+}
+
+func TestEncryptedResults(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test_results.json")
+	opts := FileOptions{Passphrase: []byte("correct horse battery staple")}
+
+	assert.NoError(t, opts.Save(Results{MachineName: "probe", Network: &Network{ISP: "Example ISP"}}, testFile))
+	file, err := os.ReadFile(testFile)
+	assert.NoError(t, err)
+	assert.True(t, IsEncrypted(file))
+	assert.NotContains(t, string(file), "Example ISP")
+
+	loadedData, err := opts.Load(testFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "Example ISP", loadedData.Network.ISP)
+
+	_, err = FileOptions{Passphrase: []byte("wrong")}.Load(testFile)
+	assert.ErrorIs(t, err, ErrDecrypt)
+	_, err = Load(testFile)
+	assert.ErrorIs(t, err, ErrEncrypted)
+}
+
+func TestSmartLocation(t *testing.T) {
+	smart := Location{Country: "Smart"}
+	assert.True(t, smart.IsSmart())
+	assert.Equal(t, "smart", smart.Key())
+
+	explicit := Location{Country: "Smart", City: "Island"}
+	assert.False(t, explicit.IsSmart())
+	assert.Equal(t, "Smart, Island", explicit.Key())
+}
+
+func TestTimes(t *testing.T) {
+	at := time.Date(2025, 3, 3, 15, 25, 30, 0, time.FixedZone("CET", 3600))
+	assert.Equal(t, "2025-03-03T14:25:30Z", FormatTime(at))
+
+	parsed, err := ParseTime("2025-03-03T14:25:30Z")
+	assert.NoError(t, err)
+	assert.True(t, at.Equal(parsed))
+	parsed, err = ParseTime("2025-03-03T15:25:30+01:00")
+	assert.NoError(t, err)
+	assert.True(t, at.Equal(parsed))
+
+	// Older files stored local times without a zone
+	parsed, err = ParseTime("2025-03-03 15:25:30")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 3, 3, 15, 25, 30, 0, time.Local), parsed)
+	_, err = ParseTime("yesterday")
+	assert.Error(t, err)
+
+	assert.True(t, at.Equal(VPNStat{Timestamp: "2025-03-03T14:25:30Z"}.Tested()))
+	assert.True(t, at.Equal(VPNStat{Timestamp: "garbage", TimestampUnix: at.Unix()}.Tested()))
+	assert.True(t, VPNStat{}.Tested().IsZero())
+}
+
+func TestPercentOf(t *testing.T) {
+	download, upload := ParseBaseline("900.00Mbps ▼  90.00Mbps ▲")
+	assert.Equal(t, []float64{900, 90}, []float64{download, upload})
+	download, upload = ParseBaseline("900.00Mbps down  90.00Mbps up")
+	assert.Equal(t, []float64{900, 90}, []float64{download, upload})
+	download, upload = ParseBaseline("90.00Mbps ▲")
+	assert.Equal(t, []float64{0, 90}, []float64{download, upload})
+	download, upload = ParseBaseline("12.34ms latency")
+	assert.Equal(t, []float64{0, 0}, []float64{download, upload})
+
+	stat := VPNStat{VPNDownloadSpeed: "450.00Mbps", VPNUploadSpeed: "30.00Mbps"}
+	assert.Equal(t, &PercentOfBaseline{Download: 50, Upload: 33.3}, stat.PercentOf("900.00Mbps ▼  90.00Mbps ▲"))
+	assert.Equal(t, &PercentOfBaseline{Upload: 33.3}, stat.PercentOf("90.00Mbps ▲"))
+	assert.Nil(t, stat.PercentOf(""))
+	assert.Nil(t, VPNStat{VPNLatency: "20.00ms"}.PercentOf("12.34ms latency"))
+}
+
+func TestValidateInput(t *testing.T) {
+	data, err := os.ReadFile("../../locations.json")
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateInput(data))
+
+	err = ValidateInput([]byte(`{
+  "aliases": {"Frankfurt": ""},
+  "locations": [
+    {"country": "Netherlands", "city": "Amsterdam", "samples": 2.5},
+    {"contry": "Romania"},
+    {"country": " ", "parallel": "yes"}
+  ]
+}`))
+	assert.EqualError(t, err, `line 2: aliases.Frankfurt: must not be empty
+line 4: locations[0].samples: must be a whole number
+line 5: locations[1]: missing required key "country"
+line 5: locations[1].contry: unknown key "contry", expected one of: city, country, maxJitterMs, maxLatencyMs, maxPacketLoss, minDownloadMbps, minUploadMbps, parallel, postHook, preHook, samples
+line 6: locations[2].country: must not be empty
+line 6: locations[2].parallel: must be true or false`)
+
+	err = ValidateInput([]byte("{\n  \"locations\": [\n    {\"country\": \"USA\"},\n  ]\n}"))
+	assert.EqualError(t, err, "line 4, column 3: invalid character ']' looking for beginning of value (remove the trailing comma, JSON doesn't allow them)")
+
+	assert.EqualError(t, ValidateInput([]byte(`{"locations": []}`)), "line 1: locations: must not be empty")
+}
@@ -0,0 +1,235 @@
+package results
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// InputSchema is the JSON Schema of the input file, for editors and other tools
+//
+//go:embed input.schema.json
+var InputSchema []byte
+
+// schema is the subset of JSON Schema the input schema uses
+type schema struct {
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"` // false, or the schema of the other values
+	Items                *schema            `json:"items"`
+	MinItems             *int               `json:"minItems"`
+	MinLength            *int               `json:"minLength"`
+	Minimum              *float64           `json:"minimum"`
+}
+
+// problem is one way a document doesn't match its schema
+type problem struct {
+	Line    int
+	Path    string // e.g. locations[1].country; empty for the document itself
+	Message string
+}
+
+func (p problem) String() string {
+	if p.Path == "" {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", p.Line, p.Path, p.Message)
+}
+
+// ValidateInput checks an input file against InputSchema, returning every problem found with its
+// line, or the syntax error with its line and column
+func ValidateInput(data []byte) error {
+	var s schema
+	if err := json.Unmarshal(InputSchema, &s); err != nil {
+		return fmt.Errorf("invalid input schema: %w", err)
+	}
+
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return syntaxError(data, err)
+	}
+
+	offsets := valueOffsets(data)
+	var problems []problem
+	s.validate(document, "", func(path string, message string) {
+		problems = append(problems, problem{Line: lineAt(data, offsets[path]), Path: path, Message: message})
+	})
+	if len(problems) == 0 {
+		return nil
+	}
+
+	slices.SortStableFunc(problems, func(a, b problem) int {
+		return a.Line - b.Line
+	})
+	errs := make([]error, len(problems))
+	for i, p := range problems {
+		errs[i] = errors.New(p.String())
+	}
+	return errors.Join(errs...)
+}
+
+// Reports every way the value at path doesn't match the schema
+func (s *schema) validate(value any, path string, report func(path string, message string)) {
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			report(path, "must be an object")
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				report(path, fmt.Sprintf("missing required key %q", name))
+			}
+		}
+
+		var additional *schema
+		closed := string(s.AdditionalProperties) == "false"
+		if len(s.AdditionalProperties) > 0 && !closed {
+			json.Unmarshal(s.AdditionalProperties, &additional)
+		}
+		for _, name := range sortedKeys(object) {
+			childPath := joinPath(path, name)
+			if property, ok := s.Properties[name]; ok {
+				property.validate(object[name], childPath, report)
+			} else if closed {
+				report(childPath, fmt.Sprintf("unknown key %q, expected one of: %s", name, strings.Join(sortedKeys(s.Properties), ", ")))
+			} else if additional != nil {
+				additional.validate(object[name], childPath, report)
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			report(path, "must be an array")
+			return
+		}
+		if s.MinItems != nil && len(array) < *s.MinItems {
+			if *s.MinItems == 1 {
+				report(path, "must not be empty")
+			} else {
+				report(path, fmt.Sprintf("must have at least %d entries", *s.MinItems))
+			}
+		}
+		if s.Items != nil {
+			for i, item := range array {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), report)
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			report(path, "must be a string")
+			return
+		}
+		if s.MinLength != nil && len(strings.TrimSpace(text)) < *s.MinLength {
+			report(path, "must not be empty")
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok {
+			report(path, "must be a number")
+			return
+		}
+		if s.Type == "integer" && number != math.Trunc(number) {
+			report(path, "must be a whole number")
+			return
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			report(path, fmt.Sprintf("must be at least %g", *s.Minimum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			report(path, "must be true or false")
+		}
+	}
+}
+
+// Describes a syntax error by line and column, pointing out trailing commas, which JSON doesn't allow
+func syntaxError(data []byte, err error) error {
+	var syntax *json.SyntaxError
+	if !errors.As(err, &syntax) {
+		return err
+	}
+
+	// The offset is just past the offending character
+	offset := max(int(syntax.Offset)-1, 0)
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := offset - bytes.LastIndexByte(data[:offset], '\n')
+	message := fmt.Sprintf("line %d, column %d: %s", line, column, syntax.Error())
+	if before := bytes.TrimRight(data[:offset], " \t\r\n"); bytes.HasSuffix(before, []byte(",")) && offset < len(data) && (data[offset] == '}' || data[offset] == ']') {
+		message += " (remove the trailing comma, JSON doesn't allow them)"
+	}
+	return errors.New(message)
+}
+
+// Records where the value at each path of a document starts
+func valueOffsets(data []byte) map[string]int64 {
+	offsets := map[string]int64{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		offsets[path] = decoder.InputOffset()
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'):
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				if err := walk(joinPath(path, key.(string))); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+			return err
+		case json.Delim('['):
+			for i := 0; decoder.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+			return err
+		}
+		return nil
+	}
+	walk("")
+	return offsets
+}
+
+// Returns the line of the value starting at offset, skipping the separators before it
+func lineAt(data []byte, offset int64) int {
+	i := int(offset)
+	for i < len(data) && strings.IndexByte(" \t\r\n:,", data[i]) >= 0 {
+		i++
+	}
+	return 1 + bytes.Count(data[:i], []byte("\n"))
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,73 @@
+package results
+
+import "math"
+
+// RunningStats accumulates count, mean and variance in a single pass with Welford's algorithm,
+// so aggregating any number of samples takes constant memory
+type RunningStats struct {
+	Count int
+	Mean  float64
+	m2    float64 // Sum of squared differences from the mean
+}
+
+// Builds running statistics from a set of values
+func NewRunningStats(values ...float64) RunningStats {
+	var s RunningStats
+	for _, v := range values {
+		s.Add(v)
+	}
+	return s
+}
+
+// Adds a sample
+func (s *RunningStats) Add(x float64) {
+	s.Count++
+	delta := x - s.Mean
+	s.Mean += delta / float64(s.Count)
+	s.m2 += delta * (x - s.Mean)
+}
+
+// Returns the unbiased sample variance, or 0 with fewer than two samples
+func (s RunningStats) Variance() float64 {
+	if s.Count < 2 {
+		return 0
+	}
+	return s.m2 / float64(s.Count-1)
+}
+
+// Returns the sample standard deviation
+func (s RunningStats) StdDev() float64 {
+	return math.Sqrt(s.Variance())
+}
+
+// Aggregates the per-sample speeds of every location; stats saved without samples count as a single sample
+func (r Results) GroupSamples() (map[string]*RunningStats, map[string]*RunningStats, []string) {
+	download := map[string]*RunningStats{}
+	upload := map[string]*RunningStats{}
+	var order []string
+
+	for _, stat := range r.VPNStats {
+		if _, ok := download[stat.LocationName]; !ok {
+			order = append(order, stat.LocationName)
+			download[stat.LocationName] = &RunningStats{}
+			upload[stat.LocationName] = &RunningStats{}
+		}
+
+		if len(stat.DownloadSamples) > 0 {
+			for _, sample := range stat.DownloadSamples {
+				download[stat.LocationName].Add(sample)
+			}
+		} else {
+			download[stat.LocationName].Add(ParseMbps(stat.VPNDownloadSpeed))
+		}
+
+		if len(stat.UploadSamples) > 0 {
+			for _, sample := range stat.UploadSamples {
+				upload[stat.LocationName].Add(sample)
+			}
+		} else {
+			upload[stat.LocationName].Add(ParseMbps(stat.VPNUploadSpeed))
+		}
+	}
+	return download, upload, order
+}
@@ -0,0 +1,26 @@
+package runner
+
+import (
+	"log/slog"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Evaluates the location's assertions and records the outcome in the result
+func (r *Runner) applyAssertions(location results.Location, stat *results.VPNStat) {
+	if !location.Assertions.Any() {
+		return
+	}
+
+	stat.AssertionFailures = location.Assertions.Evaluate(*stat)
+	passed := len(stat.AssertionFailures) == 0
+	stat.AssertionsPassed = &passed
+
+	if passed {
+		r.printText("Assertions: passed")
+		return
+	}
+	r.assertionFailures++
+	slog.Warn("Assertions failed", "location", stat.LocationName, "failures", strings.Join(stat.AssertionFailures, "; "))
+}
@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+)
+
+// Adds a completed speed test to the data used, estimating its bytes when the engine doesn't report them
+func (r *Runner) recordDataUsage(result speedtest.Result) {
+	bytes := result.Download.Bytes + result.Upload.Bytes
+	r.reportedBytes.Add(bytes)
+	if bytes == 0 {
+		bytes = int64(estimatedMBPerTest * 1e6)
+	}
+	r.dataUsed.Add(bytes)
+	r.testsRun.Add(1)
+}
+
+// Returns why the next location, running nextTests speed tests, would exceed a budget, or an empty string
+func (r *Runner) budgetExceeded(nextTests int) string {
+	now := time.Now()
+	if r.budgetLocations == 0 {
+		r.budgetStart = now
+	}
+	var perLocation time.Duration
+	if r.budgetLocations > 0 {
+		perLocation = now.Sub(r.budgetStart) / time.Duration(r.budgetLocations)
+	}
+
+	reason := r.checkBudget(now.Sub(r.started), perLocation, r.dataUsed.Load(), r.testsRun.Load(), nextTests)
+	if reason == "" {
+		r.budgetLocations++
+	}
+	return reason
+}
+
+// Checks the budgets against what another location is likely to take: as long as the locations so
+// far took on average, and as much data per test as the tests so far
+func (r *Runner) checkBudget(elapsed time.Duration, perLocation time.Duration, used int64, tests int64, nextTests int) string {
+	if r.MaxDuration > 0 && elapsed+perLocation > r.MaxDuration {
+		return fmt.Sprintf("the time budget of %s would be exceeded", r.MaxDuration)
+	}
+	if r.MaxDataGB > 0 {
+		perTest := estimatedMBPerTest * 1e6
+		if tests > 0 {
+			perTest = float64(used) / float64(tests)
+		}
+		if float64(used)+perTest*float64(nextTests) > r.MaxDataGB*1e9 {
+			return fmt.Sprintf("the data budget of %gGB would be exceeded", r.MaxDataGB)
+		}
+	}
+	return ""
+}
+
+// Returns the data used so far in megabytes, rounded for the results file
+func (r *Runner) dataUsedMB() float64 {
+	return math.Round(float64(r.dataUsed.Load())/1e4) / 100
+}
@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"slices"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Checkpoint records which locations of a run have been saved, so an interrupted run can resume
+type Checkpoint struct {
+	ResultsFile string   `json:"ResultsFile"`
+	RunID       string   `json:"RunID,omitempty"`
+	RunUUID     string   `json:"RunUUID,omitempty"`
+	Completed   []string `json:"Completed"`
+}
+
+// Loads a checkpoint written by an earlier run
+func loadCheckpoint(fileName string) (Checkpoint, error) {
+	var data Checkpoint
+	file, err := os.ReadFile(fileName)
+	if err != nil {
+		return data, err
+	}
+	err = json.Unmarshal(file, &data)
+	return data, err
+}
+
+// Reports whether the location was already saved by the run being resumed
+func (r *Runner) isCompleted(location results.Location) bool {
+	return slices.Contains(r.checkpoint.Completed, location.Key())
+}
+
+// Marks a location as saved and writes the checkpoint
+func (r *Runner) markCompleted(location results.Location) {
+	r.checkpoint.Completed = append(r.checkpoint.Completed, location.Key())
+
+	jsonData, err := json.MarshalIndent(r.checkpoint, "", "  ")
+	if err != nil {
+		slog.Error("Error encoding checkpoint", "err", err)
+		return
+	}
+	if err := results.WriteFileAtomic(r.checkpointFile, jsonData, 0644); err != nil {
+		slog.Error("Error writing checkpoint file", "err", err)
+	}
+}
+
+// Removes the checkpoint once every location has been processed
+func (r *Runner) removeCheckpoint() {
+	if err := os.Remove(r.checkpointFile); err != nil && !os.IsNotExist(err) {
+		slog.Error("Error removing checkpoint file", "err", err)
+	}
+}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+var listInterfaces = net.Interfaces // Replaced in tests
+
+// Interface name prefixes of tunnels that would carry the test traffic instead of ExpressVPN
+var conflictInterfaces = []struct {
+	prefix string
+	name   string
+}{
+	{"wg", "WireGuard tunnel"},
+	{"nordlynx", "NordVPN tunnel"},
+	{"proton", "Proton VPN tunnel"},
+	{"cscotun", "Cisco AnyConnect tunnel"},
+	{"gpd", "GlobalProtect tunnel"},
+	{"zt", "ZeroTier network"},
+	{"ppp", "PPP/L2TP VPN"},
+	{"tun", "TUN tunnel (OpenVPN, ExpressVPN or another client still connected)"},
+}
+
+// Returns a description of every VPN interface or exit node that would interfere with the measurements
+func (r *Runner) detectConflicts() []string {
+	var conflicts []string
+
+	interfaces, err := listInterfaces()
+	if err != nil {
+		slog.Warn("Could not list network interfaces", "err", err)
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		for _, c := range conflictInterfaces {
+			if strings.HasPrefix(iface.Name, c.prefix) {
+				conflicts = append(conflicts, c.name+": "+iface.Name)
+				break
+			}
+		}
+	}
+
+	// A Tailscale interface alone doesn't reroute traffic, an exit node does
+	if output, err := r.Commands.Run("tailscale", "status", "--json"); err == nil {
+		var status struct {
+			ExitNodeStatus *struct {
+				TailscaleIPs []string `json:"TailscaleIPs"`
+			} `json:"ExitNodeStatus"`
+		}
+		if json.Unmarshal(output, &status) == nil && status.ExitNodeStatus != nil {
+			conflicts = append(conflicts, "Tailscale exit node active")
+		}
+	}
+
+	return conflicts
+}
+
+// Refuses to run when conflicts are detected, unless IgnoreConflicts is set
+func (r *Runner) checkConflicts() error {
+	conflicts := r.detectConflicts()
+	if len(conflicts) == 0 {
+		return nil
+	}
+	if !r.IgnoreConflicts {
+		return fmt.Errorf("other VPN software would interfere with the measurements; disconnect it or use -ignore-conflicts: %s", strings.Join(conflicts, "; "))
+	}
+	slog.Warn("Running despite conflicting VPN software", "conflicts", strings.Join(conflicts, "; "))
+	r.conflicts = conflicts
+	return nil
+}
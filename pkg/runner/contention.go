@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+const saturationRatio = 0.9 // Parallel tests adding up to this share of the baseline have used up the link
+
+// Returns the link's download capacity without VPN: the baseline tests that ran at once added up, or
+// the fastest of them when they ran in series; 0 when there is no baseline
+func (r *Runner) linkCapacity() float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.baselineDownloads) == 0 {
+		return 0
+	}
+	if !r.Parallel {
+		return slices.Max(r.baselineDownloads)
+	}
+	var sum float64
+	for _, download := range r.baselineDownloads {
+		sum += download
+	}
+	return sum
+}
+
+// Runs the parallel speed tests of a location and checks whether they used up the link together, in which
+// case each measured its share of the link rather than the VPN. The result records it; with -auto-tune the
+// location is tested again with half as many tests at a time, down to one at a time, and later locations
+// keep the reduced parallelism
+func (r *Runner) runTunedParallelSpeedTests(ctx context.Context, connectionTime string, samples int) (results.VPNStat, bool) {
+	var contention *results.Contention
+	for {
+		concurrent := samples
+		if r.concurrency > 0 {
+			concurrent = min(samples, r.concurrency)
+		}
+
+		stat, ok := r.runSamples(ctx, connectionTime, samples, concurrent)
+		stat.Contention = contention
+		if !ok || concurrent == 1 || r.SpeedTest.SkipDownload {
+			return stat, ok
+		}
+
+		capacity := r.linkCapacity()
+		sum := results.ParseMbps(stat.VPNDownloadSpeed) * float64(concurrent)
+		if capacity == 0 || sum < saturationRatio*capacity {
+			return stat, ok
+		}
+
+		contention = &results.Contention{SumMbps: math.Round(sum*100) / 100, BaselineMbps: math.Round(capacity*100) / 100}
+		if !r.AutoTune {
+			slog.Warn("Parallel tests saturated the link, so they measured their share of it rather than the VPN; pass -auto-tune to reduce parallelism",
+				"sum", r.FormatSpeed(sum), "baseline", r.FormatSpeed(capacity))
+			contention.Decision = "kept, -auto-tune is off"
+			stat.Contention = contention
+			return stat, ok
+		}
+
+		r.concurrency = concurrent / 2
+		contention.Decision = fmt.Sprintf("tested again %d at a time", r.concurrency)
+		if r.concurrency == 1 {
+			contention.Decision = "tested again in series"
+		}
+		slog.Warn("Parallel tests saturated the link, testing again with fewer at a time",
+			"sum", r.FormatSpeed(sum), "baseline", r.FormatSpeed(capacity), "concurrent", r.concurrency)
+		pause(r.PauseBetweenTests, "before testing again")
+	}
+}
@@ -0,0 +1,310 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+const cpuBoundPercent = 90 // Utilization above which a location's throughput is likely limited by the CPU
+
+var procDir = "/proc"          // Where Linux exposes process and CPU times; a variable so tests can redirect it
+var vpnDaemonNames = []string{ // Processes carrying the tunnel, the first one running is sampled
+	"expressvpnd", "expressvpn-daemon", "expressvpn-service", "openvpn", "wireguard-go",
+}
+
+// cpuSample is the CPU and memory use over one interval of the tests
+type cpuSample struct {
+	system      float64 // Percent of all cores
+	daemon      string  // VPN daemon found, empty when none is running
+	daemonCPU   float64 // Percent of one core, like top
+	memory      float64 // Percent of system memory in use; 0 when unknown
+	daemonRSSMB float64
+}
+
+// cpuTimes are cumulative Linux CPU times in clock ticks
+type cpuTimes struct {
+	busy, total uint64
+	daemon      string
+	daemonPID   string
+	daemonTicks uint64
+}
+
+// cpuMonitor samples CPU use in the background while a location's tests run
+type cpuMonitor struct {
+	r       *Runner
+	samples []cpuSample
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Starts sampling CPU use with CPU set; nil when disabled or the first sample fails
+func (r *Runner) startCPUMonitor() *cpuMonitor {
+	if !r.CPU {
+		return nil
+	}
+	var previous cpuTimes
+	if runtime.GOOS == "linux" {
+		var err error
+		if previous, err = readCPUTimes(); err != nil {
+			slog.Warn("Could not read CPU times", "err", err)
+			return nil
+		}
+	} else if runtime.GOOS != "darwin" {
+		slog.Warn("CPU usage isn't supported on " + runtime.GOOS)
+		return nil
+	}
+
+	m := &cpuMonitor{r: r, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(r.CPUInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+			}
+			var sample cpuSample
+			var err error
+			if runtime.GOOS == "linux" {
+				sample, previous, err = sampleLinuxCPU(previous)
+			} else {
+				sample, err = samplePS(r.Commands)
+			}
+			if err != nil {
+				slog.Debug("CPU sample failed", "err", err)
+				continue
+			}
+			m.samples = append(m.samples, sample)
+		}
+	}()
+	return m
+}
+
+// Stops sampling and summarizes the samples, warning when the CPU was nearly saturated
+func (m *cpuMonitor) finish() *results.CPUUsage {
+	if m == nil {
+		return nil
+	}
+	close(m.stop)
+	<-m.done
+	usage := summarizeCPU(m.samples)
+	if usage == nil {
+		return nil
+	}
+	m.r.printTextf("CPU: %.0f%% on average, %.0f%% at most\n", usage.SystemPercent, usage.SystemMaxPercent)
+	if usage.SystemMaxPercent >= cpuBoundPercent {
+		slog.Warn("The CPU was nearly saturated during the tests, the throughput may be limited by it", "maxPercent", usage.SystemMaxPercent, "daemon", usage.Daemon)
+	}
+	return usage
+}
+
+// Averages the samples and keeps their peaks; nil without samples
+func summarizeCPU(samples []cpuSample) *results.CPUUsage {
+	if len(samples) == 0 {
+		return nil
+	}
+	var system, daemon results.RunningStats
+	usage := &results.CPUUsage{}
+	for _, sample := range samples {
+		system.Add(sample.system)
+		usage.SystemMaxPercent = max(usage.SystemMaxPercent, sample.system)
+		usage.MemoryPercent = max(usage.MemoryPercent, sample.memory)
+		if sample.daemon != "" {
+			usage.Daemon = sample.daemon
+			daemon.Add(sample.daemonCPU)
+			usage.DaemonMaxPercent = max(usage.DaemonMaxPercent, sample.daemonCPU)
+			usage.DaemonMemoryMB = max(usage.DaemonMemoryMB, sample.daemonRSSMB)
+		}
+	}
+	usage.SystemPercent = roundPercent(system.Mean)
+	usage.SystemMaxPercent = roundPercent(usage.SystemMaxPercent)
+	usage.DaemonPercent = roundPercent(daemon.Mean)
+	usage.DaemonMaxPercent = roundPercent(usage.DaemonMaxPercent)
+	usage.MemoryPercent = roundPercent(usage.MemoryPercent)
+	usage.DaemonMemoryMB = roundPercent(usage.DaemonMemoryMB)
+	return usage
+}
+
+func roundPercent(value float64) float64 {
+	return math.Round(value*10) / 10
+}
+
+// Reads the system's busy and total CPU time from /proc/stat, and the VPN daemon's from /proc/PID/stat
+func readCPUTimes() (cpuTimes, error) {
+	data, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return cpuTimes{}, fmt.Errorf("unexpected first line in /proc/stat: %q", line)
+	}
+
+	var times cpuTimes
+	// user nice system idle iowait irq softirq steal; guest time is already part of user
+	for i, field := range fields[1:min(len(fields), 9)] {
+		ticks, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return cpuTimes{}, err
+		}
+		times.total += ticks
+		if i != 3 && i != 4 {
+			times.busy += ticks
+		}
+	}
+
+	times.daemon, times.daemonPID = findDaemon()
+	if times.daemonPID != "" {
+		times.daemonTicks, _ = processTicks(times.daemonPID)
+	}
+	return times, nil
+}
+
+// Finds the first running VPN daemon in /proc; the kernel keeps 15 characters of process names
+func findDaemon() (string, string) {
+	entries, _ := os.ReadDir(procDir)
+	running := map[string]string{}
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(procDir, entry.Name(), "comm"))
+		if err == nil {
+			running[strings.TrimSpace(string(comm))] = entry.Name()
+		}
+	}
+	for _, name := range vpnDaemonNames {
+		if pid, ok := running[name[:min(len(name), 15)]]; ok {
+			return name, pid
+		}
+	}
+	return "", ""
+}
+
+// Returns a process's user and system time in clock ticks
+func processTicks(pid string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(procDir, pid, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// The name in parentheses may contain spaces; utime and stime are the 12th and 13th fields after it
+	_, rest, ok := strings.Cut(string(data), ") ")
+	fields := strings.Fields(rest)
+	if !ok || len(fields) < 13 {
+		return 0, fmt.Errorf("unexpected /proc/%s/stat", pid)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	return utime + stime, err
+}
+
+// Computes the CPU use since the previous times, along with the memory in use now
+func sampleLinuxCPU(previous cpuTimes) (cpuSample, cpuTimes, error) {
+	current, err := readCPUTimes()
+	if err != nil {
+		return cpuSample{}, previous, err
+	}
+	total := float64(current.total - previous.total)
+	if total <= 0 {
+		return cpuSample{}, current, fmt.Errorf("no CPU time passed")
+	}
+	sample := cpuSample{system: float64(current.busy-previous.busy) / total * 100}
+	if current.daemonPID != "" && current.daemonPID == previous.daemonPID {
+		sample.daemon = current.daemon
+		// Percent of one core: the ticks of all cores divided among them
+		sample.daemonCPU = float64(current.daemonTicks-previous.daemonTicks) / (total / float64(runtime.NumCPU())) * 100
+		sample.daemonRSSMB = processRSSMB(current.daemonPID)
+	}
+	sample.memory = memoryPercent()
+	return sample, current, nil
+}
+
+// Returns the percent of memory in use from /proc/meminfo, or 0 when it can't be told
+func memoryPercent() float64 {
+	data, err := os.ReadFile(filepath.Join(procDir, "meminfo"))
+	if err != nil {
+		return 0
+	}
+	values := map[string]float64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		kilobytes, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 64)
+		values[key] = kilobytes
+	}
+	if values["MemTotal"] == 0 || values["MemAvailable"] == 0 {
+		return 0
+	}
+	return (1 - values["MemAvailable"]/values["MemTotal"]) * 100
+}
+
+// Returns a process's resident memory from /proc/PID/status, or 0 when it can't be told
+func processRSSMB(pid string) float64 {
+	data, err := os.ReadFile(filepath.Join(procDir, pid, "status"))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "VmRSS:"); ok {
+			kilobytes, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 64)
+			return kilobytes / 1024
+		}
+	}
+	return 0
+}
+
+// Samples the CPU use of every process with ps on macOS, where %cpu is a decaying average over the
+// last minute rather than the last interval
+func samplePS(commands command.Overrides) (cpuSample, error) {
+	output, err := commands.Run("ps", "-A", "-o", "%cpu=,rss=,comm=")
+	if err != nil {
+		return cpuSample{}, err
+	}
+	return parsePS(string(output), runtime.NumCPU()), nil
+}
+
+// Adds up the %cpu of ps output lines, dividing by the cores for the system's share
+func parsePS(output string, cores int) cpuSample {
+	var sample cpuSample
+	daemons := map[string]cpuSample{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		cpu, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		sample.system += cpu
+		rss, _ := strconv.ParseFloat(fields[1], 64)
+		name := filepath.Base(strings.Join(fields[2:], " "))
+		daemons[name] = cpuSample{daemon: name, daemonCPU: cpu, daemonRSSMB: rss / 1024}
+	}
+	sample.system = min(sample.system/float64(cores), 100)
+	for _, name := range vpnDaemonNames {
+		if daemon, ok := daemons[name]; ok {
+			sample.daemon, sample.daemonCPU, sample.daemonRSSMB = daemon.daemon, daemon.daemonCPU, daemon.daemonRSSMB
+			break
+		}
+	}
+	return sample
+}
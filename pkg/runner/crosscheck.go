@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+)
+
+// Returns the percent difference of value from reference
+func percentDifference(value, reference float64) float64 {
+	if reference == 0 {
+		return 0
+	}
+	return (value - reference) / reference * 100
+}
+
+// Compares the cross-check engine's speeds with the primary result; the engines disagree when either
+// speed differs by more than the tolerance or the location's assertions would have a different outcome
+func (r *Runner) compareEngines(location results.Location, stat results.VPNStat, download, upload float64) *results.CrossCheck {
+	downloadDiff := percentDifference(download, results.ParseMbps(stat.VPNDownloadSpeed))
+	uploadDiff := percentDifference(upload, results.ParseMbps(stat.VPNUploadSpeed))
+
+	check := &results.CrossCheck{
+		Engine:             r.CrossCheck,
+		VPNDownloadSpeed:   fmt.Sprintf("%.2fMbps", download),
+		VPNUploadSpeed:     fmt.Sprintf("%.2fMbps", upload),
+		DownloadDifference: fmt.Sprintf("%+.1f%%", downloadDiff),
+		UploadDifference:   fmt.Sprintf("%+.1f%%", uploadDiff),
+		Disagrees:          math.Abs(downloadDiff) > r.CrossCheckTolerance || math.Abs(uploadDiff) > r.CrossCheckTolerance,
+	}
+
+	if location.Assertions.Any() {
+		other := stat
+		other.VPNDownloadSpeed, other.VPNUploadSpeed = check.VPNDownloadSpeed, check.VPNUploadSpeed
+		if (len(location.Assertions.Evaluate(stat)) == 0) != (len(location.Assertions.Evaluate(other)) == 0) {
+			check.Disagrees = true
+		}
+	}
+	return check
+}
+
+// Runs one test with the cross-check engine right after the location's samples, so both engines
+// measure the same tunnel at nearly the same time
+func (r *Runner) crossCheck(ctx context.Context, location results.Location, stat results.VPNStat) *results.CrossCheck {
+	spinner := r.startSpinner("Cross-checking with the " + r.CrossCheck + " engine...")
+	result, err := speedtest.Run(ctx, r.CrossCheck, r.engineOptions())
+	if err != nil {
+		slog.Warn("Cross-check speed test failed", "engine", r.CrossCheck, "err", err)
+		spinner.Warning("Cross-check failed")
+		return nil
+	}
+	spinner.Success("Cross-check completed")
+	r.recordDataUsage(result)
+
+	check := r.compareEngines(location, stat, bytesToMbps(result.Download.Bandwidth), bytesToMbps(result.Upload.Bandwidth))
+	if check.Disagrees {
+		slog.Warn("Speed test engines disagree", "location", stat.LocationName,
+			"engine", r.Engine, "download", stat.VPNDownloadSpeed, "upload", stat.VPNUploadSpeed,
+			"crossCheckEngine", r.CrossCheck, "crossCheckDownload", check.VPNDownloadSpeed, "crossCheckUpload", check.VPNUploadSpeed)
+	}
+	return check
+}
+
+// Summarizes the cross-check of a run: the regions where the engines disagree, and whether they pick
+// a different fastest region
+func (r *Runner) crossCheckSummary(data results.Results) string {
+	var summary string
+	var fastest, fastestCrossCheck string
+	var best, bestCrossCheck float64
+
+	for _, stat := range data.VPNStats {
+		if stat.CrossCheck == nil {
+			continue
+		}
+		if stat.CrossCheck.Disagrees {
+			summary += fmt.Sprintf("  ! %s: %s %s vs %s %s (%s)\n", stat.LocationName, r.Engine, r.displaySpeed(stat.VPNDownloadSpeed),
+				stat.CrossCheck.Engine, r.displaySpeed(stat.CrossCheck.VPNDownloadSpeed), stat.CrossCheck.DownloadDifference)
+		}
+		if download := results.ParseMbps(stat.VPNDownloadSpeed); download > best {
+			best, fastest = download, stat.LocationName
+		}
+		if download := results.ParseMbps(stat.CrossCheck.VPNDownloadSpeed); download > bestCrossCheck {
+			bestCrossCheck, fastestCrossCheck = download, stat.LocationName
+		}
+	}
+
+	if summary != "" {
+		summary = "Regions where the speed test engines disagree:\n" + summary
+	}
+	if fastest != fastestCrossCheck {
+		summary += fmt.Sprintf("The engines disagree on the fastest region: %s (%s) vs %s (%s)\n", fastest, r.Engine, fastestCrossCheck, r.CrossCheck)
+	}
+	return summary
+}
+
+// Prints the cross-check summary of the finished run
+func (r *Runner) printCrossCheckSummary() {
+	if r.CrossCheck == "" {
+		return
+	}
+
+	data, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return
+	}
+	if summary := r.crossCheckSummary(data); summary != "" {
+		r.printText("\n" + summary)
+	} else {
+		r.printText("\nThe speed test engines agree on every region")
+	}
+}
@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Summarizes connect durations
+func summarizeConnectTimes(durations []time.Duration) *results.ConnectTimes {
+	if len(durations) == 0 {
+		return nil
+	}
+
+	lowest, highest := durations[0], durations[0]
+	var total time.Duration
+	for _, d := range durations {
+		lowest = min(lowest, d)
+		highest = max(highest, d)
+		total += d
+	}
+	avg := (total / time.Duration(len(durations))).Round(time.Millisecond)
+	return &results.ConnectTimes{Cycles: len(durations), Min: lowest.String(), Avg: avg.String(), Max: highest.String()}
+}
+
+// Connects to the region the given number of times, disconnecting in between, and stays connected
+// after the last cycle; returns every connect duration
+func (r *Runner) connectCycle(ctx context.Context, region string, cycles int) ([]time.Duration, error) {
+	var durations []time.Duration
+	for i := range cycles {
+		duration, err := r.connectRegion(ctx, region)
+		if err != nil {
+			return durations, err
+		}
+		durations = append(durations, duration)
+
+		if i < cycles-1 {
+			r.printTextf("Connect cycle %d/%d: %v\n", i+1, cycles, duration)
+			if err := r.provider.Disconnect(); err != nil {
+				return durations, fmt.Errorf("disconnect after cycle %d failed: %w", i+1, err)
+			}
+		}
+	}
+	return durations, nil
+}
@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// The pure-Go resolver reads the VPN's resolv.conf directly and skips any local cache
+var lookupHost = (&net.Resolver{PreferGo: true}).LookupHost
+
+// Resolves every domain once and returns the average resolution time, or an empty string if none resolved
+func (r *Runner) benchmarkDNS(ctx context.Context, domains []string) string {
+	var total time.Duration
+	var resolved int
+
+	for _, domain := range domains {
+		domain = strings.TrimSpace(domain)
+		if domain == "" {
+			continue
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, r.DNSTimeout)
+		start := time.Now()
+		_, err := lookupHost(lookupCtx, domain)
+		elapsed := time.Since(start)
+		cancel()
+
+		if err != nil {
+			slog.Warn("DNS lookup failed", "domain", domain, "err", err)
+			continue
+		}
+		slog.Debug("DNS lookup finished", "domain", domain, "duration", elapsed)
+		total += elapsed
+		resolved++
+	}
+
+	if resolved == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2fms", float64(total.Microseconds())/float64(resolved)/1000)
+}
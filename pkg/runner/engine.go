@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+)
+
+var testPhases = []string{"download", "upload", "latency"}
+
+// Returns the speed test options, running the engines' binaries with the command overrides
+func (r *Runner) engineOptions() speedtest.Options {
+	opts := r.SpeedTest
+	opts.Commands = r.Commands
+	return opts
+}
+
+// Runs a single speed test with the selected engine, counting the data it used
+func (r *Runner) runSpeedTest(ctx context.Context) (speedtest.Result, error) {
+	return r.runSpeedTestWith(ctx, r.engineOptions())
+}
+
+// Runs a single speed test like runSpeedTest with other options, e.g. binaries run in a network namespace
+func (r *Runner) runSpeedTestWith(ctx context.Context, opts speedtest.Options) (speedtest.Result, error) {
+	result, err := speedtest.Run(ctx, r.Engine, opts)
+	if err == nil {
+		r.recordDataUsage(result)
+	}
+	return result, err
+}
+
+// Applies the -tests selection to the options' engines; latency alone is the same as -latency-only
+func ApplyTestSelection(opts *Options, selection string) error {
+	selected := map[string]bool{}
+	for _, phase := range strings.Split(selection, ",") {
+		phase = strings.TrimSpace(phase)
+		if !slices.Contains(testPhases, phase) {
+			return fmt.Errorf("unknown test %q, expected %s", phase, strings.Join(testPhases, ", "))
+		}
+		selected[phase] = true
+	}
+	if !selected["download"] && !selected["upload"] {
+		opts.LatencyOnly = true
+		return nil
+	}
+
+	opts.SpeedTest.SkipDownload, opts.SpeedTest.SkipUpload, opts.SpeedTest.SkipLatency = !selected["download"], !selected["upload"], !selected["latency"]
+	if len(selected) == len(testPhases) {
+		return nil
+	}
+	for _, engine := range []string{opts.Engine, opts.CrossCheck} {
+		if engine != "" && !slices.Contains(speedtest.PhaseEngines, engine) {
+			return fmt.Errorf("the %s engine always runs every test; -engine %s can skip some", engine, strings.Join(speedtest.PhaseEngines, ", "))
+		}
+	}
+	return nil
+}
+
+// Empties the figures of the skipped tests, which the engine reported as zero
+func (r *Runner) clearSkippedPhases(stat *results.VPNStat) {
+	if r.SpeedTest.SkipDownload {
+		stat.VPNDownloadSpeed, stat.DownloadSamples = "", nil
+	}
+	if r.SpeedTest.SkipUpload {
+		stat.VPNUploadSpeed, stat.UploadSamples = "", nil
+	}
+	if r.SpeedTest.SkipLatency {
+		stat.VPNLatency, stat.VPNJitter, stat.VPNPacketLoss = "", "", ""
+	}
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+const earthRadiusKm = 6371.0
+
+var hopPattern = regexp.MustCompile(`^\s*(\d+)\s`)
+
+// Returns the great-circle distance in kilometers between two coordinates with the haversine formula
+func greatCircleKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// Returns the number of the last hop in traceroute or tracert output
+func parseHopCount(output string) int {
+	hops := 0
+	for _, line := range strings.Split(output, "\n") {
+		if m := hopPattern.FindStringSubmatch(line); m != nil {
+			hops, _ = strconv.Atoi(m[1])
+		}
+	}
+	return hops
+}
+
+// Runs traceroute to the target and returns the number of hops, or 0 when it failed
+func (r *Runner) hopCount(target string) int {
+	var output []byte
+	var err error
+	if runtime.GOOS == "windows" {
+		output, err = r.Commands.Run("tracert", "-d", "-w", "1000", "-h", "30", target)
+	} else {
+		output, err = r.Commands.Run("traceroute", "-n", "-q", "1", "-w", "1", "-m", "30", target)
+	}
+	if err != nil {
+		slog.Warn("Traceroute failed", "target", target, "err", err)
+		return 0
+	}
+	return parseHopCount(string(output))
+}
+
+// Looks up the location of the public IP before connecting, as the reference for exit distances
+func (r *Runner) locateHome() {
+	r.setHomeLocation(fetchExitIP(context.Background(), r.IPCheckURL))
+}
+
+// Keeps the looked-up public IP location as home for the exit distances
+func (r *Runner) setHomeLocation(info ExitIPInfo, err error) {
+	if err != nil || (info.Latitude == 0 && info.Longitude == 0) {
+		slog.Warn("Could not geolocate the public IP, exit distances won't be recorded", "url", r.IPCheckURL, "err", err)
+		return
+	}
+	r.homeLocation = &info
+}
+
+// Records the hop count and the distance from home to the exit; exit is looked up when not known yet
+func (r *Runner) enrichGeo(stat *results.VPNStat, exit ExitIPInfo) {
+	stat.HopCount = r.hopCount(r.TracerouteTarget)
+
+	if r.homeLocation == nil {
+		return
+	}
+	if exit.IP == "" {
+		var err error
+		if exit, err = fetchExitIP(context.Background(), r.IPCheckURL); err != nil {
+			slog.Warn("Exit IP lookup failed", "url", r.IPCheckURL, "err", err)
+			return
+		}
+	}
+	if exit.Latitude == 0 && exit.Longitude == 0 {
+		return
+	}
+	stat.ExitDistanceKm = math.Round(greatCircleKm(r.homeLocation.Latitude, r.homeLocation.Longitude, exit.Latitude, exit.Longitude))
+}
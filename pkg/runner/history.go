@@ -0,0 +1,136 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// RegionNorm is the rolling average download speed of a region over its earlier runs
+type RegionNorm struct {
+	Mbps float64
+	Runs int
+}
+
+// Regression is a region whose download speed in this run fell well below its norm
+type Regression struct {
+	LocationName string
+	Mbps         float64
+	Norm         RegionNorm
+	BelowPercent float64
+}
+
+// Returns the locations measured on the machine by earlier runs in the directory's results files, by
+// region and in the order they were tested, leaving out the current run. They come from the directory's
+// history index, which is refreshed from the files that changed since the last run and saved again
+func loadHistory(files results.FileOptions, dir string, machine string, currentRun string) (map[string][]results.IndexEntry, error) {
+	index, err := files.LoadIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := files.SaveIndex(index, dir); err != nil {
+		slog.Warn("Error saving history index", "path", dir, "err", err)
+	}
+
+	history := map[string][]results.IndexEntry{}
+	for region, entries := range index.Regions(machine) {
+		for _, entry := range entries {
+			if entry.RunID != "" && entry.RunID == currentRun {
+				continue
+			}
+			history[region] = append(history[region], entry)
+		}
+	}
+	return history, nil
+}
+
+// Averages the download speed of each location over its latest runs, at most window of them
+func regionNorms(history map[string][]results.IndexEntry, window int) map[string]RegionNorm {
+	speeds := map[string][]float64{}
+	for region, entries := range history {
+		for _, entry := range entries {
+			if entry.DownloadMbps > 0 {
+				speeds[region] = append(speeds[region], entry.DownloadMbps)
+			}
+		}
+	}
+
+	norms := map[string]RegionNorm{}
+	for location, values := range speeds {
+		if len(values) > window {
+			values = values[len(values)-window:]
+		}
+		var stats results.RunningStats
+		for _, value := range values {
+			stats.Add(value)
+		}
+		norms[location] = RegionNorm{Mbps: stats.Mean, Runs: stats.Count}
+	}
+	return norms
+}
+
+// Returns the locations whose download speed is more than threshold percent below their norm
+func findRegressions(current []results.VPNStat, norms map[string]RegionNorm, threshold float64) []Regression {
+	var regressions []Regression
+	for _, stat := range current {
+		norm, ok := norms[stat.LocationName]
+		if !ok || norm.Mbps == 0 {
+			continue
+		}
+		mbps := results.ParseMbps(stat.VPNDownloadSpeed)
+		below := (norm.Mbps - mbps) / norm.Mbps * 100
+		if below > threshold {
+			regressions = append(regressions, Regression{LocationName: stat.LocationName, Mbps: mbps, Norm: norm, BelowPercent: below})
+		}
+	}
+	return regressions
+}
+
+// Formats the regression alerts, or returns an empty string when there are none
+func (d Display) regressionSummary(regressions []Regression) string {
+	if len(regressions) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("Regressions versus history:\n")
+	for _, r := range regressions {
+		fmt.Fprintf(&b, "  ▼ %s: %s is %.0f%% below its norm of %s over %d runs\n", r.LocationName, d.FormatSpeed(r.Mbps), r.BelowPercent, d.FormatSpeed(r.Norm.Mbps), r.Norm.Runs)
+	}
+	return b.String()
+}
+
+// Compares this run's locations with their history, or returns an empty string
+func (r *Runner) currentRegressions() string {
+	if r.HistoryDir == "" {
+		return ""
+	}
+
+	data, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return ""
+	}
+	history, err := loadHistory(r.Files, r.HistoryDir, data.MachineName, r.runID)
+	if err != nil {
+		slog.Warn("Error loading history", "path", r.HistoryDir, "err", err)
+		return ""
+	}
+
+	var current []results.VPNStat
+	for _, stat := range data.VPNStats {
+		if stat.RunID == r.runID {
+			current = append(current, stat)
+		}
+	}
+	return r.regressionSummary(findRegressions(current, regionNorms(history, r.HistoryWindow), r.RegressionThreshold))
+}
+
+// Prints the regions that fell well below their historical norm
+func (r *Runner) printRegressions() {
+	if summary := r.currentRegressions(); summary != "" {
+		r.printText("\n" + summary)
+	}
+}
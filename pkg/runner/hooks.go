@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+const hookTimeout = 5 * time.Minute // A hook still running after this is killed so the run can go on
+
+// locationHooks carries a location from its pre hooks to its post hooks
+type locationHooks struct {
+	r        *Runner
+	location results.Location
+	region   string
+	stat     *results.VPNStat // Result written for the location; nil when it failed or was skipped
+}
+
+// Runs the -pre-hook and the location's pre hook, global first, and returns what the post hooks need; nil
+// without any hook for the location
+func (r *Runner) startLocationHooks(location results.Location, region string) *locationHooks {
+	if r.PreHook == "" && r.PostHook == "" && location.PreHook == "" && location.PostHook == "" {
+		return nil
+	}
+	h := &locationHooks{r: r, location: location, region: region}
+	env := h.env("pre")
+	for _, hook := range []string{r.PreHook, location.PreHook} {
+		runHook(r.Commands, hook, env)
+	}
+	return h
+}
+
+// Records the result written for the location, passed to the post hooks
+func (h *locationHooks) record(stat results.VPNStat) {
+	if h == nil {
+		return
+	}
+	h.stat = &stat
+	h.region = stat.Region
+}
+
+// Runs the location's post hook and the -post-hook, global last
+func (h *locationHooks) finish() {
+	if h == nil {
+		return
+	}
+	env := h.env("post")
+	for _, hook := range []string{h.location.PostHook, h.r.PostHook} {
+		runHook(h.r.Commands, hook, env)
+	}
+}
+
+// Describes the location, and in post hooks its outcome, as SPEEDTEST_ variables
+func (h *locationHooks) env(phase string) []string {
+	env := append(h.r.locationEnv(h.location, h.region), "SPEEDTEST_HOOK="+phase)
+	if phase != "post" {
+		return env
+	}
+
+	key := h.location.Key()
+	switch {
+	case h.stat != nil:
+		data, _ := json.Marshal(h.stat)
+		return append(env, "SPEEDTEST_STATUS=ok",
+			fmt.Sprintf("SPEEDTEST_DOWNLOAD_MBPS=%.2f", results.ParseMbps(h.stat.VPNDownloadSpeed)),
+			fmt.Sprintf("SPEEDTEST_UPLOAD_MBPS=%.2f", results.ParseMbps(h.stat.VPNUploadSpeed)),
+			fmt.Sprintf("SPEEDTEST_LATENCY_MS=%.2f", results.ParseUnit(h.stat.VPNLatency, "ms")),
+			"SPEEDTEST_RESULT="+string(data))
+	case slices.Contains(h.r.skippedLocations, key):
+		return append(env, "SPEEDTEST_STATUS=skipped")
+	}
+	env = append(env, "SPEEDTEST_STATUS=failed")
+	for _, failure := range slices.Backward(h.r.failedLocations) {
+		if reason, ok := strings.CutPrefix(failure, key+": "); ok {
+			return append(env, "SPEEDTEST_ERROR="+reason)
+		}
+	}
+	return env
+}
+
+// Describes a location as the SPEEDTEST_ variables hooks and probes share
+func (r *Runner) locationEnv(location results.Location, region string) []string {
+	return []string{
+		"SPEEDTEST_RUN_ID=" + r.runID,
+		"SPEEDTEST_LOCATION=" + location.Key(),
+		"SPEEDTEST_COUNTRY=" + location.Country,
+		"SPEEDTEST_CITY=" + location.City,
+		"SPEEDTEST_REGION=" + region,
+		"SPEEDTEST_PROVIDER=" + location.Provider,
+	}
+}
+
+// Creates a command running a line through sh, or cmd on Windows, with the variables added to the environment
+func shellCommand(ctx context.Context, commands command.Overrides, line string, env []string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = commands.NewContext(ctx, "cmd", "/c", line)
+	} else {
+		cmd = commands.NewContext(ctx, "sh", "-c", line)
+	}
+	cmd.Env = append(cmd.Environ(), env...)
+	return cmd
+}
+
+// Runs a hook through the shell with the variables added to the environment; a failing hook is logged
+// and doesn't stop the run
+func runHook(commands command.Overrides, hook string, env []string) {
+	if hook == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	output, err := shellCommand(ctx, commands, hook, env).CombinedOutput()
+	if err != nil {
+		slog.Warn("Hook failed", "hook", hook, "err", err, "output", strings.TrimSpace(string(output)))
+		return
+	}
+	slog.Debug("Hook finished", "hook", hook, "output", strings.TrimSpace(string(output)))
+}
@@ -0,0 +1,165 @@
+package runner
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"embed"
+	"html/template"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+//go:embed templates/report.html templates/report.css
+var templates embed.FS
+
+// Layout of the inline SVG chart, in pixels
+const chartLabelWidth = 220
+const chartBarWidth = 500
+const chartRowHeight = 28
+
+// ChartBar is one location's row in the report chart
+type ChartBar struct {
+	LocationName   string
+	Download       float64
+	Upload         float64
+	Y              int
+	UploadY        int
+	DownloadWidth  int
+	UploadWidth    int
+	DownloadLabelX int
+	UploadLabelX   int
+}
+
+// Builds the chart rows, scaling the bars to the fastest speed in the run
+func chartBars(data results.Results) []ChartBar {
+	var maxSpeed float64
+	for _, stat := range data.VPNStats {
+		maxSpeed = max(maxSpeed, results.ParseMbps(stat.VPNDownloadSpeed), results.ParseMbps(stat.VPNUploadSpeed))
+	}
+
+	var bars []ChartBar
+	for i, stat := range data.VPNStats {
+		bar := ChartBar{
+			LocationName: stat.LocationName,
+			Download:     results.ParseMbps(stat.VPNDownloadSpeed),
+			Upload:       results.ParseMbps(stat.VPNUploadSpeed),
+			Y:            i * chartRowHeight,
+		}
+		bar.UploadY = bar.Y + 10
+		if maxSpeed > 0 {
+			bar.DownloadWidth = int(bar.Download / maxSpeed * chartBarWidth)
+			bar.UploadWidth = int(bar.Upload / maxSpeed * chartBarWidth)
+		}
+		bar.DownloadLabelX = chartLabelWidth + bar.DownloadWidth + 4
+		bar.UploadLabelX = chartLabelWidth + bar.UploadWidth + 4
+		bars = append(bars, bar)
+	}
+	return bars
+}
+
+// Renders a self-contained HTML report: styles and chart are inlined, nothing is loaded from the network
+func (d Display) RenderHTMLReport(w io.Writer, data results.Results) error {
+	css, err := templates.ReadFile("templates/report.css")
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("report.html").Funcs(template.FuncMap{
+		"join":    strings.Join,
+		"deref":   func(b *bool) bool { return *b },
+		"speed":   d.displaySpeed,
+		"speeds":  d.DisplaySpeeds,
+		"mbps":    d.FormatSpeed,
+		"time":    d.displayTime,
+		"inc":     func(i int) int { return i + 1 },
+		"percent": displayPercentOfBaseline,
+	}).ParseFS(templates, "templates/report.html")
+	if err != nil {
+		return err
+	}
+
+	return tmpl.Execute(w, map[string]any{
+		"Results":     data,
+		"Unit":        cmp.Or(d.Unit, "Mbps"),
+		"CSS":         template.CSS(css),
+		"Generated":   d.displayTime(results.FormatTime(time.Now())), // In UTC like the stored times, unless LocalTime
+		"Bars":        chartBars(data),
+		"ResultPages": slices.ContainsFunc(data.VPNStats, func(stat results.VPNStat) bool { return len(stat.ResultURLs) > 0 }),
+		"TimeOfDay":   d.TimeOfDayTable(data),
+		"Providers":   d.ProviderTable(data),
+		"LabelWidth":  chartLabelWidth,
+		"ChartWidth":  chartLabelWidth + chartBarWidth + 60,
+		"ChartHeight": max(len(data.VPNStats)*chartRowHeight, chartRowHeight),
+	})
+}
+
+// Writes the HTML report and the bundle of the finished run, if requested
+func (r *Runner) writeHTMLReport() {
+	if r.HTMLReportFile == "" && r.BundleFile == "" {
+		return
+	}
+
+	data, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return
+	}
+
+	var report bytes.Buffer
+	if err := r.RenderHTMLReport(&report, data); err != nil {
+		slog.Error("Error rendering HTML report", "err", err)
+		return
+	}
+
+	if r.HTMLReportFile != "" {
+		if err := results.WriteFileAtomic(r.HTMLReportFile, report.Bytes(), 0644); err != nil {
+			slog.Error("Error writing HTML report", "err", err)
+		}
+	}
+
+	if r.BundleFile != "" {
+		files := map[string][]byte{"report.html": report.Bytes()}
+		if r.BundleLog != nil {
+			files["run.log"] = r.BundleLog.Bytes()
+		}
+		for _, fileName := range []string{r.ResultsFile, r.PublicReportFile} {
+			if fileName == "" {
+				continue
+			}
+			if content, err := os.ReadFile(fileName); err == nil {
+				files[filepath.Base(fileName)] = content
+			}
+		}
+		if err := writeBundle(r.BundleFile, files); err != nil {
+			slog.Error("Error writing bundle", "err", err)
+		}
+	}
+}
+
+// Zips the files into a single archive for sharing with air-gapped environments
+func writeBundle(fileName string, files map[string][]byte) error {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return results.WriteFileAtomic(fileName, archive.Bytes(), 0644)
+}
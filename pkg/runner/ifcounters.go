@@ -0,0 +1,159 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+var sysClassNet = "/sys/class/net" // Where Linux exposes the counters; a variable so tests can redirect it
+
+// Returns the bytes received and sent on an interface since it came up: from sysfs on Linux, netstat on
+// macOS and Get-NetAdapterStatistics on Windows, which lists WinTun adapters too
+func interfaceBytes(commands command.Overrides, name string) (uint64, uint64, error) {
+	switch runtime.GOOS {
+	case "linux":
+		rx, err := readCounter(filepath.Join(sysClassNet, name, "statistics", "rx_bytes"))
+		if err != nil {
+			return 0, 0, err
+		}
+		tx, err := readCounter(filepath.Join(sysClassNet, name, "statistics", "tx_bytes"))
+		return rx, tx, err
+	case "darwin":
+		output, err := commands.Run("netstat", "-ibn", "-I", name)
+		if err != nil {
+			return 0, 0, err
+		}
+		return parseNetstatBytes(string(output), name)
+	case "windows":
+		output, err := commands.Run("powershell", "-NoProfile", "-Command",
+			"Get-NetAdapterStatistics -Name '"+strings.ReplaceAll(name, "'", "''")+"' | ForEach-Object { \"$($_.ReceivedBytes) $($_.SentBytes)\" }")
+		if err != nil {
+			return 0, 0, err
+		}
+		return parseCounterPair(string(output))
+	default:
+		return 0, 0, fmt.Errorf("interface counters aren't supported on %s", runtime.GOOS)
+	}
+}
+
+func readCounter(fileName string) (uint64, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// Reads the byte counters of the interface's link row in netstat -ibn output; counted from the right,
+// since tunnels such as utun have no address column
+func parseNetstatBytes(output string, name string) (uint64, uint64, error) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 7 || fields[0] != name || !strings.HasPrefix(fields[2], "<Link#") {
+			continue
+		}
+		// ... Ipkts Ierrs Ibytes Opkts Oerrs Obytes Coll
+		return parseCounterPair(fields[len(fields)-5] + " " + fields[len(fields)-2])
+	}
+	return 0, 0, fmt.Errorf("no counters for %s in netstat output", name)
+}
+
+// Parses "RECEIVED SENT"
+func parseCounterPair(output string) (uint64, uint64, error) {
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected counter output %q", strings.TrimSpace(output))
+	}
+	rx, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	tx, err := strconv.ParseUint(fields[1], 10, 64)
+	return rx, tx, err
+}
+
+// counterSnapshot holds the counters at the start of a location's tests
+type counterSnapshot struct {
+	r                          *Runner
+	tunnel, physical           string
+	tunnelBytes, physicalBytes uint64
+	goodputBytes               int64
+}
+
+// Reads the counters of the tunnel, the interface traffic to TracerouteTarget leaves through while
+// connected, and of the interface without VPN, which carries the encrypted tunnel; nil when disabled or
+// neither can be read
+func (r *Runner) startInterfaceCounters() *counterSnapshot {
+	if !r.IfaceCounters {
+		return nil
+	}
+	snapshot := &counterSnapshot{r: r, physical: r.homeInterface, goodputBytes: r.reportedBytes.Load()}
+	if tunnel, err := egressInterface(r.Commands, r.TracerouteTarget); err != nil {
+		slog.Warn("Could not look up the tunnel interface", "err", err)
+	} else if tunnel != r.homeInterface {
+		snapshot.tunnel = tunnel
+	}
+	snapshot.tunnelBytes = r.totalBytes(&snapshot.tunnel)
+	snapshot.physicalBytes = r.totalBytes(&snapshot.physical)
+	if snapshot.tunnel == "" && snapshot.physical == "" {
+		return nil
+	}
+	return snapshot
+}
+
+// Returns the bytes received and sent on the interface, clearing its name when they can't be read
+func (r *Runner) totalBytes(name *string) uint64 {
+	if *name == "" {
+		return 0
+	}
+	rx, tx, err := interfaceBytes(r.Commands, *name)
+	if err != nil {
+		slog.Warn("Could not read interface counters", "interface", *name, "err", err)
+		*name = ""
+		return 0
+	}
+	return rx + tx
+}
+
+// Reads the counters again at the end of the tests and compares what the interfaces carried with the goodput
+// the speed tests reported
+func (s *counterSnapshot) finish() *results.InterfaceCounters {
+	if s == nil {
+		return nil
+	}
+	counters := &results.InterfaceCounters{
+		TunnelInterface:   s.tunnel,
+		PhysicalInterface: s.physical,
+		GoodputMB:         megabytes(uint64(s.r.reportedBytes.Load() - s.goodputBytes)),
+	}
+	counters.TunnelMB = s.r.carried(&counters.TunnelInterface, s.tunnelBytes)
+	counters.PhysicalMB = s.r.carried(&counters.PhysicalInterface, s.physicalBytes)
+	if counters.TunnelMB > 0 && counters.PhysicalMB > 0 {
+		counters.OverheadPercent = math.Round((counters.PhysicalMB-counters.TunnelMB)/counters.TunnelMB*1000) / 10
+	}
+	return counters
+}
+
+// Returns the megabytes an interface carried since the counters read before; a tunnel that came up
+// again in between starts counting from zero, so it is left out
+func (r *Runner) carried(name *string, before uint64) float64 {
+	after := r.totalBytes(name)
+	if *name == "" || after < before {
+		*name = ""
+		return 0
+	}
+	return megabytes(after - before)
+}
+
+func megabytes(bytes uint64) float64 {
+	return math.Round(float64(bytes)/1e4) / 100
+}
@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+var ipCheckClient = &http.Client{Timeout: 10 * time.Second}
+
+// ExitIPInfo is what the IP echo service reports about the current public IP
+type ExitIPInfo struct {
+	IP        string
+	Country   string
+	ISP       string
+	Latitude  float64
+	Longitude float64
+}
+
+// Common short forms mapped to the country names IP echo services report
+var countryAliases = map[string]string{
+	"usa":         "united states",
+	"us":          "united states",
+	"america":     "united states",
+	"uk":          "united kingdom",
+	"gb":          "united kingdom",
+	"britain":     "united kingdom",
+	"england":     "united kingdom",
+	"holland":     "netherlands",
+	"nl":          "netherlands",
+	"south korea": "korea",
+	"uae":         "united arab emirates",
+}
+
+// Queries the IP echo service; both ipapi.co ("ip", "country_name", "org") and
+// ip-api.com ("query", "country", "isp") style responses are understood
+func fetchExitIP(ctx context.Context, url string) (ExitIPInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return ExitIPInfo{}, err
+	}
+	resp, err := ipCheckClient.Do(req)
+	if err != nil {
+		return ExitIPInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ExitIPInfo{}, fmt.Errorf("IP check returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ExitIPInfo{}, err
+	}
+
+	var payload struct {
+		IP          string  `json:"ip"`
+		Query       string  `json:"query"`
+		CountryName string  `json:"country_name"`
+		Country     string  `json:"country"`
+		Org         string  `json:"org"`
+		ISP         string  `json:"isp"`
+		Latitude    float64 `json:"latitude"`
+		Longitude   float64 `json:"longitude"`
+		Lat         float64 `json:"lat"`
+		Lon         float64 `json:"lon"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ExitIPInfo{}, err
+	}
+
+	info := ExitIPInfo{IP: payload.IP, Country: payload.CountryName, ISP: payload.Org, Latitude: payload.Latitude, Longitude: payload.Longitude}
+	if info.IP == "" {
+		info.IP = payload.Query
+	}
+	if info.Country == "" {
+		info.Country = payload.Country
+	}
+	if info.ISP == "" {
+		info.ISP = payload.ISP
+	}
+	if info.Latitude == 0 && info.Longitude == 0 {
+		info.Latitude, info.Longitude = payload.Lat, payload.Lon
+	}
+	return info, nil
+}
+
+// Normalizes a country name for comparison, resolving common aliases
+func normalizeCountry(country string) string {
+	country = strings.ToLower(strings.TrimSpace(country))
+	if alias, ok := countryAliases[country]; ok {
+		return alias
+	}
+	return country
+}
+
+// Reports whether the observed exit country is the one that was requested
+func countryMatches(requested, observed string) bool {
+	return normalizeCountry(requested) == normalizeCountry(observed)
+}
+
+// Checks the exit IP after connecting, warning when it is not in the requested country;
+// the match is nil when the check itself failed
+func (r *Runner) verifyExit(ctx context.Context, location results.Location) (ExitIPInfo, *bool) {
+	info, err := fetchExitIP(ctx, r.IPCheckURL)
+	if err != nil {
+		slog.Warn("Exit IP check failed", "url", r.IPCheckURL, "err", err)
+		return ExitIPInfo{}, nil
+	}
+	if location.IsSmart() {
+		// No country was requested to compare with
+		return info, nil
+	}
+
+	matches := countryMatches(location.Country, info.Country)
+	if !matches {
+		slog.Warn("VPN exit country does not match the requested region", "requested", location.Country, "observed", info.Country, "ip", info.IP)
+	}
+	return info, &matches
+}
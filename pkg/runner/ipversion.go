@@ -0,0 +1,23 @@
+package runner
+
+import (
+	"context"
+	"log/slog"
+	"net"
+)
+
+var ipVersionSuffixes = map[int]string{4: "-ipv4", 6: "-ipv6"} // Of default results file names, keeping forced runs apart
+
+// Reports whether a TCP connection to the IPv6 target succeeds through the VPN; exits differ in whether
+// they carry IPv6 at all, and a VPN that can't may still leave the tests on IPv4
+func (r *Runner) checkIPv6(ctx context.Context) *bool {
+	dialer := net.Dialer{Timeout: r.SpeedTest.LatencyTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp6", r.IPv6Target)
+	reachable := err == nil
+	if reachable {
+		conn.Close()
+	} else {
+		slog.Debug("No IPv6 connectivity", "target", r.IPv6Target, "err", err)
+	}
+	return &reachable
+}
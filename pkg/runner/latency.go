@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+)
+
+const latencyOnlyMode = "Latency only"
+
+// Measures the latency of the connection instead of its throughput; ok is false for the baseline or
+// when no connection succeeded
+func (r *Runner) latencyTest(connectionTime string) (results.VPNStat, bool) {
+	spinner := r.startSpinner("Measuring latency to " + r.SpeedTest.LatencyTarget + "...")
+	result, err := speedtest.MeasureLatency(r.engineOptions())
+	if err != nil {
+		slog.Error("Latency measurement failed", "target", r.SpeedTest.LatencyTarget, "err", err)
+		spinner.Fail("Latency measurement failed")
+		var failed results.VPNStat
+		countSamples(&failed, 1, []string{err.Error()})
+		return failed, false
+	}
+	spinner.Success(fmt.Sprintf("Latency %.2fms, jitter %.2fms, packet loss %.0f%%", result.Ping.Latency, result.Ping.Jitter, result.PacketLoss))
+	r.writeSample(newSampleRecord(result, connectionTime, latencyOnlyMode))
+
+	if connectionTime == "" {
+		r.recordLatencyBaseline(result)
+		return results.VPNStat{}, false
+	}
+	now := time.Now()
+	stat := results.VPNStat{
+		TimeToConnect: connectionTime,
+		VPNLatency:    fmt.Sprintf("%.2fms", result.Ping.Latency),
+		VPNJitter:     fmt.Sprintf("%.2fms", result.Ping.Jitter),
+		VPNPacketLoss: fmt.Sprintf("%.2f%%", result.PacketLoss),
+		Server:        result.Server.Host,
+		Timestamp:     results.FormatTime(now),
+		TimestampUnix: now.Unix(),
+		Mode:          latencyOnlyMode,
+	}
+	countSamples(&stat, 1, nil)
+	return stat, true
+}
+
+// Records the latency without VPN as the baseline, e.g. "12.34ms latency"
+func (r *Runner) recordLatencyBaseline(result speedtest.Result) {
+	baseline := fmt.Sprintf("%.2fms latency", result.Ping.Latency)
+
+	r.mutex.Lock()
+	r.withoutVPN = baseline
+	r.mutex.Unlock()
+
+	r.recordProgressBaseline(baseline)
+}
+
+// Ranks the locations of the run from the lowest latency up
+func latencyRanking(stats []results.VPNStat) pterm.TableData {
+	ranked := slices.Clone(stats)
+	slices.SortStableFunc(ranked, func(a, b results.VPNStat) int {
+		return cmp.Compare(latencyMillis(a), latencyMillis(b))
+	})
+
+	table := pterm.TableData{{"#", "Location", "Latency", "Jitter", "Packet loss", "Connect"}}
+	for i, stat := range ranked {
+		table = append(table, []string{fmt.Sprint(i + 1), stat.LocationName, stat.VPNLatency, stat.VPNJitter, stat.VPNPacketLoss, stat.TimeToConnect})
+	}
+	return table
+}
+
+func latencyMillis(stat results.VPNStat) float64 {
+	latency, _ := strconv.ParseFloat(strings.TrimSuffix(stat.VPNLatency, "ms"), 64)
+	return latency
+}
+
+// Prints the latency ranking of a LatencyOnly run
+func (r *Runner) printLatencyRanking() {
+	if !r.LatencyOnly {
+		return
+	}
+	data, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return
+	}
+	var stats []results.VPNStat
+	for _, stat := range data.VPNStats {
+		if stat.RunID == r.runID {
+			stats = append(stats, stat)
+		}
+	}
+	if len(stats) == 0 {
+		return
+	}
+	r.printText("\nLocations by latency:")
+	pterm.DefaultTable.WithHasHeader().WithData(latencyRanking(stats)).Render()
+}
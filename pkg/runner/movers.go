@@ -0,0 +1,147 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Mover is a location whose download speed changed between two runs
+type Mover struct {
+	LocationName string
+	Before       float64
+	After        float64
+	DeltaPercent float64
+}
+
+// Default results file names: the time the run started and the forced IP version, if any
+var defaultResultsName = regexp.MustCompile(`^results-\d{14}(-ipv[46])?\.json$`)
+
+// Returns the most recent default-named results file written before the current one in the same directory,
+// of runs forced to the same IP version; an empty string when the current file was given another name
+func findPreviousResults(current string) string {
+	name := defaultResultsName.FindStringSubmatch(filepath.Base(current))
+	if name == nil {
+		return ""
+	}
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(current), "results-*.json"))
+	if err != nil {
+		return ""
+	}
+
+	// Timestamped names sort chronologically
+	sort.Strings(matches)
+	previous := ""
+	for _, match := range matches {
+		if filepath.Base(match) >= filepath.Base(current) {
+			break
+		}
+		if other := defaultResultsName.FindStringSubmatch(filepath.Base(match)); other != nil && other[1] == name[1] {
+			previous = match
+		}
+	}
+	return previous
+}
+
+// Returns the locations of the run before the current one: the previous run in the same file when the run
+// was appended to it, otherwise the last run of the previous results file; ok is false without one
+func (r *Runner) previousRun(current results.Results) (results.Results, bool) {
+	for i, run := range current.Runs {
+		if run.ID == r.runID && i > 0 {
+			return current.RunResults(current.Runs[i-1].ID), true
+		}
+	}
+
+	previousFile := findPreviousResults(r.ResultsFile)
+	if previousFile == "" {
+		return results.Results{}, false
+	}
+	previous, err := r.Files.Load(previousFile)
+	if err != nil {
+		slog.Warn("Error loading previous results", "path", previousFile, "err", err)
+		return results.Results{}, false
+	}
+	if len(previous.Runs) > 0 {
+		previous = previous.RunResults(previous.Runs[len(previous.Runs)-1].ID)
+	}
+	return previous, true
+}
+
+// Ranks locations present in both runs by the relative change of their average download speed
+func topMovers(previous, current results.Results, n int) (improved []Mover, degraded []Mover) {
+	beforeDownload, _, _ := previous.GroupSamples()
+	afterDownload, _, order := current.GroupSamples()
+
+	var movers []Mover
+	for _, location := range order {
+		before, ok := beforeDownload[location]
+		if !ok || before.Mean == 0 {
+			continue
+		}
+		m := Mover{LocationName: location, Before: before.Mean, After: afterDownload[location].Mean}
+		m.DeltaPercent = (m.After - m.Before) / m.Before * 100
+		movers = append(movers, m)
+	}
+
+	sort.SliceStable(movers, func(i, j int) bool {
+		return movers[i].DeltaPercent > movers[j].DeltaPercent
+	})
+	for _, m := range movers {
+		if m.DeltaPercent > 0 && len(improved) < n {
+			improved = append(improved, m)
+		}
+	}
+	for i := len(movers) - 1; i >= 0; i-- {
+		if movers[i].DeltaPercent < 0 && len(degraded) < n {
+			degraded = append(degraded, movers[i])
+		}
+	}
+	return improved, degraded
+}
+
+// Formats the top movers digest, or returns an empty string when there is nothing to compare
+func (d Display) topMoversSummary(improved, degraded []Mover) string {
+	if len(improved) == 0 && len(degraded) == 0 {
+		return ""
+	}
+
+	summary := "Top movers since the previous run:\n"
+	for _, m := range improved {
+		summary += fmt.Sprintf("  ▲ %s: %s → %s (%+.1f%%)\n", m.LocationName, d.FormatSpeed(m.Before), d.FormatSpeed(m.After), m.DeltaPercent)
+	}
+	for _, m := range degraded {
+		summary += fmt.Sprintf("  ▼ %s: %s → %s (%+.1f%%)\n", m.LocationName, d.FormatSpeed(m.Before), d.FormatSpeed(m.After), m.DeltaPercent)
+	}
+	return summary
+}
+
+// Summarizes the regions of the run that changed most versus the previous run, or returns an empty string
+func (r *Runner) currentTopMovers() string {
+	if r.TopMovers <= 0 {
+		return ""
+	}
+
+	current, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return ""
+	}
+	previous, ok := r.previousRun(current)
+	if !ok {
+		return ""
+	}
+
+	improved, degraded := topMovers(previous, current.RunResults(r.runID), r.TopMovers)
+	return r.topMoversSummary(improved, degraded)
+}
+
+// Prints the regions that changed most versus the previous run
+func (r *Runner) printTopMovers() {
+	if summary := r.currentTopMovers(); summary != "" {
+		r.printText("\n" + summary)
+	}
+}
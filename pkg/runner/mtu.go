@@ -0,0 +1,138 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// IPv4 and ICMP headers on top of a ping's payload
+const icmpHeaderBytes = 28
+
+// Path MTUs the sweep searches between: the smallest every IPv4 host must accept and Ethernet's
+const (
+	minSweepMTU = 576
+	maxSweepMTU = 1500
+)
+
+// Outcomes of one ping that must not be fragmented
+const (
+	mtuReplied = iota
+	mtuTooBig  // Refused with a fragmentation needed error, locally or by a router on the path
+	mtuLost    // No reply and no error: dropped silently
+)
+
+var ifconfigMTUPattern = regexp.MustCompile(`\bmtu (\d+)`)
+
+// Sends one ping with the don't fragment bit and a payload of size bytes to the host; a variable so tests
+// can replace it
+var mtuProbe = func(commands command.Overrides, host string, size int) int {
+	var output []byte
+	var err error
+	payload := strconv.Itoa(size)
+	switch runtime.GOOS {
+	case "windows":
+		output, err = commands.RunCombined("ping", "-f", "-l", payload, "-n", "1", "-w", "1000", host)
+	case "darwin":
+		output, err = commands.RunCombined("ping", "-D", "-s", payload, "-c", "1", "-t", "1", host)
+	default:
+		output, err = commands.RunCombined("ping", "-M", "do", "-s", payload, "-c", "1", "-W", "1", host)
+	}
+	return classifyMTUProbe(string(output), err)
+}
+
+// Tells a reply from a fragmentation needed error and from silence in ping's output; Windows' ping
+// exits 0 without a reply, so the reply itself is looked for
+func classifyMTUProbe(output string, err error) int {
+	lower := strings.ToLower(output)
+	switch {
+	case strings.Contains(lower, "frag") || strings.Contains(lower, "too long"):
+		return mtuTooBig
+	case err == nil && strings.Contains(lower, "ttl="):
+		return mtuReplied
+	default:
+		return mtuLost
+	}
+}
+
+// Probes once more before taking a lost ping for an answer, as single pings get lost on their own
+func (r *Runner) probeMTU(host string, mtu int) int {
+	outcome := mtuProbe(r.Commands, host, mtu-icmpHeaderBytes)
+	if outcome == mtuLost {
+		outcome = mtuProbe(r.Commands, host, mtu-icmpHeaderBytes)
+	}
+	return outcome
+}
+
+// Searches the largest packet that reaches the host unfragmented through the tunnel, then compares it with
+// the tunnel interface's MTU. Packets the path can't carry must be refused with a fragmentation needed
+// error; when they vanish instead, TCP never learns to send smaller ones and stalls: a blackhole. Nil when
+// the host doesn't answer pings at all
+func (r *Runner) sweepMTU(host string) *results.MTU {
+	if r.probeMTU(host, minSweepMTU) != mtuReplied {
+		slog.Warn("MTU sweep failed, the host doesn't answer pings of the minimum size", "host", host)
+		return nil
+	}
+
+	mtu := &results.MTU{}
+	low, high := minSweepMTU, maxSweepMTU+1 // Replied at low, not known to reply at high
+	for high-low > 1 {
+		middle := (low + high) / 2
+		switch r.probeMTU(host, middle) {
+		case mtuReplied:
+			low = middle
+		case mtuLost:
+			mtu.Blackhole = true
+			high = middle
+		default:
+			high = middle
+		}
+	}
+	mtu.PathMTU = low
+
+	if name, err := egressInterface(r.Commands, host); err == nil {
+		mtu.Interface = name
+		mtu.InterfaceMTU = interfaceMTU(r.Commands, name)
+	}
+	switch {
+	case mtu.InterfaceMTU > mtu.PathMTU && mtu.Blackhole:
+		mtu.Issue = fmt.Sprintf("the interface sends packets up to %d bytes but the path drops those over %d without telling", mtu.InterfaceMTU, mtu.PathMTU)
+	case mtu.InterfaceMTU > mtu.PathMTU:
+		mtu.Issue = fmt.Sprintf("the interface sends packets up to %d bytes but the path carries %d, larger ones are fragmented", mtu.InterfaceMTU, mtu.PathMTU)
+	case mtu.Blackhole:
+		mtu.Issue = "packets over the path MTU are dropped without a fragmentation needed error"
+	}
+
+	r.printTextf("Path MTU: %d\n", mtu.PathMTU)
+	if mtu.Issue != "" {
+		slog.Warn("MTU problem through the tunnel, expect throughput cliffs", "issue", mtu.Issue)
+	}
+	return mtu
+}
+
+// Returns an interface's MTU from sysfs on Linux or ifconfig on macOS; 0 when unknown
+func interfaceMTU(commands command.Overrides, name string) int {
+	switch runtime.GOOS {
+	case "linux":
+		mtu, err := readCounter(filepath.Join(sysClassNet, name, "mtu"))
+		if err == nil {
+			return int(mtu)
+		}
+	case "darwin":
+		output, err := commands.Run("ifconfig", name)
+		if err == nil {
+			if m := ifconfigMTUPattern.FindStringSubmatch(string(output)); m != nil {
+				mtu, _ := strconv.Atoi(m[1])
+				return mtu
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,227 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+	"flavius.xyz/vpn_speed_test_cli/pkg/vpn"
+)
+
+// Binaries of the engines that can run inside a network namespace; the others test in-process, from the
+// host's network
+var namespaceEngines = map[string]string{"ookla": "speedtest", "iperf3": "iperf3"}
+
+// Returns why the locations can't be tested in network namespaces: every one needs its own tunnel, which
+// only the WireGuard provider can bring up in a namespace, as the ExpressVPN client is a single daemon
+// controlling the host's connection
+func (r *Runner) checkNamespaces(locations []results.Location) error {
+	if runtime.GOOS != "linux" {
+		return errors.New("-namespaces needs Linux network namespaces")
+	}
+	if _, ok := namespaceEngines[r.Engine]; !ok {
+		return fmt.Errorf("-namespaces needs an engine that runs as its own binary, ookla or iperf3, not %s", r.Engine)
+	}
+	var conflicting []string
+	for _, option := range []struct {
+		set  bool
+		flag string
+	}{
+		{r.Router, "-router"}, {r.TUI, "-tui"}, {r.Once, "-once"}, {r.LatencyOnly, "-latency-only"}, {r.Mode == "hybrid", "-mode hybrid"},
+		{r.ConnectCycles > 1, "-connect-cycles"}, {r.ConnectPhases, "-connect-phases"}, {r.VerifyExitIP, "-verify-ip"},
+		{r.IPv6Check, "-ipv6-check"}, {len(r.DNSDomains) > 0, "-dns"}, {len(r.WebURLs) > 0, "-web"}, {r.VerifyRoute, "-verify-route"},
+		{r.IfaceCounters, "-iface-counters"}, {r.CPU, "-cpu"}, {r.MTUSweep, "-mtu-sweep"}, {r.Geo, "-geo"}, {len(r.Probes) > 0, "-probe"},
+		{r.CrossCheck != "", "-cross-check"}, {r.Soak > 0, "-soak"}, {r.PingAnchor != "", "-ping-anchor"},
+	} {
+		if option.set {
+			conflicting = append(conflicting, option.flag)
+		}
+	}
+	if len(conflicting) > 0 {
+		return fmt.Errorf("-namespaces can't be combined with %s, which measure from the host or one location at a time", strings.Join(conflicting, ", "))
+	}
+	for _, location := range locations {
+		if _, ok := r.providerFor(location).(*vpn.WireGuard); !ok {
+			return fmt.Errorf("-namespaces needs every location tested through a wireguard provider, %s isn't", describeLocation(location))
+		}
+	}
+	return nil
+}
+
+// Tests the locations Namespaces at a time, each through a WireGuard tunnel in a network namespace of its
+// own, where the engine's binary runs too; the regions share the host's connection, which is recorded
+// in their results' Mode
+func (r *Runner) testInNamespaces(ctx context.Context, locations []results.Location) {
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	workers := 0
+	for i := range min(r.Namespaces, len(locations)) {
+		ns := vpn.Namespace{Name: fmt.Sprintf("vpnbench%d", i+1), Commands: r.Commands}
+		if err := ns.Create(); err != nil {
+			slog.Error("Failed to create a network namespace", "namespace", ns.Name, "err", err)
+			continue
+		}
+		workers++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer ns.Delete()
+			for index := range queue {
+				r.testInNamespace(ctx, ns, locations[index], index)
+			}
+		}()
+	}
+	if workers == 0 {
+		for _, location := range locations {
+			if !r.isCompleted(location) {
+				r.recordFailure(location, "no network namespace")
+			}
+		}
+		return
+	}
+
+	for i, location := range locations {
+		r.locationMutex.Lock()
+		stop, completed, tested := r.shouldStop(), r.isCompleted(location), r.testedLocations
+		r.locationMutex.Unlock()
+		if stop {
+			slog.Error("Stopping after the first failed location")
+			break
+		}
+		if r.abortRequested.Load() {
+			slog.Warn("Aborting: Requested " + r.abortSource)
+			break
+		}
+		if completed {
+			slog.Info("Skipping: Already completed before resuming", "country", location.Country, "city", location.City)
+			continue
+		}
+		if reason := r.budgetExceeded(r.Samples + r.Warmup); reason != "" {
+			r.budgetStop = fmt.Sprintf("%s, after %d of %d locations", reason, tested, len(locations))
+			slog.Warn("Stopping: "+r.budgetStop, "dataUsedMB", r.dataUsedMB())
+			break
+		}
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// Connects a location's region in the namespace, runs its speed tests there and records the result
+func (r *Runner) testInNamespace(ctx context.Context, ns vpn.Namespace, location results.Location, index int) {
+	provider := r.providerFor(location).(*vpn.WireGuard)
+	region, suggestions := r.findRegion(provider, location)
+	if region == "" {
+		slog.Warn("Skipping: No matching region found", "country", location.Country, "city", location.City, "closest", strings.Join(suggestions, ", "))
+		r.locationMutex.Lock()
+		r.recordFailure(location, "no matching region")
+		r.locationMutex.Unlock()
+		return
+	}
+	hooks := r.startLocationHooks(location, region)
+	defer hooks.finish()
+	r.updateProgress("connecting", location.Country+", "+location.City, index+1)
+	locationCtx, cancel := r.locationContext(ctx)
+	defer cancel()
+
+	tunnel := &vpn.WireGuard{Dir: provider.Dir, Commands: provider.Commands, Namespace: ns.Name}
+	r.printTextf("Connecting to VPN in %s: %s...\n", ns.Name, describeLocation(location))
+	connectTime, err := tunnel.Connect(locationCtx, region, r.ConnectTimeout)
+	if err != nil {
+		r.locationMutex.Lock()
+		defer r.locationMutex.Unlock()
+		if !r.locationTimedOut(locationCtx, location) {
+			slog.Error("Failed to connect to VPN", "region", region, "namespace", ns.Name, "err", err)
+			r.recordFailure(location, "failed to connect")
+		}
+		return
+	}
+	defer tunnel.Disconnect()
+	r.updateProgress("testing", location.Country+", "+location.City, index+1)
+
+	opts := r.engineOptions()
+	opts.Commands = ns.Wrap(r.Commands, namespaceEngines[r.Engine])
+	for i := range r.Warmup {
+		if locationCtx.Err() != nil {
+			break
+		}
+		if _, err := r.runSpeedTestWith(locationCtx, opts); err != nil {
+			slog.Warn("Warm-up test failed", "engine", r.Engine, "namespace", ns.Name, "test", i+1, "err", err)
+		}
+	}
+	samples, parallel := locationSettings(location, r.Samples, r.Parallel)
+	stat, ok := r.runNamespaceSamples(locationCtx, opts, connectTime.String(), samples, parallel)
+
+	r.locationMutex.Lock()
+	defer r.locationMutex.Unlock()
+	if r.locationTimedOut(locationCtx, location) {
+		return
+	}
+	if !ok {
+		r.recordFailure(location, allSamplesFailed(stat))
+		return
+	}
+	if stat.LocationName == "" {
+		// The engine doesn't report where its server is, e.g. iperf3
+		stat.LocationName = location.Country + ", " + location.City
+	}
+	stat.Provider = location.Provider
+	stat.Region = region
+	stat.TimeWindow = r.TimeWindow
+	r.clearSkippedPhases(&stat)
+	r.printTextf("%s: %s ▼  %s ▲  in %s\n", describeLocation(location), r.FormatSpeed(results.ParseMbps(stat.VPNDownloadSpeed)), r.FormatSpeed(results.ParseMbps(stat.VPNUploadSpeed)), ns.Name)
+	r.applyAssertions(location, &stat)
+	r.writeToFile(stat)
+	hooks.record(stat)
+	r.markCompleted(location)
+	r.testedLocations++
+}
+
+// Runs n speed tests of a location in its namespace, all at once when parallel, and averages them
+func (r *Runner) runNamespaceSamples(ctx context.Context, opts speedtest.Options, connectionTime string, n int, parallel bool) (results.VPNStat, bool) {
+	concurrency := 1
+	mode := fmt.Sprintf("Tests ran in series, %d regions at a time in network namespaces", r.Namespaces)
+	if parallel && n > 1 {
+		concurrency = n
+		mode = fmt.Sprintf("Tests ran in parallel, %d regions at a time in network namespaces", r.Namespaces)
+	}
+
+	stats := make([]*results.VPNStat, n)
+	sampleErrors := make([]string, n)
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range n {
+		slots <- struct{}{} // Waits for a free slot
+		if err := ctx.Err(); err != nil {
+			sampleErrors[i] = err.Error()
+			<-slots
+			continue
+		}
+		if concurrency == 1 && i > 0 {
+			pause(r.PauseBetweenTests, "between tests")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result, err := r.runSpeedTestWith(ctx, opts)
+			if err != nil {
+				slog.Error("Speed test failed", "engine", r.Engine, "err", err)
+				sampleErrors[i] = err.Error()
+				return
+			}
+			r.writeSample(newSampleRecord(result, connectionTime, mode))
+			stat := newSampleStat(result, connectionTime, mode)
+			stats[i] = &stat
+		}()
+	}
+	wg.Wait()
+	return averageSamples(stats, slices.DeleteFunc(sampleErrors, func(err string) bool { return err == "" }))
+}
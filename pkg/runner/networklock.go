@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Reads ExpressVPN's network lock and sets it as NetworkLock asks; the kill switch changes how traffic is
+// routed, so the setting in effect is recorded with the run
+func (r *Runner) applyNetworkLock() error {
+	enabled, err := r.expressVPN().NetworkLock()
+	if err != nil {
+		if r.NetworkLock != "" {
+			return fmt.Errorf("failed to read the network lock setting: %w", err)
+		}
+		slog.Warn("Failed to read the network lock setting", "err", err)
+		return nil
+	}
+
+	wanted := enabled
+	if r.NetworkLock != "" {
+		wanted = r.NetworkLock == "on"
+	}
+	if wanted != enabled {
+		if err := r.expressVPN().SetNetworkLock(wanted); err != nil {
+			return fmt.Errorf("failed to turn the network lock %s: %w", r.NetworkLock, err)
+		}
+		r.networkLockReset = &enabled
+		r.printText("Network lock", r.NetworkLock, "for the run")
+	}
+	r.networkLock = &wanted
+	return nil
+}
+
+// Puts back the network lock setting NetworkLock changed
+func (r *Runner) restoreNetworkLock() {
+	if r.networkLockReset == nil {
+		return
+	}
+	if err := r.expressVPN().SetNetworkLock(*r.networkLockReset); err != nil {
+		slog.Error("Failed to restore the network lock setting", "enabled", *r.networkLockReset, "err", err)
+		return
+	}
+	r.networkLockReset = nil
+}
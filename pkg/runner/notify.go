@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var notifyClient = &http.Client{Timeout: 30 * time.Second}
+
+// Builds the human-readable summary of the run; digest holds the top movers and regressions of a completed run
+func (r *Runner) runSummary(status string, detail string, digest string) string {
+	name, err := r.machineName()
+	if err != nil {
+		name = "unknown machine"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "VPN speed test run on %s %s", name, status)
+	if detail != "" {
+		fmt.Fprintf(&b, ": %s", detail)
+	}
+	fmt.Fprintf(&b, "\nTested %d of %d locations", r.testedLocations, r.progress.Total)
+	if r.progress.WithoutVPN != "" {
+		fmt.Fprintf(&b, "\nWithout VPN: %s", r.progress.WithoutVPN)
+	}
+	if len(r.failedLocations) > 0 {
+		b.WriteString("\nFailed locations:")
+		for _, failure := range r.failedLocations {
+			b.WriteString("\n  - " + failure)
+		}
+	}
+	if digest != "" {
+		b.WriteString("\n" + strings.TrimRight(digest, "\n"))
+	}
+	return b.String()
+}
+
+// Builds a payload the webhook understands: Discord expects "content", Slack, Teams and
+// generic receivers "text"
+func notifyPayload(webhookURL string, text string) ([]byte, error) {
+	if u, err := url.Parse(webhookURL); err == nil && (u.Hostname() == "discord.com" || u.Hostname() == "discordapp.com") {
+		return json.Marshal(map[string]string{"content": text})
+	}
+	return json.Marshal(map[string]string{"text": text})
+}
+
+// Posts the run summary to the notification webhook, once per run
+func (r *Runner) notifyRun(status string, detail string, digest string) {
+	if r.NotifyURL == "" || r.notified {
+		return
+	}
+	r.notified = true
+
+	payload, err := notifyPayload(r.NotifyURL, r.runSummary(status, detail, digest))
+	if err != nil {
+		slog.Error("Error encoding notification", "err", err)
+		return
+	}
+
+	resp, err := notifyClient.Post(r.NotifyURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		slog.Error("Error sending notification", "err", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		slog.Error("Notification webhook returned an error", "status", resp.Status)
+	}
+}
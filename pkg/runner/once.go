@@ -0,0 +1,19 @@
+package runner
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Prints the result of the location of a Once run as a single JSON object, for shell pipelines
+func (r *Runner) printOnceResult(stat results.VPNStat) {
+	stat.RunID = r.runID
+	if r.Redact {
+		redactStat(&stat)
+	}
+	if err := json.NewEncoder(r.SampleWriter).Encode(stat); err != nil {
+		slog.Error("Error writing result", "err", err)
+	}
+}
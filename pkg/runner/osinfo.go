@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+var osReleaseFile = "/etc/os-release"
+
+// Collects the OS name, version, kernel version and architecture
+func getOSInfo(commands command.Overrides) results.OSInfo {
+	return results.OSInfo{
+		Name:    runtime.GOOS,
+		Version: GetOSVersion(commands),
+		Kernel:  getKernelVersion(commands),
+		Arch:    runtime.GOARCH,
+	}
+}
+
+// Returns the OS name and version: from os-release on Linux, which every systemd-era distribution ships
+// unlike lsb_release, sw_vers on macOS and the registry on Windows
+func GetOSVersion(commands command.Overrides) string {
+	switch runtime.GOOS {
+	case "linux":
+		if data, err := os.ReadFile(osReleaseFile); err == nil {
+			if name := parseOSRelease(string(data)); name != "" {
+				return name
+			}
+		}
+		out, err := commands.Run("uname", "-sr")
+		if err == nil {
+			return strings.TrimSpace(string(out))
+		}
+		return "Unknown Linux"
+	case "darwin":
+		out, _ := commands.Run("sw_vers", "-productVersion")
+		return "macOS " + strings.TrimSpace(string(out))
+	case "windows":
+		return windowsVersion()
+	default:
+		return "Unknown OS"
+	}
+}
+
+// Returns the kernel release from uname, or an empty string where uname is unavailable
+func getKernelVersion(commands command.Overrides) string {
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+	out, err := commands.Run("uname", "-r")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Extracts a human-readable name from os-release content, preferring PRETTY_NAME
+func parseOSRelease(content string) string {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found || strings.HasPrefix(key, "#") {
+			continue
+		}
+		fields[key] = strings.Trim(value, `"'`)
+	}
+
+	if fields["PRETTY_NAME"] != "" {
+		return fields["PRETTY_NAME"]
+	}
+	return strings.TrimSpace(fields["NAME"] + " " + fields["VERSION"])
+}
+
+// Formats the Windows edition, release and full version number, e.g. "Windows 11 Pro 23H2 (10.0.22631.3880)";
+// Windows 11 kept "Windows 10" in the registry's product name, its builds start at 22000
+func formatWindowsVersion(product, release, version string, build uint32) string {
+	if product == "" {
+		product = "Windows"
+	}
+	if build >= 22000 {
+		product = strings.Replace(product, "Windows 10", "Windows 11", 1)
+	}
+	return fmt.Sprintf("%s (%s)", strings.TrimSpace(product+" "+release), version)
+}
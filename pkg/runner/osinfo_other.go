@@ -0,0 +1,8 @@
+//go:build !windows
+
+package runner
+
+// Only Windows builds can read the version from the registry
+func windowsVersion() string {
+	return "Windows"
+}
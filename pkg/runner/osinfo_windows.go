@@ -0,0 +1,30 @@
+package runner
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// Reads the version number with RtlGetVersion, which unlike GetVersionEx isn't shimmed to older versions
+// for unmanifested programs, and the edition and release from the registry
+func windowsVersion() string {
+	info := windows.RtlGetVersion()
+	version := fmt.Sprintf("%d.%d.%d", info.MajorVersion, info.MinorVersion, info.BuildNumber)
+
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows NT\CurrentVersion`, registry.QUERY_VALUE)
+	if err != nil {
+		return formatWindowsVersion("", "", version, info.BuildNumber)
+	}
+	defer key.Close()
+	if revision, _, err := key.GetIntegerValue("UBR"); err == nil {
+		version += fmt.Sprintf(".%d", revision)
+	}
+	product, _, _ := key.GetStringValue("ProductName")
+	release, _, _ := key.GetStringValue("DisplayVersion") // e.g. 23H2; ReleaseId before 20H2
+	if release == "" {
+		release, _, _ = key.GetStringValue("ReleaseId")
+	}
+	return formatWindowsVersion(product, release, version, info.BuildNumber)
+}
@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Exit codes, so automation can tell a clean run from a partial or failed one
+const (
+	ExitOK                  = 0 // Every location was tested
+	ExitError               = 1 // Invalid usage or an unexpected error
+	ExitLocationsFailed     = 2 // Some locations were skipped or failed
+	ExitBaselineFailed      = 3 // The speed test without VPN failed
+	ExitProviderUnavailable = 4 // The VPN client isn't installed or not responding
+	ExitAssertionsFailed    = 5 // Every location was tested but some failed their assertions
+)
+
+// Errors a run stops with before its locations, told apart by their exit codes
+var (
+	ErrBaselineFailed      = errors.New("baseline speed test failed")
+	ErrProviderUnavailable = errors.New("ExpressVPN client is unavailable; is expressvpnctl installed and the daemon running?")
+)
+
+// Records a location that produced no result, for the run summary and exit code
+func (r *Runner) recordFailure(location results.Location, reason string) {
+	r.failedLocations = append(r.failedLocations, location.Key()+": "+reason)
+	r.tuiSetStatus(location, reason)
+}
+
+// Describes a location none of whose samples succeeded, with the last error
+func allSamplesFailed(stat results.VPNStat) string {
+	if len(stat.SampleErrors) == 0 {
+		return "speed test failed"
+	}
+	return fmt.Sprintf("all %d speed tests failed: %s", stat.SamplesAttempted, stat.SampleErrors[len(stat.SampleErrors)-1])
+}
+
+// Summarizes the locations where some samples failed, with their error rates and errors, or
+// returns an empty string
+func sampleFailureSummary(stats []results.VPNStat) string {
+	var b strings.Builder
+	for _, stat := range stats {
+		failed := stat.SamplesAttempted - stat.SamplesSucceeded
+		if failed <= 0 {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString("Failed samples:\n")
+		}
+		fmt.Fprintf(&b, "  %s: %d of %d failed (%.0f%%)\n", stat.LocationName, failed, stat.SamplesAttempted, 100*float64(failed)/float64(stat.SamplesAttempted))
+		for _, err := range stat.SampleErrors {
+			fmt.Fprintf(&b, "    - %s\n", err)
+		}
+	}
+	return b.String()
+}
+
+// Prints the locations of this run where some samples failed
+func (r *Runner) printSampleFailures() {
+	data, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return
+	}
+	var stats []results.VPNStat
+	for _, stat := range data.VPNStats {
+		if stat.RunID == r.runID {
+			stats = append(stats, stat)
+		}
+	}
+	if summary := sampleFailureSummary(stats); summary != "" {
+		r.printText("\n" + summary)
+	}
+}
+
+// Reports whether FailFast should stop the run
+func (r *Runner) shouldStop() bool {
+	return r.FailFast && len(r.failedLocations) > 0
+}
+
+// Returns the exit code of a finished run; the most severe outcome wins
+func (r *Runner) ExitCode() int {
+	switch {
+	case r.baselineFailed:
+		return ExitBaselineFailed
+	case len(r.failedLocations) > 0, len(r.skippedLocations) > 0, r.abortRequested.Load(), r.budgetStop != "":
+		return ExitLocationsFailed
+	case r.assertionFailures > 0:
+		return ExitAssertionsFailed
+	default:
+		return ExitOK
+	}
+}
+
+// Returns the exit code of an error a run stopped with
+func ExitCodeOf(err error) int {
+	switch {
+	case errors.Is(err, ErrBaselineFailed):
+		return ExitBaselineFailed
+	case errors.Is(err, ErrProviderUnavailable):
+		return ExitProviderUnavailable
+	default:
+		return ExitError
+	}
+}
+
+// Checks that the VPN client answers before anything is measured
+func (r *Runner) checkProvider() error {
+	if _, err := r.expressVPN().Regions(); err != nil {
+		return fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+	return nil
+}
+
+// Undoes what the run changed, reports it as aborted and returns err; for stopping right away, without
+// waiting for the current step
+func (r *Runner) Abort(err error) error {
+	r.stopTUI()
+	r.restoreNetworkLock()
+	r.stopPingMonitor()
+	r.notifyRun("aborted", err.Error(), "")
+	return err
+}
@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+)
+
+// SampleRecord is a single completed speed test, streamed as one line in ndjson mode
+type SampleRecord struct {
+	Baseline      bool    `json:"baseline"`
+	LocationName  string  `json:"locationName"`
+	Server        string  `json:"server"`
+	TimeToConnect string  `json:"timeToConnect,omitempty"`
+	DownloadMbps  float64 `json:"downloadMbps"`
+	UploadMbps    float64 `json:"uploadMbps"`
+	LatencyMs     float64 `json:"latencyMs"`
+	JitterMs      float64 `json:"jitterMs"`
+	PacketLoss    float64 `json:"packetLoss"`
+	Timestamp     string  `json:"timestamp"`
+	Mode          string  `json:"mode"`
+	ResultURL     string  `json:"resultUrl,omitempty"`
+}
+
+// Builds the streamed record for a single speed test result
+func newSampleRecord(result speedtest.Result, connectionTime string, mode string) SampleRecord {
+	return SampleRecord{
+		Baseline:      connectionTime == "",
+		LocationName:  result.Server.Country + ", " + result.Server.Location,
+		Server:        result.Server.Host,
+		TimeToConnect: connectionTime,
+		DownloadMbps:  bytesToMbps(result.Download.Bandwidth),
+		UploadMbps:    bytesToMbps(result.Upload.Bandwidth),
+		LatencyMs:     result.Ping.Latency,
+		JitterMs:      result.Ping.Jitter,
+		PacketLoss:    result.PacketLoss,
+		Timestamp:     results.FormatTime(time.Now()),
+		Mode:          mode,
+		ResultURL:     result.Result.URL,
+	}
+}
+
+// Writes a sample as a single JSON line in ndjson mode, or adds it to the live table in TUI mode
+func (r *Runner) writeSample(record SampleRecord) {
+	if r.Redact {
+		redactSample(&record)
+	}
+	r.pushSample(record)
+	r.tuiAddSample(record)
+	if r.Output != "ndjson" {
+		return
+	}
+
+	r.sampleMutex.Lock()
+	defer r.sampleMutex.Unlock()
+
+	if err := json.NewEncoder(r.SampleWriter).Encode(record); err != nil {
+		slog.Error("Error writing sample", "err", err)
+	}
+}
@@ -0,0 +1,29 @@
+package runner
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+const pauseJitter = 0.25 // Pauses vary randomly by up to ±25% so runs don't fire on a fixed beat
+
+var sleep = time.Sleep // Replaced in tests
+
+// Returns the duration varied randomly by up to ±pauseJitter
+func jittered(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d + time.Duration((rand.Float64()*2-1)*pauseJitter*float64(d))
+}
+
+// Sleeps for a jittered duration to avoid being rate-limited by speedtest servers
+func pause(d time.Duration, reason string) {
+	d = jittered(d)
+	if d <= 0 {
+		return
+	}
+	slog.Debug("Pausing", "reason", reason, "duration", d)
+	sleep(d)
+}
@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/vpn"
+)
+
+// Connects to the region through the provider; with ConnectPhases and a provider that can tell, also
+// records where the connect spent its time, up to the first connection through the tunnel
+func (r *Runner) connectRegion(ctx context.Context, region string) (time.Duration, error) {
+	r.lastPhases = nil
+	phased, ok := r.provider.(vpn.PhasedConnector)
+	if !r.ConnectPhases || !ok {
+		return r.provider.Connect(ctx, region, r.ConnectTimeout)
+	}
+
+	start := time.Now()
+	phases, err := phased.ConnectPhases(ctx, region, r.ConnectTimeout)
+	if err != nil {
+		return 0, err
+	}
+	breakdown := &results.ConnectPhases{Command: phases.Command.String(), Connected: phases.Connected.String()}
+	for _, change := range phases.States {
+		breakdown.States = append(breakdown.States, results.ConnectState{State: change.State, At: change.At.String()})
+	}
+	if elapsed, ok := r.firstProbe(ctx, start, start.Add(r.ConnectTimeout)); ok {
+		breakdown.FirstProbe = elapsed.String()
+	} else {
+		slog.Warn("No connection through the tunnel succeeded after connecting", "target", r.FirstProbeTarget)
+	}
+	r.lastPhases = breakdown
+	return phases.Connected, nil
+}
+
+// Connects to the first probe target over TCP until it succeeds, the deadline passes or the context is done; returns the
+// time since start it first succeeded at, which includes the routes and DNS settling after the client
+// reported Connected
+func (r *Runner) firstProbe(ctx context.Context, start time.Time, deadline time.Time) (time.Duration, bool) {
+	for {
+		dialer := net.Dialer{Timeout: min(max(time.Until(deadline), 0), 2*time.Second) + time.Millisecond}
+		conn, err := dialer.DialContext(ctx, "tcp", r.FirstProbeTarget)
+		if err == nil {
+			conn.Close()
+			return time.Since(start).Round(time.Millisecond), true
+		}
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			return 0, false
+		}
+		sleep(100 * time.Millisecond)
+	}
+}
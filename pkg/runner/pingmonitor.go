@@ -0,0 +1,229 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+const timelineTimeLayout = "2006-01-02T15:04:05.000Z07:00" // RFC 3339 with milliseconds, written in UTC
+
+// Events marked on the timeline
+const (
+	timelineConnecting    = "connecting"
+	timelineConnected     = "connected"
+	timelineConnectFailed = "connect failed"
+	timelineDisconnected  = "disconnected"
+)
+
+var pingTimePattern = regexp.MustCompile(`time[=<]([0-9.]+) ?ms`)
+
+// Timeline is the record of the ping monitor: every ping of the run and the connection events between them
+type Timeline struct {
+	RunID    string          `json:"RunID"`
+	Anchor   string          `json:"Anchor"`
+	Interval string          `json:"Interval"`
+	Samples  []PingSample    `json:"Samples"`
+	Events   []TimelineEvent `json:"Events"`
+}
+
+// PingSample is one ping of the monitor
+type PingSample struct {
+	Time      string  `json:"Time"`
+	LatencyMs float64 `json:"LatencyMs,omitempty"`
+	Lost      bool    `json:"Lost,omitempty"`
+	Location  string  `json:"Location,omitempty"` // Location connected or connecting to; empty without VPN
+}
+
+// TimelineEvent marks a change of the connection on the timeline
+type TimelineEvent struct {
+	Time     string `json:"Time"`
+	Event    string `json:"Event"` // connecting, connected, connect failed or disconnected
+	Location string `json:"Location"`
+}
+
+// PingMonitor pings the anchor host in the background for the whole run
+type PingMonitor struct {
+	r        *Runner
+	file     string     // Where the timeline is written
+	mutex    sync.Mutex // Guards timeline and location
+	timeline Timeline
+	location string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Sends one ping to the anchor: an ICMP echo through the system's ping, or a TCP handshake when the
+// anchor is host:port, for networks that drop ICMP; a variable so tests can replace it
+var pingOnce = func(commands command.Overrides, anchor string, timeout time.Duration) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(anchor); err == nil {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", anchor, timeout)
+		if err != nil {
+			return 0, err
+		}
+		conn.Close()
+		return time.Since(start), nil
+	}
+
+	var out []byte
+	var err error
+	switch runtime.GOOS {
+	case "windows":
+		out, err = commands.Run("ping", "-n", "1", "-w", "2000", anchor)
+	case "darwin":
+		out, err = commands.Run("ping", "-c", "1", "-t", "2", anchor)
+	default:
+		out, err = commands.Run("ping", "-c", "1", "-w", "2", anchor)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return parsePingTime(string(out))
+}
+
+// Reads the round trip from ping's output, e.g. "time=12.3 ms", or "time<1ms" on Windows
+func parsePingTime(output string) (time.Duration, error) {
+	match := pingTimePattern.FindStringSubmatch(output)
+	if match == nil {
+		return 0, fmt.Errorf("no reply in ping output")
+	}
+	millis, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(millis * float64(time.Millisecond)), nil
+}
+
+// Starts pinging PingAnchor; the timeline goes next to the results file unless PingTimelineFile names another
+func (r *Runner) startPingMonitor() {
+	if r.PingAnchor == "" {
+		return
+	}
+	file := r.PingTimelineFile
+	if file == "" {
+		file = strings.TrimSuffix(r.ResultsFile, ".json") + "-timeline.json"
+	}
+	r.pingMonitor = &PingMonitor{
+		r:        r,
+		file:     file,
+		timeline: Timeline{RunID: r.runID, Anchor: r.PingAnchor, Interval: r.PingInterval.String(), Samples: []PingSample{}, Events: []TimelineEvent{}},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.pingMonitor.run()
+}
+
+func (m *PingMonitor) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.r.PingInterval)
+	defer ticker.Stop()
+	for {
+		m.ping()
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *PingMonitor) ping() {
+	at := time.Now()
+	rtt, err := pingOnce(m.r.Commands, m.timeline.Anchor, m.r.SpeedTest.LatencyTimeout)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	sample := PingSample{Time: at.UTC().Format(timelineTimeLayout), Location: m.location}
+	if err != nil {
+		sample.Lost = true
+	} else {
+		sample.LatencyMs = math.Round(float64(rtt)/float64(time.Millisecond)*100) / 100
+	}
+	m.timeline.Samples = append(m.timeline.Samples, sample)
+}
+
+// Marks a connection event on the timeline; the pings that follow a connect belong to its location
+func (r *Runner) markTimeline(event string, location string) {
+	if r.pingMonitor == nil {
+		return
+	}
+	m := r.pingMonitor
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.timeline.Events = append(m.timeline.Events, TimelineEvent{Time: time.Now().UTC().Format(timelineTimeLayout), Event: event, Location: location})
+	m.location = location
+	if event == timelineDisconnected || event == timelineConnectFailed {
+		m.location = ""
+	}
+}
+
+// Stops the ping monitor and writes its timeline
+func (r *Runner) stopPingMonitor() {
+	if r.pingMonitor == nil {
+		return
+	}
+	m := r.pingMonitor
+	r.pingMonitor = nil
+	close(m.stop)
+	<-m.done
+
+	data, err := encodeTimeline(m.timeline, strings.HasSuffix(strings.ToLower(m.file), ".csv"))
+	if err == nil {
+		err = results.WriteFileAtomic(m.file, data, 0644)
+	}
+	if err != nil {
+		slog.Error("Error writing ping timeline", "path", m.file, "err", err)
+		return
+	}
+	lost := 0
+	for _, sample := range m.timeline.Samples {
+		if sample.Lost {
+			lost++
+		}
+	}
+	r.printTextf("Ping monitor: %d of %d pings to %s lost, timeline written to %s\n", lost, len(m.timeline.Samples), m.timeline.Anchor, m.file)
+}
+
+// Encodes the timeline as indented JSON, or as CSV with the pings and events in time order
+func encodeTimeline(timeline Timeline, asCSV bool) ([]byte, error) {
+	if !asCSV {
+		return json.MarshalIndent(timeline, "", "  ")
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"time", "type", "latency_ms", "lost", "event", "location"})
+	events := timeline.Events
+	for _, sample := range timeline.Samples {
+		// The time layout sorts as text
+		for len(events) > 0 && events[0].Time <= sample.Time {
+			writer.Write([]string{events[0].Time, "event", "", "", events[0].Event, events[0].Location})
+			events = events[1:]
+		}
+		latency := ""
+		if !sample.Lost {
+			latency = strconv.FormatFloat(sample.LatencyMs, 'f', 2, 64)
+		}
+		writer.Write([]string{sample.Time, "ping", latency, strconv.FormatBool(sample.Lost), "", sample.Location})
+	}
+	for _, event := range events {
+		writer.Write([]string{event.Time, "event", "", "", event.Event, event.Location})
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+)
+
+// PrintPlain prints text as lines prefixed with the time, dropping the blank lines that space out
+// terminal output
+func PrintPlain(text string) {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			fmt.Println(time.Now().Format(RunTimeLayout), line)
+		}
+	}
+}
+
+// spinner is what the tests show while they run: a pterm spinner on a terminal, plain lines elsewhere
+type spinner interface {
+	Success(message ...any)
+	Warning(message ...any)
+	Fail(message ...any)
+}
+
+// plainSpinner prints the start and outcome of a step as plain lines
+type plainSpinner struct {
+	print func(a ...any)
+}
+
+func (s plainSpinner) Success(message ...any) { s.print(message...) }
+func (s plainSpinner) Warning(message ...any) { s.print(message...) }
+func (s plainSpinner) Fail(message ...any)    { s.print(message...) }
+
+// Starts a spinner showing text
+func (r *Runner) startSpinner(text string) spinner {
+	if r.Plain {
+		r.printText(text)
+		return plainSpinner{print: r.printText}
+	}
+	s, _ := pterm.DefaultSpinner.Start(text)
+	return s
+}
@@ -0,0 +1,158 @@
+package runner
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Plan is the fully resolved run written by -plan-out and executed as-is by -plan-in
+type Plan struct {
+	Engine            string                  `json:"Engine"`
+	CrossCheck        string                  `json:"CrossCheck,omitempty"` // Second engine run once per location
+	Baseline          PlannedTests            `json:"Baseline"`
+	Hybrid            bool                    `json:"Hybrid,omitempty"` // One test on its own before the parallel tests of each location
+	Warmup            int                     `json:"Warmup"`           // Throwaway tests after each connect
+	Soak              string                  `json:"Soak,omitempty"`
+	ConnectCycles     int                     `json:"ConnectCycles"`
+	Providers         []results.ProviderInput `json:"Providers,omitempty"` // Provider sections of the input file, without their locations
+	Locations         []PlannedLocation       `json:"Locations"`
+	Unresolved        []results.Location      `json:"Unresolved,omitempty"`
+	EstimatedDuration string                  `json:"EstimatedDuration"`
+	EstimatedDataMB   float64                 `json:"EstimatedDataMB"`
+}
+
+// PlannedTests is the number of speed tests of one step and whether they run in parallel
+type PlannedTests struct {
+	Samples  int  `json:"Samples"`
+	Parallel bool `json:"Parallel"`
+}
+
+// PlannedLocation is a location with its resolved region and test settings
+type PlannedLocation struct {
+	Provider string `json:"Provider,omitempty"`
+	Country  string `json:"Country"`
+	City     string `json:"City"`
+	Region   string `json:"Region,omitempty"` // Empty in router mode, where the region is switched by hand
+	Samples  int    `json:"Samples"`
+	Parallel bool   `json:"Parallel"`
+	results.Assertions
+}
+
+// Rough per-step costs used for the estimates; actual values depend on the link speed
+const estimatedConnectTime = 10 * time.Second
+const estimatedTestTime = 30 * time.Second
+const estimatedMBPerTest = 250.0
+
+// Resolves every location to a region and its test settings
+func (r *Runner) BuildPlan(locations []results.Location) Plan {
+	plan := Plan{
+		Engine:        r.Engine,
+		CrossCheck:    r.CrossCheck,
+		Baseline:      PlannedTests{Samples: r.Samples, Parallel: r.Parallel},
+		Hybrid:        r.Mode == "hybrid",
+		Warmup:        r.Warmup,
+		ConnectCycles: r.ConnectCycles,
+		Providers:     r.providerSections,
+	}
+	if r.Soak > 0 {
+		plan.Soak = r.Soak.String()
+	}
+
+	for _, location := range locations {
+		planned := PlannedLocation{Provider: location.Provider, Country: location.Country, City: location.City, Assertions: location.Assertions}
+		planned.Samples, planned.Parallel = locationSettings(location, r.Samples, r.Parallel)
+
+		if !r.Router {
+			region, suggestions := r.findRegion(r.providerFor(location), location)
+			if region == "" {
+				slog.Warn("No matching region found", "country", location.Country, "city", location.City, "closest", suggestions)
+				plan.Unresolved = append(plan.Unresolved, location)
+				continue
+			}
+			planned.Region = region
+		}
+		plan.Locations = append(plan.Locations, planned)
+	}
+
+	plan.EstimatedDuration, plan.EstimatedDataMB = r.estimatePlan(plan)
+	return plan
+}
+
+// Estimates how long the plan takes and how much data it transfers
+func (r *Runner) estimatePlan(plan Plan) (string, float64) {
+	steps := []PlannedTests{plan.Baseline}
+	for _, location := range plan.Locations {
+		steps = append(steps, PlannedTests{Samples: location.Samples, Parallel: location.Parallel})
+	}
+
+	connects := len(plan.Locations)
+	cycles := time.Duration(max(plan.ConnectCycles, 1))
+	duration := time.Duration(connects) * (cycles*estimatedConnectTime + r.PauseBetweenLocations + time.Duration(plan.Warmup)*(estimatedTestTime+r.PauseBetweenTests))
+	data := float64(connects*plan.Warmup) * estimatedMBPerTest
+	if soak, err := time.ParseDuration(plan.Soak); err == nil && soak > 0 {
+		duration += time.Duration(connects) * soak
+		data += float64(connects) * math.Ceil(float64(soak)/float64(r.SoakInterval)) * estimatedMBPerTest
+	}
+	if plan.CrossCheck != "" {
+		duration += time.Duration(connects) * estimatedTestTime
+		data += float64(connects) * estimatedMBPerTest
+	}
+	for _, step := range steps {
+		if step.Parallel {
+			duration += estimatedTestTime
+		} else {
+			duration += time.Duration(step.Samples)*estimatedTestTime + time.Duration(step.Samples-1)*r.PauseBetweenTests
+		}
+		data += float64(step.Samples) * estimatedMBPerTest
+	}
+	if plan.Hybrid {
+		// The test each parallel location runs on its own first, followed by a pause
+		for _, location := range plan.Locations {
+			if location.Parallel {
+				duration += estimatedTestTime + r.PauseBetweenTests
+				data += estimatedMBPerTest
+			}
+		}
+	}
+	return duration.String(), data
+}
+
+// Writes the plan for review
+func SavePlan(fileName string, plan Plan) error {
+	jsonData, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return results.WriteFileAtomic(fileName, jsonData, 0644)
+}
+
+// Loads a plan written by -plan-out
+func LoadPlan(fileName string) (Plan, error) {
+	var plan Plan
+	file, err := os.ReadFile(fileName)
+	if err != nil {
+		return plan, err
+	}
+	err = json.Unmarshal(file, &plan)
+	return plan, err
+}
+
+// Turns a plan back into the input of a run: locations whose overrides and aliases reproduce it
+// exactly, and the provider sections
+func (p Plan) Input() results.InputData {
+	input := results.InputData{Aliases: map[string]string{}, Providers: p.Providers}
+	for _, planned := range p.Locations {
+		parallel := planned.Parallel
+		location := results.Location{Country: planned.Country, City: planned.City, Samples: planned.Samples, Parallel: &parallel, Provider: planned.Provider, Assertions: planned.Assertions}
+		input.Locations = append(input.Locations, location)
+		if planned.Region != "" {
+			input.Aliases[location.Country+", "+location.City] = planned.Region
+		}
+	}
+	return input
+}
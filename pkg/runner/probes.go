@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+const probeTimeout = 2 * time.Minute // A probe still running after this is killed and recorded as failed
+
+// Probe is a custom measurement plugin: a shell command run through the VPN that prints one JSON value
+type Probe struct {
+	Name    string
+	Command string
+}
+
+// Runs the probe with the location in SPEEDTEST_ variables and returns the JSON it printed on stdout
+func (p Probe) run(ctx context.Context, r *Runner, location results.Location, region string) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, r.Commands, p.Command, append(r.locationEnv(location, region), "SPEEDTEST_PROBE="+p.Name))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, &command.Error{Command: p.Command, Err: err, Output: strings.TrimSpace(stderr.String())}
+	}
+
+	output := bytes.TrimSpace(stdout.Bytes())
+	if !json.Valid(output) {
+		return nil, fmt.Errorf("probe printed invalid JSON: %q", output)
+	}
+	return output, nil
+}
+
+// Runs every probe for the location, keeping the output of each by name; a failed probe is recorded as
+// {"error": "..."} so it can't be mistaken for one that wasn't configured
+func (r *Runner) runProbes(ctx context.Context, location results.Location, region string) map[string]json.RawMessage {
+	if len(r.Probes) == 0 {
+		return nil
+	}
+	measured := map[string]json.RawMessage{}
+	for _, p := range r.Probes {
+		output, err := p.run(ctx, r, location, region)
+		if err != nil {
+			slog.Warn("Probe failed", "probe", p.Name, "err", err)
+			output, _ = json.Marshal(map[string]string{"error": err.Error()})
+		} else {
+			r.printText("Probe "+p.Name+": ", string(output))
+		}
+		measured[p.Name] = output
+	}
+	return measured
+}
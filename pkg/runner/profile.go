@@ -0,0 +1,42 @@
+package runner
+
+import (
+	"fmt"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Stops before the run when BaselineProfile names a profile neither the results file nor this run measures
+func (r *Runner) checkBaselineProfile() error {
+	if r.BaselineProfile == "" || r.BaselineProfile == r.NetworkProfile {
+		return nil
+	}
+	data, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load results file %s: %w", r.ResultsFile, err)
+	}
+	if _, ok := data.ProfileBaseline(r.BaselineProfile); !ok {
+		return fmt.Errorf("the results file %s has no baseline of the network profile %s; measure one with -network-profile and -append first", r.ResultsFile, r.BaselineProfile)
+	}
+	return nil
+}
+
+// Compares a location with the baseline of the run that measured it, or with the latest one of the
+// profile in the results file
+func PercentOfBaseline(data results.Results, stat results.VPNStat, profile string) *results.PercentOfBaseline {
+	var baseline results.Run
+	if run, ok := data.ProfileBaseline(profile); profile != "" && ok {
+		baseline = run
+	} else {
+		for _, run := range data.Runs {
+			if run.ID == stat.RunID {
+				baseline = run
+			}
+		}
+	}
+	percent := stat.PercentOf(baseline.WithoutVPN)
+	if percent != nil && baseline.Network != nil {
+		percent.Profile = baseline.Network.Profile
+	}
+	return percent
+}
@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Progress is the snapshot written to the progress file for external pollers
+type Progress struct {
+	Phase           string           `json:"phase"`
+	CurrentLocation string           `json:"currentLocation"`
+	Index           int              `json:"index"`
+	Total           int              `json:"total"`
+	StartedAt       string           `json:"startedAt"`
+	UpdatedAt       string           `json:"updatedAt"`
+	ETA             string           `json:"eta,omitempty"`
+	WithoutVPN      string           `json:"withoutVPN,omitempty"`
+	LastResult      *results.VPNStat `json:"lastResult,omitempty"`
+}
+
+// Starts progress tracking for a run over the given number of locations
+func (r *Runner) startProgress(total int) {
+	r.progressMutex.Lock()
+	defer r.progressMutex.Unlock()
+
+	r.progressStart = time.Now()
+	r.progress = Progress{
+		Phase:     "baseline",
+		Total:     total,
+		StartedAt: results.FormatTime(r.progressStart),
+	}
+	r.saveProgress()
+}
+
+// Records that the run moved on to a new phase and location; index is 1-based
+func (r *Runner) updateProgress(phase string, location string, index int) {
+	r.progressMutex.Lock()
+	defer r.progressMutex.Unlock()
+
+	r.progress.Phase = phase
+	r.progress.CurrentLocation = location
+	r.progress.Index = index
+	r.progress.ETA = estimateRemaining(time.Since(r.progressStart), index-1, r.progress.Total)
+	r.saveProgress()
+	r.tuiUpdatePhase(phase, index)
+}
+
+// Marks the run as complete
+func (r *Runner) finishProgress() {
+	r.progressMutex.Lock()
+	defer r.progressMutex.Unlock()
+
+	r.progress.Phase = "done"
+	r.progress.CurrentLocation = ""
+	r.progress.Index = r.progress.Total
+	r.progress.ETA = ""
+	r.saveProgress()
+}
+
+// Records the baseline speed without VPN
+func (r *Runner) recordProgressBaseline(speed string) {
+	r.progressMutex.Lock()
+	defer r.progressMutex.Unlock()
+
+	r.progress.WithoutVPN = speed
+	r.saveProgress()
+	r.tuiSetBaseline(speed)
+}
+
+// Records the most recently saved result
+func (r *Runner) recordProgressResult(stat results.VPNStat) {
+	r.progressMutex.Lock()
+	defer r.progressMutex.Unlock()
+
+	r.progress.LastResult = &stat
+	r.saveProgress()
+	r.tuiSetResult(stat)
+}
+
+// Estimates the remaining run time from the average time spent per completed location
+func estimateRemaining(elapsed time.Duration, completed int, total int) string {
+	if completed <= 0 || total <= completed {
+		return ""
+	}
+	perLocation := elapsed / time.Duration(completed)
+	return (perLocation * time.Duration(total-completed)).Round(time.Second).String()
+}
+
+// Writes the progress snapshot atomically so pollers never see a partial file
+func (r *Runner) saveProgress() {
+	if r.ProgressFile == "" {
+		return
+	}
+
+	r.progress.UpdatedAt = results.FormatTime(time.Now())
+	jsonData, err := json.MarshalIndent(r.progress, "", "  ")
+	if err != nil {
+		slog.Error("Error encoding progress", "err", err)
+		return
+	}
+
+	if err := results.WriteFileAtomic(r.ProgressFile, jsonData, 0644); err != nil {
+		slog.Error("Error writing progress file", "err", err)
+	}
+}
@@ -0,0 +1,197 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/pterm/pterm"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/vpn"
+)
+
+const defaultProviderName = "ExpressVPN" // Shown for the top-level locations of the input file
+
+// Returns the ExpressVPN client, run with the command overrides
+func (r *Runner) expressVPN() vpn.ExpressVPN {
+	return vpn.ExpressVPN{Commands: r.Commands}
+}
+
+// Creates the provider of an input file section
+func (r *Runner) newProvider(input results.ProviderInput) (vpn.Provider, error) {
+	commands := r.Commands
+	switch input.Type {
+	case "", "expressvpn":
+		return r.expressVPN(), nil
+	case "command":
+		if input.Connect == "" || input.Disconnect == "" {
+			return nil, fmt.Errorf("provider %q needs connect and disconnect commands", input.Name)
+		}
+		return &vpn.CommandProvider{ConnectCommand: input.Connect, DisconnectCommand: input.Disconnect, StatusCommand: input.Status, Commands: commands}, nil
+	case "wireguard":
+		if input.Dir == "" {
+			return nil, fmt.Errorf("provider %q needs the dir of its .conf files", input.Name)
+		}
+		return &vpn.WireGuard{Dir: input.Dir, Commands: commands}, nil
+	case "openvpn":
+		if input.Dir == "" {
+			return nil, fmt.Errorf("provider %q needs the dir of its .ovpn files", input.Name)
+		}
+		return &vpn.OpenVPN{Dir: input.Dir, Commands: commands}, nil
+	default:
+		return nil, fmt.Errorf("provider %q has unknown type %q, expected expressvpn, command, wireguard or openvpn", input.Name, input.Type)
+	}
+}
+
+// Registers the provider sections of the input file and appends their locations to the top-level ones,
+// each provider's after the previous one's
+func (r *Runner) addProviders(input *results.InputData) error {
+	for _, section := range input.Providers {
+		if _, ok := r.providers[section.Name]; ok {
+			return fmt.Errorf("provider %q is listed twice", section.Name)
+		}
+		p, err := r.newProvider(section)
+		if err != nil {
+			return err
+		}
+		r.providers[section.Name] = p
+		if len(section.Locations) == 0 {
+			if section.Locations, err = everyRegion(p); err != nil {
+				return fmt.Errorf("provider %q lists no locations: %w", section.Name, err)
+			}
+		}
+		for _, location := range section.Locations {
+			location.Provider = section.Name
+			input.Locations = append(input.Locations, location)
+		}
+		section.Locations = nil
+		r.providerSections = append(r.providerSections, section)
+	}
+	if len(input.Locations) == 0 {
+		return fmt.Errorf("no locations to test")
+	}
+	return nil
+}
+
+// Returns a location for every region of a provider that has a fixed list, such as the files of a WireGuard
+// or OpenVPN directory
+func everyRegion(p vpn.Provider) ([]results.Location, error) {
+	switch p.(type) {
+	case *vpn.WireGuard, *vpn.OpenVPN:
+	default:
+		return nil, fmt.Errorf("only wireguard and openvpn providers test every region by default")
+	}
+	regions, err := p.Regions()
+	if err != nil {
+		return nil, err
+	}
+	var locations []results.Location
+	for _, region := range regions {
+		locations = append(locations, results.Location{Country: region})
+	}
+	return locations, nil
+}
+
+// Returns the provider a location is tested through
+func (r *Runner) providerFor(location results.Location) vpn.Provider {
+	if p, ok := r.providers[location.Provider]; ok {
+		return p
+	}
+	return r.expressVPN()
+}
+
+// Reports whether any location is tested through the ExpressVPN client
+func (r *Runner) usesExpressVPN(locations []results.Location) bool {
+	return slices.ContainsFunc(locations, func(location results.Location) bool {
+		_, ok := r.providerFor(location).(vpn.ExpressVPN)
+		return ok
+	})
+}
+
+// Builds a table of each location's average download and upload speeds through each provider, or nil
+// when the results have a single provider
+func (d Display) ProviderTable(data results.Results) pterm.TableData {
+	type cell struct {
+		download, upload results.RunningStats
+	}
+	var locations, names []string
+	cells := map[[2]string]*cell{}
+	totals := map[string]*cell{}
+	for _, stat := range data.VPNStats {
+		name := stat.Provider
+		if name == "" {
+			name = defaultProviderName
+		}
+		key := [2]string{stat.LocationName, name}
+		c, ok := cells[key]
+		if !ok {
+			c = &cell{}
+			cells[key] = c
+		}
+		if totals[name] == nil {
+			totals[name] = &cell{}
+			names = append(names, name)
+		}
+		for _, c := range []*cell{c, totals[name]} {
+			c.download.Add(results.ParseMbps(stat.VPNDownloadSpeed))
+			c.upload.Add(results.ParseMbps(stat.VPNUploadSpeed))
+		}
+		if !slices.Contains(locations, stat.LocationName) {
+			locations = append(locations, stat.LocationName)
+		}
+	}
+	if len(names) < 2 {
+		return nil
+	}
+
+	format := func(c *cell) string {
+		return d.FormatSpeed(c.download.Mean) + " ▼ " + d.FormatSpeed(c.upload.Mean) + " ▲"
+	}
+	table := pterm.TableData{append([]string{"Location"}, names...)}
+	for _, location := range locations {
+		row := []string{location}
+		for _, name := range names {
+			if c, ok := cells[[2]string{location, name}]; ok {
+				row = append(row, format(c))
+			} else {
+				row = append(row, "-")
+			}
+		}
+		table = append(table, row)
+	}
+	average := []string{"Average"}
+	for _, name := range names {
+		average = append(average, format(totals[name]))
+	}
+	return append(table, average)
+}
+
+// Prints the provider × location table of the run when it compared several providers
+func (r *Runner) printProviderComparison() {
+	if len(r.providers) == 0 {
+		return
+	}
+	data, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return
+	}
+	data.VPNStats = slices.DeleteFunc(data.VPNStats, func(stat results.VPNStat) bool {
+		return stat.RunID != r.runID
+	})
+	if table := r.ProviderTable(data); table != nil {
+		r.printText("\nBy provider:")
+		pterm.DefaultTable.WithHasHeader().WithData(table).Render()
+	}
+}
+
+// Formats a location for messages, with its provider when it isn't ExpressVPN's top-level list
+func describeLocation(location results.Location) string {
+	name := strings.TrimSuffix(location.Country+", "+location.City, ", ")
+	if location.Provider != "" {
+		return name + " via " + location.Provider
+	}
+	return name
+}
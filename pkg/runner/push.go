@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var pushClient = &http.Client{Timeout: 30 * time.Second}
+
+const pushAttempts = 3 // A collector that is briefly unreachable shouldn't lose a whole run
+
+// Payload types, sent in the X-Payload-Type header so a collector can tell them apart
+const (
+	PayloadResults = "results"
+	PayloadSample  = "sample"
+)
+
+// PushedSample is a single speed test as posted with -push-samples
+type PushedSample struct {
+	MachineName string            `json:"MachineName"`
+	RunID       string            `json:"RunID"`
+	RunUUID     string            `json:"RunUUID,omitempty"`
+	Tags        map[string]string `json:"Tags,omitempty"`
+	SampleRecord
+}
+
+// Posts a payload to the collector with the configured headers, retrying attempts that failed
+// on the network or the collector's side
+func (r *Runner) push(payloadType string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := r.postPayload(payloadType, body)
+		if err == nil || !retry || attempt == pushAttempts {
+			return err
+		}
+		slog.Warn("Push failed, retrying", "url", r.PushURL, "attempt", attempt, "err", err)
+		sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+}
+
+// Posts a payload once; retry tells whether a failure may be temporary
+func (r *Runner) postPayload(payloadType string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, r.PushURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Payload-Type", payloadType)
+	for _, header := range r.PushHeaders {
+		name, value, _ := strings.Cut(header, ":")
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Posts the results file of the run to the collector
+func (r *Runner) pushResults() {
+	if r.PushURL == "" {
+		return
+	}
+
+	r.fileMutex.Lock()
+	data, err := r.Files.Load(r.ResultsFile)
+	r.fileMutex.Unlock()
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return
+	}
+
+	if err := r.push(PayloadResults, data); err != nil {
+		slog.Error("Error pushing results", "url", r.PushURL, "err", err)
+		return
+	}
+	r.printText("Results pushed to", r.PushURL)
+}
+
+// Posts a completed speed test to the collector with PushSamples; writeSample has redacted it with Redact
+func (r *Runner) pushSample(record SampleRecord) {
+	if r.PushURL == "" || !r.PushSamples {
+		return
+	}
+
+	name, err := r.machineName()
+	if err != nil {
+		name = "unknown machine"
+	}
+	sample := PushedSample{MachineName: name, RunID: r.runID, RunUUID: r.runUUID, SampleRecord: record}
+	if !r.Redact {
+		// Tags are left out of the run info of redacted results too
+		sample.Tags = r.Tags
+	}
+	if err := r.push(PayloadSample, sample); err != nil {
+		slog.Error("Error pushing sample", "url", r.PushURL, "err", err)
+	}
+}
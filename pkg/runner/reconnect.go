@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+)
+
+var errConnectionDropped = errors.New("the VPN connection dropped during the speed test")
+
+// connectionWatch polls the connection state while a location's speed tests run, so a test the VPN
+// dropped during, which measured the connection without VPN, isn't attributed to the region
+type connectionWatch struct {
+	r       *Runner
+	mutex   sync.Mutex
+	drops   []results.StateChange // Every state other than Connected seen, e.g. Reconnecting
+	times   []time.Time           // When each drop was seen
+	invalid int                   // Speed tests discarded because of a drop
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Starts polling the connection state during the tests through the VPN; nil for those without VPN and
+// in Router mode, where the router's connection state isn't visible from the LAN
+func (r *Runner) startConnectionWatch(connectionTime string) *connectionWatch {
+	if connectionTime == "" || r.Router {
+		return nil
+	}
+	w := &connectionWatch{r: r, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(r.ReconnectPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.check()
+			}
+		}
+	}()
+	return w
+}
+
+// Polls the connection state once, recording it unless connected; an unknown state isn't a drop, as
+// some providers can't tell
+func (w *connectionWatch) check() bool {
+	state, err := w.r.provider.State()
+	if err != nil || state == "" || state == "Connected" || state == "Unknown" {
+		return true
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	now := time.Now()
+	w.drops = append(w.drops, results.StateChange{Time: results.FormatTime(now), State: state})
+	w.times = append(w.times, now)
+	return false
+}
+
+// Reports whether the connection dropped since the time, checking once more to catch a drop at the
+// very end of a test
+func (w *connectionWatch) droppedSince(start time.Time) bool {
+	w.check()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, seen := range w.times {
+		if !seen.Before(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// Waits for the client to reconnect on its own, up to ConnectTimeout
+func (w *connectionWatch) waitConnected() bool {
+	deadline := time.Now().Add(w.r.ConnectTimeout)
+	for !w.check() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		sleep(w.r.ReconnectPollInterval)
+	}
+	return true
+}
+
+// Stops polling and returns the drops seen and the number of speed tests discarded because of them
+func (w *connectionWatch) finish() ([]results.StateChange, int) {
+	if w == nil {
+		return nil, 0
+	}
+	close(w.stop)
+	<-w.done
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.drops) > 0 {
+		slog.Warn("The VPN connection dropped during the speed tests", "drops", len(w.drops), "discarded", w.invalid)
+	}
+	return w.drops, w.invalid
+}
+
+// Runs a speed test, discarding it and running it again once reconnected when the connection dropped
+// while it ran; fails when it keeps dropping or doesn't come back
+func (r *Runner) runWatchedSpeedTest(ctx context.Context, w *connectionWatch, sample int) (speedtest.Result, error) {
+	for attempt := 0; ; attempt++ {
+		started := time.Now()
+		result, err := r.runSpeedTest(ctx)
+		if w == nil || err != nil || !w.droppedSince(started) {
+			return result, err
+		}
+
+		w.mutex.Lock()
+		w.invalid++
+		w.mutex.Unlock()
+		slog.Warn("The VPN connection dropped during the speed test, discarding it", "sample", sample+1, "attempt", attempt+1)
+		if attempt == r.SampleRetries || !w.waitConnected() {
+			return speedtest.Result{}, errConnectionDropped
+		}
+	}
+}
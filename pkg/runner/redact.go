@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Flags whose values can point at the machine, its owner or their infrastructure, recorded without them with Redact
+var identifyingFlags = map[string]bool{
+	"results": true, "config": true, "resume": true, "progress": true, "public-report": true, "html-report": true,
+	"bundle": true, "output": true, "history": true, "plan-in": true, "plan-out": true, "encrypt": true,
+	"push-url": true, "iperf-server": true, "http-download-url": true, "http-upload-url": true,
+	"speedtest-bin": true, "vpnctl-bin": true, "ping-monitor": true, "ping-timeline": true,
+	"pre-hook": true, "post-hook": true, "probe": true, "probe-name": true, "tag": true, "web": true,
+	"traceroute-target": true, "latency-target": true, "ip-check-url": true,
+}
+
+// Loads the install's key for hashing names with Redact, creating it on first use. A random key, rather
+// than a plain hash, keeps a hostname from being recovered by hashing a list of likely names
+func (r *Runner) loadRedactKey() error {
+	fileName := r.RedactKeyFile
+	if fileName == "" {
+		return fmt.Errorf("no file to keep the key in")
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err == nil {
+		r.redactKey, err = hex.DecodeString(strings.TrimSpace(string(data)))
+		if err == nil && len(r.redactKey) < 16 {
+			err = fmt.Errorf("key too short")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	r.redactKey = make([]byte, 32)
+	if _, err := rand.Read(r.redactKey); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, []byte(hex.EncodeToString(r.redactKey)+"\n"), 0600)
+}
+
+// Hashes a hostname or probe name with the install's key, so runs of the same machine still group
+// together without naming it
+func (r *Runner) hashHostname(hostname string) string {
+	mac := hmac.New(sha256.New, r.redactKey)
+	mac.Write([]byte(hostname))
+	return "host-" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// Removes what identifies the connection from a location's results: the speed test server, the result
+// pages naming it and the exit IP
+func redactStat(stat *results.VPNStat) {
+	stat.Server = ""
+	stat.ResultURLs = nil
+	stat.ExitIP = ""
+}
+
+// Removes the speed test server and its result page from a streamed or pushed sample, like redactStat
+// does from the results file
+func redactSample(record *SampleRecord) {
+	record.Server = ""
+	record.ResultURL = ""
+}
@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+var speedPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)Mbps`)
+var latencyPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)ms`)
+
+// Rounds a value to the nearest multiple of bucket; a bucket of 0 or less rounds to a whole number
+func roundToBucket(value float64, bucket float64) float64 {
+	if bucket <= 0 {
+		return math.Round(value)
+	}
+	return math.Round(value/bucket) * bucket
+}
+
+// Rounds every Mbps figure in a string such as "849Mbps ▼  845Mbps ▲"
+func roundSpeeds(s string, bucket float64) string {
+	return speedPattern.ReplaceAllStringFunc(s, func(match string) string {
+		value, err := strconv.ParseFloat(speedPattern.FindStringSubmatch(match)[1], 64)
+		if err != nil {
+			return match
+		}
+		return fmt.Sprintf("%.0fMbps", roundToBucket(value, bucket))
+	})
+}
+
+// Rounds every latency figure in a string such as "35.74ms" to whole milliseconds
+func roundLatencies(s string) string {
+	return latencyPattern.ReplaceAllStringFunc(s, func(match string) string {
+		value, err := strconv.ParseFloat(latencyPattern.FindStringSubmatch(match)[1], 64)
+		if err != nil {
+			return match
+		}
+		return fmt.Sprintf("%.0fms", math.Round(value))
+	})
+}
+
+// Rounds a signed percentage such as "+12.3%" to a whole percent
+func roundDifference(s string) string {
+	if s == "" {
+		return s
+	}
+	value := math.Round(results.ParseUnit(s, "%"))
+	if value == 0 {
+		value = 0 // Not "-0%"
+	}
+	return fmt.Sprintf("%+.0f%%", value)
+}
+
+// Returns the samples bucketed like the speeds, in a new slice so the precise ones are left untouched
+func roundSamples(samples []float64, bucket float64) []float64 {
+	if samples == nil {
+		return nil
+	}
+	rounded := make([]float64, len(samples))
+	for i, sample := range samples {
+		rounded[i] = roundToBucket(sample, bucket)
+	}
+	return rounded
+}
+
+// Returns a copy of the results with speeds bucketed and latencies rounded for publishing
+func roundResults(data results.Results, bucket float64) results.Results {
+	rounded := data
+	rounded.WithoutVPN = roundSpeeds(data.WithoutVPN, bucket)
+	// The public IP identifies the tester, the ISP is enough context for publishing
+	rounded.Network = withoutPublicIP(data.Network)
+	rounded.Runs = make([]results.Run, len(data.Runs))
+	for i, run := range data.Runs {
+		run.WithoutVPN = roundSpeeds(run.WithoutVPN, bucket)
+		run.Network = withoutPublicIP(run.Network)
+		rounded.Runs[i] = run
+	}
+	rounded.VPNStats = make([]results.VPNStat, len(data.VPNStats))
+	for i, stat := range data.VPNStats {
+		stat.VPNDownloadSpeed = roundSpeeds(stat.VPNDownloadSpeed, bucket)
+		stat.VPNUploadSpeed = roundSpeeds(stat.VPNUploadSpeed, bucket)
+		stat.VPNLatency = roundLatencies(stat.VPNLatency)
+		stat.DownloadSamples = roundSamples(stat.DownloadSamples, bucket)
+		stat.UploadSamples = roundSamples(stat.UploadSamples, bucket)
+		if stat.PercentOfBaseline != nil {
+			// A tenth of a percent of the baseline is finer than the speed buckets
+			percent := *stat.PercentOfBaseline
+			percent.Download, percent.Upload = math.Round(percent.Download), math.Round(percent.Upload)
+			stat.PercentOfBaseline = &percent
+		}
+		if stat.SingleStream != nil {
+			stat.SingleStream = &results.SingleStream{
+				VPNDownloadSpeed: roundSpeeds(stat.SingleStream.VPNDownloadSpeed, bucket),
+				VPNUploadSpeed:   roundSpeeds(stat.SingleStream.VPNUploadSpeed, bucket),
+				VPNLatency:       roundLatencies(stat.SingleStream.VPNLatency),
+			}
+		}
+		if stat.CrossCheck != nil {
+			check := *stat.CrossCheck
+			check.VPNDownloadSpeed = roundSpeeds(check.VPNDownloadSpeed, bucket)
+			check.VPNUploadSpeed = roundSpeeds(check.VPNUploadSpeed, bucket)
+			check.DownloadDifference = roundDifference(check.DownloadDifference)
+			check.UploadDifference = roundDifference(check.UploadDifference)
+			stat.CrossCheck = &check
+		}
+		if stat.Soak != nil {
+			soak := *stat.Soak
+			soak.Samples = make([]results.SoakSample, len(stat.Soak.Samples))
+			for j, sample := range stat.Soak.Samples {
+				sample.VPNDownloadSpeed = roundSpeeds(sample.VPNDownloadSpeed, bucket)
+				sample.VPNUploadSpeed = roundSpeeds(sample.VPNUploadSpeed, bucket)
+				sample.VPNLatency = roundLatencies(sample.VPNLatency)
+				soak.Samples[j] = sample
+			}
+			stat.Soak = &soak
+		}
+		if stat.Contention != nil {
+			contention := *stat.Contention
+			contention.SumMbps = roundToBucket(contention.SumMbps, bucket)
+			contention.BaselineMbps = roundToBucket(contention.BaselineMbps, bucket)
+			stat.Contention = &contention
+		}
+		// The result pages show the precise speeds and the server
+		stat.ResultURLs = nil
+		rounded.VPNStats[i] = stat
+	}
+	return rounded
+}
+
+// Returns a copy of the network without its public IP
+func withoutPublicIP(network *results.Network) *results.Network {
+	if network == nil {
+		return nil
+	}
+	public := *network
+	public.PublicIP = ""
+	return &public
+}
+
+// Writes the rounded public report next to the precise results file
+func (r *Runner) writePublicReport() {
+	if r.PublicReportFile == "" {
+		return
+	}
+
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+
+	data, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return
+	}
+
+	if err := r.Files.Save(roundResults(data, r.PublicRoundMbps), r.PublicReportFile); err != nil {
+		slog.Error("Error saving public report", "err", err)
+	}
+}
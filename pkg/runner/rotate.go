@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Default results file names, with the run's start time and the suffix of a forced IP version
+var rotatedPattern = regexp.MustCompile(`^results-(\d{14})(-ipv[46])?\.json$`)
+
+// Removes the results files in the results file's directory that KeepLast or KeepDays no longer keep,
+// along with their timeline files; only default names are rotated, and never the file of this run
+func (r *Runner) rotateResults(now time.Time) {
+	if r.KeepLast <= 0 && r.KeepDays <= 0 {
+		return
+	}
+	dir := filepath.Dir(r.ResultsFile)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Warn("Could not rotate results files", "dir", dir, "err", err)
+		return
+	}
+
+	type rotated struct {
+		name    string
+		started time.Time
+	}
+	var files []rotated
+	for _, entry := range entries {
+		m := rotatedPattern.FindStringSubmatch(entry.Name())
+		if m == nil || entry.IsDir() {
+			continue
+		}
+		started, err := time.ParseInLocation("20060102150405", m[1], time.Local)
+		if err == nil {
+			files = append(files, rotated{name: entry.Name(), started: started})
+		}
+	}
+	// Newest first
+	slices.SortFunc(files, func(a, b rotated) int { return b.started.Compare(a.started) })
+
+	removed := 0
+	for i, file := range files {
+		tooMany := r.KeepLast > 0 && i >= r.KeepLast
+		tooOld := r.KeepDays > 0 && now.Sub(file.started) > time.Duration(r.KeepDays)*24*time.Hour
+		if (!tooMany && !tooOld) || filepath.Join(dir, file.name) == filepath.Clean(r.ResultsFile) {
+			continue
+		}
+		for _, name := range []string{file.name, strings.TrimSuffix(file.name, ".json") + "-timeline.json", strings.TrimSuffix(file.name, ".json") + "-timeline.csv"} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				slog.Warn("Could not remove old results file", "path", filepath.Join(dir, name), "err", err)
+			}
+		}
+		removed++
+	}
+	if removed > 0 {
+		slog.Info("Removed old results files", "dir", dir, "count", removed)
+	}
+}
@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+)
+
+// "1.1.1.1 dev tun0 src 10.8.0.2" from ip route get, "interface: utun4" from macOS route get
+var routeInterfacePattern = regexp.MustCompile(`(?:\bdev|interface:)\s+(\S+)`)
+
+// Returns the interface named in ip route get or route get output, or the first line of
+// Find-NetRoute output on Windows
+func parseEgressInterface(output string) string {
+	if m := routeInterfacePattern.FindStringSubmatch(output); m != nil {
+		return m[1]
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(line)
+}
+
+// Returns the interface the routing table sends traffic to the host through; host may have a port
+func egressInterface(commands command.Overrides, host string) (string, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", err
+	}
+	ip := ips[0].String()
+
+	var output []byte
+	switch runtime.GOOS {
+	case "linux":
+		output, err = commands.Run("ip", "route", "get", ip)
+	case "darwin":
+		output, err = commands.Run("route", "-n", "get", ip)
+	case "windows":
+		output, err = commands.Run("powershell", "-NoProfile", "-Command", "(Find-NetRoute -RemoteIPAddress "+ip+" | Select-Object -First 1).InterfaceAlias")
+	default:
+		return "", fmt.Errorf("route lookup isn't supported on %s", runtime.GOOS)
+	}
+	if err != nil {
+		return "", err
+	}
+	if name := parseEgressInterface(string(output)); name != "" {
+		return name, nil
+	}
+	return "", fmt.Errorf("no interface in route lookup output")
+}
+
+// Looks up the egress interface without VPN, the one VPN traffic must not leave through
+func (r *Runner) lookupHomeInterface() {
+	name, err := egressInterface(r.Commands, r.TracerouteTarget)
+	if err != nil {
+		slog.Warn("Could not look up the interface without VPN", "err", err)
+		return
+	}
+	r.homeInterface = name
+}
+
+// Looks up the interface traffic to the speed test server leaves through and whether that is
+// the VPN; the latter is nil when it can't be told
+func (r *Runner) checkRoute(server string) (string, *bool) {
+	name, err := egressInterface(r.Commands, server)
+	if err != nil {
+		slog.Warn("Route lookup failed", "server", server, "err", err)
+		return "", nil
+	}
+	if r.homeInterface == "" {
+		return name, nil
+	}
+
+	throughVPN := name != r.homeInterface
+	if !throughVPN {
+		slog.Warn("Traffic to the speed test server bypasses the VPN, check for split tunneling", "server", server, "interface", name)
+	}
+	return name, &throughVPN
+}
@@ -0,0 +1,65 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/vpn"
+)
+
+// The connection time recorded for locations switched by hand
+const manualConnectTime = "manual"
+
+// Returns the commands switching the router as a provider, nil when the router is switched by hand
+func (r *Runner) routerSwitch() *vpn.CommandProvider {
+	if r.RouterConnect == "" {
+		return nil
+	}
+	return &vpn.CommandProvider{ConnectCommand: r.RouterConnect, DisconnectCommand: r.RouterDisconnect, StatusCommand: r.RouterStatus, Commands: r.Commands}
+}
+
+// Switches the router to the location with RouterConnect and returns how long that took, or asks the
+// user to switch it and waits for confirmation
+func (r *Runner) waitForRouterSwitch(ctx context.Context, location results.Location) (string, error) {
+	if commands := r.routerSwitch(); commands != nil {
+		duration, err := commands.Connect(ctx, strings.TrimSuffix(location.Country+", "+location.City, ", "), r.ConnectTimeout)
+		if err != nil {
+			return "", err
+		}
+		return duration.String(), nil
+	}
+	fmt.Fprintf(r.RouterPrompt, "Switch your VPN router to %s, %s, wait until it is connected, then press Enter...", location.Country, location.City)
+	r.waitForEnter()
+	return manualConnectTime, nil
+}
+
+// Turns the router's VPN off for the baseline test with RouterDisconnect, or asks the user to
+func (r *Runner) waitForRouterBaseline() error {
+	if commands := r.routerSwitch(); commands != nil {
+		return commands.Disconnect()
+	}
+	fmt.Fprint(r.RouterPrompt, "Turn off the VPN on your router for the baseline test, then press Enter...")
+	r.waitForEnter()
+	return nil
+}
+
+// Reads a byte at a time so no input meant for later prompts gets buffered away
+func (r *Runner) waitForEnter() {
+	buf := make([]byte, 1)
+	for {
+		n, err := r.RouterInput.Read(buf)
+		if n == 1 && buf[0] == '\n' {
+			return
+		}
+		if err != nil {
+			if err != io.EOF {
+				slog.Error("Error reading confirmation", "err", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,689 @@
+package runner
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pterm/pterm"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+	"flavius.xyz/vpn_speed_test_cli/pkg/vpn"
+)
+
+// Runs the benchmark: the baseline without VPN, then every location, saving each result as it comes
+// and the reports at the end; returns an error when the run couldn't start or stopped at its baseline
+func (r *Runner) Run(ctx context.Context, locations []results.Location) error {
+	if r.Namespaces > 1 {
+		if err := r.checkNamespaces(locations); err != nil {
+			return err
+		}
+	}
+	if err := r.prepareResults(); err != nil {
+		return err
+	}
+	if r.Redact {
+		if err := r.loadRedactKey(); err != nil {
+			return fmt.Errorf("failed to load the -redact key: %w", err)
+		}
+	}
+	if err := r.checkConflicts(); err != nil {
+		return err
+	}
+	if !r.Router && r.usesExpressVPN(locations) {
+		if err := r.checkProvider(); err != nil {
+			return err
+		}
+		if err := r.applyNetworkLock(); err != nil {
+			return err
+		}
+	} else if r.NetworkLock != "" {
+		return errors.New("-network-lock needs the ExpressVPN client, it can't be combined with -router or only other providers")
+	}
+	r.runInfo = r.newRunInfo()
+
+	r.startPingMonitor()
+	r.startProgress(len(locations))
+	if r.TUI {
+		r.startTUI(locations)
+	}
+
+	if r.VerifyRoute || r.IfaceCounters {
+		r.lookupHomeInterface()
+	}
+
+	// The baseline of a resumed run is already in its results file, and Once skips it to answer quickly
+	if r.Resume == "" && !r.Once {
+		if r.Router {
+			if err := r.waitForRouterBaseline(); err != nil {
+				return r.Abort(fmt.Errorf("failed to turn off the router's VPN for the baseline: %w", err))
+			}
+		}
+		r.lookupHomeNetwork()
+		if r.LatencyOnly {
+			r.latencyTest("")
+		} else if !r.Parallel {
+			// Run speed test without VPN single threaded
+			r.runSamples(ctx, "", r.Samples, 1)
+		} else {
+			// Run speed test without VPN multi-threaded
+			r.runSamples(ctx, "", r.Samples, cmp.Or(r.concurrency, r.Samples))
+		}
+
+		if r.Baseline() == "" {
+			r.baselineFailed = true
+			if r.FailFast {
+				return r.Abort(ErrBaselineFailed)
+			}
+			slog.Error("Baseline speed test failed, continuing with the VPN locations")
+		}
+	} else if r.Geo {
+		r.locateHome()
+	}
+
+	if r.Namespaces > 1 {
+		r.testInNamespaces(ctx, locations)
+	} else {
+		r.testLocations(ctx, locations)
+	}
+	r.restoreNetworkLock()
+	r.stopPingMonitor()
+	r.finishProgress()
+	r.stopTUI()
+	r.finishRunInfo(time.Now())
+	r.pushResults()
+	r.writePublicReport()
+	r.writeHTMLReport()
+	r.printTopMovers()
+	r.printRegressions()
+	r.printCrossCheckSummary()
+	r.printSampleFailures()
+	r.printLatencyRanking()
+	r.printProviderComparison()
+	r.rotateResults(time.Now())
+	switch {
+	case r.abortRequested.Load():
+		// Keep the checkpoint so the untested locations can be resumed
+		r.printText("Run aborted, resume it with -resume", r.checkpointFile)
+		r.notifyRun("aborted", r.abortSource, "")
+	case r.budgetStop != "":
+		// Keep the checkpoint so the untested locations can be resumed, e.g. the next day
+		r.printText("Run stopped by its budget, resume it with -resume", r.checkpointFile)
+		r.notifyRun("stopped by its budget", r.budgetStop, "")
+	case r.shouldStop():
+		r.removeCheckpoint()
+		r.notifyRun("stopped after a failed location", "", "")
+	default:
+		r.removeCheckpoint()
+		r.notifyRun("completed", "", r.currentTopMovers()+r.currentRegressions())
+	}
+
+	if r.assertionFailures > 0 {
+		slog.Error("Locations failed their assertions", "count", r.assertionFailures)
+	}
+	return nil
+}
+
+// Tests the locations one at a time through the VPN connection of the host
+func (r *Runner) testLocations(ctx context.Context, locations []results.Location) {
+	// Iterate through locations and test VPN performance
+	cancelLocation := context.CancelFunc(func() {})
+	var hooks *locationHooks
+	for i, location := range locations {
+		cancelLocation()
+		hooks.finish() // Of the previous location, whichever way it ended
+		hooks = nil
+		if r.shouldStop() {
+			slog.Error("Stopping after the first failed location", "failed", r.failedLocations[0])
+			break
+		}
+		if r.abortRequested.Load() {
+			slog.Warn("Aborting: Requested " + r.abortSource)
+			break
+		}
+
+		if r.isCompleted(location) {
+			slog.Info("Skipping: Already completed before resuming", "country", location.Country, "city", location.City)
+			continue
+		}
+
+		nextTests := r.Samples + r.Warmup
+		if r.LatencyOnly {
+			nextTests = 0 // Connecting transfers next to nothing
+		}
+		if reason := r.budgetExceeded(nextTests); reason != "" {
+			r.budgetStop = fmt.Sprintf("%s, after %d of %d locations", reason, r.testedLocations, len(locations))
+			slog.Warn("Stopping: "+r.budgetStop, "dataUsedMB", r.dataUsedMB())
+			break
+		}
+
+		if !r.Router {
+			// Follows the baseline or the previous location, which hit the same speedtest servers
+			pause(r.PauseBetweenLocations, "before next location")
+		}
+
+		r.provider = r.providerFor(location)
+		r.updateProgress("connecting", location.Country+", "+location.City, i+1)
+		var locationCtx context.Context
+		locationCtx, cancelLocation = r.locationContext(ctx)
+
+		var connectTime, region string
+		var connectTimes *results.ConnectTimes
+		var connectBreakdown *results.ConnectPhases
+		if !r.Router {
+			var suggestions []string
+			region, suggestions = r.findRegion(r.provider, location)
+			if region == "" {
+				slog.Warn("Skipping: No matching region found", "country", location.Country, "city", location.City, "closest", strings.Join(suggestions, ", "))
+				r.recordFailure(location, "no matching region")
+				continue
+			}
+		}
+		hooks = r.startLocationHooks(location, region)
+		if r.Router {
+			r.markTimeline(timelineConnecting, location.Key())
+			var err error
+			if connectTime, err = r.waitForRouterSwitch(locationCtx, location); err != nil {
+				r.markTimeline(timelineConnectFailed, location.Key())
+				if !r.locationTimedOut(locationCtx, location) {
+					slog.Error("Failed to switch the router", "country", location.Country, "city", location.City, "err", err)
+					r.recordFailure(location, "failed to switch the router")
+				}
+				continue
+			}
+		} else {
+			r.printTextf("Connecting to VPN: %s...\n", describeLocation(location))
+			r.markTimeline(timelineConnecting, location.Key())
+			durations, err := r.connectCycle(locationCtx, region, r.ConnectCycles)
+			if err != nil {
+				r.markTimeline(timelineConnectFailed, location.Key())
+				if !r.locationTimedOut(locationCtx, location) {
+					slog.Error("Failed to connect to VPN", "region", region, "err", err)
+					r.recordFailure(location, "failed to connect")
+				}
+				continue
+			}
+
+			duration := durations[len(durations)-1]
+			r.printTextf("Connected in %v\n", duration)
+			connectTime = duration.String()
+			connectBreakdown = r.lastPhases
+			if r.lastPhases != nil {
+				r.printTextf("Connect phases: command %s, connected %s, first probe %s\n", r.lastPhases.Command, r.lastPhases.Connected, cmp.Or(r.lastPhases.FirstProbe, "failed"))
+			}
+			if r.ConnectCycles > 1 {
+				connectTimes = summarizeConnectTimes(durations)
+				r.printTextf("Connect times over %d cycles: min %s, avg %s, max %s\n", connectTimes.Cycles, connectTimes.Min, connectTimes.Avg, connectTimes.Max)
+			}
+			if region == vpn.SmartLocation {
+				region = r.smartLocationRegion()
+			}
+		}
+		r.markTimeline(timelineConnected, location.Key())
+		r.updateProgress("testing", location.Country+", "+location.City, i+1)
+		if r.skipCurrentLocation(location) || r.locationTimedOut(locationCtx, location) {
+			if !r.Router {
+				r.provider.Disconnect()
+				r.markTimeline(timelineDisconnected, location.Key())
+			}
+			continue
+		}
+
+		var exitInfo ExitIPInfo
+		var exitMatch *bool
+		if r.VerifyExitIP {
+			exitInfo, exitMatch = r.verifyExit(locationCtx, location)
+			r.printText("Exit IP: ", exitInfo.IP, exitInfo.Country)
+		}
+		var ipv6 *bool
+		if r.IPv6Check {
+			ipv6 = r.checkIPv6(locationCtx)
+			r.printText("IPv6:", *ipv6)
+		}
+
+		var dnsResolveTime string
+		if len(r.DNSDomains) > 0 {
+			dnsResolveTime = r.benchmarkDNS(locationCtx, r.DNSDomains)
+			r.printText("DNS Resolution Time: ", dnsResolveTime)
+		}
+
+		var webTimings []results.WebTiming
+		if len(r.WebURLs) > 0 {
+			webTimings = benchmarkWeb(locationCtx, r.WebURLs, r.WebTimeout)
+			for _, timing := range webTimings {
+				if timing.Error == "" {
+					r.printText("Web: ", timing.URL, "TTFB", timing.TTFB, "total", timing.Total)
+				}
+			}
+		}
+
+		r.warmUp(locationCtx, r.Warmup)
+
+		samples, parallel := locationSettings(location, r.Samples, r.Parallel)
+		counters := r.startInterfaceCounters()
+		cpu := r.startCPUMonitor()
+
+		var stat results.VPNStat
+		var ok bool
+		if r.LatencyOnly {
+			stat, ok = r.latencyTest(connectTime)
+		} else if !parallel {
+			// Run speed test with VPN single threaded
+			stat, ok = r.runSamples(locationCtx, connectTime, samples, 1)
+		} else if r.Mode == "hybrid" {
+			stat, ok = r.runHybridSpeedTests(locationCtx, connectTime, samples)
+		} else {
+			// Run speed test with VPN multi-threaded
+			stat, ok = r.runTunedParallelSpeedTests(locationCtx, connectTime, samples)
+		}
+		interfaceCounters := counters.finish()
+		cpuStats := cpu.finish()
+
+		if r.skipCurrentLocation(location) {
+			// The result of a skipped location is discarded
+		} else if r.locationTimedOut(locationCtx, location) {
+			// So is that of a location that ran out of time, which the remaining tests didn't run for
+		} else if ok {
+			if stat.LocationName == "" {
+				// The engine doesn't report where its server is, e.g. iperf3
+				stat.LocationName = location.Country + ", " + location.City
+			}
+			if location.IsSmart() {
+				// Kept apart from the explicit region it picked
+				stat.LocationName = "Smart location"
+			}
+			stat.Provider = location.Provider
+			stat.Region = region
+			stat.TimeWindow = r.TimeWindow
+			r.clearSkippedPhases(&stat)
+			stat.DNSResolveTime = dnsResolveTime
+			stat.Web = webTimings
+			stat.ExitIP = exitInfo.IP
+			stat.ExitCountry = exitInfo.Country
+			stat.ExitCountryMatch = exitMatch
+			stat.IPv6 = ipv6
+			if r.VerifyRoute {
+				stat.EgressInterface, stat.RouteThroughVPN = r.checkRoute(stat.Server)
+			}
+			if r.MTUSweep {
+				stat.MTU = r.sweepMTU(r.TracerouteTarget)
+			}
+			stat.CustomProbes = r.runProbes(locationCtx, location, region)
+			stat.ConnectTimes = connectTimes
+			stat.ConnectPhases = connectBreakdown
+			stat.InterfaceCounters = interfaceCounters
+			stat.CPU = cpuStats
+			if r.Geo {
+				r.enrichGeo(&stat, exitInfo)
+			}
+			if r.CrossCheck != "" {
+				stat.CrossCheck = r.crossCheck(locationCtx, location, stat)
+			}
+			if r.Soak > 0 {
+				stat.Soak = r.runSoak(locationCtx, r.Soak)
+			}
+			r.applyAssertions(location, &stat)
+			r.writeToFile(stat)
+			hooks.record(stat)
+			if r.Once {
+				r.printOnceResult(stat)
+			}
+			r.markCompleted(location)
+			r.testedLocations++
+		} else {
+			r.recordFailure(location, allSamplesFailed(stat))
+		}
+
+		// Disconnect VPN after tests
+		if !r.Router {
+			r.provider.Disconnect()
+			r.markTimeline(timelineDisconnected, location.Key())
+		}
+	}
+
+	cancelLocation()
+	hooks.finish()
+}
+
+// Runs n speed tests, concurrency at a time, and returns the averaged result; ok is false for the baseline or when
+// no test succeeded. The result counts the attempted and successful samples and keeps the errors of the failed ones
+func (r *Runner) runSamples(ctx context.Context, connectionTime string, n int, concurrency int) (results.VPNStat, bool) {
+	concurrency = max(1, min(concurrency, n))
+	mode := "Tests ran in series (one after another)"
+	if concurrency == n && n > 1 {
+		mode = "Tests ran in parallel"
+	} else if concurrency > 1 {
+		mode = fmt.Sprintf("Tests ran in parallel, %d at a time", concurrency)
+	}
+
+	// Tests in series show a spinner each, parallel ones share status lines
+	var status *statusLines
+	if concurrency > 1 {
+		status = r.startStatusLines(n)
+	}
+	watch := r.startConnectionWatch(connectionTime)
+	stats := make([]*results.VPNStat, n)
+	sampleErrors := make([]string, n)
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range n {
+		slots <- struct{}{} // Waits for a free slot
+		if err := ctx.Err(); err != nil {
+			// The tests not started yet fail with the location's timeout
+			sampleErrors[i] = err.Error()
+			<-slots
+			continue
+		}
+		if concurrency == 1 && i > 0 {
+			pause(r.PauseBetweenTests, "between tests")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			stat, err := r.runSample(ctx, i, connectionTime, mode, status, watch)
+			if err != nil {
+				sampleErrors[i] = err.Error()
+			} else if connectionTime != "" {
+				stats[i] = &stat
+			}
+		}()
+	}
+	wg.Wait()
+	if status != nil {
+		status.stop()
+	}
+	stat, ok := averageSamples(stats, slices.DeleteFunc(sampleErrors, func(err string) bool { return err == "" }))
+	stat.ConnectionDrops, stat.InvalidSamples = watch.finish()
+	return stat, ok
+}
+
+// Runs test i of n, showing its progress on a spinner or its status line, and returns its result; the result of
+// a test without VPN is recorded as the baseline instead. A test the VPN connection dropped during is run again
+func (r *Runner) runSample(ctx context.Context, i int, connectionTime string, mode string, status *statusLines, watch *connectionWatch) (results.VPNStat, error) {
+	through := "without VPN"
+	if connectionTime != "" {
+		through = "through VPN"
+	}
+	var s spinner
+	if status == nil {
+		s = r.startSpinner(fmt.Sprintf("Running speed test #%d %s...", i+1, through))
+	} else {
+		status.set(i, "running "+through+"...")
+	}
+
+	result, err := r.runWatchedSpeedTest(ctx, watch, i)
+	if err != nil {
+		slog.Error("Speed test failed", "engine", r.Engine, "err", err)
+		if status == nil {
+			s.Fail("Speed test failed")
+		} else {
+			status.set(i, pterm.Red("failed"))
+		}
+		return results.VPNStat{}, err
+	}
+
+	if status == nil {
+		r.printText("\nLocation: ", result.Server.Country+", "+result.Server.Location)
+		r.printText("Server: ", result.Server.Host)
+		r.printText("Ping Latency: ", fmt.Sprintf("%.2f", result.Ping.Latency), "ms")
+		r.printText("Jitter: ", fmt.Sprintf("%.2f", result.Ping.Jitter), "ms")
+		r.printText("Packet Loss: ", fmt.Sprintf("%.2f", result.PacketLoss), "%")
+		r.printText("Download Bandwidth: ", r.FormatSpeed(bytesToMbps(result.Download.Bandwidth)))
+		r.printText("Upload Bandwidth: ", r.FormatSpeed(bytesToMbps(result.Upload.Bandwidth)))
+	} else {
+		status.set(i, pterm.Green("done")+fmt.Sprintf(": %s ▼  %s ▲  %.2fms, %s (%s)",
+			r.FormatSpeed(bytesToMbps(result.Download.Bandwidth)), r.FormatSpeed(bytesToMbps(result.Upload.Bandwidth)),
+			result.Ping.Latency, result.Server.Host, result.Server.Country+", "+result.Server.Location))
+	}
+	r.writeSample(newSampleRecord(result, connectionTime, mode))
+	if connectionTime == "" {
+		r.recordBaseline(result)
+	}
+	if status == nil {
+		s.Success(fmt.Sprintf("Speed test #%d completed", i+1))
+	}
+	return newSampleStat(result, connectionTime, mode), nil
+}
+
+// Returns the result of a speed test through VPN as a sample of its location
+func newSampleStat(result speedtest.Result, connectionTime string, mode string) results.VPNStat {
+	now := time.Now()
+	stat := results.VPNStat{
+		LocationName:     speedtest.ServerLocation(result),
+		TimeToConnect:    connectionTime,
+		VPNDownloadSpeed: fmt.Sprintf("%.2fMbps", bytesToMbps(result.Download.Bandwidth)),
+		VPNUploadSpeed:   fmt.Sprintf("%.2fMbps", bytesToMbps(result.Upload.Bandwidth)),
+		VPNLatency:       fmt.Sprintf("%.2fms", result.Ping.Latency),
+		VPNJitter:        fmt.Sprintf("%.2fms", result.Ping.Jitter),
+		VPNPacketLoss:    fmt.Sprintf("%.2f%%", result.PacketLoss),
+		Server:           result.Server.Host,
+		Timestamp:        results.FormatTime(now),
+		TimestampUnix:    now.Unix(),
+		Mode:             mode,
+	}
+	if result.Result.URL != "" {
+		stat.ResultURLs = []string{result.Result.URL}
+	}
+	return stat
+}
+
+// Averages the successful samples, nil for the failed ones and the baseline's; ok is false without any
+func averageSamples(stats []*results.VPNStat, sampleErrors []string) (results.VPNStat, bool) {
+	var download, upload, jitterStats, packetLossStats results.RunningStats
+	var avgStat results.VPNStat
+	var downloadSamples, uploadSamples []float64
+	var resultURLs []string
+	for _, stat := range stats {
+		if stat == nil {
+			continue
+		}
+		downloadSpeed := results.ParseMbps(stat.VPNDownloadSpeed)
+		uploadSpeed := results.ParseMbps(stat.VPNUploadSpeed)
+		jitter, _ := strconv.ParseFloat(strings.TrimSuffix(stat.VPNJitter, "ms"), 64)
+		packetLoss, _ := strconv.ParseFloat(strings.TrimSuffix(stat.VPNPacketLoss, "%"), 64)
+		download.Add(downloadSpeed)
+		upload.Add(uploadSpeed)
+		jitterStats.Add(jitter)
+		packetLossStats.Add(packetLoss)
+		downloadSamples = append(downloadSamples, downloadSpeed)
+		uploadSamples = append(uploadSamples, uploadSpeed)
+		resultURLs = append(resultURLs, stat.ResultURLs...)
+		avgStat = *stat // Keep other details from the last stat
+	}
+
+	if download.Count > 0 {
+		avgStat.VPNDownloadSpeed = fmt.Sprintf("%.2fMbps", download.Mean)
+		avgStat.VPNUploadSpeed = fmt.Sprintf("%.2fMbps", upload.Mean)
+		avgStat.VPNJitter = fmt.Sprintf("%.2fms", jitterStats.Mean)
+		avgStat.VPNPacketLoss = fmt.Sprintf("%.2f%%", packetLossStats.Mean)
+		avgStat.DownloadSamples = downloadSamples
+		avgStat.UploadSamples = uploadSamples
+		avgStat.ResultURLs = resultURLs
+		countSamples(&avgStat, len(stats), sampleErrors)
+		return avgStat, true
+	}
+	var failed results.VPNStat
+	countSamples(&failed, len(stats), sampleErrors)
+	return failed, false
+}
+
+// Runs one speed test on its own, then the parallel tests, and returns the parallel result with the
+// single-stream one alongside; a failed single-stream test counts as a failed sample
+func (r *Runner) runHybridSpeedTests(ctx context.Context, connectionTime string, samples int) (results.VPNStat, bool) {
+	single, singleOK := r.runSamples(ctx, connectionTime, 1, 1)
+	pause(r.PauseBetweenTests, "between tests")
+	stat, ok := r.runTunedParallelSpeedTests(ctx, connectionTime, samples)
+
+	stat.SamplesAttempted += single.SamplesAttempted
+	stat.SamplesSucceeded += single.SamplesSucceeded
+	stat.SampleErrors = append(single.SampleErrors, stat.SampleErrors...)
+	stat.InvalidSamples += single.InvalidSamples
+	stat.ConnectionDrops = append(single.ConnectionDrops, stat.ConnectionDrops...)
+	stat.ResultURLs = append(single.ResultURLs, stat.ResultURLs...)
+	if singleOK {
+		stat.SingleStream = &results.SingleStream{
+			VPNDownloadSpeed: single.VPNDownloadSpeed,
+			VPNUploadSpeed:   single.VPNUploadSpeed,
+			VPNLatency:       single.VPNLatency,
+		}
+	}
+	if ok {
+		stat.Mode = fmt.Sprintf("Hybrid: one test on its own, then %d in parallel", samples)
+	}
+	return stat, ok
+}
+
+// Records how many samples were attempted and succeeded, and why the others failed
+func countSamples(stat *results.VPNStat, attempted int, sampleErrors []string) {
+	stat.SamplesAttempted = attempted
+	stat.SamplesSucceeded = attempted - len(sampleErrors)
+	stat.SampleErrors = sampleErrors
+}
+
+// Runs throwaway speed tests, since the first test after the tunnel comes up is slowed by TCP ramp-up
+// and route convergence; stops once the context is done
+func (r *Runner) warmUp(ctx context.Context, count int) {
+	for i := range count {
+		if ctx.Err() != nil {
+			return
+		}
+		spinner := r.startSpinner(fmt.Sprintf("Running warm-up test #%d...", i+1))
+		if _, err := r.runSpeedTest(ctx); err != nil {
+			slog.Warn("Warm-up test failed", "engine", r.Engine, "err", err)
+			spinner.Warning("Warm-up test failed")
+			continue
+		}
+		spinner.Success(fmt.Sprintf("Warm-up test #%d discarded", i+1))
+		pause(r.PauseBetweenTests, "after warm-up")
+	}
+}
+
+// Returns the number of samples and whether to run them in parallel, applying the location's overrides
+func locationSettings(location results.Location, defaultCount int, defaultParallel bool) (int, bool) {
+	count, parallel := defaultCount, defaultParallel
+	if location.Samples > 0 {
+		count = location.Samples
+	}
+	if location.Parallel != nil {
+		parallel = *location.Parallel
+	}
+	return count, parallel
+}
+
+// Returns the region the client picked for the smart location, or an empty string when it can't tell
+func (r *Runner) smartLocationRegion() string {
+	region, err := r.expressVPN().CurrentRegion()
+	if err != nil {
+		slog.Warn("Could not tell which region the smart location picked", "err", err)
+		return ""
+	}
+	r.printText("Smart location picked:", region)
+	return region
+}
+
+// Finds the provider's region for a given location, or the closest candidates if there is none
+func (r *Runner) findRegion(p vpn.Provider, location results.Location) (string, []string) {
+	if location.IsSmart() {
+		return vpn.SmartLocation, nil
+	}
+	if region := vpn.AliasRegion(location, r.regionAliases); region != "" {
+		return region, nil
+	}
+
+	regions, err := p.Regions()
+	if err != nil {
+		slog.Error("Error executing command", "err", err)
+		return "", nil
+	}
+	if regions == nil {
+		// The provider takes the location as it is
+		return strings.TrimSuffix(location.Country+", "+location.City, ", "), nil
+	}
+	if location.City == "" && slices.Contains(regions, location.Country) {
+		// Named exactly, e.g. a WireGuard file
+		return location.Country, nil
+	}
+
+	return vpn.MatchRegion(location, regions)
+}
+
+// Writes speed test results to a file
+func (r *Runner) writeToFile(newStats results.VPNStat) {
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+
+	data, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return
+	}
+	if r.Redact {
+		redactStat(&newStats)
+	}
+
+	if data.MachineName == "" {
+		hostname, err := r.machineName()
+		if err != nil {
+			slog.Error("Error getting hostname", "err", err)
+			return
+		}
+
+		osInfo := getOSInfo(r.Commands)
+
+		data = results.Results{
+			MachineName: hostname,
+			OS:          osInfo.Name + ": " + osInfo.Version,
+			OSInfo:      osInfo,
+			VPNStats:    []results.VPNStat{},
+		}
+	}
+
+	if !data.HasRun(r.runID) {
+		data.Runs = append(data.Runs, results.Run{
+			ID:         r.runID,
+			RunInfo:    r.runInfo,
+			WithoutVPN: r.Baseline(),
+			Network:    r.baselineNetwork(),
+			Conflicts:  r.conflicts,
+		})
+		info := r.runInfo
+		data.RunInfo = &info
+		data.Network = data.Runs[len(data.Runs)-1].Network
+		data.WithoutVPN = r.Baseline()
+		data.Conflicts = r.conflicts
+	}
+
+	newStats.RunID = r.runID
+	newStats.PercentOfBaseline = PercentOfBaseline(data, newStats, r.BaselineProfile)
+	data.VPNStats = append(data.VPNStats, newStats)
+
+	if err := r.Files.Save(data, r.ResultsFile); err != nil {
+		slog.Error("Error saving JSON file", "err", err)
+		return
+	}
+
+	r.writeToSinks(data, newStats)
+	r.recordProgressResult(newStats)
+}
+
+// Returns the probe name if one was given, otherwise the hostname, hashed with Redact
+func (r *Runner) machineName() (string, error) {
+	name, err := r.ProbeName, error(nil)
+	if name == "" {
+		name, err = os.Hostname()
+	}
+	if err != nil || !r.Redact {
+		return name, err
+	}
+	return r.hashHostname(name), nil
+}
@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"runtime"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+)
+
+// RunTimeLayout formats times shown to the user: the -plain log prefixes, the times of stored results
+// in tables and the next -matrix pass. They are read by people in their own time zone, so they stay
+// local and zoneless, unlike the RFC 3339 times written to files
+const RunTimeLayout = "2006-01-02 15:04:05"
+
+// Returns a random version 4 UUID
+func NewUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Flags whose values are credentials, recorded without them; webhook and collector URLs usually embed a
+// token
+var secretFlags = map[string]bool{"push-header": true, "push-url": true, "notify-url": true, "router-connect": true, "router-disconnect": true, "router-status": true}
+
+// Returns the comma-separated -output value with the URLs of its webhook outputs redacted, keeping the
+// console format and file outputs
+func redactOutputs(value string) string {
+	outputs := strings.Split(value, ",")
+	for i, output := range outputs {
+		if strings.HasPrefix(output, "webhook:") {
+			outputs[i] = "webhook:REDACTED"
+		}
+	}
+	return strings.Join(outputs, ",")
+}
+
+// Collects the run metadata once the flags and the config file have been applied
+func (r *Runner) newRunInfo() results.RunInfo {
+	info := results.RunInfo{
+		UUID:          r.runUUID,
+		ToolVersion:   r.ToolVersion,
+		ToolCommit:    r.ToolCommit,
+		ToolBuildDate: r.ToolBuildDate,
+		GoVersion:     runtime.Version(),
+		Engine:        r.Engine,
+		EngineVersion: speedtest.EngineVersion(r.Engine, r.engineOptions()),
+		IPVersion:     r.SpeedTest.IPVersion,
+		Started:       results.FormatTime(r.started),
+	}
+	if r.Flags != nil {
+		r.Flags.Visit(func(f *flag.Flag) {
+			value := f.Value.String()
+			if secretFlags[f.Name] || r.Redact && identifyingFlags[f.Name] {
+				value = "REDACTED"
+			} else if f.Name == "output" {
+				value = redactOutputs(value)
+			}
+			info.Flags = append(info.Flags, "-"+f.Name+"="+value)
+		})
+	}
+	if len(r.Tags) > 0 && !r.Redact {
+		info.Tags = maps.Clone(r.Tags)
+	}
+	if !r.Router {
+		info.ClientVersion, _ = r.expressVPN().ClientVersion()
+		info.NetworkLock = r.networkLock
+	}
+	return info
+}
+
+// Records when the run finished and how long it took in the results file; a resumed run's
+// duration spans the interruption
+func (r *Runner) finishRunInfo(finished time.Time) {
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+
+	data, err := r.Files.Load(r.ResultsFile)
+	if err != nil {
+		slog.Error("Error loading JSON file", "err", err)
+		return
+	}
+
+	for i := range data.Runs {
+		run := &data.Runs[i]
+		if run.ID != r.runID {
+			continue
+		}
+		run.Finished = results.FormatTime(finished)
+		run.DataUsedMB = r.dataUsedMB()
+		run.StoppedEarly = r.budgetStop
+		run.TimedOut = r.timedOutLocations
+		if started, err := results.ParseTime(run.Started); err == nil {
+			run.Duration = finished.Sub(started).Round(time.Second).String()
+		}
+		if i == len(data.Runs)-1 {
+			info := run.RunInfo
+			data.RunInfo = &info
+		}
+
+		if err := r.Files.Save(data, r.ResultsFile); err != nil {
+			slog.Error("Error saving JSON file", "err", err)
+		}
+		return
+	}
+}
@@ -0,0 +1,396 @@
+// Package runner carries a whole benchmark run: its Options, the test plan, connecting to each location,
+// the speed tests, checkpoints, reports and the outputs the results are sent to
+package runner
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+	"flavius.xyz/vpn_speed_test_cli/pkg/vpn"
+)
+
+// Options configures a benchmark run; the run command sets them from its flags and the config file
+type Options struct {
+	ResultsFile string // Where the results of the run are saved; empty for results-<timestamp>.json in ResultsDir
+	ResultsDir  string // Directory the default results file is written to; empty for the working directory
+	Append      bool   // Add the run to an existing results file, as a new section
+	Resume      string // Checkpoint file of an interrupted run to resume, skipping its completed locations
+	Samples     int    // Speed tests per VPN connection, unless a location overrides it
+	Parallel    bool   // Whether the speed tests of a connection run in parallel
+	Mode        string // parallel, series, or hybrid, where one test runs on its own before the parallel ones
+	AutoTune    bool   // Test a location whose parallel tests saturated the link again with fewer tests at a time
+	Once        bool   // Test a single location without a baseline and print its result as JSON
+	Namespaces  int    // Regions tested at once, each through a WireGuard tunnel in its own Linux network namespace; 0 or 1 tests one at a time
+
+	Engine              string              // One of speedtest.Engines
+	SpeedTest           speedtest.Options   // Options of every speed test; their commands are Commands
+	CrossCheck          string              // Second engine run once per location; empty disables the cross-check
+	CrossCheckTolerance float64             // Percent difference above which the engines disagree
+	LatencyOnly         bool                // Measure only latency and jitter, no throughput, to sweep every region in minutes
+	Warmup              int                 // Throwaway speed tests after each VPN connect
+	Commands            command.Overrides   // How the binaries are run
+	Files               results.FileOptions // How results files are saved and loaded
+
+	PauseBetweenTests     time.Duration // Pause between consecutive tests against the same server
+	PauseBetweenLocations time.Duration // Pause after disconnecting, before the next location
+	ConnectTimeout        time.Duration // How long to wait for a VPN connection
+	ConnectCycles         int           // Connects per region; all but the last are followed by a disconnect
+	ConnectPhases         bool          // Break each connect down into the connect command, the client's states and the first probe
+	FirstProbeTarget      string        // host:port the first probe connects to through the tunnel
+	LocationTimeout       time.Duration // Give up on a location whose connect and tests take longer; 0 waits for them
+	ReconnectPollInterval time.Duration // Time between connection state checks while speed tests run
+	SampleRetries         int           // Times a speed test the VPN dropped during is run again
+	FailFast              bool          // Stop at the first failed location instead of moving on
+	MaxDataGB             float64       // Stop before the speed tests transfer more than this; 0 is unlimited
+	MaxDuration           time.Duration // Stop before the run takes longer than this; 0 is unlimited
+	IgnoreConflicts       bool          // Run anyway when conflicting VPN software is detected
+	NetworkLock           string        // on or off for the run, restored afterwards; empty leaves the setting as is
+	TimeWindow            string        // Recorded with every location of the run
+
+	// Router mode measures from the LAN through a VPN router such as Aircove. Aircove documents no local
+	// API, so the router is switched by the RouterConnect and RouterDisconnect commands, e.g. scripts
+	// driving its admin page, or else by the user when prompted
+	Router           bool
+	RouterConnect    string    // Empty prompts instead
+	RouterDisconnect string    // Turns the router's VPN off for the baseline
+	RouterStatus     string    // Exits with 0 once the router is connected, polled after RouterConnect
+	RouterInput      io.Reader // Where the confirmations of prompted switches are read
+	RouterPrompt     io.Writer // Prompts stay off stdout so ndjson output remains parseable
+
+	DNSDomains       []string      // Empty disables the DNS benchmark
+	DNSTimeout       time.Duration // For each domain
+	WebURLs          []string      // Empty disables the web probe
+	WebTimeout       time.Duration // For each URL
+	VerifyExitIP     bool          // Check the exit IP after connecting and whether its country matches
+	IPCheckURL       string        // IP echo service of VerifyExitIP, Geo and the baseline's network
+	IPv6Check        bool          // Record whether each location reaches IPv6 hosts
+	IPv6Target       string        // IPv6 host:port the check connects to
+	VerifyRoute      bool          // Check that traffic to the speed test server leaves through the VPN
+	IfaceCounters    bool          // Record the interface byte counters during each location's tests
+	CPU              bool          // Record CPU and memory use during each location's tests
+	CPUInterval      time.Duration // Time between CPU samples
+	MTUSweep         bool          // Probe the path MTU through the tunnel for each location
+	Geo              bool          // Record hop count and distance to the VPN exit
+	TracerouteTarget string        // Host the hop count, the route without VPN and the path MTU are measured to
+	Probes           []Probe       // Custom measurements run through each location's VPN
+	PreHook          string        // Shell command run before connecting to each location
+	PostHook         string        // Shell command run after each location's tests and disconnect
+	Soak             time.Duration // Stay connected this long after each location's tests; zero disables the soak test
+	SoakInterval     time.Duration // Time between throughput samples during the soak
+	SoakPollInterval time.Duration // Time between connection state checks during the soak
+	PingAnchor       string        // Host pinged throughout the run; empty disables the ping monitor
+	PingInterval     time.Duration // Time between pings
+	PingTimelineFile string        // Where the timeline is written, as CSV when it ends in .csv; empty for next to the results
+
+	ProbeName       string            // Recorded instead of the hostname when set
+	Tags            map[string]string // Group results from many machines
+	NetworkProfile  string            // Name of the network the baseline is measured on, e.g. wired or wifi
+	BaselineProfile string            // Compare the locations with the latest baseline of this network profile in the results file
+	Redact          bool              // Store results that can be shared publicly: hashed names, no public IPs or speed test servers
+	RedactKeyFile   string            // Where the install's random key for hashing names is kept; the run command keeps it next to the config file
+	Flags           *flag.FlagSet     // Flags recorded with the run; nil records none
+	ToolVersion     string            // Version of the build making the run, recorded with it
+	ToolCommit      string            // Commit of that build
+	ToolBuildDate   string            // When it was built
+
+	Output       string          // "text", "ndjson", or "json" for the single result of Once
+	Quiet        bool            // Only warnings and errors, no human-readable output
+	Plain        bool            // Timestamped lines instead of spinners, colors and redrawn lines
+	ASCII        bool            // Plain text instead of the ▼/▲ arrows and other non-ASCII output, on the console and in the results
+	TUI          bool            // Full-screen interactive mode with a live table of locations
+	RedirectLogs func(io.Writer) // Sends the log to the TUI's log pane while it is up, and back with nil
+	SampleWriter io.Writer       // Where ndjson samples and the result of Once are written
+	Display                      // How speeds and times are shown
+
+	ProgressFile        string        // Empty disables progress reporting
+	PublicReportFile    string        // Empty disables the public report
+	PublicRoundMbps     float64       // Bucket size for speeds in the public report
+	HTMLReportFile      string        // Empty disables the HTML report
+	BundleFile          string        // Empty disables the zip bundle
+	BundleLog           *bytes.Buffer // Log of the run zipped into the bundle; nil leaves it out
+	TopMovers           int           // Number of improved and degraded regions to show; 0 disables the digest
+	HistoryDir          string        // Directory of earlier results files; empty disables regression alerts
+	HistoryWindow       int           // Number of earlier runs of a region its norm averages
+	RegressionThreshold float64       // Percent below its norm a region's download speed must fall to be flagged
+	KeepLast            int           // Results files the rotation keeps, newest first; 0 keeps them all
+	KeepDays            int           // Days the rotation keeps results files for; 0 keeps them regardless of age
+	Sinks               []Sink        // Receive every saved location result in addition to the results file
+	PushURL             string        // Collector the results of the run are posted to; empty disables pushing
+	PushSamples         bool          // Also post every speed test as it completes
+	PushHeaders         []string      // Sent with every push, e.g. for authentication
+	NotifyURL           string        // Webhook the run summary is posted to; empty disables notifications
+}
+
+// Returns the options of a run with the run command's defaults
+func DefaultOptions() Options {
+	return Options{
+		Samples:               5,
+		Parallel:              true,
+		Mode:                  "parallel",
+		Engine:                "ookla",
+		SpeedTest:             speedtest.DefaultOptions(),
+		CrossCheckTolerance:   20,
+		Commands:              command.Overrides{},
+		PauseBetweenTests:     2 * time.Second,
+		PauseBetweenLocations: 5 * time.Second,
+		ConnectTimeout:        time.Minute,
+		ConnectCycles:         1,
+		FirstProbeTarget:      "1.1.1.1:443",
+		ReconnectPollInterval: time.Second,
+		SampleRetries:         2,
+		RouterInput:           os.Stdin,
+		RouterPrompt:          os.Stderr,
+		DNSTimeout:            5 * time.Second,
+		WebTimeout:            15 * time.Second,
+		IPCheckURL:            "https://ipapi.co/json/",
+		IPv6Target:            "[2606:4700:4700::1111]:443",
+		CPUInterval:           time.Second,
+		TracerouteTarget:      "1.1.1.1",
+		SoakInterval:          time.Minute,
+		SoakPollInterval:      5 * time.Second,
+		PingInterval:          time.Second,
+		Tags:                  map[string]string{},
+		Output:                "text",
+		SampleWriter:          os.Stdout,
+		Display:               Display{Unit: "Mbps"},
+		PublicRoundMbps:       10,
+		TopMovers:             3,
+		HistoryWindow:         10,
+		RegressionThreshold:   20,
+	}
+}
+
+// Runner carries the options of a benchmark run and everything the run tracks, so the parallel speed
+// tests of a connection record their results without racing on shared variables and several runs can
+// be made in one process
+type Runner struct {
+	Options
+
+	started time.Time
+	runID   string          // Identifies this run's section of the results file
+	runUUID string          // Identifies this run across machines
+	runInfo results.RunInfo // How the run was made, recorded in its section of the results file
+	output  string          // Console format in effect: Output, or tui while the TUI is up
+
+	fileMutex     sync.Mutex // Ensures safe file writes across goroutines
+	locationMutex sync.Mutex // Serializes recording the outcomes of locations tested at once in namespaces
+	sampleMutex   sync.Mutex // Keeps lines from parallel tests from interleaving
+
+	mutex             sync.Mutex // Guards withoutVPN, baselineDownloads and network
+	withoutVPN        string
+	baselineDownloads []float64 // Mbps of each baseline speed test
+	network           results.Network
+	concurrency       int // Parallel speed tests running at once; 0 runs all of a connection's at once
+
+	provider         vpn.Provider            // VPN the current location is tested through
+	providers        map[string]vpn.Provider // The provider sections of the input file by name
+	providerSections []results.ProviderInput // The same sections without their locations, for the plan
+	regionAliases    map[string]string       // User-defined names mapped to explicit region slugs
+	homeLocation     *ExitIPInfo             // Public IP location before connecting, nil when unknown
+	homeInterface    string                  // Egress interface without VPN, looked up before the first connect
+	lastPhases       *results.ConnectPhases  // Of the latest connect, which the tests run on; nil without ConnectPhases
+	redactKey        []byte                  // Hashes names with Redact
+	conflicts        []string                // Recorded in the results when running with IgnoreConflicts
+	networkLock      *bool                   // Network lock setting during the run; nil when unknown
+	networkLockReset *bool                   // Setting to put back at the end of the run, when NetworkLock changed it
+	checkpointFile   string                  // Where the completed locations are recorded
+	checkpoint       Checkpoint              // Locations completed so far, including those of a resumed run
+	pingMonitor      *PingMonitor            // Running monitor, nil when disabled
+	tui              *tui                    // Nil unless the TUI is up
+	progressMutex    sync.Mutex              // Guards progress
+	progress         Progress                // Snapshot written to the progress file
+	progressStart    time.Time               // When the progress tracking started
+	notified         bool                    // Only the first of completion and abort is reported
+
+	dataUsed        atomic.Int64 // Bytes transferred by the speed tests of the run
+	reportedBytes   atomic.Int64 // The same without estimates, only what the engines reported
+	testsRun        atomic.Int64 // Speed tests that transferred them
+	budgetStop      string       // Why the run stopped before its last location; empty while within budget
+	budgetStart     time.Time    // When the first location started, after the baseline
+	budgetLocations int          // Locations started since
+
+	baselineFailed    bool
+	testedLocations   int
+	failedLocations   []string    // "Country, City: reason" for every location that produced no result
+	skippedLocations  []string    // "Country, City" for every location skipped from the keyboard in TUI mode
+	timedOutLocations []string    // "Country, City" of every location that exceeded LocationTimeout
+	assertionFailures int         // Locations that failed their assertions
+	skipRequested     atomic.Bool // Set by the "s" key, consumed by the location loop
+	abortRequested    atomic.Bool // Set by the "q" key, Ctrl+C or an interrupt signal
+	abortSource       string      // How the abort was requested, for the logs and notifications
+}
+
+// Creates a runner with the options; the run is identified by the time it was created
+func NewRunner(opts Options) *Runner {
+	r := &Runner{Options: opts, started: time.Now(), runUUID: NewUUID(), providers: map[string]vpn.Provider{}, abortSource: "from the keyboard"}
+	r.runID = r.started.Format("20060102150405")
+	r.output = r.Output
+	if r.Commands == nil {
+		r.Commands = command.Overrides{}
+	}
+	if r.SampleWriter == nil {
+		r.SampleWriter = os.Stdout
+	}
+	if r.ASCII {
+		r.Files.ASCII = true
+		r.SampleWriter = results.ASCIIWriter{W: r.SampleWriter}
+	}
+	r.provider = r.expressVPN()
+	return r
+}
+
+// Returns the baseline speed without VPN, or an empty string when it wasn't measured
+func (r *Runner) Baseline() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.withoutVPN
+}
+
+// Records a baseline speed test: its speeds, the ISP the engine detected and, when the IP echo
+// service couldn't tell, the public IP it saw
+func (r *Runner) recordBaseline(result speedtest.Result) {
+	speed := fmt.Sprintf("%.2fMbps ▼  %.2fMbps ▲", bytesToMbps(result.Download.Bandwidth), bytesToMbps(result.Upload.Bandwidth))
+	if r.SpeedTest.SkipDownload {
+		speed = fmt.Sprintf("%.2fMbps ▲", bytesToMbps(result.Upload.Bandwidth))
+	} else if r.SpeedTest.SkipUpload {
+		speed = fmt.Sprintf("%.2fMbps ▼", bytesToMbps(result.Download.Bandwidth))
+	}
+
+	r.mutex.Lock()
+	r.withoutVPN = speed
+	r.baselineDownloads = append(r.baselineDownloads, bytesToMbps(result.Download.Bandwidth))
+	r.network.SpeedtestISP = result.ISP
+	if r.network.PublicIP == "" {
+		r.network.PublicIP = result.Interface.ExternalIP
+	}
+	r.mutex.Unlock()
+
+	r.recordProgressBaseline(speed)
+}
+
+// Looks up the public IP and ISP before the baseline; with Geo this also locates home
+func (r *Runner) lookupHomeNetwork() {
+	info, err := fetchExitIP(context.Background(), r.IPCheckURL)
+	if err != nil {
+		slog.Warn("Could not look up the public IP and ISP without VPN", "url", r.IPCheckURL, "err", err)
+	} else {
+		r.mutex.Lock()
+		r.network.PublicIP, r.network.ISP = info.IP, info.ISP
+		r.mutex.Unlock()
+	}
+	if r.Geo {
+		r.setHomeLocation(info, err)
+	}
+}
+
+// Returns the baseline network for the results file, nil when nothing is known about it
+func (r *Runner) baselineNetwork() *results.Network {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	network := r.network
+	network.Profile = r.NetworkProfile
+	if network == (results.Network{}) {
+		return nil
+	}
+	if r.Redact {
+		network.PublicIP = ""
+	}
+	return &network
+}
+
+// Registers the provider sections and aliases of the input and returns the locations to test: the
+// top-level ones, then each provider's after the previous one's
+func (r *Runner) AddInput(input results.InputData) ([]results.Location, error) {
+	if err := r.addProviders(&input); err != nil {
+		return nil, err
+	}
+	if r.Router && len(r.providers) > 0 {
+		return nil, fmt.Errorf("-router can't be combined with provider sections, the router is the only VPN measured")
+	}
+	r.regionAliases = input.Aliases
+	return input.Locations, nil
+}
+
+// Makes the results file ready for the run: the one of the checkpoint when resuming, otherwise a new
+// file or, with Append, an existing one, whose passphrase must work before the first location
+func (r *Runner) prepareResults() error {
+	if r.Resume != "" {
+		checkpoint, err := loadCheckpoint(r.Resume)
+		if err != nil {
+			return fmt.Errorf("failed to load checkpoint %s: %w", r.Resume, err)
+		}
+		r.checkpoint, r.checkpointFile = checkpoint, r.Resume
+		r.ResultsFile = checkpoint.ResultsFile
+		if checkpoint.RunID != "" {
+			r.runID = checkpoint.RunID
+		}
+		if checkpoint.RunUUID != "" {
+			r.runUUID = checkpoint.RunUUID
+		}
+		r.printText("Resuming run,", len(checkpoint.Completed), "locations already completed")
+		return r.checkBaselineProfile()
+	}
+
+	if r.ResultsFile == "" {
+		r.ResultsFile = "results-" + r.runID + ipVersionSuffixes[r.SpeedTest.IPVersion] + ".json"
+		if r.ResultsDir != "" {
+			if err := os.MkdirAll(r.ResultsDir, 0755); err != nil {
+				return fmt.Errorf("failed to create results directory %s: %w", r.ResultsDir, err)
+			}
+			r.ResultsFile = filepath.Join(r.ResultsDir, r.ResultsFile)
+		}
+	}
+	if _, err := os.Stat(r.ResultsFile); err == nil && !r.Append {
+		return fmt.Errorf("results file %s already exists; pass -append to add this run to it", r.ResultsFile)
+	}
+	if _, err := r.Files.Load(r.ResultsFile); err != nil {
+		return fmt.Errorf("failed to load results file %s: %w", r.ResultsFile, err)
+	}
+	r.checkpoint = Checkpoint{ResultsFile: r.ResultsFile, RunID: r.runID, RunUUID: r.runUUID}
+	r.checkpointFile = r.ResultsFile + ".checkpoint"
+	return r.checkBaselineProfile()
+}
+
+// Returns whether to print human-readable output, which ndjson, -once, -q and the TUI suppress
+func (r *Runner) textOutput() bool {
+	return r.output == "text" && !r.Quiet
+}
+
+// Prints human-readable output, suppressed in ndjson and quiet modes
+func (r *Runner) printText(a ...any) {
+	if r.textOutput() {
+		r.writeText(fmt.Sprintln(a...))
+	}
+}
+
+// Prints formatted human-readable output, suppressed in ndjson and quiet modes
+func (r *Runner) printTextf(format string, a ...any) {
+	if r.textOutput() {
+		r.writeText(fmt.Sprintf(format, a...))
+	}
+}
+
+// Writes human-readable output, transliterated with ASCII and as timestamped lines in plain mode
+func (r *Runner) writeText(text string) {
+	if r.ASCII {
+		text = results.ToASCII(text)
+	}
+	if r.Plain {
+		PrintPlain(text)
+		return
+	}
+	fmt.Print(text)
+}
@@ -0,0 +1,2897 @@
+package runner
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"flavius.xyz/vpn_speed_test_cli/pkg/speedtest"
+	"flavius.xyz/vpn_speed_test_cli/pkg/vpn"
+)
+
+// Constants for testing
+const testResultsFile = "test_results.json"
+
+// Variables for mocking
+var (
+	mockRuntime struct {
+		GOOS string
+	}
+)
+
+// Returns a runner with the run command's defaults and its results file in a temporary directory
+func newTestRunner(t *testing.T) *Runner {
+	opts := DefaultOptions()
+	opts.ResultsFile = filepath.Join(t.TempDir(), "results.json")
+	return NewRunner(opts)
+}
+
+// Mock for the exec.Command
+type CommandMock struct {
+	mock.Mock
+}
+
+func (m *CommandMock) Run() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *CommandMock) CombinedOutput() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *CommandMock) Output() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+// Helper to set Stdout
+func (m *CommandMock) SetStdout(out *bytes.Buffer) {
+	// This is just a helper method
+}
+
+// Setup Test Helper
+func setupTest(t *testing.T) func() {
+	// Save original functions that we'll override
+	originalExecCommand := execCommand
+
+	// Create temp dir for test files
+	tempDir, err := os.MkdirTemp("", "speedtest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+
+	// Setup mock runtime
+	mockRuntime.GOOS = "linux" // default value
+
+	// Return cleanup function
+	return func() {
+		// Restore original functions
+		execCommand = originalExecCommand
+		os.RemoveAll(tempDir)
+	}
+}
+
+// Mock for exec.Command
+var execCommand = exec.Command
+
+func mockExecCommand(command string, args ...string) *exec.Cmd {
+	cs := []string{"-test.run=TestHelperProcess", "--", command}
+	cs = append(cs, args...)
+	cmd := exec.Command(os.Args[0], cs...)
+	cmd.Env = []string{"GO_HELPER_PROCESS=1"}
+	return cmd
+}
+
+// TestHelperProcess isn't a real test, it's used as a helper process for command mocking
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+
+	if len(args) == 0 {
+		return
+	}
+
+	cmd, cmdArgs := args[0], args[1:]
+	switch cmd {
+	case "speedtest":
+		if cmdArgs[0] == "-f" && cmdArgs[1] == "json-pretty" {
+			mockSpeedTest()
+		}
+	case "expressvpnctl":
+		if cmdArgs[0] == "get" && cmdArgs[1] == "regions" {
+			mockGetRegions()
+		} else if cmdArgs[0] == "connect" {
+			// Success case for connect
+		} else if cmdArgs[0] == "disconnect" {
+			// Success case for disconnect
+		} else if cmdArgs[0] == "get" && cmdArgs[1] == "networklock" {
+			os.Stdout.Write([]byte("false"))
+		} else if cmdArgs[0] == "get" && cmdArgs[1] == "connectionstate" {
+			mockConnectionState()
+		}
+	case "sw_vers":
+		mockOSVersion(cmd)
+	}
+}
+
+func mockSpeedTest() {
+	result := speedtest.Result{}
+	result.Ping.Latency = 25.5
+	result.Download.Bandwidth = 125000000 // 1000 Mbps
+	result.Upload.Bandwidth = 62500000    // 500 Mbps
+	result.Server.Host = "test.speedtest.com"
+	result.Server.Name = "TestServer"
+	result.Server.Country = "TestCountry"
+	result.Server.Location = "TestCity"
+
+	jsonData, _ := json.MarshalIndent(result, "", "  ")
+	os.Stdout.Write(jsonData)
+}
+
+func mockGetRegions() {
+	regions := []string{
+		"netherlands-amsterdam",
+		"romania-bucharest",
+		"canada-toronto",
+		"usa",
+	}
+	os.Stdout.Write([]byte(strings.Join(regions, "\n")))
+}
+
+func mockConnectionState() {
+	os.Stdout.Write([]byte("Connected"))
+}
+
+func mockOSVersion(cmd string) {
+	switch cmd {
+	case "sw_vers":
+		os.Stdout.Write([]byte("11.2.3"))
+	}
+}
+
+// Modified GetOSVersion for testing
+func testGetOSVersion(goos string) string {
+	switch goos {
+	case "linux":
+		return "Ubuntu 20.04 LTS"
+	case "darwin":
+		return "macOS 11.2.3"
+	case "windows":
+		return "Windows 10 Pro 22H2 (10.0.19045.4529)"
+	default:
+		return "Unknown OS"
+	}
+}
+
+// Unit Tests
+
+func TestGetOSVersion(t *testing.T) {
+	cleanup := setupTest(t)
+	defer cleanup()
+
+	// Override exec.Command with our mockExecCommand
+	execCommand = mockExecCommand
+
+	// Test with different OS types
+	tests := []struct {
+		os       string
+		expected string
+	}{
+		{"linux", "Ubuntu 20.04 LTS"},
+		{"darwin", "macOS 11.2.3"},
+		{"windows", "Windows 10 Pro 22H2 (10.0.19045.4529)"},
+		{"other", "Unknown OS"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.os, func(t *testing.T) {
+			// Use our mock function
+			result := testGetOSVersion(test.os)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+// func TestFindRegion(t *testing.T) {
+// 	cleanup := setupTest(t)
+// 	defer cleanup()
+
+// 	// Override exec.Command with our mockExecCommand
+// 	execCommand = mockExecCommand
+
+// 	tests := []struct {
+// 		name     string
+// 		location Location
+// 		expected string
+// 	}{
+// 		{
+// 			name:     "Exact match",
+// 			location: Location{Country: "Netherlands", City: "Amsterdam"},
+// 			expected: "netherlands-amsterdam",
+// 		},
+// 		{
+// 			name:     "Country only match",
+// 			location: Location{Country: "Romania", City: "Bucharest"},
+// 			expected: "romania",
+// 		},
+// 		{
+// 			name:     "No match",
+// 			location: Location{Country: "France", City: "Paris"},
+// 			expected: "",
+// 		},
+// 	}
+
+// 	for _, test := range tests {
+// 		t.Run(test.name, func(t *testing.T) {
+// 			result := findRegion(test.location)
+// 			assert.Equal(t, test.expected, result)
+// 		})
+// 	}
+// }
+
+func TestLoadAndSaveFile(t *testing.T) {
+	cleanup := setupTest(t)
+	defer cleanup()
+
+	// Create test file path
+	testFile := filepath.Join(t.TempDir(), testResultsFile)
+
+	// Create test data
+	testData := results.Results{
+		MachineName: "TestMachine",
+		OS:          "TestOS",
+		WithoutVPN:  "1000Mbps ▼ 500Mbps ▲",
+		VPNStats: []results.VPNStat{
+			{
+				LocationName:     "TestCountry, TestCity",
+				TimeToConnect:    "1.5s",
+				VPNDownloadSpeed: "800Mbps",
+				VPNUploadSpeed:   "400Mbps",
+				VPNLatency:       "25.50ms",
+				Server:           "test.server.com",
+				Timestamp:        time.Now().Format("2006-01-02 15:04:05"),
+				Mode:             "Tests ran in parallel",
+			},
+		},
+	}
+
+	// Test saving to file
+	err := results.Save(testData, testFile)
+	assert.NoError(t, err)
+
+	// Test loading from file
+	loadedData, err := results.Load(testFile)
+	assert.NoError(t, err)
+	assert.Equal(t, testData.MachineName, loadedData.MachineName)
+	assert.Equal(t, testData.OS, loadedData.OS)
+	assert.Equal(t, testData.WithoutVPN, loadedData.WithoutVPN)
+	assert.Equal(t, 1, len(loadedData.VPNStats))
+	assert.Equal(t, testData.VPNStats[0].LocationName, loadedData.VPNStats[0].LocationName)
+
+	// Test loading from non-existent file
+	nonExistentFile := filepath.Join(t.TempDir(), "nonexistent.json")
+	emptyData, err := results.Load(nonExistentFile)
+	assert.NoError(t, err)
+	assert.Equal(t, results.Results{}, emptyData)
+}
+
+// Test VPN connection functions
+// func TestConnectToVPN(t *testing.T) {
+// 	cleanup := setupTest(t)
+// 	defer cleanup()
+
+// 	// Override exec.Command with our mockExecCommand
+// 	execCommand = mockExecCommand
+
+// 	// Test connecting to VPN
+// 	duration, err := vpn.Connect("netherlands-amsterdam", connectTimeout)
+// 	assert.NoError(t, err)
+// 	assert.True(t, duration > 0)
+// }
+
+// func TestDisconnectVPN(t *testing.T) {
+// 	cleanup := setupTest(t)
+// 	defer cleanup()
+
+// 	// Override exec.Command with our mockExecCommand
+// 	execCommand = mockExecCommand
+
+// 	// Test disconnecting from VPN
+// 	err := disconnectVPN()
+// 	assert.NoError(t, err)
+// }
+
+// Integration Tests
+// Modified to work with constant values
+
+func TestSpeedTestFunction(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	cleanup := setupTest(t)
+	defer cleanup()
+
+	// Override exec.Command with our mockExecCommand
+	execCommand = mockExecCommand
+
+	// Set a local variable to capture results
+	var localSpeedWithoutVPN string
+	var capturedStats []results.VPNStat
+
+	speedTestCount := 1 // Reduce the count for testing
+
+	// Create a custom version of speedTest for testing
+	testSpeedTest := func(connectionTime string) {
+		var vpnStats []results.VPNStat
+		counter := 0
+
+		var totalDownload, totalUpload float64
+		var count int
+
+		for range speedTestCount {
+			counter++
+			// Mock speedtest command output
+			result := speedtest.Result{}
+			result.Ping.Latency = 25.5
+			result.Download.Bandwidth = 125000000 // 1000 Mbps
+			result.Upload.Bandwidth = 62500000    // 500 Mbps
+			result.Server.Host = "test.speedtest.com"
+			result.Server.Name = "TestServer"
+			result.Server.Country = "TestCountry"
+			result.Server.Location = "TestCity"
+
+			if connectionTime == "" {
+				localSpeedWithoutVPN = fmt.Sprintf("%dMbps ▼  %dMbps ▲", result.Download.Bandwidth/125000, result.Upload.Bandwidth/125000)
+			} else {
+				vpnStats = append(vpnStats, results.VPNStat{
+					LocationName:     result.Server.Country + ", " + result.Server.Location,
+					TimeToConnect:    connectionTime,
+					VPNDownloadSpeed: fmt.Sprintf("%dMbps", result.Download.Bandwidth/125000),
+					VPNUploadSpeed:   fmt.Sprintf("%dMbps", result.Upload.Bandwidth/125000),
+					VPNLatency:       fmt.Sprintf("%.2fms", result.Ping.Latency),
+					Server:           result.Server.Host,
+					Timestamp:        time.Now().Format("2006-01-02 15:04:05"),
+					Mode:             "Tests ran in series (one after another)",
+				})
+			}
+		}
+
+		// Compute the average speed
+		var avgStat results.VPNStat
+		for _, stat := range vpnStats {
+			downloadSpeed, _ := strconv.Atoi(strings.TrimSuffix(stat.VPNDownloadSpeed, "Mbps"))
+			uploadSpeed, _ := strconv.Atoi(strings.TrimSuffix(stat.VPNUploadSpeed, "Mbps"))
+			totalDownload += float64(downloadSpeed)
+			totalUpload += float64(uploadSpeed)
+			count++
+			avgStat = stat // Keep other details from the last stat
+		}
+
+		if count > 0 {
+			avgStat.VPNDownloadSpeed = fmt.Sprintf("%.2fMbps", totalDownload/float64(count))
+			avgStat.VPNUploadSpeed = fmt.Sprintf("%.2fMbps", totalUpload/float64(count))
+			capturedStats = append(capturedStats, avgStat)
+		}
+	}
+
+	// Test speed test without VPN
+	testSpeedTest("")
+
+	// Verify results
+	assert.Equal(t, "1000Mbps ▼  500Mbps ▲", localSpeedWithoutVPN)
+
+	// Test speed test with VPN
+	testSpeedTest("1.5s")
+
+	// Verify VPN stats
+	assert.Equal(t, 1, len(capturedStats))
+	assert.Contains(t, capturedStats[0].LocationName, "TestCountry")
+	assert.Equal(t, "1.5s", capturedStats[0].TimeToConnect)
+}
+
+func TestParallelSpeedTestFunction(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	cleanup := setupTest(t)
+	defer cleanup()
+
+	// Override exec.Command with our mockExecCommand
+	execCommand = mockExecCommand
+
+	// Set a local variable to capture results
+	var localSpeedWithoutVPN string
+	var capturedStats []results.VPNStat
+
+	speedTestCount := 2 // Reduce the count for testing
+
+	// Create a test version of runParallelSpeedTests
+	testRunParallelSpeedTests := func(connectionTime string) {
+		resultsChan := make(chan results.VPNStat, speedTestCount)
+
+		// Simulate parallel tests
+		for i := 0; i < speedTestCount; i++ {
+			// Mock speedtest command output
+			result := speedtest.Result{}
+			result.Ping.Latency = 25.5
+			result.Download.Bandwidth = 125000000 // 1000 Mbps
+			result.Upload.Bandwidth = 62500000    // 500 Mbps
+			result.Server.Host = "test.speedtest.com"
+			result.Server.Name = "TestServer"
+			result.Server.Country = "TestCountry"
+			result.Server.Location = "TestCity"
+
+			if connectionTime == "" {
+				localSpeedWithoutVPN = fmt.Sprintf("%dMbps ▼  %dMbps ▲", result.Download.Bandwidth/125000, result.Upload.Bandwidth/125000)
+			} else {
+				resultsChan <- results.VPNStat{
+					LocationName:     result.Server.Country + ", " + result.Server.Location,
+					TimeToConnect:    connectionTime,
+					VPNDownloadSpeed: fmt.Sprintf("%dMbps", result.Download.Bandwidth/125000),
+					VPNUploadSpeed:   fmt.Sprintf("%dMbps", result.Upload.Bandwidth/125000),
+					VPNLatency:       fmt.Sprintf("%.2fms", result.Ping.Latency),
+					Server:           result.Server.Host,
+					Timestamp:        time.Now().Format("2006-01-02 15:04:05"),
+					Mode:             "Tests ran in parallel",
+				}
+			}
+		}
+		close(resultsChan)
+
+		// Compute the average speed
+		var totalDownload, totalUpload float64
+		var count int
+		var avgStat results.VPNStat
+
+		for stat := range resultsChan {
+			downloadSpeed, _ := strconv.Atoi(strings.TrimSuffix(stat.VPNDownloadSpeed, "Mbps"))
+			uploadSpeed, _ := strconv.Atoi(strings.TrimSuffix(stat.VPNUploadSpeed, "Mbps"))
+			totalDownload += float64(downloadSpeed)
+			totalUpload += float64(uploadSpeed)
+			count++
+			avgStat = stat // Keep other details from the last stat
+		}
+
+		if count > 0 {
+			avgStat.VPNDownloadSpeed = fmt.Sprintf("%.2fMbps", totalDownload/float64(count))
+			avgStat.VPNUploadSpeed = fmt.Sprintf("%.2fMbps", totalUpload/float64(count))
+			capturedStats = append(capturedStats, avgStat)
+		}
+	}
+
+	// Test parallel speed tests without VPN
+	testRunParallelSpeedTests("")
+
+	// Verify results
+	assert.Equal(t, "1000Mbps ▼  500Mbps ▲", localSpeedWithoutVPN)
+
+	// Test parallel speed tests with VPN
+	testRunParallelSpeedTests("1.5s")
+
+	// Verify VPN stats
+	assert.Equal(t, 1, len(capturedStats))
+	assert.Contains(t, capturedStats[0].LocationName, "TestCountry")
+	assert.Equal(t, "1.5s", capturedStats[0].TimeToConnect)
+	assert.Contains(t, capturedStats[0].Mode, "parallel")
+}
+
+func TestAverageSpeedCalculation(t *testing.T) {
+	// Create a controlled test for the average calculation logic
+	// Create mock VPNStats with different speeds
+	stats := []results.VPNStat{
+		{
+			LocationName:     "TestCountry, TestCity",
+			TimeToConnect:    "1.5s",
+			VPNDownloadSpeed: "100Mbps",
+			VPNUploadSpeed:   "50Mbps",
+		},
+		{
+			LocationName:     "TestCountry, TestCity",
+			TimeToConnect:    "1.5s",
+			VPNDownloadSpeed: "200Mbps",
+			VPNUploadSpeed:   "150Mbps",
+		},
+		{
+			LocationName:     "TestCountry, TestCity",
+			TimeToConnect:    "1.5s",
+			VPNDownloadSpeed: "300Mbps",
+			VPNUploadSpeed:   "250Mbps",
+		},
+	}
+
+	// Calculate average (replicating logic from the function)
+	var totalDownload, totalUpload float64
+	var count int
+	var avgStat results.VPNStat
+
+	for _, stat := range stats {
+		downloadSpeed, _ := strconv.Atoi(strings.TrimSuffix(stat.VPNDownloadSpeed, "Mbps"))
+		uploadSpeed, _ := strconv.Atoi(strings.TrimSuffix(stat.VPNUploadSpeed, "Mbps"))
+		totalDownload += float64(downloadSpeed)
+		totalUpload += float64(uploadSpeed)
+		count++
+		avgStat = stat
+	}
+
+	if count > 0 {
+		avgStat.VPNDownloadSpeed = fmt.Sprintf("%.2fMbps", totalDownload/float64(count))
+		avgStat.VPNUploadSpeed = fmt.Sprintf("%.2fMbps", totalUpload/float64(count))
+	}
+
+	// Verify calculations
+	assert.Equal(t, "200.00Mbps", avgStat.VPNDownloadSpeed)
+	assert.Equal(t, "150.00Mbps", avgStat.VPNUploadSpeed)
+}
+
+func TestWriteSampleNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	runner := newTestRunner(t)
+	runner.SampleWriter, runner.Output = &buf, "ndjson"
+
+	result := speedtest.Result{}
+	result.Ping.Latency = 25.5
+	result.Download.Bandwidth = 125000000 // 1000 Mbps
+	result.Upload.Bandwidth = 62500000    // 500 Mbps
+	result.Server.Host = "test.speedtest.com"
+	result.Server.Country = "TestCountry"
+	result.Server.Location = "TestCity"
+
+	runner.writeSample(newSampleRecord(result, "", "Tests ran in parallel"))
+	runner.writeSample(newSampleRecord(result, "1.5s", "Tests ran in parallel"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	var baseline, vpn SampleRecord
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &baseline))
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &vpn))
+	assert.True(t, baseline.Baseline)
+	assert.False(t, vpn.Baseline)
+	assert.Equal(t, 1000.0, vpn.DownloadMbps)
+	assert.Equal(t, 500.0, vpn.UploadMbps)
+	assert.Equal(t, "1.5s", vpn.TimeToConnect)
+
+	// Nothing is streamed in text mode
+	buf.Reset()
+	runner.Output = "text"
+	runner.writeSample(newSampleRecord(result, "1.5s", "Tests ran in parallel"))
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestProgressFile(t *testing.T) {
+	runner := newTestRunner(t)
+	runner.ProgressFile = filepath.Join(t.TempDir(), "progress.json")
+
+	runner.startProgress(4)
+	runner.updateProgress("testing", "Netherlands, Amsterdam", 2)
+	runner.recordProgressResult(results.VPNStat{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "100.00Mbps"})
+
+	data, err := os.ReadFile(runner.ProgressFile)
+	assert.NoError(t, err)
+
+	var p Progress
+	assert.NoError(t, json.Unmarshal(data, &p))
+	assert.Equal(t, "testing", p.Phase)
+	assert.Equal(t, "Netherlands, Amsterdam", p.CurrentLocation)
+	assert.Equal(t, 2, p.Index)
+	assert.Equal(t, 4, p.Total)
+	assert.Equal(t, "100.00Mbps", p.LastResult.VPNDownloadSpeed)
+	for _, stamp := range []string{p.StartedAt, p.UpdatedAt} {
+		_, err := time.Parse(time.RFC3339, stamp)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasSuffix(stamp, "Z"), stamp)
+	}
+
+	runner.finishProgress()
+	data, _ = os.ReadFile(runner.ProgressFile)
+	assert.NoError(t, json.Unmarshal(data, &p))
+	assert.Equal(t, "done", p.Phase)
+	assert.Equal(t, 4, p.Index)
+}
+
+func TestEstimateRemaining(t *testing.T) {
+	assert.Equal(t, "", estimateRemaining(time.Minute, 0, 4))
+	assert.Equal(t, "3m0s", estimateRemaining(time.Minute, 1, 4))
+	assert.Equal(t, "1m0s", estimateRemaining(3*time.Minute, 3, 4))
+	assert.Equal(t, "", estimateRemaining(time.Minute, 4, 4))
+}
+
+func TestRoundResults(t *testing.T) {
+	data := results.Results{
+		MachineName: "TestMachine",
+		WithoutVPN:  "849Mbps ▼  845Mbps ▲",
+		Network:     &results.Network{PublicIP: "198.51.100.7", ISP: "Example Fiber"},
+		VPNStats: []results.VPNStat{
+			{
+				LocationName:      "Netherlands, Amsterdam",
+				VPNDownloadSpeed:  "397.00Mbps",
+				VPNUploadSpeed:    "254.50Mbps",
+				VPNLatency:        "35.74ms",
+				DownloadSamples:   []float64{391.2, 402.8},
+				UploadSamples:     []float64{254.5},
+				PercentOfBaseline: &results.PercentOfBaseline{Download: 46.8, Upload: 30.1},
+				SingleStream:      &results.SingleStream{VPNDownloadSpeed: "212.40Mbps", VPNUploadSpeed: "98.10Mbps", VPNLatency: "34.20ms"},
+				CrossCheck: &results.CrossCheck{Engine: "cloudflare", VPNDownloadSpeed: "421.30Mbps", VPNUploadSpeed: "253.90Mbps",
+					DownloadDifference: "+6.1%", UploadDifference: "-0.2%", Disagrees: false},
+				Soak: &results.Soak{Duration: "10m0s", Disconnects: 0, Samples: []results.SoakSample{
+					{Time: "2026-10-15T10:00:00Z", VPNDownloadSpeed: "388.70Mbps", VPNUploadSpeed: "241.20Mbps", VPNLatency: "36.51ms"},
+					{Time: "2026-10-15T10:01:00Z", Error: "speedtest failed"},
+				}},
+				Contention: &results.Contention{SumMbps: 803.46, BaselineMbps: 849.12, Decision: "fell back to 2 tests at once"},
+				ResultURLs: []string{"https://www.speedtest.net/result/c/8e2b7a1f"},
+			},
+		},
+	}
+
+	rounded := roundResults(data, 10)
+	assert.Equal(t, "850Mbps ▼  850Mbps ▲", rounded.WithoutVPN)
+	assert.Equal(t, "400Mbps", rounded.VPNStats[0].VPNDownloadSpeed)
+	assert.Equal(t, "250Mbps", rounded.VPNStats[0].VPNUploadSpeed)
+	assert.Equal(t, "36ms", rounded.VPNStats[0].VPNLatency)
+	assert.Equal(t, []float64{390, 400}, rounded.VPNStats[0].DownloadSamples)
+	assert.Equal(t, []float64{250}, rounded.VPNStats[0].UploadSamples)
+	assert.Equal(t, &results.PercentOfBaseline{Download: 47, Upload: 30}, rounded.VPNStats[0].PercentOfBaseline)
+	assert.Equal(t, &results.SingleStream{VPNDownloadSpeed: "210Mbps", VPNUploadSpeed: "100Mbps", VPNLatency: "34ms"}, rounded.VPNStats[0].SingleStream)
+	assert.Equal(t, &results.CrossCheck{Engine: "cloudflare", VPNDownloadSpeed: "420Mbps", VPNUploadSpeed: "250Mbps",
+		DownloadDifference: "+6%", UploadDifference: "+0%"}, rounded.VPNStats[0].CrossCheck)
+	assert.Equal(t, []results.SoakSample{
+		{Time: "2026-10-15T10:00:00Z", VPNDownloadSpeed: "390Mbps", VPNUploadSpeed: "240Mbps", VPNLatency: "37ms"},
+		{Time: "2026-10-15T10:01:00Z", Error: "speedtest failed"},
+	}, rounded.VPNStats[0].Soak.Samples)
+	assert.Equal(t, &results.Contention{SumMbps: 800, BaselineMbps: 850, Decision: "fell back to 2 tests at once"}, rounded.VPNStats[0].Contention)
+	assert.Nil(t, rounded.VPNStats[0].ResultURLs)
+	assert.Equal(t, &results.Network{ISP: "Example Fiber"}, rounded.Network)
+
+	// The precise values are left untouched
+	assert.Equal(t, "397.00Mbps", data.VPNStats[0].VPNDownloadSpeed)
+	assert.Equal(t, []float64{391.2, 402.8}, data.VPNStats[0].DownloadSamples)
+	assert.Equal(t, 46.8, data.VPNStats[0].PercentOfBaseline.Download)
+	assert.Equal(t, "212.40Mbps", data.VPNStats[0].SingleStream.VPNDownloadSpeed)
+	assert.Equal(t, "421.30Mbps", data.VPNStats[0].CrossCheck.VPNDownloadSpeed)
+	assert.Equal(t, "388.70Mbps", data.VPNStats[0].Soak.Samples[0].VPNDownloadSpeed)
+	assert.Equal(t, 803.46, data.VPNStats[0].Contention.SumMbps)
+	assert.Len(t, data.VPNStats[0].ResultURLs, 1)
+	assert.Equal(t, "198.51.100.7", data.Network.PublicIP)
+
+	assert.Equal(t, "397Mbps", roundSpeeds("397.00Mbps", 0))
+}
+
+func TestParseOSRelease(t *testing.T) {
+	alpine := `NAME="Alpine Linux"
+ID=alpine
+VERSION_ID=3.19.1
+PRETTY_NAME="Alpine Linux v3.19"
+`
+	assert.Equal(t, "Alpine Linux v3.19", parseOSRelease(alpine))
+
+	noPrettyName := `# Minimal image
+NAME=Arch
+VERSION="rolling"
+`
+	assert.Equal(t, "Arch rolling", parseOSRelease(noPrettyName))
+	assert.Equal(t, "", parseOSRelease(""))
+
+	if runtime.GOOS == "linux" {
+		defer func(orig string) { osReleaseFile = orig }(osReleaseFile)
+		osReleaseFile = filepath.Join(t.TempDir(), "os-release")
+		os.WriteFile(osReleaseFile, []byte(alpine), 0644)
+		assert.Equal(t, "Alpine Linux v3.19", GetOSVersion(command.Overrides{}))
+	}
+}
+
+func TestFormatWindowsVersion(t *testing.T) {
+	assert.Equal(t, "Windows 10 Pro 22H2 (10.0.19045.4529)", formatWindowsVersion("Windows 10 Pro", "22H2", "10.0.19045.4529", 19045))
+	// Windows 11 still calls itself Windows 10 in the registry
+	assert.Equal(t, "Windows 11 Pro 23H2 (10.0.22631.3880)", formatWindowsVersion("Windows 10 Pro", "23H2", "10.0.22631.3880", 22631))
+	assert.Equal(t, "Windows (10.0.22631)", formatWindowsVersion("", "", "10.0.22631", 22631))
+}
+
+func TestParsePacketLossAndJitter(t *testing.T) {
+	output := `{
+  "ping": {"jitter": 1.25, "latency": 12.5},
+  "download": {"bandwidth": 62500000},
+  "upload": {"bandwidth": 12500000},
+  "packetLoss": 0.5,
+  "server": {"host": "test.speedtest.com", "country": "TestCountry", "location": "TestCity"}
+}`
+
+	var result speedtest.Result
+	assert.NoError(t, json.Unmarshal([]byte(output), &result))
+	assert.Equal(t, 1.25, result.Ping.Jitter)
+	assert.Equal(t, 0.5, result.PacketLoss)
+
+	record := newSampleRecord(result, "1.5s", "Tests ran in parallel")
+	assert.Equal(t, 1.25, record.JitterMs)
+	assert.Equal(t, 0.5, record.PacketLoss)
+}
+
+func TestBenchmarkDNS(t *testing.T) {
+	origLookup := lookupHost
+	defer func() { lookupHost = origLookup }()
+
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host == "broken.invalid" {
+			return nil, fmt.Errorf("no such host")
+		}
+		time.Sleep(2 * time.Millisecond)
+		return []string{"192.0.2.1"}, nil
+	}
+
+	runner := newTestRunner(t)
+	result := runner.benchmarkDNS(context.Background(), []string{"example.com", "broken.invalid", " example.org "})
+	assert.True(t, strings.HasSuffix(result, "ms"))
+	ms, err := strconv.ParseFloat(strings.TrimSuffix(result, "ms"), 64)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, ms, 2.0)
+
+	assert.Equal(t, "", runner.benchmarkDNS(context.Background(), []string{"broken.invalid"}))
+}
+
+func TestMachineNameProbeOverride(t *testing.T) {
+	runner := newTestRunner(t)
+	hostname, _ := os.Hostname()
+	name, err := runner.machineName()
+	assert.NoError(t, err)
+	assert.Equal(t, hostname, name)
+
+	runner.ProbeName = "office-nyc-1"
+	name, err = runner.machineName()
+	assert.NoError(t, err)
+	assert.Equal(t, "office-nyc-1", name)
+}
+
+func TestVerifyExit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ip": "198.51.100.7", "country_name": "United States", "country_code": "US", "org": "Example Fiber"}`))
+	}))
+	defer server.Close()
+
+	runner := newTestRunner(t)
+	runner.IPCheckURL = server.URL
+
+	info, matches := runner.verifyExit(context.Background(), results.Location{Country: "USA"})
+	assert.Equal(t, "198.51.100.7", info.IP)
+	assert.Equal(t, "United States", info.Country)
+	assert.Equal(t, "Example Fiber", info.ISP)
+	assert.True(t, *matches)
+
+	_, matches = runner.verifyExit(context.Background(), results.Location{Country: "Netherlands", City: "Amsterdam"})
+	assert.False(t, *matches)
+
+	// The smart location has no country to compare with
+	info, matches = runner.verifyExit(context.Background(), results.Location{Country: "smart"})
+	assert.Equal(t, "198.51.100.7", info.IP)
+	assert.Nil(t, matches)
+
+	// ip-api.com style responses
+	ipAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"query": "203.0.113.9", "country": "Netherlands", "isp": "Example Cable"}`))
+	}))
+	defer ipAPIServer.Close()
+
+	info, err := fetchExitIP(context.Background(), ipAPIServer.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.9", info.IP)
+	assert.Equal(t, "Example Cable", info.ISP)
+	assert.True(t, countryMatches("Holland", info.Country))
+}
+
+func TestFindSmartRegion(t *testing.T) {
+	region, suggestions := newTestRunner(t).findRegion(nil, results.Location{Country: "Smart"})
+	assert.Equal(t, vpn.SmartLocation, region)
+	assert.Nil(t, suggestions)
+}
+
+func TestBaselineNetwork(t *testing.T) {
+	runner := newTestRunner(t)
+	assert.Nil(t, runner.baselineNetwork())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ip": "198.51.100.7", "country_name": "Romania", "org": "Example Fiber"}`))
+	}))
+	defer server.Close()
+	runner.IPCheckURL = server.URL
+
+	runner.lookupHomeNetwork()
+	result := baselineResult(112_500_000, 11_250_000)
+	result.ISP = "Example Fiber SRL"
+	result.Interface.ExternalIP = "198.51.100.8"
+	runner.recordBaseline(result)
+	assert.Equal(t, &results.Network{PublicIP: "198.51.100.7", ISP: "Example Fiber", SpeedtestISP: "Example Fiber SRL"}, runner.baselineNetwork())
+
+	// The speed test's view of the public IP fills in when the IP echo service is unreachable
+	runner = newTestRunner(t)
+	runner.IPCheckURL = "http://127.0.0.1:0"
+	runner.lookupHomeNetwork()
+	runner.recordBaseline(result)
+	assert.Equal(t, "198.51.100.8", runner.baselineNetwork().PublicIP)
+}
+
+// Returns a speed test result with the given bandwidths in bytes per second
+func baselineResult(download int64, upload int64) speedtest.Result {
+	var result speedtest.Result
+	result.Download.Bandwidth = download
+	result.Upload.Bandwidth = upload
+	return result
+}
+
+func TestRunnerBaseline(t *testing.T) {
+	runner := newTestRunner(t)
+	runner.Samples = 4
+
+	// Parallel baseline tests record their results concurrently; go test -race checks this
+	var wg sync.WaitGroup
+	for range runner.Samples {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runner.recordBaseline(baselineResult(112_500_000, 11_250_000))
+			runner.Baseline()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, "900.00Mbps ▼  90.00Mbps ▲", runner.Baseline())
+	assert.Equal(t, runner.Baseline(), runner.progress.WithoutVPN)
+}
+
+func TestCheckpointResume(t *testing.T) {
+	runner := newTestRunner(t)
+	runner.checkpointFile = filepath.Join(t.TempDir(), "results.json.checkpoint")
+	runner.checkpoint = Checkpoint{ResultsFile: "results.json"}
+
+	amsterdam := results.Location{Country: "Netherlands", City: "Amsterdam"}
+	bucharest := results.Location{Country: "Romania", City: "Bucharest"}
+	runner.markCompleted(amsterdam)
+
+	loaded, err := loadCheckpoint(runner.checkpointFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "results.json", loaded.ResultsFile)
+
+	runner.checkpoint = loaded
+	assert.True(t, runner.isCompleted(amsterdam))
+	assert.False(t, runner.isCompleted(bucharest))
+
+	runner.removeCheckpoint()
+	_, err = os.Stat(runner.checkpointFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRouterSwitchPrompts(t *testing.T) {
+	var prompts bytes.Buffer
+	runner := newTestRunner(t)
+	runner.RouterInput = strings.NewReader("\n\n")
+	runner.RouterPrompt = &prompts
+
+	assert.NoError(t, runner.waitForRouterBaseline())
+	connectTime, err := runner.waitForRouterSwitch(context.Background(), results.Location{Country: "Netherlands", City: "Amsterdam"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, manualConnectTime, connectTime)
+	assert.Contains(t, prompts.String(), "Turn off the VPN")
+	assert.Contains(t, prompts.String(), "Netherlands, Amsterdam")
+}
+
+func TestRouterSwitchCommands(t *testing.T) {
+	var prompts bytes.Buffer
+	runner := newTestRunner(t)
+	runner.RouterPrompt = &prompts
+
+	// The router's region is kept in a file, as a script driving its admin page would set it
+	state := filepath.Join(t.TempDir(), "region")
+	runner.RouterConnect = "echo {country}/{city} > " + state
+	runner.RouterDisconnect = "rm -f " + state
+	runner.RouterStatus = "test -f " + state
+
+	assert.NoError(t, runner.waitForRouterBaseline())
+	assert.NoFileExists(t, state)
+	connectTime, err := runner.waitForRouterSwitch(context.Background(), results.Location{Country: "Netherlands", City: "Amsterdam"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, manualConnectTime, connectTime, "The time the switch took is recorded")
+	_, err = time.ParseDuration(connectTime)
+	assert.NoError(t, err)
+	region, err := os.ReadFile(state)
+	assert.NoError(t, err)
+	assert.Equal(t, "Netherlands/Amsterdam\n", string(region))
+	assert.Empty(t, prompts.String(), "Nobody is asked to switch the router")
+
+	runner.RouterConnect = "false"
+	_, err = runner.waitForRouterSwitch(context.Background(), results.Location{Country: "Japan", City: "Tokyo"})
+	assert.Error(t, err)
+
+	// Switch commands may carry the router's credentials
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.String("router-connect", "", "")
+	assert.NoError(t, flags.Parse([]string{"-router-connect", "curl -u admin:secret http://192.168.1.1/switch"}))
+	runner.Flags, runner.Router = flags, true
+	assert.Equal(t, []string{"-router-connect=REDACTED"}, runner.newRunInfo().Flags)
+}
+
+func TestRouterSkipKeepsLocalVPN(t *testing.T) {
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	// A skipped location leaves the router's VPN alone and doesn't touch the local client
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "expressvpnctl")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+calls+"\n"), 0755))
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"expressvpnctl": {Path: script}}
+	runner.Router, runner.RouterInput, runner.RouterPrompt = true, strings.NewReader("\n"), io.Discard
+	runner.skipRequested.Store(true)
+
+	runner.testLocations(context.Background(), []results.Location{{Country: "Japan", City: "Tokyo"}})
+	assert.Equal(t, []string{"Japan, Tokyo"}, runner.skippedLocations)
+	assert.NoFileExists(t, calls)
+}
+
+func TestTopMovers(t *testing.T) {
+	previous := results.Results{VPNStats: []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "400.00Mbps"},
+		{LocationName: "Romania, Bucharest", VPNDownloadSpeed: "300.00Mbps"},
+		{LocationName: "Canada, Toronto", VPNDownloadSpeed: "200.00Mbps"},
+	}}
+	current := results.Results{VPNStats: []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "200.00Mbps"},
+		{LocationName: "Romania, Bucharest", VPNDownloadSpeed: "330.00Mbps"},
+		{LocationName: "Canada, Toronto", VPNDownloadSpeed: "300.00Mbps"},
+		{LocationName: "Japan, Tokyo", VPNDownloadSpeed: "100.00Mbps"},
+	}}
+
+	improved, degraded := topMovers(previous, current, 1)
+	assert.Equal(t, 1, len(improved))
+	assert.Equal(t, "Canada, Toronto", improved[0].LocationName)
+	assert.InDelta(t, 50.0, improved[0].DeltaPercent, 0.01)
+	assert.Equal(t, 1, len(degraded))
+	assert.Equal(t, "Netherlands, Amsterdam", degraded[0].LocationName)
+
+	summary := Display{}.topMoversSummary(improved, degraded)
+	assert.Contains(t, summary, "Canada, Toronto")
+	assert.Contains(t, summary, "-50.0%")
+}
+
+func TestFindPreviousResults(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"results-20250301000000.json", "results-20250302000000.json", "results-20250304000000.json"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644))
+	}
+
+	assert.Equal(t, filepath.Join(dir, "results-20250302000000.json"), findPreviousResults(filepath.Join(dir, "results-20250303000000.json")))
+	assert.Equal(t, "", findPreviousResults(filepath.Join(dir, "results-20250201000000.json")))
+
+	// Only default names are compared, of runs forced to the same IP version
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "results-20250303000000-ipv6.json"), []byte("{}"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "results-custom.json"), []byte("{}"), 0644))
+	assert.Equal(t, filepath.Join(dir, "results-20250302000000.json"), findPreviousResults(filepath.Join(dir, "results-20250303120000.json")))
+	assert.Equal(t, filepath.Join(dir, "results-20250303000000-ipv6.json"), findPreviousResults(filepath.Join(dir, "results-20250305000000-ipv6.json")))
+	assert.Equal(t, "", findPreviousResults(filepath.Join(dir, "results-zzz.json")), "A -results name has no timestamp to order by")
+}
+
+func TestTopMoversAppended(t *testing.T) {
+	// -append with a -results name: the run is compared with the previous run in the same file, not every run in it
+	runner := newTestRunner(t)
+	runner.ResultsFile = filepath.Join(t.TempDir(), "office.json")
+	runner.runID, runner.TopMovers = "20250303000000", 1
+	data := results.Results{
+		Runs: []results.Run{{ID: "20250301000000"}, {ID: "20250302000000"}, {ID: runner.runID}},
+		VPNStats: []results.VPNStat{
+			{RunID: "20250301000000", LocationName: "Canada, Toronto", VPNDownloadSpeed: "900.00Mbps"},
+			{RunID: "20250302000000", LocationName: "Canada, Toronto", VPNDownloadSpeed: "100.00Mbps"},
+			{RunID: runner.runID, LocationName: "Canada, Toronto", VPNDownloadSpeed: "150.00Mbps"},
+		},
+	}
+	assert.NoError(t, runner.Files.Save(data, runner.ResultsFile))
+	summary := runner.currentTopMovers()
+	assert.Contains(t, summary, "Canada, Toronto")
+	assert.Contains(t, summary, "+50.0%", "Compared with 100Mbps, without pooling the older runs")
+
+	// The first run of a file with another name has nothing to compare with
+	data.Runs, data.VPNStats = data.Runs[2:], data.VPNStats[2:]
+	assert.NoError(t, runner.Files.Save(data, runner.ResultsFile))
+	assert.Equal(t, "", runner.currentTopMovers())
+}
+
+func TestLocationSettingsOverrides(t *testing.T) {
+	var input results.InputData
+	err := json.Unmarshal([]byte(`{"locations": [
+		{"country": "Netherlands", "city": "Amsterdam", "samples": 10, "parallel": false},
+		{"country": "Romania", "city": "Bucharest", "samples": 1},
+		{"country": "Canada", "city": "Toronto"}
+	]}`), &input)
+	assert.NoError(t, err)
+
+	samples, parallel := locationSettings(input.Locations[0], 5, true)
+	assert.Equal(t, 10, samples)
+	assert.False(t, parallel)
+
+	samples, parallel = locationSettings(input.Locations[1], 5, true)
+	assert.Equal(t, 1, samples)
+	assert.True(t, parallel)
+
+	samples, parallel = locationSettings(input.Locations[2], 5, false)
+	assert.Equal(t, 5, samples)
+	assert.False(t, parallel)
+}
+
+func TestBuildPlan(t *testing.T) {
+	runner := newTestRunner(t)
+	runner.regionAliases = map[string]string{"Netherlands, Amsterdam": "netherlands-amsterdam", "Romania, Bucharest": "romania"}
+
+	series := false
+	locations := []results.Location{
+		{Country: "Netherlands", City: "Amsterdam", Samples: 2, Parallel: &series},
+		{Country: "Romania", City: "Bucharest"},
+	}
+
+	plan := runner.BuildPlan(locations)
+	assert.Equal(t, 2, len(plan.Locations))
+	assert.Equal(t, "netherlands-amsterdam", plan.Locations[0].Region)
+	assert.Equal(t, 2, plan.Locations[0].Samples)
+	assert.False(t, plan.Locations[0].Parallel)
+	assert.Equal(t, 5, plan.Locations[1].Samples)
+	assert.True(t, plan.Locations[1].Parallel)
+
+	// Baseline and the parallel location take one test slot each, the series location two with a pause
+	// between them, plus two connects each preceded by a pause
+	expected := 4*estimatedTestTime + runner.PauseBetweenTests + 2*(estimatedConnectTime+runner.PauseBetweenLocations)
+	assert.Equal(t, expected.String(), plan.EstimatedDuration)
+	assert.Equal(t, 12*estimatedMBPerTest, plan.EstimatedDataMB)
+
+	fileName := filepath.Join(t.TempDir(), "plan.json")
+	assert.NoError(t, SavePlan(fileName, plan))
+	loaded, err := LoadPlan(fileName)
+	assert.NoError(t, err)
+
+	replayed := loaded.Input()
+	assert.Equal(t, 2, len(replayed.Locations))
+	assert.Equal(t, "romania", vpn.AliasRegion(replayed.Locations[1], replayed.Aliases))
+	samples, parallel := locationSettings(replayed.Locations[0], 5, true)
+	assert.Equal(t, 2, samples)
+	assert.False(t, parallel)
+}
+
+func TestWarmUp(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "speedtest")
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho run >> "+calls+"\necho '{}'\n"), 0755)
+	assert.NoError(t, err)
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"speedtest": {Path: script}}
+
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	var pauses []time.Duration
+	sleep = func(d time.Duration) { pauses = append(pauses, d) }
+
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	runner.warmUp(context.Background(), 0)
+	_, err = os.Stat(calls)
+	assert.True(t, os.IsNotExist(err))
+
+	runner.warmUp(context.Background(), 2)
+	data, err := os.ReadFile(calls)
+	assert.NoError(t, err)
+	assert.Equal(t, "run\nrun\n", string(data))
+	assert.Equal(t, 2, len(pauses))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runner.warmUp(ctx, 2)
+	data, err = os.ReadFile(calls)
+	assert.NoError(t, err)
+	assert.Equal(t, "run\nrun\n", string(data), "No warm-up runs once the location is out of time")
+}
+
+func TestPause(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	var pauses []time.Duration
+	sleep = func(d time.Duration) { pauses = append(pauses, d) }
+
+	for range 100 {
+		pause(4*time.Second, "test")
+	}
+	assert.Equal(t, 100, len(pauses))
+	for _, d := range pauses {
+		assert.GreaterOrEqual(t, d, 3*time.Second)
+		assert.LessOrEqual(t, d, 5*time.Second)
+	}
+
+	// A zero pause disables pacing entirely
+	pause(0, "test")
+	assert.Equal(t, 100, len(pauses))
+}
+
+func TestDetectConflicts(t *testing.T) {
+	origList := listInterfaces
+	defer func() { listInterfaces = origList }()
+
+	listInterfaces = func() ([]net.Interface, error) {
+		return []net.Interface{
+			{Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+			{Name: "eth0", Flags: net.FlagUp},
+			{Name: "wg0", Flags: net.FlagUp},
+			{Name: "tun1"}, // Down
+			{Name: "tailscale0", Flags: net.FlagUp},
+		}, nil
+	}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "tailscale")
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho '{\"ExitNodeStatus\": {\"TailscaleIPs\": [\"100.64.0.1\"]}}'\n"), 0755)
+	assert.NoError(t, err)
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"tailscale": {Path: script}}
+
+	assert.Equal(t, []string{"WireGuard tunnel: wg0", "Tailscale exit node active"}, runner.detectConflicts())
+
+	// Tailscale without an exit node, or not installed at all, is not a conflict
+	err = os.WriteFile(script, []byte("#!/bin/sh\necho '{\"ExitNodeStatus\": null}'\n"), 0755)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"WireGuard tunnel: wg0"}, runner.detectConflicts())
+
+	runner.Commands = command.Overrides{"tailscale": {Path: filepath.Join(dir, "missing")}}
+	assert.Equal(t, []string{"WireGuard tunnel: wg0"}, runner.detectConflicts())
+}
+
+func TestResultSinks(t *testing.T) {
+	_, err := ParseSink("xml:a.xml", results.FileOptions{}, Display{})
+	assert.Error(t, err)
+	_, err = ParseSink("csv:", results.FileOptions{}, Display{})
+	assert.Error(t, err)
+
+	var posted []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		json.NewDecoder(r.Body).Decode(&payload)
+		posted = append(posted, payload)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "results.csv")
+	jsonFile := filepath.Join(dir, "copy.json")
+
+	runner := newTestRunner(t)
+	for _, output := range []string{"csv:" + csvFile, "json:" + jsonFile, "webhook:" + server.URL} {
+		sink, err := ParseSink(output, runner.Files, runner.Display)
+		assert.NoError(t, err)
+		runner.Sinks = append(runner.Sinks, sink)
+	}
+
+	data := results.Results{MachineName: "probe-1", WithoutVPN: "500Mbps ▼  100Mbps ▲"}
+	for _, stat := range []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "400.00Mbps"},
+		{LocationName: "Romania, Bucharest", VPNDownloadSpeed: "380.00Mbps"},
+	} {
+		data.VPNStats = append(data.VPNStats, stat)
+		runner.writeToSinks(data, stat)
+	}
+
+	rows, err := csv.NewReader(mustOpen(t, csvFile)).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(rows))
+	assert.Equal(t, csvHeader, rows[0])
+	assert.Equal(t, "Romania, Bucharest", rows[2][1])
+	assert.Equal(t, "380.00Mbps", rows[2][3])
+
+	copied, err := results.Load(jsonFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(copied.VPNStats))
+
+	assert.Equal(t, 2, len(posted))
+	assert.Equal(t, "probe-1", posted[0]["MachineName"])
+}
+
+func mustOpen(t *testing.T, fileName string) *os.File {
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestAssertions(t *testing.T) {
+	var input results.InputData
+	err := json.Unmarshal([]byte(`{"locations": [
+		{"country": "Netherlands", "city": "Amsterdam", "minDownloadMbps": 200, "maxLatencyMs": 60, "maxPacketLoss": 0},
+		{"country": "Romania", "city": "Bucharest"}
+	]}`), &input)
+	assert.NoError(t, err)
+
+	stat := results.VPNStat{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "150.50Mbps", VPNUploadSpeed: "90.00Mbps",
+		VPNLatency: "45.20ms", VPNJitter: "1.00ms", VPNPacketLoss: "0.25%"}
+
+	runner := newTestRunner(t)
+	runner.applyAssertions(input.Locations[0], &stat)
+	assert.False(t, *stat.AssertionsPassed)
+	assert.Equal(t, []string{"download 150.50Mbps < 200Mbps", "packet loss 0.25% > 0%"}, stat.AssertionFailures)
+	assert.Equal(t, 1, runner.assertionFailures)
+
+	// Locations without assertions are neither passed nor failed
+	other := results.VPNStat{VPNDownloadSpeed: "10.00Mbps"}
+	runner.applyAssertions(input.Locations[1], &other)
+	assert.Nil(t, other.AssertionsPassed)
+	assert.Equal(t, 1, runner.assertionFailures)
+
+	stat.VPNDownloadSpeed = "250.00Mbps"
+	stat.VPNPacketLoss = "0.00%"
+	runner.applyAssertions(input.Locations[0], &stat)
+	assert.True(t, *stat.AssertionsPassed)
+	assert.Empty(t, stat.AssertionFailures)
+}
+
+func TestHTMLReportAndBundle(t *testing.T) {
+	passed, failed := true, false
+	data := results.Results{
+		MachineName: "probe-1",
+		WithoutVPN:  "500Mbps ▼  100Mbps ▲",
+		VPNStats: []results.VPNStat{
+			{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "400.00Mbps", VPNUploadSpeed: "200.00Mbps", AssertionsPassed: &passed},
+			{LocationName: "Romania, Bucharest <b>", VPNDownloadSpeed: "200.00Mbps", VPNUploadSpeed: "100.00Mbps",
+				AssertionsPassed: &failed, AssertionFailures: []string{"download 200.00Mbps < 300Mbps"}},
+		},
+	}
+
+	bars := chartBars(data)
+	assert.Equal(t, chartBarWidth, bars[0].DownloadWidth)
+	assert.Equal(t, chartBarWidth/4, bars[1].UploadWidth)
+
+	var report bytes.Buffer
+	assert.NoError(t, Display{}.RenderHTMLReport(&report, data))
+	html := report.String()
+	assert.Contains(t, html, "<style>body {")
+	assert.Contains(t, html, "Netherlands, Amsterdam")
+	assert.Contains(t, html, "Romania, Bucharest &lt;b&gt;")
+	assert.Contains(t, html, "download 200.00Mbps &lt; 300Mbps")
+	assert.NotContains(t, html, "http://")
+	assert.NotContains(t, html, "https://")
+	assert.NotContains(t, html, "<script")
+	assert.Regexp(t, `generated \d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ`, html)
+
+	fileName := filepath.Join(t.TempDir(), "bundle.zip")
+	assert.NoError(t, writeBundle(fileName, map[string][]byte{"report.html": report.Bytes(), "run.log": []byte("log")}))
+
+	archive, err := zip.OpenReader(fileName)
+	assert.NoError(t, err)
+	defer archive.Close()
+	assert.Equal(t, 2, len(archive.File))
+	assert.Equal(t, "report.html", archive.File[0].Name)
+	assert.Equal(t, "run.log", archive.File[1].Name)
+}
+
+func TestNotifyRun(t *testing.T) {
+	var payloads []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+	}))
+	defer server.Close()
+
+	runner := newTestRunner(t)
+	runner.NotifyURL = server.URL
+	runner.ProbeName = "probe-1"
+	runner.progress = Progress{Total: 3, WithoutVPN: "500Mbps ▼  100Mbps ▲"}
+	runner.testedLocations = 2
+	runner.recordFailure(results.Location{Country: "France", City: "Paris"}, "no matching region")
+
+	runner.notifyRun("aborted", "Failed to read input file", "")
+	runner.notifyRun("completed", "", "")
+
+	// Only the first outcome is reported
+	assert.Equal(t, 1, len(payloads))
+	text := payloads[0]["text"]
+	assert.Contains(t, text, "VPN speed test run on probe-1 aborted: Failed to read input file")
+	assert.Contains(t, text, "Tested 2 of 3 locations")
+	assert.Contains(t, text, "France, Paris: no matching region")
+
+	payload, err := notifyPayload("https://discord.com/api/webhooks/1/abc", "done")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"content": "done"}`, string(payload))
+}
+
+func TestExitCode(t *testing.T) {
+	runner := newTestRunner(t)
+	assert.Equal(t, ExitOK, runner.ExitCode())
+
+	runner.assertionFailures = 1
+	assert.Equal(t, ExitAssertionsFailed, runner.ExitCode())
+
+	runner.recordFailure(results.Location{Country: "France", City: "Paris"}, "failed to connect")
+	assert.Equal(t, ExitLocationsFailed, runner.ExitCode())
+	assert.False(t, runner.shouldStop())
+	runner.FailFast = true
+	assert.True(t, runner.shouldStop())
+
+	runner.baselineFailed = true
+	assert.Equal(t, ExitBaselineFailed, runner.ExitCode())
+}
+
+func TestCrossCheck(t *testing.T) {
+	runner := newTestRunner(t)
+	runner.Engine, runner.CrossCheck = "ookla", "native"
+
+	minDownload := 300.0
+	location := results.Location{Country: "Netherlands", City: "Amsterdam"}
+	stat := results.VPNStat{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "400.00Mbps", VPNUploadSpeed: "200.00Mbps"}
+
+	check := runner.compareEngines(location, stat, 360, 210)
+	assert.Equal(t, "-10.0%", check.DownloadDifference)
+	assert.Equal(t, "+5.0%", check.UploadDifference)
+	assert.False(t, check.Disagrees)
+
+	// Within tolerance, but the engines reach a different assertion verdict
+	location.Assertions.MinDownloadMbps = &minDownload
+	assert.True(t, runner.compareEngines(location, stat, 290, 210).Disagrees)
+
+	assert.True(t, runner.compareEngines(results.Location{}, stat, 250, 210).Disagrees)
+
+	data := results.Results{VPNStats: []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "400.00Mbps", CrossCheck: &results.CrossCheck{Engine: "native", VPNDownloadSpeed: "250.00Mbps", DownloadDifference: "-37.5%", Disagrees: true}},
+		{LocationName: "Romania, Bucharest", VPNDownloadSpeed: "380.00Mbps", CrossCheck: &results.CrossCheck{Engine: "native", VPNDownloadSpeed: "370.00Mbps"}},
+	}}
+	summary := runner.crossCheckSummary(data)
+	assert.Contains(t, summary, "! Netherlands, Amsterdam: ookla 400.00Mbps vs native 250.00Mbps (-37.5%)")
+	assert.NotContains(t, summary, "! Romania")
+	assert.Contains(t, summary, "fastest region: Netherlands, Amsterdam (ookla) vs Romania, Bucharest (native)")
+}
+
+func TestSoak(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+
+	dir := t.TempDir()
+	speedtest := filepath.Join(dir, "speedtest")
+	err := os.WriteFile(speedtest, []byte(`#!/bin/sh
+echo '{"ping": {"latency": 20.5}, "download": {"bandwidth": 50000000}, "upload": {"bandwidth": 25000000}}'
+`), 0755)
+	assert.NoError(t, err)
+
+	// Drops the connection on the second poll, then reconnects
+	expressvpnctl := filepath.Join(dir, "expressvpnctl")
+	err = os.WriteFile(expressvpnctl, []byte(`#!/bin/sh
+echo x >> `+filepath.Join(dir, "polls")+`
+case $(wc -l < `+filepath.Join(dir, "polls")+` | tr -d ' ') in
+  2) echo Reconnecting ;;
+  *) echo Connected ;;
+esac
+`), 0755)
+	assert.NoError(t, err)
+
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"speedtest": {Path: speedtest}, "expressvpnctl": {Path: expressvpnctl}}
+	runner.provider = runner.expressVPN()
+	sleep = func(d time.Duration) { time.Sleep(time.Millisecond) }
+	runner.SoakInterval = time.Hour
+
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	soak := runner.runSoak(context.Background(), 100*time.Millisecond)
+	assert.Equal(t, "100ms", soak.Duration)
+	assert.Equal(t, 1, len(soak.Samples))
+	assert.Equal(t, "400.00Mbps", soak.Samples[0].VPNDownloadSpeed)
+	assert.Equal(t, "20.50ms", soak.Samples[0].VPNLatency)
+	assert.Equal(t, 1, soak.Disconnects)
+	assert.Equal(t, 2, len(soak.StateChanges))
+	assert.Equal(t, "Reconnecting", soak.StateChanges[0].State)
+	assert.Equal(t, "Connected", soak.StateChanges[1].State)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	soak = runner.runSoak(ctx, time.Hour)
+	assert.Empty(t, soak.Samples, "A done context ends the soak right away")
+}
+
+func TestConnectCycles(t *testing.T) {
+	times := summarizeConnectTimes([]time.Duration{1200 * time.Millisecond, 800 * time.Millisecond, 1600 * time.Millisecond})
+	assert.Equal(t, 3, times.Cycles)
+	assert.Equal(t, "800ms", times.Min)
+	assert.Equal(t, "1.2s", times.Avg)
+	assert.Equal(t, "1.6s", times.Max)
+	assert.Nil(t, summarizeConnectTimes(nil))
+
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "expressvpnctl")
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$1\" >> "+calls+"\n[ \"$1\" = get ] && echo Connected\nexit 0\n"), 0755)
+	assert.NoError(t, err)
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"expressvpnctl": {Path: script}}
+	runner.provider = runner.expressVPN()
+
+	durations, err := runner.connectCycle(context.Background(), "netherlands-amsterdam", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(durations))
+
+	// Stays connected after the last cycle
+	data, err := os.ReadFile(calls)
+	assert.NoError(t, err)
+	assert.Equal(t, "connect\nget\ndisconnect\nconnect\nget\ndisconnect\nconnect\nget\n", string(data))
+}
+
+func TestSpeedUnits(t *testing.T) {
+	// 100 kB/s used to be truncated to 0Mbps
+	assert.Equal(t, 0.8, bytesToMbps(100000))
+	assert.Equal(t, 1000.0, bytesToMbps(125000000))
+
+	assert.Equal(t, "0.80Mbps", Display{Unit: "Mbps"}.FormatSpeed(0.8))
+	megabytes := Display{Unit: "MB/s"}
+	assert.Equal(t, "50.00MB/s", megabytes.FormatSpeed(400))
+	assert.Equal(t, "50.00MB/s ▼  12.50MB/s ▲", megabytes.DisplaySpeeds("400.00Mbps ▼  100Mbps ▲"))
+	assert.Equal(t, "0.940Gbps", Display{Unit: "Gbps"}.displaySpeed("940.00Mbps"))
+}
+
+func TestGeoEnrichment(t *testing.T) {
+	// Amsterdam to New York is about 5860km
+	assert.InDelta(t, 5860, greatCircleKm(52.37, 4.90, 40.71, -74.01), 20)
+	assert.Equal(t, 0.0, greatCircleKm(52.37, 4.90, 52.37, 4.90))
+
+	traceroute := `traceroute to 1.1.1.1 (1.1.1.1), 30 hops max, 60 byte packets
+ 1  10.8.0.1  12.345 ms
+ 2  *
+ 3  185.1.2.3  20.1 ms
+12  1.1.1.1  25.0 ms
+`
+	assert.Equal(t, 12, parseHopCount(traceroute))
+	assert.Equal(t, 0, parseHopCount(""))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"query": "198.51.100.7", "country": "United States", "lat": 40.71, "lon": -74.01}`))
+	}))
+	defer server.Close()
+
+	runner := newTestRunner(t)
+	runner.IPCheckURL = server.URL
+	runner.homeLocation = &ExitIPInfo{Latitude: 52.37, Longitude: 4.90}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "traceroute")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nprintf ' 1  10.8.0.1\\n 2  1.1.1.1\\n'\n"), 0755))
+	runner.Commands = command.Overrides{"traceroute": {Path: script}}
+
+	var stat results.VPNStat
+	runner.enrichGeo(&stat, ExitIPInfo{})
+	assert.Equal(t, 2, stat.HopCount)
+	assert.InDelta(t, 5860, stat.ExitDistanceKm, 20)
+}
+
+func TestTUI(t *testing.T) {
+	runner := newTestRunner(t)
+	runner.tui = &tui{r: runner, current: -1, rows: []tuiRow{{Location: "Germany, Berlin", Status: "pending"}, {Location: "Japan, Tokyo", Status: "pending"}}}
+	runner.tuiUpdatePhase("testing", 1)
+	runner.tuiAddSample(SampleRecord{LocationName: "Germany, Berlin", DownloadMbps: 400, UploadMbps: 90, LatencyMs: 21})
+	runner.tuiSetResult(results.VPNStat{VPNDownloadSpeed: "400.00Mbps", VPNUploadSpeed: "90.00Mbps", VPNLatency: "21.00ms"})
+
+	screen := runner.tui.render()
+	assert.Contains(t, screen, "testing")
+	assert.Contains(t, screen, "Germany, Berlin: 400.00Mbps down, 90.00Mbps up, 21ms")
+	assert.Contains(t, screen, "[s] skip location  [q] abort")
+	assert.NotContains(t, strings.ReplaceAll(screen, "\r\n", ""), "\n", "raw mode needs carriage returns")
+	assert.Equal(t, tuiRow{Location: "Germany, Berlin", Status: "done", Download: "400.00Mbps", Upload: "90.00Mbps", Latency: "21.00ms"}, runner.tui.rows[0])
+
+	tokyo := results.Location{Country: "Japan", City: "Tokyo"}
+	assert.False(t, runner.skipCurrentLocation(tokyo))
+
+	runner.readKeys(strings.NewReader("xs"))
+	assert.True(t, runner.skipRequested.Load())
+	assert.True(t, runner.skipCurrentLocation(tokyo))
+	assert.False(t, runner.skipRequested.Load(), "a skip applies to one location")
+	assert.Equal(t, "skipped", runner.tui.rows[1].Status)
+	assert.Equal(t, []string{"Japan, Tokyo"}, runner.skippedLocations)
+
+	runner.readKeys(strings.NewReader("q"))
+	assert.True(t, runner.abortRequested.Load())
+	assert.Contains(t, runner.tui.render(), "Aborting after the current step")
+	assert.Equal(t, ExitLocationsFailed, runner.ExitCode())
+}
+
+func TestAppendRuns(t *testing.T) {
+	runner := newTestRunner(t)
+	resultsFile := runner.ResultsFile
+	runner.runID = "20250301080000"
+	runner.recordBaseline(baselineResult(112_500_000, 11_250_000))
+	runner.writeToFile(results.VPNStat{LocationName: "Netherlands, Amsterdam"})
+	runner.writeToFile(results.VPNStat{LocationName: "Romania, Bucharest"})
+
+	runner = newTestRunner(t)
+	runner.ResultsFile, runner.runID = resultsFile, "20250302080000"
+	runner.recordBaseline(baselineResult(62_500_000, 6_250_000))
+	runner.writeToFile(results.VPNStat{LocationName: "Netherlands, Amsterdam"})
+
+	data, err := results.Load(resultsFile)
+	assert.NoError(t, err)
+	assert.Len(t, data.Runs, 2)
+	assert.Equal(t, "900.00Mbps ▼  90.00Mbps ▲", data.Runs[0].WithoutVPN)
+	assert.Equal(t, "500.00Mbps ▼  50.00Mbps ▲", data.WithoutVPN)
+	assert.True(t, data.HasRun("20250301080000"))
+	assert.Len(t, data.VPNStats, 3)
+	assert.Equal(t, "20250301080000", data.VPNStats[1].RunID)
+	assert.Equal(t, "20250302080000", data.VPNStats[2].RunID)
+}
+
+func TestPercentOfBaseline(t *testing.T) {
+	runner := newTestRunner(t)
+	resultsFile := runner.ResultsFile
+	runner.runID = "20250301080000"
+	runner.recordBaseline(baselineResult(112_500_000, 11_250_000))
+	runner.writeToFile(results.VPNStat{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "765.00Mbps", VPNUploadSpeed: "83.00Mbps"})
+	runner.writeToFile(results.VPNStat{LocationName: "Romania, Bucharest", VPNLatency: "45.00ms"})
+
+	// A resumed run compares with the baseline already in the file
+	runner = newTestRunner(t)
+	runner.ResultsFile, runner.runID = resultsFile, "20250301080000"
+	runner.writeToFile(results.VPNStat{LocationName: "Japan, Tokyo", VPNDownloadSpeed: "300.00Mbps"})
+
+	data, err := results.Load(resultsFile)
+	assert.NoError(t, err)
+	assert.Equal(t, &results.PercentOfBaseline{Download: 85, Upload: 92.2}, data.VPNStats[0].PercentOfBaseline)
+	assert.Nil(t, data.VPNStats[1].PercentOfBaseline)
+	assert.Equal(t, &results.PercentOfBaseline{Download: 33.3}, data.VPNStats[2].PercentOfBaseline)
+
+	assert.Equal(t, "85% ▼  92% ▲", displayPercentOfBaseline(data.VPNStats[0].PercentOfBaseline))
+	assert.Equal(t, "33% ▼", displayPercentOfBaseline(data.VPNStats[2].PercentOfBaseline))
+	assert.Equal(t, "-", displayPercentOfBaseline(nil))
+	assert.Equal(t, "85% ▼  92% ▲", Display{}.ReportTable(data)[1][4])
+}
+
+func TestBaselineProfiles(t *testing.T) {
+	// A laptop measured on Ethernet in the morning and on Wi-Fi in the afternoon
+	runner := newTestRunner(t)
+	resultsFile := runner.ResultsFile
+	runner.runID, runner.NetworkProfile = "20250301080000", "wired"
+	runner.recordBaseline(baselineResult(112_500_000, 11_250_000))
+	runner.writeToFile(results.VPNStat{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "450.00Mbps", VPNUploadSpeed: "45.00Mbps"})
+
+	runner = newTestRunner(t)
+	runner.ResultsFile, runner.runID, runner.NetworkProfile = resultsFile, "20250301140000", "wifi"
+	runner.recordBaseline(baselineResult(37_500_000, 3_750_000))
+	runner.writeToFile(results.VPNStat{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "150.00Mbps", VPNUploadSpeed: "15.00Mbps"})
+
+	data, err := results.Load(resultsFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "wired", data.Runs[0].Network.Profile)
+	assert.Equal(t, "wifi", data.Runs[1].Network.Profile)
+	assert.Equal(t, &results.PercentOfBaseline{Profile: "wired", Download: 50, Upload: 50}, data.VPNStats[0].PercentOfBaseline)
+	assert.Equal(t, &results.PercentOfBaseline{Profile: "wifi", Download: 50, Upload: 50}, data.VPNStats[1].PercentOfBaseline)
+	wired, ok := data.ProfileBaseline("wired")
+	assert.True(t, ok)
+	assert.Equal(t, "900.00Mbps ▼  90.00Mbps ▲", wired.WithoutVPN)
+	_, ok = data.ProfileBaseline("lte")
+	assert.False(t, ok)
+
+	// Compared with the wired baseline instead
+	runner.BaselineProfile = "wired"
+	assert.Equal(t, &results.PercentOfBaseline{Profile: "wired", Download: 16.7, Upload: 16.7}, PercentOfBaseline(data, data.VPNStats[1], runner.BaselineProfile))
+	assert.Equal(t, "17% ▼  17% ▲  of wired", displayPercentOfBaseline(PercentOfBaseline(data, data.VPNStats[1], runner.BaselineProfile)))
+	assert.NoError(t, runner.checkBaselineProfile())
+	runner.BaselineProfile = "lte"
+	assert.Error(t, runner.checkBaselineProfile())
+}
+
+func TestRunInfo(t *testing.T) {
+	runner := newTestRunner(t)
+	runner.Router = true // Keeps expressvpnctl from being asked for its version
+
+	runner.Flags = flag.NewFlagSet("run", flag.ContinueOnError)
+	runner.Flags.Int("r", 5, "")
+	runner.Flags.Bool("s", false, "")
+	assert.NoError(t, runner.Flags.Parse([]string{"-r", "3"}))
+
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, runner.runUUID)
+	assert.NotEqual(t, runner.runUUID, NewUUID())
+	runner.Tags = map[string]string{"office": "berlin", "link": "fiber"}
+	runner.ToolVersion, runner.ToolCommit, runner.ToolBuildDate = "1.2.3", "4f1c2a9", "2025-02-28T09:12:44Z"
+
+	started := time.Date(2025, 3, 1, 8, 0, 0, 0, time.FixedZone("CET", 3600))
+	runner.started = started
+	info := runner.newRunInfo()
+	assert.Equal(t, "1.2.3", info.ToolVersion)
+	assert.Equal(t, "4f1c2a9", info.ToolCommit)
+	assert.Equal(t, "2025-02-28T09:12:44Z", info.ToolBuildDate)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+	assert.Equal(t, runner.runUUID, info.UUID)
+	assert.Equal(t, map[string]string{"office": "berlin", "link": "fiber"}, info.Tags)
+	assert.Equal(t, []string{"-r=3"}, info.Flags)
+	assert.Equal(t, "2025-03-01T07:00:00Z", info.Started)
+
+	secrets := flag.NewFlagSet("run", flag.ContinueOnError)
+	secrets.String("output", "", "")
+	secrets.String("push-url", "", "")
+	assert.NoError(t, secrets.Parse([]string{"-output", "ndjson,csv:a.csv,webhook:https://example.com/hook?token=s3cret", "-push-url", "https://collector.example.com/?token=s3cret"}))
+	runner.Flags = secrets
+	assert.Equal(t, []string{"-output=ndjson,csv:a.csv,webhook:REDACTED", "-push-url=REDACTED"}, runner.newRunInfo().Flags)
+
+	runner.runID, runner.runInfo = "20250301080000", info
+	runner.writeToFile(results.VPNStat{LocationName: "Netherlands, Amsterdam"})
+	runner.finishRunInfo(started.Add(90 * time.Minute))
+
+	data, err := results.Load(runner.ResultsFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "2025-03-01T08:30:00Z", data.Runs[0].Finished)
+	assert.Equal(t, "1h30m0s", data.Runs[0].Duration)
+	assert.Equal(t, data.Runs[0].RunInfo, *data.RunInfo)
+}
+
+func TestLocalTime(t *testing.T) {
+	stored := "2025-03-03T14:25:30Z"
+	assert.Equal(t, stored, Display{}.displayTime(stored))
+
+	local := Display{LocalTime: true}
+	assert.Equal(t, time.Date(2025, 3, 3, 14, 25, 30, 0, time.UTC).Local().Format(RunTimeLayout), local.displayTime(stored))
+	assert.Equal(t, "2025-03-03 15:25:30", local.displayTime("2025-03-03 15:25:30"))
+	assert.Equal(t, "", local.displayTime(""))
+}
+
+func TestPushResults(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	sleep = func(time.Duration) {}
+
+	// The collector fails the first attempt of every payload
+	var mutex sync.Mutex
+	var types, auths []string
+	var bodies [][]byte
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts++
+		if attempts%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		types = append(types, r.Header.Get("X-Payload-Type"))
+		auths = append(auths, r.Header.Get("Authorization"))
+		bodies = append(bodies, body)
+	}))
+	defer server.Close()
+
+	runner := newTestRunner(t)
+	runner.Router = true // Keeps expressvpnctl from being asked for its version
+	runner.PushURL, runner.PushSamples, runner.runID = server.URL, true, "20250301080000"
+	runner.PushHeaders = []string{"Authorization: Bearer secret", "X-Probe: lab"}
+
+	runner.pushSample(SampleRecord{LocationName: "Netherlands, Amsterdam", DownloadMbps: 100})
+	runner.writeToFile(results.VPNStat{LocationName: "Netherlands, Amsterdam"})
+	runner.pushResults()
+
+	assert.Equal(t, []string{PayloadSample, PayloadResults}, types)
+	assert.Equal(t, []string{"Bearer secret", "Bearer secret"}, auths)
+	var sample PushedSample
+	assert.NoError(t, json.Unmarshal(bodies[0], &sample))
+	assert.Equal(t, "20250301080000", sample.RunID)
+	assert.Equal(t, 100.0, sample.DownloadMbps)
+	var data results.Results
+	assert.NoError(t, json.Unmarshal(bodies[1], &data))
+	assert.Equal(t, "Netherlands, Amsterdam", data.VPNStats[0].LocationName)
+
+	// Credentials stay out of the recorded flags
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.String("push-header", "", "")
+	assert.NoError(t, flags.Parse([]string{"-push-header", "Authorization: Bearer secret"}))
+	runner.Flags = flags
+	assert.Equal(t, []string{"-push-header=REDACTED"}, runner.newRunInfo().Flags)
+
+	// Redacted samples leave out the server, its result page and the tags, on the collector and in ndjson
+	bodies = nil
+	var ndjson bytes.Buffer
+	runner.Redact, runner.Output, runner.SampleWriter = true, "ndjson", &ndjson
+	runner.Tags = map[string]string{"office": "nyc"}
+	runner.writeSample(SampleRecord{LocationName: "Netherlands, Amsterdam", Server: "speedtest.example.net", ResultURL: "https://www.speedtest.net/result/c/1"})
+	assert.Len(t, bodies, 1)
+	for _, payload := range []string{string(bodies[0]), ndjson.String()} {
+		assert.NotContains(t, payload, "speedtest.example.net")
+		assert.NotContains(t, payload, "speedtest.net/result")
+		assert.NotContains(t, payload, "nyc")
+	}
+	var redacted PushedSample
+	assert.NoError(t, json.Unmarshal(bodies[0], &redacted))
+	assert.Nil(t, redacted.Tags)
+}
+
+func TestSampleFailures(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	sleep = func(time.Duration) {}
+
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	// Every second test fails the way the Ookla CLI does when it is rate limited
+	dir := t.TempDir()
+	script := filepath.Join(dir, "speedtest")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+n=$(($(cat `+dir+`/count 2>/dev/null || echo 0) + 1))
+echo $n > `+dir+`/count
+if [ $((n % 2)) -eq 0 ]; then
+  echo "Limit reached: too many requests" >&2
+  exit 1
+fi
+echo '{"download": {"bandwidth": 12500000}, "upload": {"bandwidth": 2500000}, "ping": {"latency": 20}}'
+`), 0755)
+	assert.NoError(t, err)
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"speedtest": {Path: script}}
+	runner.Samples, runner.Parallel = 3, false
+	stat, ok := runner.runSamples(context.Background(), "1s", 3, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 3, stat.SamplesAttempted)
+	assert.Equal(t, 2, stat.SamplesSucceeded)
+	assert.Len(t, stat.SampleErrors, 1)
+	assert.Contains(t, stat.SampleErrors[0], "Limit reached: too many requests")
+	assert.Equal(t, "100.00Mbps", stat.VPNDownloadSpeed)
+
+	stat.LocationName = "Netherlands, Amsterdam"
+	summary := sampleFailureSummary([]results.VPNStat{stat})
+	assert.Contains(t, summary, "Netherlands, Amsterdam: 1 of 3 failed (33%)")
+	assert.Contains(t, summary, "    - exit status 1: Limit reached: too many requests")
+
+	// A location without a single successful sample fails with the last error
+	err = os.WriteFile(script, []byte("#!/bin/sh\necho 'No servers defined' >&2\nexit 1\n"), 0755)
+	assert.NoError(t, err)
+	stat, ok = runner.runSamples(context.Background(), "1s", 2, 2)
+	assert.False(t, ok)
+	assert.Equal(t, 0, stat.SamplesSucceeded)
+	assert.Equal(t, "all 2 speed tests failed: exit status 1: No servers defined", allSamplesFailed(stat))
+	assert.Empty(t, sampleFailureSummary([]results.VPNStat{{SamplesAttempted: 2, SamplesSucceeded: 2}}))
+}
+
+func TestHybridSpeedTests(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	sleep = func(time.Duration) {}
+
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	// The first test, run on its own, gets half the speed of the parallel ones
+	dir := t.TempDir()
+	script := filepath.Join(dir, "speedtest")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+if [ ! -e `+dir+`/single ]; then
+  touch `+dir+`/single
+  echo '{"download": {"bandwidth": 6250000}, "upload": {"bandwidth": 1250000}, "ping": {"latency": 30}}'
+  exit 0
+fi
+echo '{"download": {"bandwidth": 12500000}, "upload": {"bandwidth": 2500000}, "ping": {"latency": 20}}'
+`), 0755)
+	assert.NoError(t, err)
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"speedtest": {Path: script}}
+	runner.Samples = 3
+	stat, ok := runner.runHybridSpeedTests(context.Background(), "1s", 3)
+	assert.True(t, ok)
+	assert.Equal(t, "100.00Mbps", stat.VPNDownloadSpeed)
+	assert.Equal(t, &results.SingleStream{VPNDownloadSpeed: "50.00Mbps", VPNUploadSpeed: "10.00Mbps", VPNLatency: "30.00ms"}, stat.SingleStream)
+	assert.Len(t, stat.DownloadSamples, 3)
+	assert.Equal(t, 4, stat.SamplesAttempted)
+	assert.Equal(t, 4, stat.SamplesSucceeded)
+	assert.Equal(t, "Hybrid: one test on its own, then 3 in parallel", stat.Mode)
+
+	// The extra test is part of the plan's estimate
+	runner.Mode = "hybrid"
+	locations := []PlannedLocation{{Samples: 3, Parallel: true}}
+	hybrid := Plan{Hybrid: true, Locations: locations}
+	_, hybridData := runner.estimatePlan(hybrid)
+	_, parallelData := runner.estimatePlan(Plan{Locations: locations})
+	assert.Equal(t, estimatedMBPerTest, hybridData-parallelData)
+	assert.True(t, runner.BuildPlan(nil).Hybrid)
+}
+
+func TestHistoryRegressions(t *testing.T) {
+	dir := t.TempDir()
+	for day, speeds := range [][]string{{"100Mbps", "50Mbps"}, {"80Mbps", "50Mbps"}, {"90Mbps", "60Mbps"}} {
+		data := results.Results{MachineName: "probe-1"}
+		for i, location := range []string{"Netherlands, Amsterdam", "Romania, Bucharest"} {
+			data.VPNStats = append(data.VPNStats, results.VPNStat{
+				RunID:            fmt.Sprintf("2025030%d080000", day+1),
+				LocationName:     location,
+				VPNDownloadSpeed: speeds[i],
+				Timestamp:        fmt.Sprintf("2025-03-0%d 08:00:00", day+1),
+			})
+		}
+		assert.NoError(t, results.Save(data, filepath.Join(dir, fmt.Sprintf("results-2025030%d080000.json", day+1))))
+	}
+	other := results.Results{MachineName: "probe-2", VPNStats: []results.VPNStat{{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "1000Mbps"}}}
+	assert.NoError(t, results.Save(other, filepath.Join(dir, "other.json")))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "locations.json"), []byte(`{"locations": []}`), 0644))
+
+	history, err := loadHistory(results.FileOptions{}, dir, "probe-1", "20250303080000")
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Len(t, history["Netherlands, Amsterdam"], 2)
+	assert.FileExists(t, filepath.Join(dir, results.IndexFileName))
+
+	norms := regionNorms(history, 1)
+	assert.Equal(t, RegionNorm{Mbps: 80, Runs: 1}, norms["Netherlands, Amsterdam"])
+	norms = regionNorms(history, 10)
+	assert.Equal(t, RegionNorm{Mbps: 90, Runs: 2}, norms["Netherlands, Amsterdam"])
+
+	current := []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "45.00Mbps"},
+		{LocationName: "Romania, Bucharest", VPNDownloadSpeed: "48.00Mbps"},
+		{LocationName: "France, Paris", VPNDownloadSpeed: "10.00Mbps"},
+	}
+	regressions := findRegressions(current, norms, 20)
+	assert.Len(t, regressions, 1)
+	assert.Equal(t, "Netherlands, Amsterdam", regressions[0].LocationName)
+	assert.Equal(t, 50.0, regressions[0].BelowPercent)
+	assert.Contains(t, Display{}.regressionSummary(regressions), "Netherlands, Amsterdam: 45.00Mbps is 50% below its norm of 90.00Mbps over 2 runs")
+	assert.Empty(t, Display{}.regressionSummary(nil))
+
+	_, err = loadHistory(results.FileOptions{}, filepath.Join(dir, "missing"), "probe-1", "")
+	assert.Error(t, err)
+}
+
+func TestVerifyRoute(t *testing.T) {
+	assert.Equal(t, "tun0", parseEgressInterface("1.1.1.1 dev tun0 table 51820 src 10.8.0.2 uid 1000\n    cache\n"))
+	assert.Equal(t, "utun4", parseEgressInterface("   route to: 1.1.1.1\ndestination: default\n  interface: utun4\n      flags: <UP,DONE>\n"))
+	assert.Equal(t, "ExpressVPN", parseEgressInterface("ExpressVPN\r\n"))
+
+	if runtime.GOOS != "linux" {
+		t.Skip("The route lookup is faked with an ip script")
+	}
+
+	script := filepath.Join(t.TempDir(), "ip")
+	setRoute := func(device string) {
+		err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$3 via 192.168.1.1 dev "+device+" src 192.168.1.20 uid 1000\"\n"), 0755)
+		assert.NoError(t, err)
+	}
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"ip": {Path: script}}
+
+	setRoute("eth0")
+	name, throughVPN := runner.checkRoute("1.1.1.1:8080")
+	assert.Equal(t, "eth0", name)
+	assert.Nil(t, throughVPN)
+
+	runner.lookupHomeInterface()
+	assert.Equal(t, "eth0", runner.homeInterface)
+
+	// A split tunnel leaves the speed test server on the home interface
+	_, throughVPN = runner.checkRoute("1.1.1.1:8080")
+	assert.False(t, *throughVPN)
+
+	setRoute("tun0")
+	name, throughVPN = runner.checkRoute("1.1.1.1:8080")
+	assert.Equal(t, "tun0", name)
+	assert.True(t, *throughVPN)
+}
+
+func TestBudget(t *testing.T) {
+	runner := newTestRunner(t)
+	assert.Empty(t, runner.checkBudget(10*time.Hour, time.Hour, 100e9, 100, 5), "No budget is unlimited")
+
+	// Tests so far used 300MB each, so five more need 1.5GB
+	runner.MaxDataGB = 2
+	assert.Empty(t, runner.checkBudget(0, 0, 300e6, 1, 5))
+	assert.Equal(t, "the data budget of 2GB would be exceeded", runner.checkBudget(0, 0, 600e6, 2, 5))
+	// Before any test, the plan's estimate per test is used
+	assert.Empty(t, runner.checkBudget(0, 0, 0, 0, 8))
+	assert.NotEmpty(t, runner.checkBudget(0, 0, 0, 0, 9))
+
+	runner.MaxDataGB, runner.MaxDuration = 0, time.Hour
+	assert.Empty(t, runner.checkBudget(40*time.Minute, 15*time.Minute, 0, 0, 5))
+	assert.Equal(t, "the time budget of 1h0m0s would be exceeded", runner.checkBudget(50*time.Minute, 15*time.Minute, 0, 0, 5))
+
+	// Engines that report their bytes are counted exactly, the others estimated
+	var result speedtest.Result
+	result.Download.Bytes, result.Upload.Bytes = 100e6, 50e6
+	runner.recordDataUsage(result)
+	runner.recordDataUsage(speedtest.Result{})
+	assert.Equal(t, int64(150e6+estimatedMBPerTest*1e6), runner.dataUsed.Load())
+	assert.Equal(t, int64(2), runner.testsRun.Load())
+	assert.Equal(t, 400.0, runner.dataUsedMB())
+}
+
+func TestLatencyOnly(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	runner := newTestRunner(t)
+	runner.SpeedTest.LatencyTarget = listener.Addr().String()
+	_, ok := runner.latencyTest("")
+	assert.False(t, ok, "The baseline isn't a location result")
+	assert.Regexp(t, `^\d+\.\d\dms latency$`, runner.Baseline())
+
+	stat, ok := runner.latencyTest("1.5s")
+	assert.True(t, ok)
+	assert.Equal(t, latencyOnlyMode, stat.Mode)
+	assert.Equal(t, "1.5s", stat.TimeToConnect)
+	assert.Regexp(t, `^\d+\.\d\dms$`, stat.VPNLatency)
+	assert.Empty(t, stat.VPNDownloadSpeed)
+	assert.Equal(t, 1, stat.SamplesSucceeded)
+
+	ranking := latencyRanking([]results.VPNStat{
+		{LocationName: "Japan, Tokyo", VPNLatency: "210.40ms"},
+		{LocationName: "Netherlands, Amsterdam", VPNLatency: "12.10ms"},
+		{LocationName: "USA, New York", VPNLatency: "85.00ms"},
+	})
+	assert.Equal(t, []string{"1", "Netherlands, Amsterdam"}, ranking[1][:2])
+	assert.Equal(t, []string{"2", "USA, New York"}, ranking[2][:2])
+	assert.Equal(t, []string{"3", "Japan, Tokyo"}, ranking[3][:2])
+}
+
+func TestTestSelection(t *testing.T) {
+	opts := DefaultOptions()
+	assert.NoError(t, ApplyTestSelection(&opts, "download,upload,latency"))
+	assert.False(t, opts.SpeedTest.SkipDownload || opts.SpeedTest.SkipUpload || opts.SpeedTest.SkipLatency)
+
+	opts.Engine = "native"
+	assert.NoError(t, ApplyTestSelection(&opts, "download, latency"))
+	assert.True(t, opts.SpeedTest.SkipUpload)
+	assert.False(t, opts.SpeedTest.SkipDownload)
+	runner := NewRunner(opts)
+	stat := results.VPNStat{VPNDownloadSpeed: "100.00Mbps", VPNUploadSpeed: "0.00Mbps", UploadSamples: []float64{0, 0}, VPNLatency: "12.00ms"}
+	runner.clearSkippedPhases(&stat)
+	assert.Equal(t, results.VPNStat{VPNDownloadSpeed: "100.00Mbps", VPNLatency: "12.00ms"}, stat)
+
+	var result speedtest.Result
+	result.Download.Bandwidth = 12_500_000
+	runner.recordBaseline(result)
+	assert.Equal(t, "100.00Mbps ▼", runner.Baseline())
+
+	opts = DefaultOptions()
+	assert.ErrorContains(t, ApplyTestSelection(&opts, "upload"), "the ookla engine always runs every test")
+	opts.Engine, opts.CrossCheck = "http", "ookla"
+	assert.ErrorContains(t, ApplyTestSelection(&opts, "upload"), "the ookla engine", "The cross-check engine skips them too")
+	opts.Engine, opts.CrossCheck = "native", ""
+	assert.ErrorContains(t, ApplyTestSelection(&opts, "download,ping"), `unknown test "ping"`)
+
+	opts = DefaultOptions()
+	assert.NoError(t, ApplyTestSelection(&opts, "latency"))
+	assert.True(t, opts.LatencyOnly)
+}
+
+func TestBenchmarkWeb(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("<html>hello</html>"))
+	}))
+	defer server.Close()
+	origTLS := webTLSConfig
+	defer func() { webTLSConfig = origTLS }()
+	webTLSConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	timings := benchmarkWeb(context.Background(), []string{server.URL + "/", " ", server.URL + "/missing", "https://127.0.0.1:1/"}, 15*time.Second)
+	assert.Len(t, timings, 3)
+
+	page := timings[0]
+	assert.Equal(t, server.URL+"/", page.URL)
+	assert.Equal(t, http.StatusOK, page.Status)
+	assert.Empty(t, page.DNS, "An IP address isn't resolved")
+	assert.Regexp(t, `^\d+\.\d\dms$`, page.TLS)
+	assert.Regexp(t, `^\d+\.\d\dms$`, page.TTFB)
+	assert.Regexp(t, `^\d+\.\d\dms$`, page.Total)
+	assert.Empty(t, page.Error)
+
+	assert.Equal(t, http.StatusNotFound, timings[1].Status)
+	assert.NotEmpty(t, timings[2].Error)
+	assert.Empty(t, timings[2].TTFB)
+}
+
+func TestStatusLines(t *testing.T) {
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	status := newTestRunner(t).startStatusLines(3)
+	assert.Nil(t, status.area, "Nothing is drawn without output")
+	assert.Equal(t, "Speed test #1: queued\nSpeed test #2: queued\nSpeed test #3: queued", status.render())
+
+	var wg sync.WaitGroup
+	for i := range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status.set(i, "running")
+			status.set(i, fmt.Sprintf("done %d", i))
+		}()
+	}
+	wg.Wait()
+	status.stop()
+	assert.Equal(t, "Speed test #1: done 0\nSpeed test #2: done 1\nSpeed test #3: done 2", status.render())
+}
+
+func TestPlainOutput(t *testing.T) {
+	pterm.DisableStyling()
+	defer pterm.EnableStyling()
+	runner := newTestRunner(t)
+	runner.Plain = true
+
+	stdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	assert.NoError(t, err)
+	os.Stdout = writer
+
+	spinner := runner.startSpinner("Running speed test #1...")
+	spinner.Success("Speed test #1: 100.00 Mbps ▼ 50.00 Mbps ▲")
+	runner.printTextf("\n%s\n\n", "Baseline")
+	status := runner.startStatusLines(1)
+	status.set(0, "running")
+	status.stop()
+
+	writer.Close()
+	os.Stdout = stdout
+	output, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+
+	assert.Nil(t, status.area, "Status lines aren't redrawn in plain output")
+	lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	assert.Len(t, lines, 4, "Blank lines are dropped")
+	for i, want := range []string{"Running speed test #1...", "Speed test #1: 100.00 Mbps ▼ 50.00 Mbps ▲", "Baseline", "Speed test #1: running"} {
+		_, err := time.Parse(RunTimeLayout, lines[i][:len(RunTimeLayout)])
+		assert.NoError(t, err, "Every line starts with the time")
+		assert.Equal(t, " "+want, lines[i][len(RunTimeLayout):])
+	}
+	assert.NotContains(t, string(output), "\x1b", "No control sequences")
+}
+
+func TestASCIIOutput(t *testing.T) {
+	var samples bytes.Buffer
+	opts := DefaultOptions()
+	opts.ResultsFile = filepath.Join(t.TempDir(), "results.json")
+	opts.ASCII, opts.SampleWriter = true, &samples
+	runner := NewRunner(opts)
+	assert.True(t, runner.Files.ASCII, "Saved results are transliterated too")
+
+	stdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	assert.NoError(t, err)
+	os.Stdout = writer
+	runner.printText("Without VPN:", "900.00Mbps ▼  90.00Mbps ▲")
+	runner.printTextf("Connected to %s\n", "Brazil, São Paulo")
+	writer.Close()
+	os.Stdout = stdout
+	output, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "Without VPN: 900.00Mbps down  90.00Mbps up\nConnected to Brazil, Sao Paulo\n", string(output))
+
+	runner.Output = "ndjson"
+	runner.writeSample(SampleRecord{LocationName: "Switzerland, Zürich"})
+	assert.Contains(t, samples.String(), `"locationName":"Switzerland, Zurich"`)
+}
+
+func TestRedact(t *testing.T) {
+	runner := newTestRunner(t)
+	runner.Redact, runner.Router = true, true
+
+	// The key is created on first use and kept for the next runs
+	runner.RedactKeyFile = filepath.Join(t.TempDir(), "config", "redact.key")
+	assert.NoError(t, runner.loadRedactKey())
+	info, err := os.Stat(runner.RedactKeyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	hash := runner.hashHostname("alice-laptop")
+	assert.Regexp(t, `^host-[0-9a-f]{12}$`, hash)
+	assert.Equal(t, hash, runner.hashHostname("alice-laptop"), "Runs of a machine still group together")
+	plain := sha256.Sum256([]byte("alice-laptop"))
+	assert.NotEqual(t, "host-"+hex.EncodeToString(plain[:6]), hash, "Not a plain hash, which a list of names would reverse")
+	runner.redactKey = nil
+	assert.NoError(t, runner.loadRedactKey())
+	assert.Equal(t, hash, runner.hashHostname("alice-laptop"))
+
+	hostname, _ := os.Hostname()
+	name, err := runner.machineName()
+	assert.NoError(t, err)
+	assert.Equal(t, runner.hashHostname(hostname), name)
+	runner.ProbeName = "alice-laptop"
+	name, err = runner.machineName()
+	assert.NoError(t, err)
+	assert.Equal(t, hash, name, "A probe name is hashed too")
+	runner.ProbeName = ""
+
+	stat := results.VPNStat{LocationName: "Netherlands, Amsterdam", Server: "speedtest.example.net", ExitIP: "203.0.113.7", VPNDownloadSpeed: "100.00Mbps"}
+	redactStat(&stat)
+	assert.Equal(t, results.VPNStat{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "100.00Mbps"}, stat)
+
+	runner.network = results.Network{PublicIP: "198.51.100.2", ISP: "Example ISP"}
+	assert.Equal(t, &results.Network{ISP: "Example ISP"}, runner.baselineNetwork())
+
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.String("results", "", "")
+	flags.String("notify-url", "", "")
+	flags.Int("r", 5, "")
+	assert.NoError(t, flags.Parse([]string{"-results", "/home/alice/nightly.json", "-notify-url", "https://hooks.example.com/T0/B0/token", "-r", "3"}))
+	runner.Flags = flags
+	assert.Equal(t, []string{"-notify-url=REDACTED", "-r=3", "-results=REDACTED"}, runner.newRunInfo().Flags)
+
+	flags = flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.String("pre-hook", "", "")
+	flags.String("probe-name", "", "")
+	flags.String("tag", "", "")
+	assert.NoError(t, flags.Parse([]string{"-pre-hook", "/home/alice/hook.sh", "-probe-name", "alice-laptop", "-tag", "office=alice-home"}))
+	runner.Flags, runner.Tags = flags, map[string]string{"office": "alice-home"}
+	recorded := runner.newRunInfo()
+	assert.Equal(t, []string{"-pre-hook=REDACTED", "-probe-name=REDACTED", "-tag=REDACTED"}, recorded.Flags)
+	assert.Nil(t, recorded.Tags)
+}
+
+func TestProviders(t *testing.T) {
+	runner := newTestRunner(t)
+
+	input := results.InputData{
+		Locations: []results.Location{{Country: "Netherlands", City: "Amsterdam"}},
+		Providers: []results.ProviderInput{
+			{Name: "Mullvad", Type: "command", Connect: "mullvad relay set location {country} {city}", Disconnect: "mullvad disconnect",
+				Locations: []results.Location{{Country: "Netherlands", City: "Amsterdam"}, {Country: "Sweden"}}},
+		},
+	}
+	assert.NoError(t, runner.addProviders(&input))
+	assert.Len(t, input.Locations, 3)
+	assert.Equal(t, "Mullvad: Netherlands, Amsterdam", input.Locations[1].Key(), "The same place through another provider is a location of its own")
+	assert.IsType(t, vpn.ExpressVPN{}, runner.providerFor(input.Locations[0]))
+	assert.IsType(t, &vpn.CommandProvider{}, runner.providerFor(input.Locations[1]))
+	assert.True(t, runner.usesExpressVPN(input.Locations))
+	assert.False(t, runner.usesExpressVPN(input.Locations[1:]))
+	assert.Equal(t, "Sweden via Mullvad", describeLocation(input.Locations[2]))
+
+	region, _ := runner.findRegion(runner.providerFor(input.Locations[2]), input.Locations[2])
+	assert.Equal(t, "Sweden", region, "Command providers take locations as they are")
+
+	assert.ErrorContains(t, runner.addProviders(&results.InputData{Providers: []results.ProviderInput{{Name: "Mullvad"}}}), "listed twice")
+	assert.ErrorContains(t, runner.addProviders(&results.InputData{Providers: []results.ProviderInput{{Name: "Nord", Type: "command"}}}), "connect and disconnect")
+	assert.ErrorContains(t, runner.addProviders(&results.InputData{Providers: []results.ProviderInput{{Name: "Proton", Type: "carrier-pigeon"}}}), "unknown type")
+	assert.ErrorContains(t, runner.addProviders(&results.InputData{Providers: []results.ProviderInput{{Name: "Nord2", Type: "command", Connect: "a", Disconnect: "b"}}}), "lists no locations")
+
+	// A WireGuard directory without locations tests every file
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "Home_VPS.conf"), nil, 0600))
+	wireguard := results.InputData{Providers: []results.ProviderInput{{Name: "Self-hosted", Type: "wireguard", Dir: dir}}}
+	assert.NoError(t, runner.addProviders(&wireguard))
+	assert.Equal(t, []results.Location{{Country: "Home_VPS", Provider: "Self-hosted"}}, wireguard.Locations)
+	region, _ = runner.findRegion(runner.providerFor(wireguard.Locations[0]), wireguard.Locations[0])
+	assert.Equal(t, "Home_VPS", region, "Files are found by their exact name")
+
+	openvpnDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(openvpnDir, "us-nyc.ovpn"), nil, 0600))
+	openvpn := results.InputData{Providers: []results.ProviderInput{{Name: "Work", Type: "openvpn", Dir: openvpnDir}}}
+	assert.NoError(t, runner.addProviders(&openvpn))
+	assert.Equal(t, []results.Location{{Country: "us-nyc", Provider: "Work"}}, openvpn.Locations)
+	assert.IsType(t, &vpn.OpenVPN{}, runner.providerFor(openvpn.Locations[0]))
+	assert.ErrorContains(t, runner.addProviders(&results.InputData{Providers: []results.ProviderInput{{Name: "Work2", Type: "openvpn"}}}), ".ovpn files")
+
+	data := results.Results{VPNStats: []results.VPNStat{
+		{LocationName: "Netherlands, Amsterdam", VPNDownloadSpeed: "300.00Mbps", VPNUploadSpeed: "100.00Mbps"},
+		{LocationName: "Netherlands, Amsterdam", Provider: "Mullvad", VPNDownloadSpeed: "200.00Mbps", VPNUploadSpeed: "80.00Mbps"},
+		{LocationName: "Sweden, Stockholm", Provider: "Mullvad", VPNDownloadSpeed: "100.00Mbps", VPNUploadSpeed: "40.00Mbps"},
+	}}
+	assert.Equal(t, pterm.TableData{
+		{"Location", "ExpressVPN", "Mullvad"},
+		{"Netherlands, Amsterdam", "300.00Mbps ▼ 100.00Mbps ▲", "200.00Mbps ▼ 80.00Mbps ▲"},
+		{"Sweden, Stockholm", "-", "100.00Mbps ▼ 40.00Mbps ▲"},
+		{"Average", "300.00Mbps ▼ 100.00Mbps ▲", "150.00Mbps ▼ 60.00Mbps ▲"},
+	}, Display{}.ProviderTable(data))
+	assert.Nil(t, Display{}.ProviderTable(results.Results{VPNStats: data.VPNStats[:1]}), "A single provider has nothing to compare")
+}
+
+func TestNetworkLock(t *testing.T) {
+	// The setting is kept in a file, so it can be read back after it is changed
+	dir := t.TempDir()
+	setting, calls := filepath.Join(dir, "setting"), filepath.Join(dir, "calls")
+	assert.NoError(t, os.WriteFile(setting, []byte("false\n"), 0600))
+	script := filepath.Join(dir, "expressvpnctl")
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+calls+"\n[ \"$1\" = get ] && cat "+setting+"\n[ \"$1\" = set ] && echo \"$3\" > "+setting+"\nexit 0\n"), 0755)
+	assert.NoError(t, err)
+	opts := DefaultOptions()
+	opts.Commands = command.Overrides{"expressvpnctl": {Path: script}}
+
+	// Recorded as it is without -network-lock
+	runner := NewRunner(opts)
+	assert.NoError(t, runner.applyNetworkLock())
+	assert.Equal(t, false, *runner.networkLock)
+	assert.Nil(t, runner.networkLockReset)
+
+	// Turned on for the run and back off afterwards
+	opts.NetworkLock = "on"
+	runner = NewRunner(opts)
+	assert.NoError(t, runner.applyNetworkLock())
+	assert.Equal(t, true, *runner.networkLock)
+	assert.Equal(t, runner.networkLock, runner.newRunInfo().NetworkLock, "Recorded with the run")
+	runner.restoreNetworkLock()
+	runner.restoreNetworkLock()
+
+	data, err := os.ReadFile(calls)
+	assert.NoError(t, err)
+	assert.Equal(t, "get networklock\nget networklock\nset networklock true\nset networklock false\n", strings.ReplaceAll(string(data), "--version\n", ""))
+}
+
+func TestParallelContention(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	sleep = func(time.Duration) {}
+
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	// Every test gets 100Mbps, and the three baseline tests ran at once, so the link carries 300Mbps
+	dir := t.TempDir()
+	script := filepath.Join(dir, "speedtest")
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho '{\"download\": {\"bandwidth\": 12500000}, \"upload\": {\"bandwidth\": 2500000}, \"ping\": {\"latency\": 20}}'\n"), 0755)
+	assert.NoError(t, err)
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"speedtest": {Path: script}}
+	runner.Samples = 4
+	runner.baselineDownloads = []float64{100, 100, 100}
+	assert.Equal(t, 300.0, runner.linkCapacity())
+
+	// Two tests at once add up to less than the link
+	stat, ok := runner.runTunedParallelSpeedTests(context.Background(), "1s", 2)
+	assert.True(t, ok)
+	assert.Nil(t, stat.Contention)
+
+	stat, ok = runner.runTunedParallelSpeedTests(context.Background(), "1s", 4)
+	assert.True(t, ok)
+	assert.Equal(t, &results.Contention{SumMbps: 400, BaselineMbps: 300, Decision: "kept, -auto-tune is off"}, stat.Contention)
+	assert.Equal(t, "Tests ran in parallel", stat.Mode)
+
+	// Halved to two at a time, which no longer saturates the link, and kept for the next location
+	runner.AutoTune = true
+	stat, ok = runner.runTunedParallelSpeedTests(context.Background(), "1s", 4)
+	assert.True(t, ok)
+	assert.Equal(t, &results.Contention{SumMbps: 400, BaselineMbps: 300, Decision: "tested again 2 at a time"}, stat.Contention)
+	assert.Equal(t, "Tests ran in parallel, 2 at a time", stat.Mode)
+	assert.Equal(t, 4, stat.SamplesSucceeded)
+	assert.Equal(t, 2, runner.concurrency)
+
+	// Down to series when even two at a time fill the link
+	runner.baselineDownloads = []float64{200}
+	stat, ok = runner.runTunedParallelSpeedTests(context.Background(), "1s", 4)
+	assert.True(t, ok)
+	assert.Equal(t, "tested again in series", stat.Contention.Decision)
+	assert.Equal(t, "Tests ran in series (one after another)", stat.Mode)
+}
+
+func TestPingMonitor(t *testing.T) {
+	origPing := pingOnce
+	defer func() { pingOnce = origPing }()
+
+	rtt, err := parsePingTime("64 bytes from 1.1.1.1: icmp_seq=1 ttl=57 time=12.3 ms")
+	assert.NoError(t, err)
+	assert.Equal(t, 12300*time.Microsecond, rtt)
+	rtt, err = parsePingTime("Reply from 1.1.1.1: bytes=32 time<1ms TTL=57")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Millisecond, rtt)
+	_, err = parsePingTime("Request timed out.")
+	assert.Error(t, err)
+
+	// Every third ping is lost
+	var pings atomic.Int64
+	pingOnce = func(commands command.Overrides, anchor string, timeout time.Duration) (time.Duration, error) {
+		if pings.Add(1)%3 == 0 {
+			return 0, errors.New("timeout")
+		}
+		return 20 * time.Millisecond, nil
+	}
+	runner := newTestRunner(t)
+	runner.PingAnchor, runner.PingInterval = "1.1.1.1", time.Millisecond
+
+	runner.startPingMonitor()
+	for pings.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	runner.markTimeline(timelineConnecting, "Netherlands, Amsterdam")
+	runner.markTimeline(timelineConnected, "Netherlands, Amsterdam")
+	for pings.Load() < 6 {
+		time.Sleep(time.Millisecond)
+	}
+	runner.markTimeline(timelineDisconnected, "Netherlands, Amsterdam")
+	runner.stopPingMonitor()
+	runner.markTimeline(timelineConnecting, "Romania") // Ignored once stopped
+
+	// Written next to the results file
+	data, err := os.ReadFile(strings.TrimSuffix(runner.ResultsFile, ".json") + "-timeline.json")
+	assert.NoError(t, err)
+	var timeline Timeline
+	assert.NoError(t, json.Unmarshal(data, &timeline))
+	assert.Equal(t, "1.1.1.1", timeline.Anchor)
+	assert.GreaterOrEqual(t, len(timeline.Samples), 6)
+	assert.Equal(t, 20.0, timeline.Samples[0].LatencyMs)
+	assert.Empty(t, timeline.Samples[0].Location, "Pings before the first connect are without VPN")
+	assert.True(t, timeline.Samples[2].Lost)
+	assert.Equal(t, "Netherlands, Amsterdam", timeline.Samples[4].Location)
+	assert.Equal(t, []string{"connecting", "connected", "disconnected"}, []string{timeline.Events[0].Event, timeline.Events[1].Event, timeline.Events[2].Event})
+
+	// As CSV, the events are interleaved with the pings
+	csvData, err := encodeTimeline(Timeline{
+		Samples: []PingSample{{Time: "2025-03-03 14:25:00.000", LatencyMs: 20}, {Time: "2025-03-03 14:25:01.000", Lost: true, Location: "Romania"}},
+		Events:  []TimelineEvent{{Time: "2025-03-03 14:25:00.500", Event: "connected", Location: "Romania"}},
+	}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "time,type,latency_ms,lost,event,location\n"+
+		"2025-03-03 14:25:00.000,ping,20.00,false,,\n"+
+		"2025-03-03 14:25:00.500,event,,,connected,Romania\n"+
+		"2025-03-03 14:25:01.000,ping,,true,,Romania\n", string(csvData))
+}
+
+func TestInterfaceCounters(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads the counters from sysfs")
+	}
+	origSys := sysClassNet
+	defer func() { sysClassNet = origSys }()
+
+	rx, tx, err := parseNetstatBytes(`Name       Mtu   Network       Address            Ipkts Ierrs     Ibytes    Opkts Oerrs     Obytes  Coll
+utun4      1380  <Link#20>                      51234     0   61234567    40123     0    5123456     0
+utun4      1380  10.8.0.2/32   10.8.0.2          51234     -   61234567    40123     -    5123456     -
+`, "utun4")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{61234567, 5123456}, []uint64{rx, tx})
+	_, _, err = parseNetstatBytes("Name Mtu Network Address\n", "utun4")
+	assert.Error(t, err)
+
+	sysClassNet = t.TempDir()
+	setCounters := func(name string, rx, tx int) {
+		dir := filepath.Join(sysClassNet, name, "statistics")
+		assert.NoError(t, os.MkdirAll(dir, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "rx_bytes"), []byte(fmt.Sprintln(rx)), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "tx_bytes"), []byte(fmt.Sprintln(tx)), 0644))
+	}
+	script := filepath.Join(t.TempDir(), "ip")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$3 dev tun0 src 10.8.0.2 uid 1000\"\n"), 0755))
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"ip": {Path: script}}
+	assert.Nil(t, runner.startInterfaceCounters())
+
+	runner.IfaceCounters, runner.homeInterface = true, "eth0"
+	setCounters("tun0", 1000000, 500000)
+	setCounters("eth0", 9000000, 1000000)
+	snapshot := runner.startInterfaceCounters()
+	assert.NotNil(t, snapshot)
+
+	// 100MB through the tunnel, 105MB on the wire, of which the speed test reported 98MB
+	runner.reportedBytes.Add(98000000)
+	setCounters("tun0", 91000000, 10500000)
+	setCounters("eth0", 103500000, 11500000)
+	assert.Equal(t, &results.InterfaceCounters{
+		TunnelInterface: "tun0", TunnelMB: 100, PhysicalInterface: "eth0", PhysicalMB: 105, GoodputMB: 98, OverheadPercent: 5,
+	}, snapshot.finish())
+
+	// A tunnel that came up again restarted its counters
+	snapshot = runner.startInterfaceCounters()
+	setCounters("tun0", 0, 0)
+	counters := snapshot.finish()
+	assert.Empty(t, counters.TunnelInterface)
+	assert.Zero(t, counters.OverheadPercent)
+}
+
+func TestCPUUsage(t *testing.T) {
+	origProc := procDir
+	defer func() { procDir = origProc }()
+
+	assert.Nil(t, newTestRunner(t).startCPUMonitor())
+	assert.Nil(t, summarizeCPU(nil))
+
+	procDir = t.TempDir()
+	write := func(name string, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(procDir, name)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(procDir, name), []byte(content), 0644))
+	}
+	write("stat", "cpu  100 0 100 800 0 0 0 0 0 0\ncpu0 100 0 100 800 0 0 0 0 0 0\n")
+	write("meminfo", "MemTotal:        1000000 kB\nMemFree:          100000 kB\nMemAvailable:     250000 kB\n")
+	write("1/comm", "systemd\n")
+	write("1/stat", "1 (systemd) S 0 1 1 0 -1 4194560 1 1 0 0 5 5 0 0 20 0 1 0\n")
+	write("42/comm", "expressvpn-daem\n")
+	write("42/stat", "42 (expressvpn-daem) S 1 42 42 0 -1 4194560 1 1 0 0 10 10 0 0 20 0 1 0\n")
+	write("42/status", "Name:\texpressvpn-daem\nVmRSS:\t   51200 kB\n")
+	write("self/comm", "expressvpnspeed\n")
+
+	previous, err := readCPUTimes()
+	assert.NoError(t, err)
+	assert.Equal(t, cpuTimes{busy: 200, total: 1000, daemon: "expressvpn-daemon", daemonPID: "42", daemonTicks: 20}, previous)
+
+	write("stat", "cpu  400 0 200 1400 0 0 0 0 0 0\n")
+	write("42/stat", "42 (expressvpn-daem) S 1 42 42 0 -1 4194560 1 1 0 0 30 30 0 0 20 0 1 0\n")
+	sample, current, err := sampleLinuxCPU(previous)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2000), current.total)
+	assert.InDelta(t, 40, sample.system, 0.001)
+	assert.Equal(t, "expressvpn-daemon", sample.daemon)
+	assert.InDelta(t, 40/(1000/float64(runtime.NumCPU()))*100, sample.daemonCPU, 0.001)
+	assert.InDelta(t, 75, sample.memory, 0.001)
+	assert.Equal(t, 50.0, sample.daemonRSSMB)
+
+	_, _, err = sampleLinuxCPU(current)
+	assert.Error(t, err, "no time passed")
+
+	sample = parsePS(` 12.0  2048 /sbin/launchd
+ 50.0 40960 /Applications/ExpressVPN.app/Contents/MacOS/expressvpnd
+  2.0  1024 /usr/bin/some tool
+`, 4)
+	assert.Equal(t, cpuSample{system: 16, daemon: "expressvpnd", daemonCPU: 50, daemonRSSMB: 40}, sample)
+
+	assert.Equal(t, &results.CPUUsage{
+		SystemPercent: 60, SystemMaxPercent: 95, MemoryPercent: 80, Daemon: "openvpn", DaemonPercent: 70, DaemonMaxPercent: 90, DaemonMemoryMB: 12.5,
+	}, summarizeCPU([]cpuSample{
+		{system: 25, memory: 80},
+		{system: 95, memory: 70, daemon: "openvpn", daemonCPU: 90, daemonRSSMB: 12.5},
+		{system: 60, memory: 60, daemon: "openvpn", daemonCPU: 50, daemonRSSMB: 10},
+	}))
+}
+
+func TestMTUSweep(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads the interface MTU from sysfs")
+	}
+	origProbe, origSys := mtuProbe, sysClassNet
+	defer func() { mtuProbe, sysClassNet = origProbe, origSys }()
+	pterm.DisableOutput()
+
+	assert.Equal(t, mtuReplied, classifyMTUProbe("1480 bytes from 1.1.1.1: icmp_seq=1 ttl=57 time=12.1 ms", nil))
+	assert.Equal(t, mtuTooBig, classifyMTUProbe("ping: local error: message too long, mtu=1420", errors.New("exit status 1")))
+	assert.Equal(t, mtuTooBig, classifyMTUProbe("Packet needs to be fragmented but DF set.", nil))
+	assert.Equal(t, mtuLost, classifyMTUProbe("Request timed out.", nil))
+	assert.Equal(t, mtuLost, classifyMTUProbe("1 packets transmitted, 0 received, 100% packet loss", errors.New("exit status 1")))
+
+	sysClassNet = t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(sysClassNet, "tun0"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(sysClassNet, "tun0", "mtu"), []byte("1500\n"), 0644))
+	script := filepath.Join(t.TempDir(), "ip")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$3 dev tun0 src 10.8.0.2 uid 1000\"\n"), 0755))
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"ip": {Path: script}}
+
+	// The path refuses what it can't carry
+	probes := 0
+	mtuProbe = func(commands command.Overrides, host string, size int) int {
+		probes++
+		if size+icmpHeaderBytes <= 1420 {
+			return mtuReplied
+		}
+		return mtuTooBig
+	}
+	assert.Equal(t, &results.MTU{
+		PathMTU: 1420, Interface: "tun0", InterfaceMTU: 1500,
+		Issue: "the interface sends packets up to 1500 bytes but the path carries 1420, larger ones are fragmented",
+	}, runner.sweepMTU("1.1.1.1"))
+	assert.Less(t, probes, 12, "binary search")
+
+	// The path drops what it can't carry silently
+	mtuProbe = func(commands command.Overrides, host string, size int) int {
+		if size+icmpHeaderBytes <= 1400 {
+			return mtuReplied
+		}
+		return mtuLost
+	}
+	mtu := runner.sweepMTU("1.1.1.1")
+	assert.Equal(t, 1400, mtu.PathMTU)
+	assert.True(t, mtu.Blackhole)
+	assert.Contains(t, mtu.Issue, "without telling")
+
+	// A clean path up to the interface's MTU
+	assert.NoError(t, os.WriteFile(filepath.Join(sysClassNet, "tun0", "mtu"), []byte("1400\n"), 0644))
+	mtuProbe = func(commands command.Overrides, host string, size int) int {
+		if size+icmpHeaderBytes <= 1400 {
+			return mtuReplied
+		}
+		return mtuTooBig
+	}
+	assert.Empty(t, runner.sweepMTU("1.1.1.1").Issue)
+
+	// No answer at all
+	mtuProbe = func(commands command.Overrides, host string, size int) int { return mtuLost }
+	assert.Nil(t, runner.sweepMTU("1.1.1.1"))
+}
+
+func TestCheckIPv6(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback")
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	runner := newTestRunner(t)
+	runner.IPv6Target = listener.Addr().String()
+	assert.True(t, *runner.checkIPv6(context.Background()))
+
+	// An IPv4 address can't be reached over IPv6
+	runner.IPv6Target = "127.0.0.1:1"
+	assert.False(t, *runner.checkIPv6(context.Background()))
+}
+
+func TestOnce(t *testing.T) {
+	var buf bytes.Buffer
+	runner := newTestRunner(t)
+	runner.SampleWriter, runner.runID = &buf, "20261015-120000"
+
+	runner.printOnceResult(results.VPNStat{LocationName: "Germany, Frankfurt", VPNDownloadSpeed: "250.00Mbps"})
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"), "a single line")
+	var stat results.VPNStat
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &stat))
+	assert.Equal(t, "20261015-120000", stat.RunID)
+	assert.Equal(t, "250.00Mbps", stat.VPNDownloadSpeed)
+}
+
+func TestLocationTimeout(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	sleep = func(time.Duration) {}
+
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	// The first test hangs past the timeout and is stopped, the second isn't started
+	dir := t.TempDir()
+	script := filepath.Join(dir, "speedtest")
+	err := os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 10\n"), 0755)
+	assert.NoError(t, err)
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"speedtest": {Path: script}}
+	runner.Samples, runner.Parallel = 2, false
+
+	location := results.Location{Country: "Japan", City: "Tokyo"}
+	runner.LocationTimeout = 100 * time.Millisecond
+	ctx, cancel := runner.locationContext(context.Background())
+	defer cancel()
+	started := time.Now()
+	stat, ok := runner.runSamples(ctx, "1s", 2, 1)
+	assert.Less(t, time.Since(started), 5*time.Second)
+	assert.False(t, ok)
+	assert.Equal(t, 2, stat.SamplesAttempted)
+	assert.Equal(t, 0, stat.SamplesSucceeded)
+	assert.Contains(t, stat.SampleErrors[1], "deadline exceeded")
+
+	// So is a hanging warm-up test
+	warmupCtx, cancelWarmup := runner.locationContext(context.Background())
+	defer cancelWarmup()
+	started = time.Now()
+	runner.warmUp(warmupCtx, 1)
+	assert.Less(t, time.Since(started), 5*time.Second)
+
+	assert.True(t, runner.locationTimedOut(ctx, location))
+	assert.Equal(t, []string{"Japan, Tokyo"}, runner.timedOutLocations)
+	assert.Equal(t, []string{"Japan, Tokyo: timed out after 100ms"}, runner.failedLocations)
+
+	// Without a timeout, the context only ends when canceled
+	runner.LocationTimeout = 0
+	ctx, cancel = runner.locationContext(context.Background())
+	_, hasDeadline := ctx.Deadline()
+	assert.False(t, hasDeadline)
+	cancel()
+	assert.False(t, runner.locationTimedOut(ctx, location))
+}
+
+func TestLocationHooks(t *testing.T) {
+	// Every hook appends its phase and a few variables to the same file, in the order they ran
+	dir := t.TempDir()
+	out := filepath.Join(dir, "hooks.txt")
+	hook := func(name string) string {
+		return `echo "` + name + ` $SPEEDTEST_HOOK $SPEEDTEST_LOCATION $SPEEDTEST_REGION $SPEEDTEST_STATUS $SPEEDTEST_DOWNLOAD_MBPS $SPEEDTEST_ERROR" >> ` + out
+	}
+	runner := newTestRunner(t)
+	runner.PreHook, runner.PostHook = hook("global"), hook("global")
+	location := results.Location{Country: "Japan", City: "Tokyo", PreHook: hook("location"), PostHook: hook("location")}
+
+	hooks := runner.startLocationHooks(location, "Japan - Tokyo")
+	hooks.record(results.VPNStat{Region: "Japan - Tokyo - 2", VPNDownloadSpeed: "95.50Mbps"})
+	hooks.finish()
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "global pre Japan, Tokyo Japan - Tokyo   \n"+
+		"location pre Japan, Tokyo Japan - Tokyo   \n"+
+		"location post Japan, Tokyo Japan - Tokyo - 2 ok 95.50 \n"+
+		"global post Japan, Tokyo Japan - Tokyo - 2 ok 95.50 \n", string(data))
+
+	// A failed location passes the reason it failed with; a failing hook doesn't stop the others
+	os.Remove(out)
+	runner.failedLocations = []string{"Japan, Tokyo: failed to connect"}
+	runner.PreHook = "exit 1"
+	location.PreHook = ""
+	runner.startLocationHooks(location, "Japan - Tokyo").finish()
+	data, err = os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "location post Japan, Tokyo Japan - Tokyo failed  failed to connect\n"+
+		"global post Japan, Tokyo Japan - Tokyo failed  failed to connect\n", string(data))
+
+	// Without hooks there is nothing to run afterwards
+	runner.PreHook, runner.PostHook = "", ""
+	assert.Nil(t, runner.startLocationHooks(results.Location{Country: "Japan"}, "Japan"))
+}
+
+func TestCustomProbes(t *testing.T) {
+	runner := newTestRunner(t)
+	runner.Probes = []Probe{
+		{Name: "game", Command: `echo "{\"location\": \"$SPEEDTEST_LOCATION\", \"probe\": \"$SPEEDTEST_PROBE\"}"`},
+		{Name: "bad", Command: "echo not json"},
+		{Name: "fail", Command: "echo 'no route' >&2; exit 3"},
+	}
+
+	measured := runner.runProbes(context.Background(), results.Location{Country: "Japan", City: "Tokyo"}, "Japan - Tokyo")
+	assert.JSONEq(t, `{"location": "Japan, Tokyo", "probe": "game"}`, string(measured["game"]))
+	assert.Contains(t, string(measured["bad"]), `"error":"probe printed invalid JSON`)
+	assert.JSONEq(t, `{"error": "exit status 3: no route"}`, string(measured["fail"]))
+
+	// Stored in the result as the JSON the probe printed
+	data, err := json.Marshal(results.VPNStat{CustomProbes: map[string]json.RawMessage{"game": measured["game"]}})
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"CustomProbes":{"game":{"location":"Japan, Tokyo","probe":"game"}}`)
+
+	runner.Probes = nil
+	assert.Nil(t, runner.runProbes(context.Background(), results.Location{Country: "Japan"}, "Japan"))
+}
+
+func TestConnectPhases(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	sleep = func(time.Duration) {}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "expressvpnctl")
+	err := os.WriteFile(script, []byte("#!/bin/sh\n[ \"$1\" = get ] && echo Connected\nexit 0\n"), 0755)
+	assert.NoError(t, err)
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"expressvpnctl": {Path: script}}
+	runner.provider = runner.expressVPN()
+
+	// The first probe connects to a local listener standing in for the host behind the tunnel
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	runner.FirstProbeTarget = listener.Addr().String()
+
+	runner.ConnectPhases = true
+	duration, err := runner.connectRegion(context.Background(), "netherlands-amsterdam")
+	assert.NoError(t, err)
+	assert.NotNil(t, runner.lastPhases)
+	assert.Equal(t, duration.String(), runner.lastPhases.Connected)
+	assert.Equal(t, "Connected", runner.lastPhases.States[0].State)
+	assert.NotEmpty(t, runner.lastPhases.FirstProbe)
+
+	// Without the flag, or with a provider that can't tell, only the duration is measured
+	runner.ConnectPhases = false
+	_, err = runner.connectRegion(context.Background(), "netherlands-amsterdam")
+	assert.NoError(t, err)
+	assert.Nil(t, runner.lastPhases)
+}
+
+func TestConnectionDrops(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	sleep = func(time.Duration) {}
+
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	// The first test makes the client reconnect, which it reports once before it is connected again
+	dir := t.TempDir()
+	state := filepath.Join(dir, "state")
+	assert.NoError(t, os.WriteFile(state, []byte("Connected\n"), 0644))
+	ctl := filepath.Join(dir, "expressvpnctl")
+	err := os.WriteFile(ctl, []byte("#!/bin/sh\ncat "+state+"\necho Connected > "+state+"\n"), 0755)
+	assert.NoError(t, err)
+	speedtestScript := filepath.Join(dir, "speedtest")
+	err = os.WriteFile(speedtestScript, []byte(`#!/bin/sh
+n=$(($(cat `+dir+`/count 2>/dev/null || echo 0) + 1))
+echo $n > `+dir+`/count
+[ $n -eq 1 ] && echo Reconnecting > `+state+`
+echo '{"download": {"bandwidth": 12500000}, "upload": {"bandwidth": 2500000}, "ping": {"latency": 20}}'
+`), 0755)
+	assert.NoError(t, err)
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"expressvpnctl": {Path: ctl}, "speedtest": {Path: speedtestScript}}
+	runner.provider = runner.expressVPN()
+	runner.Samples, runner.Parallel = 2, false
+	stat, ok := runner.runSamples(context.Background(), "1s", 2, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 2, stat.SamplesSucceeded)
+	assert.Equal(t, 1, stat.InvalidSamples)
+	assert.Len(t, stat.ConnectionDrops, 1)
+	assert.Equal(t, "Reconnecting", stat.ConnectionDrops[0].State)
+	count, _ := os.ReadFile(filepath.Join(dir, "count"))
+	assert.Equal(t, "3\n", string(count))
+
+	// A connection that drops during every test fails the sample after the retries
+	err = os.WriteFile(ctl, []byte("#!/bin/sh\necho Reconnecting\n"), 0755)
+	assert.NoError(t, err)
+	runner.ConnectTimeout = 0
+	stat, ok = runner.runSamples(context.Background(), "1s", 1, 1)
+	assert.False(t, ok)
+	assert.Equal(t, []string{errConnectionDropped.Error()}, stat.SampleErrors)
+	assert.Equal(t, 1, stat.InvalidSamples)
+
+	// Tests without VPN aren't watched
+	assert.Nil(t, runner.startConnectionWatch(""))
+}
+
+func TestRotateResults(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"results-20250301080000.json", "results-20250301080000-timeline.json",
+		"results-20250310080000-ipv6.json",
+		"results-20250320080000.json",
+		"results-20250330080000.json",
+		"nightly.json", "results-latest.json",
+	}
+	for _, name := range names {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644))
+	}
+	remaining := func() []string {
+		entries, _ := os.ReadDir(dir)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+	now := time.Date(2025, 4, 1, 8, 0, 0, 0, time.Local)
+
+	// Without retention options nothing is removed
+	runner := newTestRunner(t)
+	runner.ResultsFile = filepath.Join(dir, "results-20250330080000.json")
+	runner.rotateResults(now)
+	assert.Len(t, remaining(), len(names))
+
+	// Older than 25 days, with its timeline
+	runner.KeepDays = 25
+	runner.rotateResults(now)
+	assert.Equal(t, []string{"nightly.json", "results-20250310080000-ipv6.json", "results-20250320080000.json", "results-20250330080000.json", "results-latest.json"}, remaining())
+
+	// Only the newest, but never the file of the current run
+	runner.KeepDays, runner.KeepLast = 0, 1
+	runner.ResultsFile = filepath.Join(dir, "results-20250310080000-ipv6.json")
+	runner.rotateResults(now)
+	assert.Equal(t, []string{"nightly.json", "results-20250310080000-ipv6.json", "results-20250330080000.json", "results-latest.json"}, remaining())
+}
+
+func TestResultURLs(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	sleep = func(time.Duration) {}
+
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	dir := t.TempDir()
+	ctl := filepath.Join(dir, "expressvpnctl")
+	assert.NoError(t, os.WriteFile(ctl, []byte("#!/bin/sh\necho Connected\n"), 0755))
+	speedtestScript := filepath.Join(dir, "speedtest")
+	err := os.WriteFile(speedtestScript, []byte(`#!/bin/sh
+n=$(($(cat `+dir+`/count 2>/dev/null || echo 0) + 1))
+echo $n > `+dir+`/count
+echo '{"download": {"bandwidth": 12500000}, "upload": {"bandwidth": 2500000}, "ping": {"latency": 20}, "result": {"url": "https://www.speedtest.net/result/c/'$n'"}}'
+`), 0755)
+	assert.NoError(t, err)
+	runner := newTestRunner(t)
+	runner.Commands = command.Overrides{"expressvpnctl": {Path: ctl}, "speedtest": {Path: speedtestScript}}
+	runner.provider = runner.expressVPN()
+	runner.Samples, runner.Parallel = 2, false
+	stat, ok := runner.runSamples(context.Background(), "1s", 2, 1)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"https://www.speedtest.net/result/c/1", "https://www.speedtest.net/result/c/2"}, stat.ResultURLs)
+
+	var report bytes.Buffer
+	stat.LocationName = "Netherlands, Amsterdam"
+	assert.NoError(t, Display{}.RenderHTMLReport(&report, results.Results{VPNStats: []results.VPNStat{stat}}))
+	assert.Contains(t, report.String(), `<a href="https://www.speedtest.net/result/c/2">#2</a>`)
+
+	// They name the speed test server
+	redactStat(&stat)
+	assert.Nil(t, stat.ResultURLs)
+}
+
+func TestNamespaces(t *testing.T) {
+	pterm.DisableOutput()
+	defer pterm.EnableOutput()
+
+	dir, bin := t.TempDir(), t.TempDir()
+	for _, name := range []string{"nl-ams", "se-got", "broken"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".conf"), []byte("[Interface]\nAddress = 10.64.0.2/32\n"), 0600))
+	}
+	calls := filepath.Join(bin, "calls")
+	scripts := map[string]string{
+		// Runs the binaries of netns exec, like ip does inside the namespace
+		"ip":        "echo ip \"$@\" >> " + calls + "\n[ \"$1 $2\" = \"netns exec\" ] && shift 3 && exec \"$@\"\nexit 0",
+		"wg":        "exit 0",
+		"wg-quick":  "case \"$2\" in *broken.conf) echo 'Line unrecognized' >&2; exit 1;; esac\necho '[Interface]'",
+		"speedtest": "echo '{\"download\": {\"bandwidth\": 12500000}, \"upload\": {\"bandwidth\": 2500000}, \"ping\": {\"latency\": 20}}'",
+	}
+	commands := command.Overrides{}
+	for name, script := range scripts {
+		assert.NoError(t, os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0755))
+		commands[name] = command.Config{Path: filepath.Join(bin, name)}
+	}
+
+	runner := newTestRunner(t)
+	runner.Commands = commands
+	runner.Namespaces = 2
+	runner.Samples = 2
+	locations, err := runner.AddInput(results.InputData{Providers: []results.ProviderInput{{Name: "Self-hosted", Type: "wireguard", Dir: dir}}})
+	assert.NoError(t, err)
+	assert.NoError(t, runner.checkNamespaces(locations))
+	assert.NoError(t, runner.prepareResults())
+	runner.testInNamespaces(context.Background(), locations)
+
+	data, err := results.FileOptions{}.Load(runner.ResultsFile)
+	assert.NoError(t, err)
+	assert.Len(t, data.VPNStats, 2)
+	for _, stat := range data.VPNStats {
+		assert.Equal(t, "100.00Mbps", stat.VPNDownloadSpeed)
+		assert.Equal(t, "Tests ran in parallel, 2 regions at a time in network namespaces", stat.Mode)
+		assert.Equal(t, 2, stat.SamplesSucceeded)
+	}
+	assert.Equal(t, 2, runner.testedLocations)
+	assert.Equal(t, []string{"Self-hosted: broken, : failed to connect"}, runner.failedLocations)
+
+	log, err := os.ReadFile(calls)
+	assert.NoError(t, err)
+	assert.Regexp(t, `ip netns exec vpnbench[12] \S+/speedtest -f json`, string(log), "The speed tests run inside a namespace")
+	assert.Contains(t, string(log), "ip netns delete vpnbench1\n")
+	assert.Contains(t, string(log), "ip netns delete vpnbench2\n")
+
+	runner.Engine = "native"
+	assert.ErrorContains(t, runner.checkNamespaces(locations), "ookla or iperf3")
+	runner.Engine = "ookla"
+	runner.VerifyExitIP, runner.Soak = true, time.Minute
+	assert.ErrorContains(t, runner.checkNamespaces(locations), "can't be combined with -verify-ip, -soak")
+	runner.VerifyExitIP, runner.Soak = false, 0
+	assert.ErrorContains(t, runner.checkNamespaces([]results.Location{{Country: "Germany"}}), "Germany isn't", "The ExpressVPN client can't run in a namespace")
+}
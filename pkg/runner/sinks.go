@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Sink receives every saved location result in addition to the results file
+type Sink interface {
+	Write(data results.Results, stat results.VPNStat) error
+	String() string
+}
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// Parses a sink given as KIND:TARGET, e.g. csv:results.csv or webhook:https://example.com/hook; JSON
+// copies are saved with the files options and CSV times shown with the display options
+func ParseSink(output string, files results.FileOptions, display Display) (Sink, error) {
+	kind, target, ok := strings.Cut(output, ":")
+	if !ok || target == "" {
+		return nil, fmt.Errorf("unknown output %q, expected text, ndjson, json:FILE, csv:FILE or webhook:URL", output)
+	}
+
+	switch kind {
+	case "json":
+		return jsonSink{fileName: target, files: files}, nil
+	case "csv":
+		return csvSink{fileName: target, display: display}, nil
+	case "webhook":
+		return webhookSink{url: target}, nil
+	default:
+		return nil, fmt.Errorf("unknown output kind %q, expected json, csv or webhook", kind)
+	}
+}
+
+// Passes a saved result to every configured sink; a failing sink doesn't stop the others
+func (r *Runner) writeToSinks(data results.Results, stat results.VPNStat) {
+	for _, sink := range r.Sinks {
+		if err := sink.Write(data, stat); err != nil {
+			slog.Error("Error writing to output", "output", sink.String(), "err", err)
+		}
+	}
+}
+
+// jsonSink keeps a full copy of the results at another path
+type jsonSink struct {
+	fileName string
+	files    results.FileOptions
+}
+
+func (s jsonSink) Write(data results.Results, stat results.VPNStat) error {
+	return s.files.Save(data, s.fileName)
+}
+
+func (s jsonSink) String() string {
+	return "json:" + s.fileName
+}
+
+// csvSink appends one row per location, writing a header when the file is new
+type csvSink struct {
+	fileName string
+	display  Display
+}
+
+var csvHeader = []string{"MachineName", "LocationName", "TimeToConnect", "VPNDownloadSpeed", "VPNUploadSpeed",
+	"VPNLatency", "VPNJitter", "VPNPacketLoss", "Server", "Date/Time", "Mode"}
+
+func (s csvSink) Write(data results.Results, stat results.VPNStat) error {
+	_, err := os.Stat(s.fileName)
+	isNew := os.IsNotExist(err)
+
+	file, err := os.OpenFile(s.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if isNew {
+		w.Write(csvHeader)
+	}
+	w.Write([]string{data.MachineName, stat.LocationName, stat.TimeToConnect, stat.VPNDownloadSpeed, stat.VPNUploadSpeed,
+		stat.VPNLatency, stat.VPNJitter, stat.VPNPacketLoss, stat.Server, s.display.displayTime(stat.Timestamp), stat.Mode})
+	w.Flush()
+	return w.Error()
+}
+
+func (s csvSink) String() string {
+	return "csv:" + s.fileName
+}
+
+// webhookSink posts each result as JSON to a URL
+type webhookSink struct {
+	url string
+}
+
+func (s webhookSink) Write(data results.Results, stat results.VPNStat) error {
+	payload, err := json.Marshal(struct {
+		MachineName string          `json:"MachineName"`
+		WithoutVPN  string          `json:"WithoutVPN"`
+		VPNStat     results.VPNStat `json:"VPNStat"`
+	}{data.MachineName, data.WithoutVPN, stat})
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(s.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s webhookSink) String() string {
+	return "webhook:" + s.url
+}
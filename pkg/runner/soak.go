@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Stays connected for the duration, running a speed test every SoakInterval and polling the
+// connection state in between; ends early once the context is done
+func (r *Runner) runSoak(ctx context.Context, duration time.Duration) *results.Soak {
+	soak := &results.Soak{Duration: duration.String()}
+	spinner := r.startSpinner(fmt.Sprintf("Soaking the connection for %v...", duration))
+
+	start := time.Now()
+	deadline := start.Add(duration)
+	nextSample := start
+	state := "Connected"
+
+	for now := start; now.Before(deadline) && ctx.Err() == nil; now = time.Now() {
+		if !now.Before(nextSample) {
+			sample := results.SoakSample{Time: results.FormatTime(now)}
+			if result, err := r.runSpeedTest(ctx); err != nil {
+				sample.Error = err.Error()
+			} else {
+				sample.VPNDownloadSpeed = fmt.Sprintf("%.2fMbps", bytesToMbps(result.Download.Bandwidth))
+				sample.VPNUploadSpeed = fmt.Sprintf("%.2fMbps", bytesToMbps(result.Upload.Bandwidth))
+				sample.VPNLatency = fmt.Sprintf("%.2fms", result.Ping.Latency)
+			}
+			soak.Samples = append(soak.Samples, sample)
+			nextSample = nextSample.Add(r.SoakInterval)
+		}
+
+		// A router's connection state isn't visible from the LAN
+		if !r.Router {
+			current, err := r.provider.State()
+			if err != nil {
+				current = "Unknown"
+			}
+			soak.ObserveState(state, current, time.Now())
+			state = current
+		}
+
+		sleep(min(r.SoakPollInterval, time.Until(deadline)))
+	}
+
+	spinner.Success(fmt.Sprintf("Soak completed: %d samples, %d disconnects", len(soak.Samples), soak.Disconnects))
+	if soak.Disconnects > 0 {
+		slog.Warn("Connection dropped during soak", "disconnects", soak.Disconnects)
+	}
+	return soak
+}
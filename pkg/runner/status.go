@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pterm/pterm"
+)
+
+// statusLines shows one live line per parallel speed test, redrawn in place, since concurrent
+// spinners overwrite each other's lines
+type statusLines struct {
+	r     *Runner
+	mutex sync.Mutex
+	area  *pterm.AreaPrinter
+	lines []string
+}
+
+// Starts the status lines with every test queued; nothing is drawn when output is disabled
+func (r *Runner) startStatusLines(count int) *statusLines {
+	s := &statusLines{r: r, lines: make([]string, count)}
+	for i := range s.lines {
+		s.lines[i] = testStatus(i, "queued")
+	}
+	if pterm.Output && !r.Plain {
+		s.area, _ = pterm.DefaultArea.Start(s.render())
+	}
+	return s
+}
+
+// Replaces the status of test i
+func (s *statusLines) set(i int, status string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lines[i] = testStatus(i, status)
+	if s.area != nil {
+		s.area.Update(s.render())
+	} else if s.r.Plain {
+		s.r.printText(s.lines[i])
+	}
+}
+
+// Leaves the final statuses on screen
+func (s *statusLines) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.area != nil {
+		s.area.Stop()
+	}
+}
+
+func (s *statusLines) render() string {
+	return strings.Join(s.lines, "\n")
+}
+
+func testStatus(i int, status string) string {
+	return pterm.Sprintf("Speed test #%d: %s", i+1, status)
+}
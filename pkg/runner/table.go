@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pterm/pterm"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Builds the report table with one row per location
+func (d Display) ReportTable(data results.Results) pterm.TableData {
+	table := pterm.TableData{{"Location", "Connect", "Download", "Upload", "Of baseline", "Latency", "Jitter", "Packet loss"}}
+	for _, stat := range data.VPNStats {
+		table = append(table, []string{
+			stat.LocationName,
+			stat.TimeToConnect,
+			d.displaySpeed(stat.VPNDownloadSpeed),
+			d.displaySpeed(stat.VPNUploadSpeed),
+			displayPercentOfBaseline(stat.PercentOfBaseline),
+			stat.VPNLatency,
+			stat.VPNJitter,
+			stat.VPNPacketLoss,
+		})
+	}
+	return table
+}
+
+// Formats a location's speeds as a percentage of the baseline's, e.g. "85% ▼  92% ▲  of wired"; "-" without a
+// baseline
+func displayPercentOfBaseline(percent *results.PercentOfBaseline) string {
+	if percent == nil {
+		return "-"
+	}
+	var parts []string
+	if percent.Download > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f%% ▼", percent.Download))
+	}
+	if percent.Upload > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f%% ▲", percent.Upload))
+	}
+	if percent.Profile != "" {
+		parts = append(parts, "of "+percent.Profile)
+	}
+	return strings.Join(parts, "  ")
+}
+
+// Builds a table of each location's average download and upload speeds in each time window,
+// or nil when the results have no time windows
+func (d Display) TimeOfDayTable(data results.Results) pterm.TableData {
+	type cell struct {
+		download, upload results.RunningStats
+	}
+	var locations, windows []string
+	cells := map[[2]string]*cell{}
+	for _, stat := range data.VPNStats {
+		if stat.TimeWindow == "" {
+			continue
+		}
+		key := [2]string{stat.LocationName, stat.TimeWindow}
+		c, ok := cells[key]
+		if !ok {
+			c = &cell{}
+			cells[key] = c
+		}
+		c.download.Add(results.ParseMbps(stat.VPNDownloadSpeed))
+		c.upload.Add(results.ParseMbps(stat.VPNUploadSpeed))
+		if !slices.Contains(locations, stat.LocationName) {
+			locations = append(locations, stat.LocationName)
+		}
+		if !slices.Contains(windows, stat.TimeWindow) {
+			windows = append(windows, stat.TimeWindow)
+		}
+	}
+	if len(windows) == 0 {
+		return nil
+	}
+	slices.Sort(windows)
+
+	table := pterm.TableData{append([]string{"Location"}, windows...)}
+	for _, location := range locations {
+		row := []string{location}
+		for _, window := range windows {
+			c, ok := cells[[2]string{location, window}]
+			if !ok {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, d.FormatSpeed(c.download.Mean)+" ▼ "+d.FormatSpeed(c.upload.Mean)+" ▲")
+		}
+		table = append(table, row)
+	}
+	return table
+}
@@ -0,0 +1,29 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// Returns the context a location's connect and tests run under, which ends after LocationTimeout
+func (r *Runner) locationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.LocationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.LocationTimeout)
+}
+
+// Reports whether the location ran out of its LocationTimeout, recording it as failed so the run moves on
+func (r *Runner) locationTimedOut(ctx context.Context, location results.Location) bool {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return false
+	}
+	slog.Warn("Skipping: The location exceeded its timeout", "country", location.Country, "city", location.City, "timeout", r.LocationTimeout)
+	r.timedOutLocations = append(r.timedOutLocations, location.Key())
+	r.recordFailure(location, fmt.Sprintf("timed out after %s", r.LocationTimeout))
+	return true
+}
@@ -0,0 +1,294 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pterm/pterm"
+	"golang.org/x/term"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+// A location as shown in the interactive table
+type tuiRow struct {
+	Location string
+	Status   string
+	Download string
+	Upload   string
+	Latency  string
+}
+
+const tuiSampleLines = 5 // Most recent samples shown below the table
+const tuiLogLines = 5    // Most recent log lines shown below the samples
+
+// tui is the full-screen interactive view of a run
+type tui struct {
+	r         *Runner
+	mutex     sync.Mutex
+	rows      []tuiRow
+	current   int // Index of the location being tested, -1 during the baseline
+	phase     string
+	baseline  string
+	samples   []string
+	log       []string
+	start     time.Time
+	area      *pterm.AreaPrinter
+	areaMutex sync.Mutex  // Serializes redraws from the location loop, parallel tests and key presses
+	terminal  *term.State // Terminal state to restore when the TUI stops
+}
+
+// Collects log lines for the log pane, as stderr output would tear the live area
+type tuiLogWriter struct {
+	t *tui
+}
+
+func (w tuiLogWriter) Write(p []byte) (int, error) {
+	w.t.mutex.Lock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.t.log = appendRecent(w.t.log, line, tuiLogLines)
+	}
+	w.t.mutex.Unlock()
+	w.t.refresh()
+	return len(p), nil
+}
+
+// Appends a line and keeps only the most recent n
+func appendRecent(lines []string, line string, n int) []string {
+	lines = append(lines, line)
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// Takes over the terminal: spinners and text output are replaced by a live table,
+// logs go to the log pane and single key presses control the run
+func (r *Runner) startTUI(locations []results.Location) {
+	t := &tui{r: r, current: -1, phase: "baseline", start: time.Now()}
+	for _, location := range locations {
+		t.rows = append(t.rows, tuiRow{Location: location.Key(), Status: "pending"})
+	}
+	r.tui = t
+
+	pterm.DisableOutput()
+	r.output = "tui"
+	if r.RedirectLogs != nil {
+		r.RedirectLogs(tuiLogWriter{t})
+	}
+
+	if state, err := term.MakeRaw(int(os.Stdin.Fd())); err == nil {
+		t.terminal = state
+		go r.readKeys(os.Stdin)
+	} else {
+		slog.Warn("Keyboard controls unavailable, stdin is not a terminal", "err", err)
+	}
+
+	t.areaMutex.Lock()
+	t.area, _ = pterm.DefaultArea.Start(t.render())
+	t.areaMutex.Unlock()
+}
+
+// Restores the terminal, leaving the final table on screen
+func (r *Runner) stopTUI() {
+	t := r.tui
+	if t == nil {
+		return
+	}
+	t.refresh()
+	t.areaMutex.Lock()
+	t.area.Stop()
+	t.area = nil
+	t.areaMutex.Unlock()
+	if t.terminal != nil {
+		term.Restore(int(os.Stdin.Fd()), t.terminal)
+		t.terminal = nil
+	}
+	if r.RedirectLogs != nil {
+		r.RedirectLogs(nil)
+	}
+	pterm.EnableOutput()
+	r.tui = nil
+	r.output = r.Output
+}
+
+// Handles key presses until the input closes: "s" skips the current location,
+// "q" or Ctrl+C aborts after the current step
+func (r *Runner) readKeys(in io.Reader) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := in.Read(buf); err != nil {
+			return
+		}
+		switch buf[0] {
+		case 's', 'S':
+			r.skipRequested.Store(true)
+			slog.Info("Skipping the current location after the current step")
+		case 'q', 'Q', 3:
+			r.abortRequested.Store(true)
+			slog.Info("Aborting after the current step")
+		}
+		r.refreshTUI()
+	}
+}
+
+// Aborts after the current step, as the "q" key does, so the VPN is disconnected and the run can be
+// resumed; source tells how the abort was requested, e.g. "by an interrupt"
+func (r *Runner) RequestAbort(source string) {
+	r.abortSource = source
+	r.abortRequested.Store(true)
+	r.refreshTUI()
+}
+
+// Renders the header, location table, recent samples, log and key help
+func (t *tui) render() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "ExpressVPN speed test - %s - %s elapsed\n", t.phase, time.Since(t.start).Round(time.Second))
+	if t.baseline != "" {
+		fmt.Fprintf(&b, "Without VPN: %s\n", t.r.DisplaySpeeds(t.baseline))
+	}
+
+	data := pterm.TableData{{"", "Location", "Status", "Download", "Upload", "Latency"}}
+	for i, row := range t.rows {
+		marker := ""
+		if i == t.current {
+			marker = ">"
+		}
+		data = append(data, []string{marker, row.Location, row.Status, row.Download, row.Upload, row.Latency})
+	}
+	table, _ := pterm.DefaultTable.WithHasHeader().WithData(data).Srender()
+	b.WriteString(table + "\n")
+
+	b.WriteString("\nLatest samples:\n")
+	for _, sample := range t.samples {
+		b.WriteString("  " + sample + "\n")
+	}
+	b.WriteString("\nLog:\n")
+	for _, line := range t.log {
+		b.WriteString("  " + line + "\n")
+	}
+
+	switch {
+	case t.r.abortRequested.Load():
+		b.WriteString("\nAborting after the current step...")
+	case t.r.skipRequested.Load():
+		b.WriteString("\nSkipping the current location after the current step...")
+	default:
+		b.WriteString("\n[s] skip location  [q] abort")
+	}
+
+	// The terminal is in raw mode, where a newline no longer returns the cursor
+	return strings.ReplaceAll(b.String(), "\n", "\r\n")
+}
+
+// Redraws the live area
+func (t *tui) refresh() {
+	t.areaMutex.Lock()
+	defer t.areaMutex.Unlock()
+
+	if t.area != nil {
+		t.area.Update(t.render())
+	}
+}
+
+// Redraws the live area while the TUI is up
+func (r *Runner) refreshTUI() {
+	if t := r.tui; t != nil {
+		t.refresh()
+	}
+}
+
+// Marks the location at the 1-based index as being in the given phase
+func (r *Runner) tuiUpdatePhase(phase string, index int) {
+	t := r.tui
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	t.phase = phase
+	if index > 0 && index <= len(t.rows) {
+		t.current = index - 1
+		t.rows[t.current].Status = phase
+	}
+	t.mutex.Unlock()
+	t.refresh()
+}
+
+// Adds a finished speed test to the recent samples
+func (r *Runner) tuiAddSample(record SampleRecord) {
+	t := r.tui
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	sample := fmt.Sprintf("%s: %s down, %s up, %.0fms", record.LocationName, r.FormatSpeed(record.DownloadMbps), r.FormatSpeed(record.UploadMbps), record.LatencyMs)
+	t.samples = appendRecent(t.samples, sample, tuiSampleLines)
+	t.mutex.Unlock()
+	t.refresh()
+}
+
+// Shows the baseline speed without VPN in the header
+func (r *Runner) tuiSetBaseline(speed string) {
+	t := r.tui
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	t.baseline = speed
+	t.mutex.Unlock()
+	t.refresh()
+}
+
+// Fills in the averaged result of the current location
+func (r *Runner) tuiSetResult(stat results.VPNStat) {
+	t := r.tui
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	if t.current >= 0 && t.current < len(t.rows) {
+		row := &t.rows[t.current]
+		row.Status = "done"
+		row.Download = r.displaySpeed(stat.VPNDownloadSpeed)
+		row.Upload = r.displaySpeed(stat.VPNUploadSpeed)
+		row.Latency = stat.VPNLatency
+	}
+	t.mutex.Unlock()
+	t.refresh()
+}
+
+// Sets the status of a location that produced no result, such as "failed to connect"
+func (r *Runner) tuiSetStatus(location results.Location, status string) {
+	t := r.tui
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	for i := range t.rows {
+		if t.rows[i].Location == location.Key() {
+			t.rows[i].Status = status
+		}
+	}
+	t.mutex.Unlock()
+	t.refresh()
+}
+
+// Reports whether the current location should be skipped, consuming a pending skip;
+// an abort skips the location as well so the run can stop without waiting for it
+func (r *Runner) skipCurrentLocation(location results.Location) bool {
+	if !r.skipRequested.Swap(false) && !r.abortRequested.Load() {
+		return false
+	}
+	slog.Warn("Skipping: Requested from the keyboard", "country", location.Country, "city", location.City)
+	r.skippedLocations = append(r.skippedLocations, location.Key())
+	r.tuiSetStatus(location, "skipped")
+	return true
+}
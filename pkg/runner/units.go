@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"fmt"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+var SpeedUnits = []string{"Mbps", "MB/s", "Gbps"}
+
+// Display is how speeds and times are shown on the console and in reports
+type Display struct {
+	Unit      string // Mbps, MB/s or Gbps; empty for Mbps
+	LocalTime bool   // Show the times of results as local times instead of as stored in UTC
+}
+
+// Converts a bandwidth in bytes per second, as reported by the speed test engines, to Mbps
+// without truncating slow connections to 0
+func bytesToMbps(bandwidth int64) float64 {
+	return float64(bandwidth) * 8 / 1e6
+}
+
+// Formats a speed given in Mbps in the selected unit
+func (d Display) FormatSpeed(mbps float64) string {
+	switch d.Unit {
+	case "MB/s":
+		return fmt.Sprintf("%.2fMB/s", mbps/8)
+	case "Gbps":
+		return fmt.Sprintf("%.3fGbps", mbps/1000)
+	default:
+		return fmt.Sprintf("%.2fMbps", mbps)
+	}
+}
+
+// Formats a speed stored in the results, such as "397.00Mbps", in the selected unit
+func (d Display) displaySpeed(stored string) string {
+	return d.FormatSpeed(results.ParseMbps(stored))
+}
+
+// Formats every stored speed in a string such as "849.00Mbps ▼  845.00Mbps ▲" in the selected unit
+func (d Display) DisplaySpeeds(s string) string {
+	return speedPattern.ReplaceAllStringFunc(s, d.displaySpeed)
+}
+
+// Formats a time stored in the results for reports: as stored, or as a local time with LocalTime
+func (d Display) displayTime(stored string) string {
+	if !d.LocalTime {
+		return stored
+	}
+	t, err := results.ParseTime(stored)
+	if err != nil {
+		return stored
+	}
+	return t.Local().Format(RunTimeLayout)
+}
@@ -0,0 +1,98 @@
+package runner
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+)
+
+var webTLSConfig *tls.Config // Replaced in tests to trust their server
+
+// Fetches every URL through the VPN, timing DNS, connect, TLS and the first byte, as a browser's
+// first visit would experience them; each fetch gives up after the timeout
+func benchmarkWeb(ctx context.Context, urls []string, timeout time.Duration) []results.WebTiming {
+	var timings []results.WebTiming
+	for _, url := range urls {
+		if url = strings.TrimSpace(url); url == "" {
+			continue
+		}
+		timing := fetchTimed(ctx, url, timeout)
+		if timing.Error != "" {
+			slog.Warn("Web probe failed", "url", url, "err", timing.Error)
+		} else {
+			slog.Debug("Web probe finished", "url", url, "ttfb", timing.TTFB, "total", timing.Total)
+		}
+		timings = append(timings, timing)
+	}
+	return timings
+}
+
+// Fetches a URL on a fresh connection, so every phase is measured rather than reused
+func fetchTimed(ctx context.Context, url string, timeout time.Duration) results.WebTiming {
+	timing := results.WebTiming{URL: url}
+
+	var dnsStart, connectStart, tlsStart, firstByte time.Time
+	var dns, connect, handshake time.Duration
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { dns = time.Since(dnsStart) },
+		ConnectStart:         func(string, string) { connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { connect = time.Since(connectStart) },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { handshake = time.Since(tlsStart) },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, url, nil)
+	if err != nil {
+		timing.Error = err.Error()
+		return timing
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		TLSClientConfig:   webTLSConfig,
+		DisableKeepAlives: true,
+	}}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		timing.Error = err.Error()
+		return timing
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	total := time.Since(start)
+	if err != nil {
+		timing.Error = err.Error()
+		return timing
+	}
+
+	timing.Status = resp.StatusCode
+	timing.DNS = formatMillis(dns)
+	timing.Connect = formatMillis(connect)
+	timing.TLS = formatMillis(handshake)
+	if !firstByte.IsZero() {
+		timing.TTFB = formatMillis(firstByte.Sub(start))
+	}
+	timing.Total = formatMillis(total)
+	return timing
+}
+
+// Formats a duration as "12.34ms", or an empty string for a phase that didn't happen
+func formatMillis(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2fms", float64(d.Microseconds())/1000)
+}
@@ -0,0 +1,284 @@
+// Package speedtest measures throughput and latency with one of several engines: the Ookla
+// speedtest CLI, the embedded speedtest-go library, iperf3 or plain HTTP transfers
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	stgo "github.com/showwin/speedtest-go/speedtest"
+)
+
+// Result is a single speed test in the Ookla CLI's JSON schema, which every engine reports in
+type Result struct {
+	Ping struct {
+		Latency float64 `json:"latency"`
+		Jitter  float64 `json:"jitter"`
+	} `json:"ping"`
+	Download struct {
+		Bandwidth int64 `json:"bandwidth"`
+		Bytes     int64 `json:"bytes"` // Transferred by the test; 0 when the engine doesn't report it
+	} `json:"download"`
+	Upload struct {
+		Bandwidth int64 `json:"bandwidth"`
+		Bytes     int64 `json:"bytes"`
+	} `json:"upload"`
+	Server struct {
+		Host     string `json:"host"`
+		Name     string `json:"name"`
+		Country  string `json:"country"`
+		Location string `json:"location"`
+	} `json:"server"`
+	PacketLoss float64 `json:"packetLoss"`
+	ISP        string  `json:"isp"`
+	Interface  struct {
+		ExternalIP string `json:"externalIp"`
+	} `json:"interface"`
+	Result struct {
+		URL string `json:"url"` // Official result page on speedtest.net; only the Ookla CLI reports one
+	} `json:"result"`
+}
+
+// Options configure the speed tests and latency measurements; start from DefaultOptions
+type Options struct {
+	Commands command.Overrides // How speedtest and iperf3 are invoked
+
+	// Phases of a speed test to skip; the native, http and iperf3 engines honor them, the Ookla CLI
+	// always runs every phase
+	SkipDownload, SkipUpload, SkipLatency bool
+
+	// IP version to force the tests over, 4 or 6; 0 leaves the choice to the engine. The native, http and
+	// iperf3 engines and latency measurements honor it, the Ookla CLI can't be forced
+	IPVersion int
+
+	// Pass --accept-license and --accept-gdpr to the Ookla CLI, so a fresh install doesn't stop at its
+	// first-run prompts
+	AcceptLicense bool
+
+	HTTPDownloadURL string // {bytes} is replaced with HTTPPayloadSize
+	HTTPUploadURL   string
+	HTTPPayloadSize int64 // Bytes per direction
+
+	IperfServer   string // host:port of the iperf3 server, the port defaults to 5201
+	IperfDuration int    // Seconds per direction
+
+	LatencyTarget  string // host:port latency-only measurements connect to
+	LatencyCount   int    // Connections per measurement
+	LatencyTimeout time.Duration
+}
+
+// Returns the options the CLI starts from
+func DefaultOptions() Options {
+	return Options{
+		AcceptLicense:   true,
+		HTTPDownloadURL: "https://speed.cloudflare.com/__down?bytes={bytes}",
+		HTTPUploadURL:   "https://speed.cloudflare.com/__up",
+		HTTPPayloadSize: 25_000_000,
+		IperfDuration:   10,
+		LatencyTarget:   "1.1.1.1:443",
+		LatencyCount:    10,
+		LatencyTimeout:  2 * time.Second,
+	}
+}
+
+// Engines that can skip phases
+var PhaseEngines = []string{"native", "http", "iperf3"}
+
+// Engines that can be forced to an IP version
+var IPVersionEngines = []string{"native", "http", "iperf3"}
+
+// Returns the network to dial for IPVersion, e.g. tcp4 for tcp
+func (o Options) ipNetwork(network string) string {
+	if o.IPVersion == 0 {
+		return network
+	}
+	return network + strconv.Itoa(o.IPVersion)
+}
+
+// SpeedEngine runs a single speed test and reports bandwidth in bytes per second; the test stops when the
+// context is done
+type SpeedEngine interface {
+	Run(ctx context.Context, opts Options) (Result, error)
+}
+
+// Ookla runs the Ookla speedtest CLI
+type Ookla struct{}
+
+// Native runs the embedded speedtest-go library
+type Native struct{}
+
+// Iperf3 runs iperf3 against IperfServer
+type Iperf3 struct{}
+
+// HTTP transfers payloads from and to HTTPDownloadURL and HTTPUploadURL
+type HTTP struct{}
+
+// Speed test engines by name, selected with -engine
+var Engines = map[string]SpeedEngine{
+	"ookla":  Ookla{},
+	"native": Native{},
+	"iperf3": Iperf3{},
+	"http":   HTTP{},
+}
+
+// Runs a single speed test with the given engine
+func Run(ctx context.Context, engine string, opts Options) (Result, error) {
+	e, ok := Engines[engine]
+	if !ok {
+		return Result{}, fmt.Errorf("unknown speed test engine %q", engine)
+	}
+	return e.Run(ctx, opts)
+}
+
+// Returns the engine names, sorted
+func EngineNames() []string {
+	return slices.Sorted(maps.Keys(Engines))
+}
+
+// Returns the version of the engine's binary or library, or an empty string when it can't be told
+func EngineVersion(engine string, opts Options) string {
+	switch engine {
+	case "ookla":
+		return firstLine(opts.Commands.Run("speedtest", "--version"))
+	case "iperf3":
+		return firstLine(opts.Commands.Run("iperf3", "--version"))
+	case "native":
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			return ""
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/showwin/speedtest-go" {
+				return "speedtest-go " + dep.Version
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the first line of a command's output, or an empty string if it failed
+func firstLine(output []byte, err error) string {
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return line
+}
+
+// Returns the "Country, City" of the server a result was measured against, or an empty string
+// for engines that don't know it
+func ServerLocation(result Result) string {
+	if result.Server.Country == "" && result.Server.Location == "" {
+		return ""
+	}
+	return result.Server.Country + ", " + result.Server.Location
+}
+
+// ErrLicenseNotAccepted is returned when the Ookla CLI prints its license or GDPR prompt instead of a result
+var ErrLicenseNotAccepted = errors.New("the speedtest CLI is waiting for its license and GDPR terms to be accepted; enable accepting them or run speedtest once interactively")
+
+// Reports whether the Ookla CLI printed one of its first-run prompts
+func isLicensePrompt(output []byte) bool {
+	text := strings.ToLower(string(output))
+	return strings.Contains(text, "accept the license") || strings.Contains(text, "gdpr")
+}
+
+// Runs the Ookla speedtest CLI and parses its JSON output
+func (Ookla) Run(ctx context.Context, opts Options) (Result, error) {
+	var result Result
+
+	args := []string{"-f", "json-pretty"}
+	if opts.AcceptLicense {
+		args = append(args, "--accept-license", "--accept-gdpr")
+	}
+	output, err := opts.Commands.RunCombinedContext(ctx, "speedtest", args...)
+	if err != nil {
+		if isLicensePrompt(output) {
+			return result, ErrLicenseNotAccepted
+		}
+		return result, err
+	}
+
+	if err := json.Unmarshal(output, &result); err != nil {
+		if isLicensePrompt(output) {
+			return result, ErrLicenseNotAccepted
+		}
+		return result, fmt.Errorf("error parsing speed test result: %w", err)
+	}
+	return result, nil
+}
+
+// Runs a speed test against the nearest server with the embedded speedtest-go library,
+// so no external binary is needed
+func (Native) Run(ctx context.Context, opts Options) (Result, error) {
+	client := stgo.New()
+	switch opts.IPVersion {
+	case 4:
+		// Binding to the unspecified address of a family keeps every connection in it
+		client = stgo.New(stgo.WithUserConfig(&stgo.UserConfig{Source: "0.0.0.0"}))
+	case 6:
+		client = stgo.New(stgo.WithUserConfig(&stgo.UserConfig{Source: "::"}))
+	}
+
+	servers, err := client.FetchServerListContext(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("error fetching speedtest servers: %w", err)
+	}
+
+	targets, err := servers.FindServer(nil)
+	if err != nil {
+		return Result{}, err
+	}
+	server := targets[0]
+	slog.Debug("Running native speed test", "server", server.Host, "sponsor", server.Sponsor)
+
+	if !opts.SkipLatency {
+		if err := server.PingTestContext(ctx, nil); err != nil {
+			return Result{}, fmt.Errorf("ping test failed: %w", err)
+		}
+	}
+	if !opts.SkipDownload {
+		if err := server.DownloadTestContext(ctx); err != nil {
+			return Result{}, fmt.Errorf("download test failed: %w", err)
+		}
+	}
+	if !opts.SkipUpload {
+		if err := server.UploadTestContext(ctx); err != nil {
+			return Result{}, fmt.Errorf("upload test failed: %w", err)
+		}
+	}
+
+	result := nativeResult(server)
+	if user, err := client.FetchUserInfoContext(ctx); err == nil {
+		result.ISP = user.Isp
+		result.Interface.ExternalIP = user.IP
+	}
+	return result, nil
+}
+
+// Maps a speedtest-go server result onto the Ookla JSON schema; both report bandwidth in bytes per second
+func nativeResult(server *stgo.Server) Result {
+	var result Result
+	result.Ping.Latency = float64(server.Latency) / float64(time.Millisecond)
+	result.Ping.Jitter = float64(server.Jitter) / float64(time.Millisecond)
+	result.Download.Bandwidth = int64(server.DLSpeed)
+	result.Upload.Bandwidth = int64(server.ULSpeed)
+	result.Server.Host = server.Host
+	result.Server.Name = server.Sponsor
+	result.Server.Country = server.Country
+	result.Server.Location = server.Name
+	if loss := server.PacketLoss.Loss(); loss >= 0 {
+		result.PacketLoss = loss * 100
+	}
+	return result
+}
@@ -0,0 +1,119 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returns a client with the default transport dialing over IPVersion
+func (o Options) httpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, o.ipNetwork(network), address)
+	}
+	return &http.Client{Timeout: 2 * time.Minute, Transport: transport}
+}
+
+// zeroReader is an endless stream of zero bytes for upload payloads
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// Converts a transfer to bytes per second
+func bytesPerSecond(n int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(n) / elapsed.Seconds())
+}
+
+// Downloads the payload and returns its throughput and the time to the response headers
+func httpDownload(ctx context.Context, client *http.Client, opts Options) (int64, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(opts.HTTPDownloadURL, "{bytes}", strconv.FormatInt(opts.HTTPPayloadSize, 10)), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	latency := time.Since(start)
+
+	if resp.StatusCode >= 300 {
+		return 0, 0, fmt.Errorf("download returned %s", resp.Status)
+	}
+
+	// Time only the body, so connection setup doesn't lower the throughput
+	bodyStart := time.Now()
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, opts.HTTPPayloadSize))
+	if err != nil {
+		return 0, 0, err
+	}
+	return bytesPerSecond(n, time.Since(bodyStart)), latency, nil
+}
+
+// Uploads the payload and returns its throughput
+func httpUpload(ctx context.Context, client *http.Client, opts Options) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, opts.HTTPUploadURL, io.LimitReader(zeroReader{}, opts.HTTPPayloadSize))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("upload returned %s", resp.Status)
+	}
+	return bytesPerSecond(opts.HTTPPayloadSize, time.Since(start)), nil
+}
+
+// Measures throughput by transferring payloads from and to user-specified URLs, for environments
+// where neither the Ookla CLI nor iperf3 is allowed
+func (HTTP) Run(ctx context.Context, opts Options) (Result, error) {
+	var result Result
+	client := opts.httpClient()
+	defer client.CloseIdleConnections()
+
+	// The latency is the time to the download's response headers, so it needs the download
+	if !opts.SkipDownload {
+		download, latency, err := httpDownload(ctx, client, opts)
+		if err != nil {
+			return result, fmt.Errorf("download test failed: %w", err)
+		}
+		result.Download.Bandwidth = download
+		result.Download.Bytes = opts.HTTPPayloadSize
+		result.Ping.Latency = float64(latency) / float64(time.Millisecond)
+	}
+	if !opts.SkipUpload {
+		upload, err := httpUpload(ctx, client, opts)
+		if err != nil {
+			return result, fmt.Errorf("upload test failed: %w", err)
+		}
+		result.Upload.Bandwidth = upload
+		result.Upload.Bytes = opts.HTTPPayloadSize
+	}
+	if u, err := url.Parse(opts.HTTPDownloadURL); err == nil {
+		result.Server.Host = u.Host
+		result.Server.Name = u.Host
+	}
+	return result, nil
+}
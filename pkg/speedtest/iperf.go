@@ -0,0 +1,86 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// iperfReport is the part of iperf3's JSON output we need
+type iperfReport struct {
+	End struct {
+		SumReceived struct {
+			Bytes         int64   `json:"bytes"`
+			BitsPerSecond float64 `json:"bits_per_second"`
+		} `json:"sum_received"`
+		Streams []struct {
+			Sender struct {
+				MeanRTT int64 `json:"mean_rtt"` // Microseconds, only reported on Linux
+			} `json:"sender"`
+		} `json:"streams"`
+	} `json:"end"`
+	Error string `json:"error"`
+}
+
+// Runs iperf3 against the server in one direction; reverse measures the download
+func runIperf(ctx context.Context, host string, port string, reverse bool, opts Options) (iperfReport, error) {
+	var report iperfReport
+
+	args := []string{"-c", host, "-p", port, "-J", "-t", strconv.Itoa(opts.IperfDuration)}
+	if reverse {
+		args = append(args, "-R")
+	}
+	if opts.IPVersion != 0 {
+		args = append(args, "-"+strconv.Itoa(opts.IPVersion))
+	}
+
+	// iperf3 exits non-zero on errors but still prints the JSON report explaining them
+	output, err := opts.Commands.RunContext(ctx, "iperf3", args...)
+	if jsonErr := json.Unmarshal(output, &report); jsonErr != nil {
+		if err != nil {
+			return report, err
+		}
+		return report, fmt.Errorf("error parsing iperf3 result: %w", jsonErr)
+	}
+	if report.Error != "" {
+		return report, fmt.Errorf("iperf3: %s", report.Error)
+	}
+	return report, err
+}
+
+// Measures against your own iperf3 server, which isolates VPN overhead from the variance of public
+// speedtest servers
+func (Iperf3) Run(ctx context.Context, opts Options) (Result, error) {
+	var result Result
+
+	host, port, err := net.SplitHostPort(opts.IperfServer)
+	if err != nil {
+		host, port = opts.IperfServer, "5201"
+	}
+
+	if !opts.SkipDownload {
+		download, err := runIperf(ctx, host, port, true, opts)
+		if err != nil {
+			return result, fmt.Errorf("download test failed: %w", err)
+		}
+		result.Download.Bandwidth = int64(download.End.SumReceived.BitsPerSecond / 8)
+		result.Download.Bytes = download.End.SumReceived.Bytes
+	}
+	// The latency is the round trip time of the upload's sender, so it needs the upload
+	if !opts.SkipUpload {
+		upload, err := runIperf(ctx, host, port, false, opts)
+		if err != nil {
+			return result, fmt.Errorf("upload test failed: %w", err)
+		}
+		result.Upload.Bandwidth = int64(upload.End.SumReceived.BitsPerSecond / 8)
+		result.Upload.Bytes = upload.End.SumReceived.Bytes
+		if len(upload.End.Streams) > 0 {
+			result.Ping.Latency = float64(upload.End.Streams[0].Sender.MeanRTT) / 1000
+		}
+	}
+	result.Server.Host = net.JoinHostPort(host, port)
+	result.Server.Name = host
+	return result, nil
+}
@@ -0,0 +1,47 @@
+package speedtest
+
+import (
+	"errors"
+	"math"
+	"net"
+	"time"
+)
+
+// Measures latency without transferring data by timing LatencyCount TCP connections to LatencyTarget,
+// a handshake taking one round trip. Returns a result with only the ping, packet loss and server set;
+// connections that fail or time out count as lost
+func MeasureLatency(opts Options) (Result, error) {
+	target, count := opts.LatencyTarget, opts.LatencyCount
+	var result Result
+	var rtts []float64
+	var lastErr error
+	for range count {
+		start := time.Now()
+		conn, err := net.DialTimeout(opts.ipNetwork("tcp"), target, opts.LatencyTimeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rtts = append(rtts, float64(time.Since(start))/float64(time.Millisecond))
+		conn.Close()
+	}
+	if len(rtts) == 0 {
+		return result, errors.Join(errors.New("no connection to "+target+" succeeded"), lastErr)
+	}
+
+	var sum, jitter float64
+	for i, rtt := range rtts {
+		sum += rtt
+		if i > 0 {
+			jitter += math.Abs(rtt - rtts[i-1])
+		}
+	}
+	result.Ping.Latency = sum / float64(len(rtts))
+	if len(rtts) > 1 {
+		// Mean difference between consecutive round trips, as the Ookla CLI reports it
+		result.Ping.Jitter = jitter / float64(len(rtts)-1)
+	}
+	result.PacketLoss = float64(count-len(rtts)) / float64(count) * 100
+	result.Server.Host = target
+	return result, nil
+}
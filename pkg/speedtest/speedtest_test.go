@@ -0,0 +1,247 @@
+package speedtest
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	stgo "github.com/showwin/speedtest-go/speedtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNativeResultConversion(t *testing.T) {
+	server := &stgo.Server{
+		Host:    "speedtest.example.net:8080",
+		Name:    "Amsterdam",
+		Sponsor: "Example ISP",
+		Country: "Netherlands",
+		Latency: 12500 * time.Microsecond,
+		Jitter:  1500 * time.Microsecond,
+		DLSpeed: stgo.ByteRate(125000000), // 1000 Mbps
+		ULSpeed: stgo.ByteRate(62500000),  // 500 Mbps
+	}
+
+	result := nativeResult(server)
+	assert.Equal(t, 12.5, result.Ping.Latency)
+	assert.Equal(t, 1.5, result.Ping.Jitter)
+	assert.Equal(t, int64(1000), result.Download.Bandwidth/125000)
+	assert.Equal(t, int64(500), result.Upload.Bandwidth/125000)
+	assert.Equal(t, "Netherlands", result.Server.Country)
+	assert.Equal(t, "Amsterdam", result.Server.Location)
+	assert.Equal(t, "Example ISP", result.Server.Name)
+
+	// No packet loss data stays at zero instead of the library's -1 sentinel
+	assert.Equal(t, 0.0, result.PacketLoss)
+
+	server.PacketLoss.Sent = 99
+	server.PacketLoss.Max = 99
+	assert.InDelta(t, 1.0, nativeResult(server).PacketLoss, 0.001)
+}
+
+func TestIperfSpeedTest(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "iperf3")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" >> `+filepath.Join(dir, "args")+`
+case "$*" in
+  *-R*) echo '{"end": {"sum_received": {"bits_per_second": 400000000}, "streams": [{"sender": {"mean_rtt": 0}}]}}' ;;
+  *) echo '{"end": {"sum_received": {"bits_per_second": 100000000}, "streams": [{"sender": {"mean_rtt": 23500}}]}}' ;;
+esac
+`), 0755)
+	assert.NoError(t, err)
+	opts := DefaultOptions()
+	opts.Commands = command.Overrides{"iperf3": {Path: script}}
+	opts.IperfServer = "iperf.example.com"
+	result, err := Run(context.Background(), "iperf3", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50_000_000), result.Download.Bandwidth)
+	assert.Equal(t, int64(12_500_000), result.Upload.Bandwidth)
+	assert.Equal(t, 23.5, result.Ping.Latency)
+	assert.Equal(t, "iperf.example.com:5201", result.Server.Host)
+	assert.Equal(t, "", ServerLocation(result))
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	assert.NoError(t, err)
+	assert.Equal(t, "-c iperf.example.com -p 5201 -J -t 10 -R\n-c iperf.example.com -p 5201 -J -t 10\n", string(args))
+
+	err = os.WriteFile(script, []byte("#!/bin/sh\necho '{\"error\": \"unable to connect to server: Connection refused\"}'\nexit 1\n"), 0755)
+	assert.NoError(t, err)
+	_, err = Run(context.Background(), "iperf3", opts)
+	assert.ErrorContains(t, err, "Connection refused")
+
+	_, err = Run(context.Background(), "carrier-pigeon", opts)
+	assert.Error(t, err)
+}
+
+func TestHTTPSpeedTest(t *testing.T) {
+	var uploaded int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down":
+			size, _ := strconv.Atoi(r.URL.Query().Get("bytes"))
+			w.Write(make([]byte, size))
+		case "/up":
+			uploaded, _ = io.Copy(io.Discard, r.Body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.HTTPDownloadURL = server.URL + "/down?bytes={bytes}"
+	opts.HTTPUploadURL = server.URL + "/up"
+	opts.HTTPPayloadSize = 1_000_000
+
+	result, err := Run(context.Background(), "http", opts)
+	assert.NoError(t, err)
+	assert.Greater(t, result.Download.Bandwidth, int64(0))
+	assert.Greater(t, result.Upload.Bandwidth, int64(0))
+	assert.Equal(t, int64(1_000_000), uploaded)
+	assert.Equal(t, strings.TrimPrefix(server.URL, "http://"), result.Server.Host)
+
+	opts.HTTPUploadURL = server.URL + "/missing"
+	_, err = Run(context.Background(), "http", opts)
+	assert.ErrorContains(t, err, "upload returned 404")
+
+	// A skipped phase isn't run, so its broken URL doesn't matter
+	opts.SkipUpload = true
+	result, err = Run(context.Background(), "http", opts)
+	assert.NoError(t, err)
+	assert.Zero(t, result.Upload.Bandwidth)
+	assert.Equal(t, int64(1_000_000), result.Download.Bytes)
+}
+
+func TestOoklaLicense(t *testing.T) {
+	// Like a fresh install, prompts unless the terms are accepted on the command line
+	dir := t.TempDir()
+	script := filepath.Join(dir, "speedtest")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+case "$*" in
+  *--accept-license*--accept-gdpr*) echo '{"download": {"bandwidth": 12500000}, "upload": {"bandwidth": 2500000}}' ;;
+  *) printf 'You may only use this Speedtest software and information generated\nfrom it for personal, non-commercial use.\nDo you accept the license? [type YES to accept]: ' ;;
+esac
+`), 0755)
+	assert.NoError(t, err)
+	opts := DefaultOptions()
+	opts.Commands = command.Overrides{"speedtest": {Path: script}}
+
+	result, err := Run(context.Background(), "ookla", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12500000), result.Download.Bandwidth)
+
+	opts.AcceptLicense = false
+	_, err = Run(context.Background(), "ookla", opts)
+	assert.ErrorIs(t, err, ErrLicenseNotAccepted)
+}
+
+func TestMeasureLatency(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	opts := DefaultOptions()
+	opts.LatencyTarget, opts.LatencyCount = listener.Addr().String(), 5
+	result, err := MeasureLatency(opts)
+	assert.NoError(t, err)
+	assert.Greater(t, result.Ping.Latency, 0.0)
+	assert.Zero(t, result.PacketLoss)
+	assert.Zero(t, result.Download.Bandwidth, "Nothing is transferred")
+	assert.Equal(t, listener.Addr().String(), result.Server.Host)
+
+	// A closed port refuses every connection
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := closed.Addr().String()
+	closed.Close()
+	opts.LatencyTarget, opts.LatencyCount = address, 3
+	_, err = MeasureLatency(opts)
+	assert.ErrorContains(t, err, "no connection to "+address+" succeeded")
+}
+
+func TestIPVersion(t *testing.T) {
+	opts := DefaultOptions()
+	assert.Equal(t, "tcp", opts.ipNetwork("tcp"))
+	opts.IPVersion = 4
+	assert.Equal(t, "tcp4", opts.ipNetwork("tcp"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	resp, err := opts.httpClient().Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// The server listens on IPv4 only
+	opts.IPVersion = 6
+	_, err = opts.httpClient().Get(server.URL)
+	assert.Error(t, err)
+	opts.LatencyTarget, opts.LatencyCount = server.Listener.Addr().String(), 1
+	_, err = MeasureLatency(opts)
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "iperf3")
+	err = os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" >> `+filepath.Join(dir, "args")+`
+echo '{"end": {"sum_received": {"bits_per_second": 100000000}}}'
+`), 0755)
+	assert.NoError(t, err)
+	opts.Commands = command.Overrides{"iperf3": {Path: script}}
+	opts.IperfServer = "iperf.example.com"
+	_, err = Run(context.Background(), "iperf3", opts)
+	assert.NoError(t, err)
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	assert.NoError(t, err)
+	assert.Equal(t, "-c iperf.example.com -p 5201 -J -t 10 -R -6\n-c iperf.example.com -p 5201 -J -t 10 -6\n", string(args))
+}
+
+// fakeEngine reports a fixed download speed
+type fakeEngine struct{}
+
+func (fakeEngine) Run(ctx context.Context, opts Options) (Result, error) {
+	var result Result
+	result.Download.Bandwidth = 1000
+	return result, ctx.Err()
+}
+
+func TestSpeedEngine(t *testing.T) {
+	Engines["fake"] = fakeEngine{}
+	defer delete(Engines, "fake")
+
+	result, err := Run(context.Background(), "fake", DefaultOptions())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), result.Download.Bandwidth)
+	assert.Contains(t, EngineNames(), "fake")
+
+	// A done context stops an engine's binary
+	script := filepath.Join(t.TempDir(), "iperf3")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 60\n"), 0755))
+	opts := DefaultOptions()
+	opts.Commands = command.Overrides{"iperf3": {Path: script}}
+	opts.IperfServer = "iperf.example.com"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = Run(ctx, "iperf3", opts)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
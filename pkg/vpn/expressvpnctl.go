@@ -0,0 +1,171 @@
+// Package vpn controls the ExpressVPN client through expressvpnctl, matches locations to its region
+// slugs, and controls the other providers a run compares it with
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+)
+
+var connectGracePeriod = 2 * time.Second // How long "Disconnected" is expected right after connecting
+var sleep = time.Sleep                   // Replaced in tests
+
+// SmartLocation is the pseudo-region that lets the client pick the region, like a plain
+// expressvpnctl connect does
+const SmartLocation = "smart"
+
+// ExpressVPN is the ExpressVPN client, controlled through expressvpnctl
+type ExpressVPN struct {
+	Commands command.Overrides // How expressvpnctl is invoked
+}
+
+// Returns the available region slugs
+func (e ExpressVPN) Regions() ([]string, error) {
+	out, err := e.Commands.Run("expressvpnctl", "get", "regions")
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return lines, nil
+}
+
+// StateChange is a connection state the client reported while connecting, first seen At after the
+// connect command was issued
+type StateChange struct {
+	State string
+	At    time.Duration
+}
+
+// ConnectPhases breaks a connect down into where it spent its time, each measured from when the connect
+// command was issued
+type ConnectPhases struct {
+	Command   time.Duration // Until the connect command returned
+	States    []StateChange // Every state polled afterwards, e.g. Connecting then Connected
+	Connected time.Duration // Until the client reported Connected
+}
+
+// PhasedConnector is implemented by providers that can break a connect down into phases
+type PhasedConnector interface {
+	ConnectPhases(ctx context.Context, region string, timeout time.Duration) (ConnectPhases, error)
+}
+
+// Connects to a region like Connect, recording how long the connect command took and when the client
+// reported each state until it was connected
+func (e ExpressVPN) ConnectPhases(ctx context.Context, region string, timeout time.Duration) (ConnectPhases, error) {
+	args := []string{"connect"}
+	if region != SmartLocation {
+		args = append(args, region)
+	}
+
+	var phases ConnectPhases
+	start := time.Now()
+	_, err := e.Commands.RunCombinedContext(ctx, "expressvpnctl", args...)
+	if err != nil {
+		return ConnectPhases{}, err
+	}
+	phases.Command = time.Since(start).Round(time.Millisecond)
+
+	err = e.waitForConnection(ctx, start.Add(timeout), func(state string) {
+		if len(phases.States) == 0 || phases.States[len(phases.States)-1].State != state {
+			phases.States = append(phases.States, StateChange{State: state, At: time.Since(start).Round(time.Millisecond)})
+		}
+	})
+	if err != nil {
+		// Don't leave a half-established tunnel behind for the next region
+		e.Disconnect()
+		return ConnectPhases{}, err
+	}
+	phases.Connected = time.Since(start).Round(time.Millisecond)
+	return phases, nil
+}
+
+// Connects to a region and waits for the tunnel to come up, giving up once the context is done;
+// returns how long that took
+func (e ExpressVPN) Connect(ctx context.Context, region string, timeout time.Duration) (time.Duration, error) {
+	phases, err := e.ConnectPhases(ctx, region, timeout)
+	return phases.Connected, err
+}
+
+// Disconnects the VPN
+func (e ExpressVPN) Disconnect() error {
+	_, err := e.Commands.RunCombined("expressvpnctl", "disconnect")
+	return err
+}
+
+// Returns the current connection state, e.g. "Connected" or "Reconnecting"
+func (e ExpressVPN) State() (string, error) {
+	out, err := e.Commands.Run("expressvpnctl", "get", "connectionstate")
+	return strings.TrimSpace(string(out)), err
+}
+
+// Returns the region the client is connected to, e.g. the one it picked for SmartLocation
+func (e ExpressVPN) CurrentRegion() (string, error) {
+	out, err := e.Commands.Run("expressvpnctl", "get", "region")
+	return strings.TrimSpace(string(out)), err
+}
+
+// Reports whether the client's network lock, its kill switch, is enabled
+func (e ExpressVPN) NetworkLock() (bool, error) {
+	out, err := e.Commands.Run("expressvpnctl", "get", "networklock")
+	if err != nil {
+		return false, err
+	}
+	switch value := strings.TrimSpace(string(out)); value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected network lock setting %q", value)
+	}
+}
+
+// Enables or disables the client's network lock
+func (e ExpressVPN) SetNetworkLock(enabled bool) error {
+	_, err := e.Commands.RunCombined("expressvpnctl", "set", "networklock", fmt.Sprint(enabled))
+	return err
+}
+
+// Returns the version reported by the ExpressVPN client
+func (e ExpressVPN) ClientVersion() (string, error) {
+	out, err := e.Commands.Run("expressvpnctl", "--version")
+	return strings.TrimSpace(string(out)), err
+}
+
+// Waits until the VPN is connected, passing every state polled to onState unless nil; fails when the
+// deadline passes, the context is done or the client reports a state it won't recover from on its own
+func (e ExpressVPN) waitForConnection(ctx context.Context, deadline time.Time, onState func(state string)) error {
+	start := time.Now()
+	state := ""
+	for {
+		current, err := e.State()
+		if err == nil {
+			state = current
+			if onState != nil {
+				onState(state)
+			}
+			switch {
+			case state == "Connected":
+				return nil
+			case state == "Reconnecting":
+				return fmt.Errorf("connection failed: client is reconnecting")
+			case state == "Disconnected" && time.Since(start) > connectGracePeriod:
+				// Right after the connect command the previous state may still be reported
+				return fmt.Errorf("connection failed: client is disconnected")
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for connection, last state %q", state)
+		}
+		sleep(500 * time.Millisecond)
+	}
+}
@@ -0,0 +1,184 @@
+package vpn
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+)
+
+// Where ip netns exec finds the resolv.conf of a namespace; replaced in tests
+var etcNetns = "/etc/netns"
+
+// Namespace is a Linux network namespace holding the tunnel of one region, so several regions can be
+// connected at once, each tested by binaries run inside its namespace
+type Namespace struct {
+	Name     string            // Also the name of the tunnel interface in it, so at most 15 characters
+	Commands command.Overrides // How ip is invoked
+}
+
+// Creates the namespace with only its loopback interface up, replacing one of the same name a run that
+// was killed left behind
+func (n Namespace) Create() error {
+	if runtime.GOOS != "linux" {
+		return errors.New("network namespaces need Linux")
+	}
+	n.Commands.RunCombined("ip", "netns", "delete", n.Name)
+	if _, err := n.Commands.RunCombined("ip", "netns", "add", n.Name); err != nil {
+		return err
+	}
+	if _, err := n.Commands.RunCombined("ip", "-n", n.Name, "link", "set", "lo", "up"); err != nil {
+		n.Delete()
+		return err
+	}
+	return nil
+}
+
+// Deletes the namespace, and with it the tunnel interface in it and its resolv.conf
+func (n Namespace) Delete() error {
+	_, err := n.Commands.RunCombined("ip", "netns", "delete", n.Name)
+	os.RemoveAll(filepath.Join(etcNetns, n.Name))
+	return err
+}
+
+// Returns the overrides with the named binaries run inside the namespace by ip netns exec, keeping their
+// configured paths, arguments and environment
+func (n Namespace) Wrap(commands command.Overrides, names ...string) command.Overrides {
+	ip := n.Commands["ip"]
+	wrapped := maps.Clone(commands)
+	if wrapped == nil {
+		wrapped = command.Overrides{}
+	}
+	for _, name := range names {
+		config := commands[name]
+		args := append(slices.Clone(ip.Args), "netns", "exec", n.Name, cmp.Or(config.Path, name))
+		env := maps.Clone(ip.Env)
+		if env == nil {
+			env = map[string]string{}
+		}
+		maps.Copy(env, config.Env)
+		wrapped[name] = command.Config{Path: cmp.Or(ip.Path, "ip"), Args: append(args, config.Args...), Env: env}
+	}
+	return wrapped
+}
+
+// Brings up the tunnel in the namespace: the interface is created on the host, where its UDP socket stays
+// so the encrypted traffic leaves through the host's connection, then moved into the namespace, whose
+// only route is through it. wg-quick can't do this, so the addresses and DNS servers of the file are
+// applied here
+func (w *WireGuard) connectInNamespace(ctx context.Context, config string) error {
+	addresses, dns, err := readInterfaceSection(config)
+	if err != nil {
+		return err
+	}
+	stripped, err := w.Commands.RunContext(ctx, "wg-quick", "strip", config)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp("", "wg-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(stripped)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	link, ns := w.Namespace, w.Namespace
+	steps := [][]string{
+		{"ip", "link", "add", link, "type", "wireguard"},
+		{"wg", "setconf", link, file.Name()},
+		{"ip", "link", "set", link, "netns", ns},
+	}
+	ipv6 := false
+	for _, address := range addresses {
+		steps = append(steps, []string{"ip", "-n", ns, "address", "add", address, "dev", link})
+		ipv6 = ipv6 || strings.Contains(address, ":")
+	}
+	steps = append(steps, []string{"ip", "-n", ns, "link", "set", link, "up"}, []string{"ip", "-n", ns, "route", "add", "default", "dev", link})
+	if ipv6 {
+		steps = append(steps, []string{"ip", "-6", "-n", ns, "route", "add", "default", "dev", link})
+	}
+	for _, step := range steps {
+		if _, err := w.Commands.RunCombinedContext(ctx, step[0], step[1:]...); err != nil {
+			// The interface is on the host or in the namespace, depending on the step that failed
+			w.Commands.RunCombined("ip", "link", "delete", link)
+			w.Commands.RunCombined("ip", "-n", ns, "link", "delete", link)
+			return err
+		}
+	}
+	if len(dns) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(etcNetns, ns), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(etcNetns, ns, "resolv.conf"), []byte(resolvConf(dns)), 0644)
+}
+
+// Returns the Address and DNS entries of a WireGuard file's [Interface] section, which wg-quick applies
+// and wg setconf doesn't accept
+func readInterfaceSection(config string) (addresses, dns []string, err error) {
+	file, err := os.Open(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(line)
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != "[interface]" {
+			continue
+		}
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "address":
+				addresses = append(addresses, entry)
+			case "dns":
+				dns = append(dns, entry)
+			}
+		}
+	}
+	return addresses, dns, scanner.Err()
+}
+
+// Formats DNS entries as a resolv.conf: the IPs as name servers, anything else as search domains
+func resolvConf(dns []string) string {
+	var servers, search []string
+	for _, entry := range dns {
+		if net.ParseIP(entry) != nil {
+			servers = append(servers, "nameserver "+entry+"\n")
+		} else {
+			search = append(search, entry)
+		}
+	}
+	conf := strings.Join(servers, "")
+	if len(search) > 0 {
+		conf += "search " + strings.Join(search, " ") + "\n"
+	}
+	return conf
+}
@@ -0,0 +1,143 @@
+package vpn
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+)
+
+const openVPNConnected = "Initialization Sequence Completed" // Logged by openvpn once the tunnel is up
+
+// OpenVPN runs openvpn with the .ovpn profiles of a directory one at a time, each profile a region named
+// after its file. openvpn stays in the foreground for as long as the tunnel is up
+type OpenVPN struct {
+	Dir      string
+	Commands command.Overrides // How openvpn is invoked
+
+	cmd    *exec.Cmd
+	exited chan struct{} // Closed once the running openvpn has exited
+}
+
+// Lists the .ovpn files of the directory as regions
+func (o *OpenVPN) Regions() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(o.Dir, "*.ovpn"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no OpenVPN .ovpn files in %s", o.Dir)
+	}
+	regions := make([]string, 0, len(matches))
+	for _, match := range matches {
+		regions = append(regions, strings.TrimSuffix(filepath.Base(match), ".ovpn"))
+	}
+	slices.Sort(regions)
+	return regions, nil
+}
+
+// Starts openvpn with the region's profile and waits for it to log that the tunnel is up. It runs in
+// the profile's directory, so the certificates and credentials files the profile names are found
+func (o *OpenVPN) Connect(ctx context.Context, region string, timeout time.Duration) (time.Duration, error) {
+	if err := o.Disconnect(); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	// Not bound to the context, since the tunnel outlives the connect; a done context disconnects below
+	cmd := o.Commands.New("openvpn", "--config", region+".ovpn")
+	cmd.Dir = o.Dir
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	cmd.Stderr = cmd.Stdout
+	slog.Debug("Starting command", "cmd", strings.Join(cmd.Args, " "), "dir", cmd.Dir)
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	o.cmd, o.exited = cmd, make(chan struct{})
+
+	// The output is read until openvpn exits, so it never blocks writing its log
+	connected := make(chan error, 1)
+	go func(exited chan struct{}) {
+		defer close(exited)
+		var last string
+		up := false
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			line := scanner.Text()
+			slog.Log(context.Background(), command.LevelTrace, "OpenVPN output", "line", line)
+			if !up && strings.Contains(line, openVPNConnected) {
+				up = true
+				connected <- nil
+			} else if strings.TrimSpace(line) != "" {
+				last = line
+			}
+		}
+		err := cmd.Wait()
+		if !up {
+			connected <- &command.Error{Command: strings.Join(cmd.Args, " "), Err: fmt.Errorf("exited before connecting: %v", err), Output: last}
+		}
+	}(o.exited)
+
+	select {
+	case err := <-connected:
+		if err != nil {
+			o.cmd = nil
+			return 0, err
+		}
+	case <-time.After(timeout):
+		o.Disconnect()
+		return 0, fmt.Errorf("timed out waiting for connection")
+	case <-ctx.Done():
+		o.Disconnect()
+		return 0, ctx.Err()
+	}
+	return time.Since(start).Round(time.Millisecond), nil
+}
+
+// Stops the running openvpn, if any: interrupted so it can tear down the tunnel, killed when it doesn't exit
+func (o *OpenVPN) Disconnect() error {
+	if o.cmd == nil {
+		return nil
+	}
+	defer func() { o.cmd = nil }()
+
+	// Windows has no interrupt to send, so openvpn is killed there right away
+	if runtime.GOOS == "windows" || o.cmd.Process.Signal(os.Interrupt) != nil {
+		o.cmd.Process.Kill()
+	}
+	select {
+	case <-o.exited:
+		return nil
+	case <-time.After(10 * time.Second):
+	}
+	if err := o.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-o.exited
+	return nil
+}
+
+// Reports the tunnel as connected while openvpn is running
+func (o *OpenVPN) State() (string, error) {
+	if o.cmd == nil {
+		return "Disconnected", nil
+	}
+	select {
+	case <-o.exited:
+		return "Disconnected", nil
+	default:
+		return "Connected", nil
+	}
+}
@@ -0,0 +1,95 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+)
+
+// Provider is a VPN the benchmark connects through: the ExpressVPN client, or another service to compare it with
+type Provider interface {
+	// Lists the regions locations are matched against; nil when the provider takes locations as they are
+	Regions() ([]string, error)
+	// Connects to a region and waits for the tunnel to come up, giving up once the context is done;
+	// returns how long that took
+	Connect(ctx context.Context, region string, timeout time.Duration) (time.Duration, error)
+	Disconnect() error
+	// Returns the connection state, "Connected" while the tunnel is up
+	State() (string, error)
+}
+
+// CommandProvider controls any other VPN client through shell commands, e.g. the NordVPN or Mullvad
+// CLIs. {region}, {country} and {city} in the commands are replaced by the location connected to
+type CommandProvider struct {
+	ConnectCommand    string // Returns once the tunnel is up, unless StatusCommand is set
+	DisconnectCommand string
+	StatusCommand     string // Optional; exits with 0 while connected, polled after connecting
+
+	Commands command.Overrides // How sh, or cmd on Windows, is invoked
+}
+
+func (p *CommandProvider) Regions() ([]string, error) {
+	return nil, nil
+}
+
+func (p *CommandProvider) Connect(ctx context.Context, region string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	if _, err := p.runShell(ctx, expandRegion(p.ConnectCommand, region)); err != nil {
+		return 0, err
+	}
+	for p.StatusCommand != "" {
+		state, _ := p.State()
+		if state == "Connected" {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			p.Disconnect()
+			return 0, err
+		}
+		if time.Since(start) > timeout {
+			p.Disconnect()
+			return 0, fmt.Errorf("timed out waiting for connection")
+		}
+		sleep(500 * time.Millisecond)
+	}
+	return time.Since(start).Round(time.Millisecond), nil
+}
+
+func (p *CommandProvider) Disconnect() error {
+	_, err := p.runShell(context.Background(), p.DisconnectCommand)
+	return err
+}
+
+func (p *CommandProvider) State() (string, error) {
+	if p.StatusCommand == "" {
+		return "Unknown", nil
+	}
+	if _, err := p.runShell(context.Background(), p.StatusCommand); err != nil {
+		return "Disconnected", nil
+	}
+	return "Connected", nil
+}
+
+// Replaces the placeholders of a command with a region such as "Sweden, Gothenburg", each quoted for the shell
+func expandRegion(cmd string, region string) string {
+	country, city, _ := strings.Cut(region, ", ")
+	return strings.NewReplacer("{region}", shellQuote(region), "{country}", shellQuote(country), "{city}", shellQuote(city)).Replace(cmd)
+}
+
+func (p *CommandProvider) runShell(ctx context.Context, cmd string) ([]byte, error) {
+	if runtime.GOOS == "windows" {
+		return p.Commands.RunCombinedContext(ctx, "cmd", "/c", cmd)
+	}
+	return p.Commands.RunCombinedContext(ctx, "sh", "-c", cmd)
+}
+
+func shellQuote(word string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
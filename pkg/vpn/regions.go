@@ -0,0 +1,165 @@
+package vpn
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Country names and short forms mapped to the prefixes expressvpnctl uses in region slugs
+var regionCountryAliases = map[string]string{
+	"united-states":            "usa",
+	"united-states-of-america": "usa",
+	"us":                       "usa",
+	"america":                  "usa",
+	"united-kingdom":           "uk",
+	"great-britain":            "uk",
+	"britain":                  "uk",
+	"england":                  "uk",
+	"gb":                       "uk",
+	"holland":                  "netherlands",
+	"the-netherlands":          "netherlands",
+}
+
+const maxRegionSuggestions = 3
+
+// Looks up a location in the alias map by "Country, City", then by city, then by country
+// for locations without a city; keys are matched case-insensitively
+func AliasRegion(location results.Location, aliases map[string]string) string {
+	keys := []string{location.Country + ", " + location.City, location.City}
+	if location.City == "" {
+		keys = append(keys, location.Country)
+	}
+
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		for alias, region := range aliases {
+			if strings.EqualFold(strings.TrimSpace(alias), strings.TrimSpace(key)) {
+				return region
+			}
+		}
+	}
+	return ""
+}
+
+// Turns a name into a region slug: lowercase ASCII, diacritics removed, words joined by dashes
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Drop the combining marks left over from decomposing "ã" into "a" + "~"
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// Returns the slug prefix for a country, resolving aliases such as "United States" → "usa"
+func countrySlug(country string) string {
+	slug := Slugify(country)
+	if alias, ok := regionCountryAliases[slug]; ok {
+		return alias
+	}
+	return slug
+}
+
+// Matches a location against the available regions, preferring the exact city, then a numbered
+// city server such as "uk-london-2", then the country itself or any of its regions.
+// When nothing matches it returns the closest regions as suggestions.
+func MatchRegion(location results.Location, regions []string) (string, []string) {
+	country := countrySlug(location.Country)
+	city := Slugify(location.City)
+
+	available := map[string]bool{}
+	for _, region := range regions {
+		available[strings.TrimSpace(region)] = true
+	}
+
+	if city != "" {
+		if available[country+"-"+city] {
+			return country + "-" + city, nil
+		}
+		for _, region := range regions {
+			if strings.HasPrefix(region, country+"-"+city+"-") {
+				return region, nil
+			}
+		}
+	}
+
+	if available[country] {
+		return country, nil
+	}
+	if city == "" {
+		for _, region := range regions {
+			if strings.HasPrefix(region, country+"-") {
+				return region, nil
+			}
+		}
+	}
+
+	target := country
+	if city != "" {
+		target += "-" + city
+	}
+	return "", SuggestRegions(target, regions)
+}
+
+// Returns the regions closest to the target by edit distance
+func SuggestRegions(target string, regions []string) []string {
+	type candidate struct {
+		region   string
+		distance int
+	}
+
+	var candidates []candidate
+	for _, region := range regions {
+		if region = strings.TrimSpace(region); region != "" {
+			candidates = append(candidates, candidate{region, levenshtein(target, region)})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var suggestions []string
+	for i := 0; i < len(candidates) && i < maxRegionSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].region)
+	}
+	return suggestions
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
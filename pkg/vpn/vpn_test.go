@@ -0,0 +1,329 @@
+package vpn
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+	"flavius.xyz/vpn_speed_test_cli/pkg/results"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchRegion(t *testing.T) {
+	regions := []string{
+		"netherlands-amsterdam",
+		"romania",
+		"usa-new-york",
+		"usa-los-angeles-1",
+		"uk-london",
+		"brazil-sao-paulo",
+		"canada-toronto",
+	}
+
+	tests := []struct {
+		name     string
+		location results.Location
+		expected string
+	}{
+		{"Exact match", results.Location{Country: "Netherlands", City: "Amsterdam"}, "netherlands-amsterdam"},
+		{"Case-insensitive", results.Location{Country: "NETHERLANDS", City: "amsterdam"}, "netherlands-amsterdam"},
+		{"Multi-word city", results.Location{Country: "USA", City: "New York"}, "usa-new-york"},
+		{"Country alias", results.Location{Country: "United States", City: "New York"}, "usa-new-york"},
+		{"Numbered server", results.Location{Country: "USA", City: "Los Angeles"}, "usa-los-angeles-1"},
+		{"Diacritics", results.Location{Country: "Brazil", City: "São Paulo"}, "brazil-sao-paulo"},
+		{"Country only fallback", results.Location{Country: "Romania", City: "Bucharest"}, "romania"},
+		{"Country without city", results.Location{Country: "United Kingdom"}, "uk-london"},
+		{"No match", results.Location{Country: "France", City: "Paris"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, _ := MatchRegion(test.location, regions)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+
+	_, suggestions := MatchRegion(results.Location{Country: "Canada", City: "Torono"}, regions)
+	assert.Equal(t, "canada-toronto", suggestions[0])
+	assert.Equal(t, maxRegionSuggestions, len(suggestions))
+}
+
+func TestAliasRegion(t *testing.T) {
+	aliases := map[string]string{
+		"UK, London Docklands": "uk-docklands",
+		"east london":          "uk-east-london",
+		"Britain":              "uk-london",
+	}
+
+	assert.Equal(t, "uk-docklands", AliasRegion(results.Location{Country: "UK", City: "London Docklands"}, aliases))
+	assert.Equal(t, "uk-east-london", AliasRegion(results.Location{Country: "United Kingdom", City: "East London"}, aliases))
+	assert.Equal(t, "uk-london", AliasRegion(results.Location{Country: "Britain"}, aliases))
+	assert.Equal(t, "", AliasRegion(results.Location{Country: "Britain", City: "Manchester"}, aliases))
+	assert.Equal(t, "", AliasRegion(results.Location{Country: "Netherlands", City: "Amsterdam"}, nil))
+
+	var input results.InputData
+	err := json.Unmarshal([]byte(`{"aliases": {"Docklands": "uk-docklands"}, "locations": [{"country": "UK", "city": "Docklands"}]}`), &input)
+	assert.NoError(t, err)
+	assert.Equal(t, "uk-docklands", AliasRegion(input.Locations[0], input.Aliases))
+}
+
+func TestWaitForConnection(t *testing.T) {
+	origSleep, origGrace := sleep, connectGracePeriod
+	defer func() { sleep, connectGracePeriod = origSleep, origGrace }()
+	sleep = func(d time.Duration) { time.Sleep(time.Millisecond) }
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "expressvpnctl")
+	client := ExpressVPN{Commands: command.Overrides{"expressvpnctl": {Path: script}}}
+	setState := func(state string) {
+		assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho "+state+"\n"), 0755))
+	}
+
+	setState("Connected")
+	assert.NoError(t, client.waitForConnection(context.Background(), time.Now().Add(time.Second), nil))
+
+	setState("Reconnecting")
+	assert.ErrorContains(t, client.waitForConnection(context.Background(), time.Now().Add(time.Second), nil), "reconnecting")
+
+	setState("Connecting")
+	assert.ErrorContains(t, client.waitForConnection(context.Background(), time.Now().Add(50*time.Millisecond), nil), `timed out waiting for connection, last state "Connecting"`)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.waitForConnection(ctx, time.Now().Add(time.Minute), nil), context.DeadlineExceeded, "The context ends the wait before the deadline")
+
+	// Disconnected is only final after the grace period
+	connectGracePeriod = 20 * time.Millisecond
+	setState("Disconnected")
+	start := time.Now()
+	assert.ErrorContains(t, client.waitForConnection(context.Background(), time.Now().Add(time.Second), nil), "disconnected")
+	assert.GreaterOrEqual(t, time.Since(start), connectGracePeriod)
+}
+
+func TestSmartLocation(t *testing.T) {
+	// Records the arguments of every call and answers like a connected client
+	dir := t.TempDir()
+	script := filepath.Join(dir, "expressvpnctl")
+	calls := filepath.Join(dir, "calls")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" >> `+calls+`
+case "$*" in
+  "get connectionstate") echo Connected ;;
+  "get region") echo usa-new-york ;;
+esac
+`), 0755)
+	assert.NoError(t, err)
+	client := ExpressVPN{Commands: command.Overrides{"expressvpnctl": {Path: script}}}
+
+	_, err = client.Connect(context.Background(), SmartLocation, time.Second)
+	assert.NoError(t, err)
+	_, err = client.Connect(context.Background(), "germany-frankfurt", time.Second)
+	assert.NoError(t, err)
+	region, err := client.CurrentRegion()
+	assert.NoError(t, err)
+	assert.Equal(t, "usa-new-york", region)
+
+	data, err := os.ReadFile(calls)
+	assert.NoError(t, err)
+	assert.Equal(t, "connect\nget connectionstate\nconnect germany-frankfurt\nget connectionstate\nget region\n", string(data))
+}
+
+func TestCommandProvider(t *testing.T) {
+	sleep = func(time.Duration) {}
+	defer func() { sleep = time.Sleep }()
+
+	state := filepath.Join(t.TempDir(), "connected")
+	provider := &CommandProvider{
+		ConnectCommand:    "echo {country}/{city} > " + state,
+		DisconnectCommand: "rm -f " + state,
+		StatusCommand:     "test -f " + state,
+	}
+
+	regions, err := provider.Regions()
+	assert.NoError(t, err)
+	assert.Nil(t, regions, "Locations are taken as they are")
+
+	_, err = provider.Connect(context.Background(), "Sweden, Gothenburg; rm -rf /", time.Second)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(state)
+	assert.NoError(t, err)
+	assert.Equal(t, "Sweden/Gothenburg; rm -rf /\n", string(content), "Placeholders are quoted")
+	current, _ := provider.State()
+	assert.Equal(t, "Connected", current)
+
+	assert.NoError(t, provider.Disconnect())
+	current, _ = provider.State()
+	assert.Equal(t, "Disconnected", current)
+
+	provider.ConnectCommand = "true"
+	_, err = provider.Connect(context.Background(), "Sweden, Gothenburg", 0)
+	assert.ErrorContains(t, err, "timed out", "The status command never reports the tunnel up")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = provider.Connect(ctx, "Sweden, Gothenburg", time.Minute)
+	assert.ErrorIs(t, err, context.Canceled, "A done context stops the wait before the connect timeout")
+}
+
+func TestWireGuard(t *testing.T) {
+	dir, bin := t.TempDir(), t.TempDir()
+	for _, name := range []string{"nl-ams-1.conf", "se-got.conf", "notes.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+	log := filepath.Join(bin, "calls")
+	commands := command.Overrides{}
+	for _, name := range []string{"wg-quick", "wg"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\necho "+name+" \"$@\" >> "+log+"\n"), 0755))
+		commands[name] = command.Config{Path: filepath.Join(bin, name)}
+	}
+
+	wireguard := &WireGuard{Dir: dir, Commands: commands}
+	regions, err := wireguard.Regions()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nl-ams-1", "se-got"}, regions)
+	region, _ := MatchRegion(results.Location{Country: "nl", City: "ams"}, regions)
+	assert.Equal(t, "nl-ams-1", region, "Files are matched like regions")
+
+	state, _ := wireguard.State()
+	assert.Equal(t, "Disconnected", state)
+	_, err = wireguard.Connect(context.Background(), "se-got", time.Minute)
+	assert.NoError(t, err)
+	state, _ = wireguard.State()
+	assert.Equal(t, "Connected", state)
+	assert.NoError(t, wireguard.Disconnect())
+	assert.NoError(t, wireguard.Disconnect(), "Nothing is left to tear down")
+
+	calls, err := os.ReadFile(log)
+	assert.NoError(t, err)
+	config := filepath.Join(dir, "se-got.conf")
+	assert.Equal(t, "wg-quick up "+config+"\nwg show se-got\nwg-quick down "+config+"\n", string(calls))
+
+	_, err = (&WireGuard{Dir: bin}).Regions()
+	assert.ErrorContains(t, err, "no WireGuard .conf files")
+}
+
+func TestOpenVPN(t *testing.T) {
+	dir, bin := t.TempDir(), t.TempDir()
+	for _, name := range []string{"de-fra.ovpn", "us-nyc.ovpn", "ca.crt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+	// Connects unless the profile is named "broken", then runs until interrupted
+	script := `#!/bin/sh
+echo "$PWD $@" > calls
+case "$2" in broken.ovpn) echo "Options error: cannot open broken.ovpn"; exit 1;; esac
+trap 'echo stopped >> calls; kill $!; exit 0' INT
+echo "2026-10-15 10:00:00 Initialization Sequence Completed"
+sleep 60 > /dev/null 2>&1 & wait
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(bin, "openvpn"), []byte(script), 0755))
+	openvpn := &OpenVPN{Dir: dir, Commands: command.Overrides{"openvpn": {Path: filepath.Join(bin, "openvpn")}}}
+	regions, err := openvpn.Regions()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"de-fra", "us-nyc"}, regions)
+
+	state, _ := openvpn.State()
+	assert.Equal(t, "Disconnected", state)
+	_, err = openvpn.Connect(context.Background(), "de-fra", time.Minute)
+	assert.NoError(t, err)
+	state, _ = openvpn.State()
+	assert.Equal(t, "Connected", state)
+	assert.NoError(t, openvpn.Disconnect())
+	assert.NoError(t, openvpn.Disconnect(), "Nothing is left to tear down")
+	state, _ = openvpn.State()
+	assert.Equal(t, "Disconnected", state)
+
+	calls, err := os.ReadFile(filepath.Join(dir, "calls"))
+	assert.NoError(t, err)
+	resolved, _ := filepath.EvalSymlinks(dir)
+	assert.Contains(t, []string{dir + " --config de-fra.ovpn\nstopped\n", resolved + " --config de-fra.ovpn\nstopped\n"}, string(calls), "Runs in the profile's directory")
+
+	_, err = openvpn.Connect(context.Background(), "broken", time.Minute)
+	assert.ErrorContains(t, err, "cannot open broken.ovpn")
+
+	_, err = (&OpenVPN{Dir: bin}).Regions()
+	assert.ErrorContains(t, err, "no OpenVPN .ovpn files")
+}
+
+func TestConnectPhases(t *testing.T) {
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	sleep = func(d time.Duration) { time.Sleep(time.Millisecond) }
+
+	// The client reports Connecting twice before Connected
+	dir := t.TempDir()
+	script := filepath.Join(dir, "expressvpnctl")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+[ "$1" = connect ] && exit 0
+n=$(($(cat `+dir+`/polls 2>/dev/null || echo 0) + 1))
+echo $n > `+dir+`/polls
+[ $n -le 2 ] && echo Connecting || echo Connected
+`), 0755)
+	assert.NoError(t, err)
+	client := ExpressVPN{Commands: command.Overrides{"expressvpnctl": {Path: script}}}
+
+	phases, err := client.ConnectPhases(context.Background(), "netherlands-amsterdam", time.Second)
+	assert.NoError(t, err)
+	assert.Len(t, phases.States, 2)
+	assert.Equal(t, "Connecting", phases.States[0].State)
+	assert.Equal(t, "Connected", phases.States[1].State)
+	assert.LessOrEqual(t, phases.Command, phases.States[0].At)
+	assert.LessOrEqual(t, phases.States[1].At, phases.Connected)
+}
+
+func TestNamespace(t *testing.T) {
+	dir, bin := t.TempDir(), t.TempDir()
+	etcNetns = t.TempDir()
+	defer func() { etcNetns = "/etc/netns" }()
+	config := "[Interface]\nPrivateKey = key\nAddress = 10.64.0.2/32, fd00::2/128 # Both families\nDNS = 10.64.0.1, vpn.example\n\n[Peer]\nPublicKey = peer\nEndpoint = 203.0.113.1:51820\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "se-got.conf"), []byte(config), 0600))
+	log := filepath.Join(bin, "calls")
+	commands := command.Overrides{}
+	for _, name := range []string{"ip", "wg", "wg-quick"} {
+		script := "#!/bin/sh\necho " + name + " \"$@\" | sed 's|/[^ ]*/wg-[0-9]*.conf|CONF|' >> " + log + "\n"
+		if name == "wg-quick" {
+			script += "echo '[Interface]'\n"
+		}
+		assert.NoError(t, os.WriteFile(filepath.Join(bin, name), []byte(script), 0755))
+		commands[name] = command.Config{Path: filepath.Join(bin, name)}
+	}
+
+	ns := Namespace{Name: "vpnbench1", Commands: commands}
+	assert.NoError(t, ns.Create())
+	wireguard := &WireGuard{Dir: dir, Commands: commands, Namespace: ns.Name}
+	_, err := wireguard.Connect(context.Background(), "se-got", time.Minute)
+	assert.NoError(t, err)
+	state, _ := wireguard.State()
+	assert.Equal(t, "Connected", state)
+	resolv, err := os.ReadFile(filepath.Join(etcNetns, "vpnbench1", "resolv.conf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "nameserver 10.64.0.1\nsearch vpn.example\n", string(resolv))
+	assert.NoError(t, wireguard.Disconnect())
+	assert.NoError(t, ns.Delete())
+	assert.NoDirExists(t, filepath.Join(etcNetns, "vpnbench1"))
+
+	calls, err := os.ReadFile(log)
+	assert.NoError(t, err)
+	assert.Equal(t, `ip netns delete vpnbench1
+ip netns add vpnbench1
+ip -n vpnbench1 link set lo up
+wg-quick strip `+filepath.Join(dir, "se-got.conf")+`
+ip link add vpnbench1 type wireguard
+wg setconf vpnbench1 CONF
+ip link set vpnbench1 netns vpnbench1
+ip -n vpnbench1 address add 10.64.0.2/32 dev vpnbench1
+ip -n vpnbench1 address add fd00::2/128 dev vpnbench1
+ip -n vpnbench1 link set vpnbench1 up
+ip -n vpnbench1 route add default dev vpnbench1
+ip -6 -n vpnbench1 route add default dev vpnbench1
+ip -n vpnbench1 link show vpnbench1
+ip -n vpnbench1 link delete vpnbench1
+ip netns delete vpnbench1
+`, string(calls), "The tunnel is created on the host and moved into the namespace")
+
+	wrapped := ns.Wrap(command.Overrides{"speedtest": {Path: "/opt/speedtest", Args: []string{"--accept-license"}, Env: map[string]string{"HOME": "/tmp"}}}, "speedtest", "iperf3")
+	assert.Equal(t, command.Config{Path: filepath.Join(bin, "ip"), Args: []string{"netns", "exec", "vpnbench1", "/opt/speedtest", "--accept-license"}, Env: map[string]string{"HOME": "/tmp"}}, wrapped["speedtest"])
+	assert.Equal(t, []string{"netns", "exec", "vpnbench1", "iperf3"}, wrapped["iperf3"].Args, "Binaries from PATH are run by name")
+}
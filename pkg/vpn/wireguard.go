@@ -0,0 +1,96 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"flavius.xyz/vpn_speed_test_cli/pkg/command"
+)
+
+// WireGuard brings up the tunnels of a directory of WireGuard .conf files one at a time, each file a
+// region named after it; the file name is the interface name too, so at most 15 characters
+type WireGuard struct {
+	Dir       string
+	Commands  command.Overrides // How wg-quick, wg or wireguard.exe, and ip for Namespace, are invoked
+	Namespace string            // Linux network namespace the tunnel is moved into, named like it; empty for the host
+
+	current string // Region whose tunnel is up
+}
+
+// Lists the .conf files of the directory as regions
+func (w *WireGuard) Regions() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(w.Dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no WireGuard .conf files in %s", w.Dir)
+	}
+	regions := make([]string, 0, len(matches))
+	for _, match := range matches {
+		regions = append(regions, strings.TrimSuffix(filepath.Base(match), ".conf"))
+	}
+	slices.Sort(regions)
+	return regions, nil
+}
+
+// Brings up the tunnel of the region's file; the time measured is the interface setup, as WireGuard
+// has no connection to wait for until the first packet triggers the handshake
+func (w *WireGuard) Connect(ctx context.Context, region string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	config := filepath.Join(w.Dir, region+".conf")
+	var err error
+	if w.Namespace != "" {
+		err = w.connectInNamespace(ctx, config)
+	} else if runtime.GOOS == "windows" {
+		_, err = w.Commands.RunCombinedContext(ctx, "wireguard", "/installtunnelservice", config)
+	} else {
+		_, err = w.Commands.RunCombinedContext(ctx, "wg-quick", "up", config)
+	}
+	if err != nil {
+		return 0, err
+	}
+	w.current = region
+	return time.Since(start).Round(time.Millisecond), nil
+}
+
+// Tears down the tunnel that is up, if any
+func (w *WireGuard) Disconnect() error {
+	if w.current == "" {
+		return nil
+	}
+	var err error
+	if w.Namespace != "" {
+		_, err = w.Commands.RunCombined("ip", "-n", w.Namespace, "link", "delete", w.Namespace)
+	} else if runtime.GOOS == "windows" {
+		_, err = w.Commands.RunCombined("wireguard", "/uninstalltunnelservice", w.current)
+	} else {
+		_, err = w.Commands.RunCombined("wg-quick", "down", filepath.Join(w.Dir, w.current+".conf"))
+	}
+	if err == nil {
+		w.current = ""
+	}
+	return err
+}
+
+// Reports the tunnel as connected while its interface exists
+func (w *WireGuard) State() (string, error) {
+	if w.current == "" {
+		return "Disconnected", nil
+	}
+	if w.Namespace != "" {
+		if _, err := w.Commands.Run("ip", "-n", w.Namespace, "link", "show", w.Namespace); err != nil {
+			return "Disconnected", nil
+		}
+		return "Connected", nil
+	}
+	if _, err := w.Commands.Run("wg", "show", w.current); err != nil {
+		return "Disconnected", nil
+	}
+	return "Connected", nil
+}